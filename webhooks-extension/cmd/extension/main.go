@@ -14,21 +14,61 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	endpoints "github.com/tektoncd/experimental/webhooks-extension/pkg/endpoints"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/metrics"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// On SIGTERM requests in flight are given shutdownTimeout to complete, then the spans left are given
+// tracingFlushTimeout to export, within the default termination grace period of the pod
+const (
+	shutdownTimeout     = 20 * time.Second
+	tracingFlushTimeout = 5 * time.Second
 )
 
 func main() {
+	// Record the requests of the Kubernetes clients created by the resource
+	metrics.RegisterKubernetesClientMetrics()
+
+	// Export spans when an OTLP endpoint is configured
+	shutdownTracing, err := tracing.Init("webhooks-extension")
+	if err != nil {
+		logging.Log.Fatalf("Fatal error setting up tracing: %s.", err.Error())
+	}
+
 	// Create/setup resource
 	r, err := endpoints.NewResource()
 	if err != nil {
 		logging.Log.Fatalf("Fatal error creating resource: %s.", err.Error())
 	}
 
+	// Change the log level as the logging ConfigMap changes
+	go logging.WatchLevel(r.K8sClient, r.Defaults.Namespace, wait.NeverStop)
+
+	// Keep the installation tokens of GitHub App credentials fresh
+	go r.RefreshGitHubAppTokens(wait.NeverStop)
+
+	// Reconcile webhook resources created, edited or deleted other than through the extension
+	go r.RunWebhookController(wait.NeverStop)
+
+	// Repair eventlisteners, Ingresses, Routes and git provider hooks changed other than through the extension
+	go r.RunDriftCheck(wait.NeverStop)
+
+	// Complete the traces of incoming events with the PipelineRuns created for them
+	if tracing.Enabled() {
+		go r.RunPipelineRunTracing(wait.NeverStop)
+	}
+
 	// Set up routes
 	wsContainer := restful.NewContainer()
 	wsContainer.Router(restful.CurlyRouter{})
@@ -41,6 +81,9 @@ func main() {
 	r.RegisterLivenessWebService(wsContainer)
 	r.RegisterReadinessWebService(wsContainer)
 
+	// Add Prometheus metrics
+	r.RegisterMetrics(wsContainer)
+
 	// Serve
 	logging.Log.Info("Creating server and entering wait loop.")
 	port := ":8080"
@@ -50,5 +93,29 @@ func main() {
 		logging.Log.Infof("Port number from config: %s.", portnum)
 	}
 	server := &http.Server{Addr: port, Handler: wsContainer}
-	logging.Log.Fatal(server.ListenAndServe())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		logging.Log.Fatal(err)
+	case sig := <-signals:
+		logging.Log.Infof("Received %s, shutting down once requests in flight complete", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			logging.Log.Errorf("Error shutting down the server: %s", err.Error())
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logging.Log.Errorf("Error flushing spans: %s", err.Error())
+	}
+	logging.Log.Info("Extension stopped")
 }
@@ -0,0 +1,343 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// zeroCommit is the commit before a branch or tag is created and after it is deleted, as given by GitHub
+// and Bitbucket Server
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// bitbucketLinks holds the links of a Bitbucket Server or Bitbucket Cloud repository
+type bitbucketLinks struct {
+	// Bitbucket Server lists the clone links by name, and has a single self link
+	Clone []struct {
+		Href string `json:"href"`
+		Name string `json:"name"`
+	} `json:"clone"`
+	Self []struct {
+		Href string `json:"href"`
+	} `json:"self"`
+	// Bitbucket Cloud has a single html link
+	HTML struct {
+		Href string `json:"href"`
+	} `json:"html"`
+}
+
+// bitbucketRepository holds the fields of a Bitbucket Server or Bitbucket Cloud repository
+type bitbucketRepository struct {
+	// Bitbucket Server identifies a repository by its project and slug
+	Slug    string `json:"slug"`
+	Project struct {
+		Key string `json:"key"`
+	} `json:"project"`
+	// Bitbucket Cloud identifies a repository by its workspace/slug full name
+	FullName string         `json:"full_name"`
+	Links    bitbucketLinks `json:"links"`
+}
+
+// bitbucketCloudRef is a branch or tag before or after a Bitbucket Cloud push, null if it
+// did not exist before the push or was deleted by it
+type bitbucketCloudRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+// BitbucketResult holds the fields of both Bitbucket Server and Bitbucket Cloud payloads
+// that are needed to validate an event, normalize it and add extras to it
+type BitbucketResult struct {
+	Repository bitbucketRepository `json:"repository"`
+
+	// Bitbucket Server push and pull request fields
+	Changes []struct {
+		Ref struct {
+			ID string `json:"id"`
+		} `json:"ref"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
+	} `json:"changes"`
+	PullRequest struct {
+		ID    int `json:"id"`
+		Links struct {
+			Self []struct {
+				Href string `json:"href"`
+			} `json:"self"`
+		} `json:"links"`
+		FromRef struct {
+			ID           string              `json:"id"`
+			LatestCommit string              `json:"latestCommit"`
+			Repository   bitbucketRepository `json:"repository"`
+		} `json:"fromRef"`
+		ToRef struct {
+			ID string `json:"id"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+
+	// Bitbucket Cloud push and pull request fields
+	Push struct {
+		Changes []struct {
+			Old *bitbucketCloudRef `json:"old"`
+			New *bitbucketCloudRef `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	CloudPullRequest struct {
+		ID    int `json:"id"`
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+		Source struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+			Commit struct {
+				Hash string `json:"hash"`
+			} `json:"commit"`
+			Repository bitbucketRepository `json:"repository"`
+		} `json:"source"`
+		Destination struct {
+			Branch struct {
+				Name string `json:"name"`
+			} `json:"branch"`
+		} `json:"destination"`
+	} `json:"pullrequest"`
+}
+
+// validateBitbucketEvent checks the X-Hub-Signature of a Bitbucket Server or Bitbucket Cloud
+// event and maps it onto the equivalent GitHub event and action
+func validateBitbucketEvent(request *http.Request, secretToken []byte) (gitEvent, error) {
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return gitEvent{}, fmt.Errorf("error %s reading payload", err.Error())
+	}
+
+	signature := request.Header.Get("X-Hub-Signature")
+	if signature == "" {
+		return gitEvent{}, errors.New("missing X-Hub-Signature header")
+	}
+	if err := github.ValidateSignature(signature, payload, secretToken); err != nil {
+		return gitEvent{}, err
+	}
+
+	var result BitbucketResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return gitEvent{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+
+	deliveryID := request.Header.Get("X-Request-Id")
+	if deliveryID == "" {
+		deliveryID = request.Header.Get("X-Request-UUID")
+	}
+
+	eventKey := request.Header.Get("X-Event-Key")
+	event := getBitbucketEventType(eventKey)
+	var ref string
+	if event == "push" {
+		ref, _, _ = getBitbucketPushChange(result)
+	}
+	return gitEvent{
+		Provider:   "bitbucket",
+		Event:      event,
+		Action:     getBitbucketAction(eventKey),
+		Ref:        ref,
+		CloneURL:   getBitbucketCloneURL(result.Repository),
+		DeliveryID: deliveryID,
+		Payload:    payload,
+	}, nil
+}
+
+// getBitbucketEventType returns the GitHub name of the Bitbucket event key, unknown events are returned unchanged.
+// Pull request comments are not supported for Bitbucket, so are returned unchanged rather than as pull requests.
+func getBitbucketEventType(eventKey string) string {
+	switch {
+	case eventKey == "repo:refs_changed", eventKey == "repo:push":
+		return "push"
+	case strings.HasPrefix(eventKey, "pr:comment:"), strings.HasPrefix(eventKey, "pullrequest:comment_"):
+		return eventKey
+	case strings.HasPrefix(eventKey, "pr:"), strings.HasPrefix(eventKey, "pullrequest:"):
+		return "pull_request"
+	}
+	return eventKey
+}
+
+// getBitbucketAction returns the GitHub action of a Bitbucket pull request event key
+func getBitbucketAction(eventKey string) string {
+	switch eventKey {
+	case "pr:opened", "pullrequest:created":
+		return "opened"
+	case "pr:from_ref_updated", "pullrequest:updated":
+		return "synchronize"
+	case "pr:modified":
+		return "edited"
+	case "pr:merged", "pr:declined", "pullrequest:fulfilled", "pullrequest:rejected":
+		return "closed"
+	}
+	return ""
+}
+
+// getBitbucketCloneURL returns the http clone URL of a Bitbucket Server repository, without
+// any user information, or the html URL of a Bitbucket Cloud repository
+func getBitbucketCloneURL(repository bitbucketRepository) string {
+	for _, clone := range repository.Links.Clone {
+		if clone.Name != "http" {
+			continue
+		}
+		u, err := url.Parse(clone.Href)
+		if err != nil {
+			return clone.Href
+		}
+		u.User = nil
+		return u.String()
+	}
+	return repository.Links.HTML.Href
+}
+
+// getBitbucketRepositoryNames returns the name of a Bitbucket Server or Bitbucket Cloud repository, its slug,
+// and its full name, project/slug or workspace/slug
+func getBitbucketRepositoryNames(repository bitbucketRepository) (name, fullName string) {
+	if repository.Slug != "" {
+		return repository.Slug, repository.Project.Key + "/" + repository.Slug
+	}
+	return repository.FullName[strings.LastIndex(repository.FullName, "/")+1:], repository.FullName
+}
+
+// getBitbucketHTMLURL returns the URL browsing a Bitbucket Server or Bitbucket Cloud repository
+func getBitbucketHTMLURL(repository bitbucketRepository) string {
+	if len(repository.Links.Self) > 0 {
+		return repository.Links.Self[0].Href
+	}
+	return repository.Links.HTML.Href
+}
+
+// getBitbucketPushChange returns the ref, and the commits before and after, of the first change pushed to a
+// Bitbucket Server or Bitbucket Cloud repository. The commit before a created and after a deleted ref is zeroCommit.
+func getBitbucketPushChange(result BitbucketResult) (ref, before, after string) {
+	if len(result.Changes) > 0 {
+		return result.Changes[0].Ref.ID, result.Changes[0].FromHash, result.Changes[0].ToHash
+	}
+	if len(result.Push.Changes) > 0 {
+		change := result.Push.Changes[0]
+		before, after = zeroCommit, zeroCommit
+		if change.Old != nil {
+			before = change.Old.Target.Hash
+		}
+		// A deleted branch or tag is only given before the push
+		changed := change.Old
+		if change.New != nil {
+			changed, after = change.New, change.New.Target.Hash
+		}
+		if changed == nil {
+			return "", before, after
+		}
+		ref = "refs/heads/" + changed.Name
+		if changed.Type == "tag" {
+			ref = "refs/tags/" + changed.Name
+		}
+		return ref, before, after
+	}
+	return "", "", ""
+}
+
+// Normalizes a Bitbucket push or pull request payload with the fields of the equivalent GitHub payload used by
+// trigger bindings, and adds branch and a suggested image tag to it. A push deleting a branch or tag is
+// normalized as a GitHub push deleting it, with a null head_commit.
+func addExtrasToBitbucketPayload(event string, payload []byte) ([]byte, error) {
+	var result BitbucketResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+
+	var ref, commit string
+	normalized := map[string]interface{}{}
+	if "push" == event {
+		var before string
+		ref, before, commit = getBitbucketPushChange(result)
+		normalized["ref"], normalized["before"], normalized["after"] = ref, before, commit
+		normalized["created"], normalized["deleted"] = before == zeroCommit, commit == zeroCommit
+		if commit == zeroCommit {
+			normalized["head_commit"] = nil
+		} else {
+			normalized["head_commit"] = map[string]interface{}{"id": commit}
+		}
+	} else if "pull_request" == event {
+		var number int
+		var htmlURL, base, headCloneURL string
+		if result.PullRequest.FromRef.ID != "" {
+			ref = result.PullRequest.FromRef.ID
+			commit = result.PullRequest.FromRef.LatestCommit
+			number, base = result.PullRequest.ID, result.PullRequest.ToRef.ID
+			if len(result.PullRequest.Links.Self) > 0 {
+				htmlURL = result.PullRequest.Links.Self[0].Href
+			}
+			headCloneURL = getBitbucketCloneURL(result.PullRequest.FromRef.Repository)
+		} else {
+			ref = result.CloudPullRequest.Source.Branch.Name
+			commit = result.CloudPullRequest.Source.Commit.Hash
+			number, base = result.CloudPullRequest.ID, result.CloudPullRequest.Destination.Branch.Name
+			htmlURL = result.CloudPullRequest.Links.HTML.Href
+			headCloneURL = getBitbucketCloneURL(result.CloudPullRequest.Source.Repository)
+		}
+		normalized["number"] = number
+		normalized["pull_request"] = map[string]interface{}{
+			"number":   number,
+			"html_url": htmlURL,
+			"head": map[string]interface{}{
+				"ref":  strings.TrimPrefix(ref, "refs/heads/"),
+				"sha":  commit,
+				"repo": map[string]interface{}{"clone_url": headCloneURL},
+			},
+			"base": map[string]interface{}{"ref": strings.TrimPrefix(base, "refs/heads/")},
+		}
+	} else {
+		return payload, nil
+	}
+	if len(commit) < 7 && !strings.HasPrefix(ref, "refs/tags/") {
+		return nil, fmt.Errorf("commit %q for ref %s is too short to suggest an image tag", commit, ref)
+	}
+
+	var toReturn map[string]interface{}
+	if err := json.Unmarshal(payload, &toReturn); err != nil {
+		return nil, err
+	}
+	for field, value := range normalized {
+		toReturn[field] = value
+	}
+	// The GitHub repository fields are added to the Bitbucket repository
+	repository, ok := toReturn["repository"].(map[string]interface{})
+	if !ok {
+		repository = map[string]interface{}{}
+		toReturn["repository"] = repository
+	}
+	repository["name"], repository["full_name"] = getBitbucketRepositoryNames(result.Repository)
+	repository["clone_url"] = getBitbucketCloneURL(result.Repository)
+	repository["html_url"] = getBitbucketHTMLURL(result.Repository)
+
+	toReturn["webhooks-tekton-git-branch"] = ref[strings.LastIndex(ref, "/")+1:]
+	toReturn["webhooks-tekton-image-tag"] = getSuggestedTag(ref, commit)
+	return json.Marshal(toReturn)
+}
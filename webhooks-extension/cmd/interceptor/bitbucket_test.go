@@ -0,0 +1,306 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const bitbucketServerPullRequestPayload = `{
+	"eventKey": "pr:from_ref_updated",
+	"pullRequest": {
+		"id": 7,
+		"links": {"self": [{"href": "https://bitbucket.company.com/projects/PROJ/repos/repo/pull-requests/7"}]},
+		"fromRef": {
+			"id": "refs/heads/feature/foo",
+			"latestCommit": "9h3f39fu3hf39uh33",
+			"repository": {
+				"slug": "repo",
+				"links": {"clone": [{"href": "https://bitbucket.company.com/scm/~user/repo.git", "name": "http"}]}
+			}
+		},
+		"toRef": {"id": "refs/heads/master"}
+	},
+	"repository": {
+		"slug": "repo",
+		"project": {"key": "PROJ"},
+		"links": {
+			"clone": [
+				{"href": "ssh://git@bitbucket.company.com:7999/proj/repo.git", "name": "ssh"},
+				{"href": "https://admin@bitbucket.company.com/scm/proj/repo.git", "name": "http"}
+			],
+			"self": [{"href": "https://bitbucket.company.com/projects/PROJ/repos/repo/browse"}]
+		}
+	}
+}`
+
+const bitbucketCloudPushPayload = `{
+	"push": {
+		"changes": [
+			{"new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "12dee2323r2ef232ef2redw2"}}}
+		]
+	},
+	"repository": {
+		"name": "My Repo",
+		"full_name": "workspace/repo",
+		"links": {"html": {"href": "https://bitbucket.org/workspace/repo"}}
+	}
+}`
+
+const bitbucketCloudBranchDeletionPayload = `{
+	"push": {
+		"changes": [
+			{"old": {"type": "branch", "name": "feature/foo", "target": {"hash": "9h3f39fu3hf39uh33"}}, "new": null}
+		]
+	},
+	"repository": {
+		"full_name": "workspace/repo",
+		"links": {"html": {"href": "https://bitbucket.org/workspace/repo"}}
+	}
+}`
+
+func signBitbucketPayload(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateBitbucketServerEvent(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(bitbucketServerPullRequestPayload))
+	request.Header.Set("X-Hub-Signature", signBitbucketPayload(bitbucketServerPullRequestPayload, "mySecret"))
+	request.Header.Set("X-Event-Key", "pr:from_ref_updated")
+	request.Header.Set("X-Request-Id", "my-request-id")
+
+	incoming, err := validateBitbucketEvent(request, []byte("mySecret"))
+	if err != nil {
+		t.Fatalf("Error in validateBitbucketEvent %s", err)
+	}
+	if incoming.Event != "pull_request" {
+		t.Errorf("Event not mapped as expected, event was returned as %s", incoming.Event)
+	}
+	if incoming.Action != "synchronize" {
+		t.Errorf("Action not mapped as expected, action was returned as %s", incoming.Action)
+	}
+	if incoming.CloneURL != "https://bitbucket.company.com/scm/proj/repo.git" {
+		t.Errorf("Clone URL not read as expected, clone URL was returned as %s", incoming.CloneURL)
+	}
+	if incoming.DeliveryID != "my-request-id" {
+		t.Errorf("Delivery ID not read as expected, delivery ID was returned as %s", incoming.DeliveryID)
+	}
+}
+
+func TestValidateBitbucketCloudEvent(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(bitbucketCloudPushPayload))
+	request.Header.Set("X-Hub-Signature", signBitbucketPayload(bitbucketCloudPushPayload, "mySecret"))
+	request.Header.Set("X-Event-Key", "repo:push")
+	request.Header.Set("X-Request-UUID", "my-uuid")
+
+	incoming, err := validateBitbucketEvent(request, []byte("mySecret"))
+	if err != nil {
+		t.Fatalf("Error in validateBitbucketEvent %s", err)
+	}
+	if incoming.Event != "push" {
+		t.Errorf("Event not mapped as expected, event was returned as %s", incoming.Event)
+	}
+	if incoming.CloneURL != "https://bitbucket.org/workspace/repo" {
+		t.Errorf("Clone URL not read as expected, clone URL was returned as %s", incoming.CloneURL)
+	}
+	if incoming.DeliveryID != "my-uuid" {
+		t.Errorf("Delivery ID not read as expected, delivery ID was returned as %s", incoming.DeliveryID)
+	}
+}
+
+func TestValidateBitbucketEventBadSignature(t *testing.T) {
+	for _, signature := range []string{"", signBitbucketPayload(bitbucketServerPullRequestPayload, "notMySecret")} {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(bitbucketServerPullRequestPayload))
+		request.Header.Set("X-Hub-Signature", signature)
+		request.Header.Set("X-Event-Key", "pr:opened")
+
+		if _, err := validateBitbucketEvent(request, []byte("mySecret")); err == nil {
+			t.Errorf("validateBitbucketEvent did not return an error for signature %q", signature)
+		}
+	}
+}
+
+func TestGetBitbucketAction(t *testing.T) {
+	tests := map[string]string{
+		"pr:opened":             "opened",
+		"pullrequest:created":   "opened",
+		"pr:from_ref_updated":   "synchronize",
+		"pullrequest:updated":   "synchronize",
+		"pr:modified":           "edited",
+		"pr:merged":             "closed",
+		"pullrequest:rejected":  "closed",
+		"repo:refs_changed":     "",
+		"pullrequest:fulfilled": "closed",
+		"pr:comment:added":      "",
+	}
+	for eventKey, want := range tests {
+		if got := getBitbucketAction(eventKey); got != want {
+			t.Errorf("getBitbucketAction(%s) = %s, want %s", eventKey, got, want)
+		}
+	}
+}
+
+func TestGetBitbucketEventType(t *testing.T) {
+	tests := map[string]string{
+		"repo:refs_changed":           "push",
+		"repo:push":                   "push",
+		"pr:opened":                   "pull_request",
+		"pullrequest:updated":         "pull_request",
+		"pr:comment:added":            "pr:comment:added",
+		"pullrequest:comment_created": "pullrequest:comment_created",
+	}
+	for eventKey, want := range tests {
+		if got := getBitbucketEventType(eventKey); got != want {
+			t.Errorf("getBitbucketEventType(%s) = %s, want %s", eventKey, got, want)
+		}
+	}
+}
+
+func TestAddExtrasToBitbucketServerPullRequestPayload(t *testing.T) {
+	bytes, err := addExtrasToBitbucketPayload("pull_request", []byte(bitbucketServerPullRequestPayload))
+	if err != nil {
+		t.Errorf("Error in addExtrasToBitbucketPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "foo" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "9h3f39f" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+	if "pr:from_ref_updated" != p["eventKey"] {
+		t.Errorf("Original payload not preserved, eventKey was returned as %s", p["eventKey"])
+	}
+
+	// The GitHub pull request fields used by trigger bindings are added
+	var normalized struct {
+		Number      int `json:"number"`
+		PullRequest struct {
+			Number  int    `json:"number"`
+			HTMLURL string `json:"html_url"`
+			Head    struct {
+				Ref  string `json:"ref"`
+				Sha  string `json:"sha"`
+				Repo struct {
+					CloneURL string `json:"clone_url"`
+				} `json:"repo"`
+			} `json:"head"`
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
+		} `json:"pull_request"`
+		Repository struct {
+			Name     string `json:"name"`
+			FullName string `json:"full_name"`
+			CloneURL string `json:"clone_url"`
+			HTMLURL  string `json:"html_url"`
+			Slug     string `json:"slug"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(bytes, &normalized); err != nil {
+		t.Fatalf("Error in json.Unmarshal %s", err)
+	}
+	pr := normalized.PullRequest
+	if normalized.Number != 7 || pr.Number != 7 || pr.HTMLURL != "https://bitbucket.company.com/projects/PROJ/repos/repo/pull-requests/7" {
+		t.Errorf("Pull request not normalized as expected, got %+v", pr)
+	}
+	if pr.Head.Ref != "feature/foo" || pr.Head.Sha != "9h3f39fu3hf39uh33" || pr.Head.Repo.CloneURL != "https://bitbucket.company.com/scm/~user/repo.git" || pr.Base.Ref != "master" {
+		t.Errorf("Pull request head and base not normalized as expected, got %+v", pr)
+	}
+	repo := normalized.Repository
+	if repo.Name != "repo" || repo.FullName != "PROJ/repo" || repo.CloneURL != "https://bitbucket.company.com/scm/proj/repo.git" ||
+		repo.HTMLURL != "https://bitbucket.company.com/projects/PROJ/repos/repo/browse" || repo.Slug != "repo" {
+		t.Errorf("Repository not normalized as expected, got %+v", repo)
+	}
+}
+
+func TestAddExtrasToBitbucketCloudPushPayload(t *testing.T) {
+	bytes, err := addExtrasToBitbucketPayload("push", []byte(bitbucketCloudPushPayload))
+	if err != nil {
+		t.Errorf("Error in addExtrasToBitbucketPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "v1.0.0" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "v1.0.0" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+
+	// The GitHub push fields used by trigger bindings are added
+	var normalized struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Created    bool   `json:"created"`
+		HeadCommit *struct {
+			ID string `json:"id"`
+		} `json:"head_commit"`
+		Repository struct {
+			Name     string `json:"name"`
+			FullName string `json:"full_name"`
+			CloneURL string `json:"clone_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(bytes, &normalized); err != nil {
+		t.Fatalf("Error in json.Unmarshal %s", err)
+	}
+	if normalized.Ref != "refs/tags/v1.0.0" || normalized.After != "12dee2323r2ef232ef2redw2" || !normalized.Created ||
+		normalized.HeadCommit == nil || normalized.HeadCommit.ID != "12dee2323r2ef232ef2redw2" {
+		t.Errorf("Push not normalized as expected, got %+v", normalized)
+	}
+	if normalized.Repository.Name != "repo" || normalized.Repository.FullName != "workspace/repo" || normalized.Repository.CloneURL != "https://bitbucket.org/workspace/repo" {
+		t.Errorf("Repository not normalized as expected, got %+v", normalized.Repository)
+	}
+}
+
+func TestAddExtrasToBitbucketCloudBranchDeletionPayload(t *testing.T) {
+	bytes, err := addExtrasToBitbucketPayload("push", []byte(bitbucketCloudBranchDeletionPayload))
+	if err != nil {
+		t.Fatalf("Error in addExtrasToBitbucketPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	if err := json.Unmarshal(bytes, &p); err != nil {
+		t.Fatalf("Error in json.Unmarshal %s", err)
+	}
+	if p["ref"] != "refs/heads/feature/foo" || p["before"] != "9h3f39fu3hf39uh33" || p["after"] != zeroCommit || p["deleted"] != true {
+		t.Errorf("Branch deletion not normalized as expected, got ref %v before %v after %v deleted %v", p["ref"], p["before"], p["after"], p["deleted"])
+	}
+	if headCommit, ok := p["head_commit"]; !ok || headCommit != nil {
+		t.Errorf("Expected a null head_commit, got %v", headCommit)
+	}
+	if "foo" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+}
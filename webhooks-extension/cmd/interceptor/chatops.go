@@ -0,0 +1,325 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/metrics"
+)
+
+// chatOpsCommands are the slash commands in pull request comments that run the pipeline
+var chatOpsCommands = []string{"/test", "/retest", "/ok-to-test"}
+
+// apiClient is the client for git provider API requests checking comments, recording their latency
+var apiClient = metrics.InstrumentClient(&http.Client{Timeout: 10 * time.Second}, gitProviderRequestDuration)
+
+// pullRequestComment is a slash command commented on a pull request by a member of the repository
+type pullRequestComment struct {
+	Command   string
+	Commenter string
+	Number    int
+	// HeadRef is the branch of the pull request and HeadSHA its latest commit
+	HeadRef string
+	HeadSHA string
+}
+
+// getCommand returns the first slash command starting a line of the comment, or "" if there is none
+func getCommand(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, command := range chatOpsCommands {
+			if fields[0] == command {
+				return command
+			}
+		}
+	}
+	return ""
+}
+
+// getPullRequestComment returns the slash command of a comment on a pull request. An error is returned
+// if the comment is not on a pull request, has no slash command, or the commenter is not a member
+// of the repository, checked with the git provider API using the access token.
+func getPullRequestComment(incoming gitEvent, accessToken []byte) (pullRequestComment, error) {
+	switch incoming.Provider {
+	case "github":
+		return getGitHubComment(incoming.Payload, string(accessToken))
+	case "gitlab":
+		return getGitLabComment(incoming.Payload, string(accessToken))
+	case "gitea", "gogs":
+		return getGiteaComment(incoming.Payload, string(accessToken))
+	}
+	return pullRequestComment{}, fmt.Errorf("pull request comments are not supported for %s", incoming.Provider)
+}
+
+// GitHubComment holds the fields of GitHub and Gitea issue_comment payloads needed to check a comment
+type GitHubComment struct {
+	Comment struct {
+		Body string `json:"body"`
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"comment"`
+	Issue struct {
+		Number      int              `json:"number"`
+		PullRequest *json.RawMessage `json:"pull_request"`
+	} `json:"issue"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+		Owner    struct {
+			Login string `json:"login"`
+			Type  string `json:"type"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// pullRequestHead is the head of a GitHub or Gitea pull request
+type pullRequestHead struct {
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+}
+
+// parseComment returns the slash command of the comment, checking it is on a pull request
+func (c GitHubComment) parseComment() (pullRequestComment, error) {
+	if c.Issue.PullRequest == nil {
+		return pullRequestComment{}, fmt.Errorf("comment on issue %d, which is not a pull request", c.Issue.Number)
+	}
+	command := getCommand(c.Comment.Body)
+	if command == "" {
+		return pullRequestComment{}, errors.New("comment has no slash command")
+	}
+	return pullRequestComment{Command: command, Commenter: c.Comment.User.Login, Number: c.Issue.Number}, nil
+}
+
+// getGitHubAPI returns the API URL of the GitHub or GitHub Enterprise server of the repository
+func getGitHubAPI(htmlURL string) (string, error) {
+	u, err := url.Parse(htmlURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing repository URL %s: %s", htmlURL, err)
+	}
+	if u.Host == "github.com" {
+		return "https://api.github.com", nil
+	}
+	return fmt.Sprintf("%s://%s/api/v3", u.Scheme, u.Host), nil
+}
+
+// getGitHubComment checks a comment on a GitHub pull request. The commenter must be a collaborator
+// on the repository or, for a repository of an organization, a member of the organization.
+func getGitHubComment(payload []byte, accessToken string) (pullRequestComment, error) {
+	var c GitHubComment
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return pullRequestComment{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+	comment, err := c.parseComment()
+	if err != nil {
+		return comment, err
+	}
+	api, err := getGitHubAPI(c.Repository.HTMLURL)
+	if err != nil {
+		return comment, err
+	}
+	header := http.Header{"Authorization": {"token " + accessToken}}
+
+	member, err := isMember(api+"/repos/"+c.Repository.FullName+"/collaborators/"+url.PathEscape(comment.Commenter), header)
+	if err == nil && !member && c.Repository.Owner.Type == "Organization" {
+		member, err = isMember(api+"/orgs/"+url.PathEscape(c.Repository.Owner.Login)+"/members/"+url.PathEscape(comment.Commenter), header)
+	}
+	if err != nil {
+		return comment, err
+	}
+	if !member {
+		return comment, fmt.Errorf("%s is not a collaborator on %s or a member of its organization", comment.Commenter, c.Repository.FullName)
+	}
+
+	var pr pullRequestHead
+	if err := getJSON(fmt.Sprintf("%s/repos/%s/pulls/%d", api, c.Repository.FullName, comment.Number), header, &pr); err != nil {
+		return comment, err
+	}
+	comment.HeadRef, comment.HeadSHA = pr.Head.Ref, pr.Head.SHA
+	return comment, nil
+}
+
+// getGiteaComment checks a comment on a Gitea or Gogs pull request. The commenter must own the
+// repository, be a collaborator on it or, for a repository of an organization, a member of the organization.
+func getGiteaComment(payload []byte, accessToken string) (pullRequestComment, error) {
+	var c GitHubComment
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return pullRequestComment{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+	comment, err := c.parseComment()
+	if err != nil {
+		return comment, err
+	}
+	// Gitea and Gogs may be served from a sub path, the API is served from <root>/api/v1
+	api := strings.TrimSuffix(c.Repository.HTMLURL, "/"+c.Repository.FullName) + "/api/v1"
+	header := http.Header{"Authorization": {"token " + accessToken}}
+
+	member := comment.Commenter == c.Repository.Owner.Login
+	if !member {
+		member, err = isMember(api+"/repos/"+c.Repository.FullName+"/collaborators/"+url.PathEscape(comment.Commenter), header)
+	}
+	if err == nil && !member {
+		member, err = isMember(api+"/orgs/"+url.PathEscape(c.Repository.Owner.Login)+"/members/"+url.PathEscape(comment.Commenter), header)
+	}
+	if err != nil {
+		return comment, err
+	}
+	if !member {
+		return comment, fmt.Errorf("%s is not the owner of %s, a collaborator on it or a member of its organization", comment.Commenter, c.Repository.FullName)
+	}
+
+	var pr pullRequestHead
+	if err := getJSON(fmt.Sprintf("%s/repos/%s/pulls/%d", api, c.Repository.FullName, comment.Number), header, &pr); err != nil {
+		return comment, err
+	}
+	comment.HeadRef, comment.HeadSHA = pr.Head.Ref, pr.Head.SHA
+	return comment, nil
+}
+
+// GitLabComment holds the fields of GitLab note payloads needed to check a comment
+type GitLabComment struct {
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		ID     int    `json:"id"`
+		WebURL string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	MergeRequest struct {
+		IID          int    `json:"iid"`
+		SourceBranch string `json:"source_branch"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"merge_request"`
+}
+
+// gitLabDeveloperAccess is the access level of a GitLab member able to push to the project
+const gitLabDeveloperAccess = 30
+
+// getGitLabComment checks a comment on a GitLab merge request. The commenter must be a
+// member of the project, or of a group the project is in, with at least developer access.
+func getGitLabComment(payload []byte, accessToken string) (pullRequestComment, error) {
+	var c GitLabComment
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return pullRequestComment{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+	if c.ObjectAttributes.NoteableType != "MergeRequest" {
+		return pullRequestComment{}, fmt.Errorf("comment on a %s, which is not a merge request", c.ObjectAttributes.NoteableType)
+	}
+	command := getCommand(c.ObjectAttributes.Note)
+	if command == "" {
+		return pullRequestComment{}, errors.New("comment has no slash command")
+	}
+	comment := pullRequestComment{
+		Command:   command,
+		Commenter: c.User.Username,
+		Number:    c.MergeRequest.IID,
+		HeadRef:   c.MergeRequest.SourceBranch,
+		HeadSHA:   c.MergeRequest.LastCommit.ID,
+	}
+
+	u, err := url.Parse(c.Project.WebURL)
+	if err != nil {
+		return comment, fmt.Errorf("error parsing project URL %s: %s", c.Project.WebURL, err)
+	}
+	var members []struct {
+		Username    string `json:"username"`
+		AccessLevel int    `json:"access_level"`
+	}
+	membersAPI := fmt.Sprintf("%s://%s/api/v4/projects/%d/members/all?query=%s", u.Scheme, u.Host, c.Project.ID, url.QueryEscape(comment.Commenter))
+	if err := getJSON(membersAPI, http.Header{"Private-Token": {accessToken}}, &members); err != nil {
+		return comment, err
+	}
+	for _, member := range members {
+		if member.Username == comment.Commenter && member.AccessLevel >= gitLabDeveloperAccess {
+			return comment, nil
+		}
+	}
+	return comment, fmt.Errorf("%s is not a member of project %s with developer access", comment.Commenter, c.Project.WebURL)
+}
+
+// isMember requests a membership API returning 204 No Content for members and 404 Not Found otherwise
+func isMember(api string, header http.Header) (bool, error) {
+	request, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header = header
+	resp, err := apiClient.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("error checking membership: %s", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("error checking membership. Status: %s", resp.Status)
+}
+
+// getJSON requests the API, unmarshalling the response
+func getJSON(api string, header http.Header, v interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return err
+	}
+	request.Header = header
+	resp, err := apiClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error requesting %s: %s", api, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error requesting %s. Status: %s", api, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Adds the command, the pull request and its head branch and commit, and the branch and
+// suggested image tag of the head, to a pull request comment payload
+func addExtrasToCommentPayload(comment pullRequestComment, payload []byte) ([]byte, error) {
+	if len(comment.HeadSHA) < 7 {
+		return nil, fmt.Errorf("commit %q for pull request %d is too short to suggest an image tag", comment.HeadSHA, comment.Number)
+	}
+	var toReturn map[string]interface{}
+	if err := json.Unmarshal(payload, &toReturn); err != nil {
+		return nil, err
+	}
+	toReturn["webhooks-tekton-command"] = comment.Command
+	toReturn["webhooks-tekton-pull-request-number"] = comment.Number
+	toReturn["webhooks-tekton-pull-request-branch"] = comment.HeadRef
+	toReturn["webhooks-tekton-pull-request-sha"] = comment.HeadSHA
+	toReturn["webhooks-tekton-git-branch"] = comment.HeadRef[strings.LastIndex(comment.HeadRef, "/")+1:]
+	toReturn["webhooks-tekton-image-tag"] = getSuggestedTag(comment.HeadRef, comment.HeadSHA)
+	return json.Marshal(toReturn)
+}
@@ -0,0 +1,179 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetCommand(t *testing.T) {
+	tests := map[string]string{
+		"/test":                            "/test",
+		"/retest please":                   "/retest",
+		"Looks good to me\n/ok-to-test":    "/ok-to-test",
+		"  /test  \r\n":                    "/test",
+		"Please run /test":                 "",
+		"/testing":                         "",
+		"":                                 "",
+		"/unknown\n/retest\n/ok-to-test\n": "/retest",
+	}
+	for body, want := range tests {
+		if got := getCommand(body); got != want {
+			t.Errorf("getCommand(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+// newFakeProviderAPI returns a server for the GitHub Enterprise, Gitea and GitLab APIs, where
+// member is a member of every repository and the head of every pull request is feature/foo
+func newFakeProviderAPI(token string) *httptest.Server {
+	mux := http.NewServeMux()
+	authorized := func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "token "+token || r.Header.Get("Private-Token") == token
+	}
+	membership := func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if strings.HasSuffix(r.URL.Path, "/member") {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	pull := func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"head": {"ref": "feature/foo", "sha": "9h3f39fu3hf39uh33"}}`)
+	}
+	mux.HandleFunc("/api/v3/repos/owner/repo/collaborators/", membership)
+	mux.HandleFunc("/api/v3/orgs/owner/members/", membership)
+	mux.HandleFunc("/api/v3/repos/owner/repo/pulls/7", pull)
+	mux.HandleFunc("/gitea/api/v1/repos/owner/repo/collaborators/", membership)
+	mux.HandleFunc("/gitea/api/v1/orgs/owner/members/", membership)
+	mux.HandleFunc("/gitea/api/v1/repos/owner/repo/pulls/7", pull)
+	mux.HandleFunc("/api/v4/projects/42/members/all", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		members := []map[string]interface{}{
+			{"username": "member", "access_level": 30},
+			{"username": "reporter", "access_level": 20},
+		}
+		json.NewEncoder(w).Encode(members)
+	})
+	return httptest.NewServer(mux)
+}
+
+func gitHubCommentPayload(repoURL, commenter, body string, onPullRequest bool) []byte {
+	payload := map[string]interface{}{
+		"action":  "created",
+		"comment": map[string]interface{}{"body": body, "user": map[string]string{"login": commenter}},
+		"issue":   map[string]interface{}{"number": 7},
+		"repository": map[string]interface{}{
+			"full_name": "owner/repo",
+			"html_url":  repoURL,
+			"owner":     map[string]string{"login": "owner", "type": "Organization"},
+		},
+	}
+	if onPullRequest {
+		payload["issue"].(map[string]interface{})["pull_request"] = map[string]string{"url": repoURL + "/pulls/7"}
+	}
+	marshalled, _ := json.Marshal(payload)
+	return marshalled
+}
+
+func TestGetPullRequestComment(t *testing.T) {
+	server := newFakeProviderAPI("access")
+	defer server.Close()
+
+	gitLabPayload := func(commenter, noteableType string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"object_kind": "note",
+			"user": {"username": "%s"},
+			"project": {"id": 42, "web_url": "%s/owner/repo"},
+			"object_attributes": {"note": "/retest", "noteable_type": "%s"},
+			"merge_request": {"iid": 7, "source_branch": "feature/foo", "last_commit": {"id": "9h3f39fu3hf39uh33"}}
+		}`, commenter, server.URL, noteableType))
+	}
+
+	tests := []struct {
+		name     string
+		incoming gitEvent
+		token    string
+		command  string
+	}{
+		{name: "github member", incoming: gitEvent{Provider: "github", Payload: gitHubCommentPayload(server.URL+"/owner/repo", "member", "/test", true)}, token: "access", command: "/test"},
+		{name: "github other user", incoming: gitEvent{Provider: "github", Payload: gitHubCommentPayload(server.URL+"/owner/repo", "someone", "/test", true)}, token: "access"},
+		{name: "github bad token", incoming: gitEvent{Provider: "github", Payload: gitHubCommentPayload(server.URL+"/owner/repo", "member", "/test", true)}, token: "wrong"},
+		{name: "github issue", incoming: gitEvent{Provider: "github", Payload: gitHubCommentPayload(server.URL+"/owner/repo", "member", "/test", false)}, token: "access"},
+		{name: "github no command", incoming: gitEvent{Provider: "github", Payload: gitHubCommentPayload(server.URL+"/owner/repo", "member", "LGTM", true)}, token: "access"},
+		{name: "gitea member", incoming: gitEvent{Provider: "gitea", Payload: gitHubCommentPayload(server.URL+"/gitea/owner/repo", "member", "/ok-to-test", true)}, token: "access", command: "/ok-to-test"},
+		{name: "gitea owner", incoming: gitEvent{Provider: "gogs", Payload: gitHubCommentPayload(server.URL+"/gitea/owner/repo", "owner", "/retest", true)}, token: "access", command: "/retest"},
+		{name: "gitea other user", incoming: gitEvent{Provider: "gitea", Payload: gitHubCommentPayload(server.URL+"/gitea/owner/repo", "someone", "/test", true)}, token: "access"},
+		{name: "gitlab member", incoming: gitEvent{Provider: "gitlab", Payload: gitLabPayload("member", "MergeRequest")}, token: "access", command: "/retest"},
+		{name: "gitlab reporter", incoming: gitEvent{Provider: "gitlab", Payload: gitLabPayload("reporter", "MergeRequest")}, token: "access"},
+		{name: "gitlab issue", incoming: gitEvent{Provider: "gitlab", Payload: gitLabPayload("member", "Issue")}, token: "access"},
+		{name: "bitbucket", incoming: gitEvent{Provider: "bitbucket", Payload: []byte(`{}`)}, token: "access"},
+	}
+	for _, tt := range tests {
+		comment, err := getPullRequestComment(tt.incoming, []byte(tt.token))
+		if tt.command == "" {
+			if err == nil {
+				t.Errorf("%s: getPullRequestComment() did not return an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: getPullRequestComment() returned an error: %s", tt.name, err)
+			continue
+		}
+		if comment.Command != tt.command || comment.Number != 7 || comment.HeadRef != "feature/foo" || comment.HeadSHA != "9h3f39fu3hf39uh33" {
+			t.Errorf("%s: comment not read as expected, got %+v", tt.name, comment)
+		}
+	}
+}
+
+func TestAddExtrasToCommentPayload(t *testing.T) {
+	comment := pullRequestComment{Command: "/retest", Number: 7, HeadRef: "feature/foo", HeadSHA: "9h3f39fu3hf39uh33"}
+	returned, err := addExtrasToCommentPayload(comment, []byte(`{"action": "created"}`))
+	if err != nil {
+		t.Fatalf("Error in addExtrasToCommentPayload %s", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(returned, &payload); err != nil {
+		t.Fatalf("Error unmarshalling returned payload %s", err)
+	}
+	expected := map[string]interface{}{
+		"action":                              "created",
+		"webhooks-tekton-command":             "/retest",
+		"webhooks-tekton-pull-request-number": float64(7),
+		"webhooks-tekton-pull-request-branch": "feature/foo",
+		"webhooks-tekton-pull-request-sha":    "9h3f39fu3hf39uh33",
+		"webhooks-tekton-git-branch":          "foo",
+		"webhooks-tekton-image-tag":           "9h3f39f",
+	}
+	for key, value := range expected {
+		if payload[key] != value {
+			t.Errorf("Payload %s was %v, expected %v", key, payload[key], value)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	envDeliveryStore     = "DELIVERY_STORE"
+	envDeliveryTTL       = "DELIVERY_TTL"
+	envDeliveryStoreSize = "DELIVERY_STORE_SIZE"
+	envDeliveryConfigMap = "DELIVERY_CONFIGMAP"
+
+	defaultDeliveryTTL       = 24 * time.Hour
+	defaultDeliveryStoreSize = 5000
+	defaultDeliveryConfigMap = "tekton-webhooks-deliveries"
+
+	// deliveryStoreRetries is the number of times the ConfigMap is updated before giving up on conflicts
+	deliveryStoreRetries = 5
+)
+
+// deliveryStore records the deliveries handled by the interceptor so that redelivered and replayed events
+// are rejected rather than running their pipelines again
+type deliveryStore interface {
+	// record records the delivery, returning whether it was already recorded
+	record(key string) (bool, error)
+}
+
+// deliveryKey returns the key of a delivery to a trigger. The eventlistener sends each delivery to the
+// interceptor once for each of its triggers, so deliveries are only duplicates for the same trigger.
+// Keys are hashed to be valid ConfigMap keys whatever the delivery IDs of the git provider look like.
+func deliveryKey(trigger, deliveryID string) string {
+	sum := sha256.Sum256([]byte(trigger + "/" + deliveryID))
+	return hex.EncodeToString(sum[:])
+}
+
+// newDeliveryStore returns the delivery store configured by the DELIVERY_STORE, DELIVERY_TTL,
+// DELIVERY_STORE_SIZE and DELIVERY_CONFIGMAP environment variables: the in-memory store by default,
+// or with DELIVERY_STORE=configmap a ConfigMap in the install namespace shared by all replicas.
+func newDeliveryStore(clientset kubernetes.Interface) (deliveryStore, error) {
+	ttl := defaultDeliveryTTL
+	if value := os.Getenv(envDeliveryTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("%s %q is not a positive duration", envDeliveryTTL, value)
+		}
+		ttl = parsed
+	}
+	size := defaultDeliveryStoreSize
+	if value := os.Getenv(envDeliveryStoreSize); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("%s %q is not a positive number", envDeliveryStoreSize, value)
+		}
+		size = parsed
+	}
+
+	switch os.Getenv(envDeliveryStore) {
+	case "", "memory":
+		return newMemoryDeliveryStore(ttl, size), nil
+	case "configmap":
+		name := os.Getenv(envDeliveryConfigMap)
+		if name == "" {
+			name = defaultDeliveryConfigMap
+		}
+		return &configMapDeliveryStore{client: clientset, namespace: os.Getenv("INSTALLED_NAMESPACE"), name: name, ttl: ttl, size: size, now: time.Now}, nil
+	}
+	return nil, fmt.Errorf("%s %q is not valid, valid stores are memory and configmap", envDeliveryStore, os.Getenv(envDeliveryStore))
+}
+
+// recordDelivery records the delivery at now in deliveries, returning whether it was already recorded.
+// Deliveries older than the ttl are forgotten, as are the oldest deliveries beyond size.
+func recordDelivery(deliveries map[string]time.Time, key string, now time.Time, ttl time.Duration, size int) bool {
+	for k, recorded := range deliveries {
+		if now.Sub(recorded) >= ttl {
+			delete(deliveries, k)
+		}
+	}
+	if _, ok := deliveries[key]; ok {
+		return true
+	}
+	if len(deliveries) >= size {
+		keys := make([]string, 0, len(deliveries))
+		for k := range deliveries {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return deliveries[keys[i]].Before(deliveries[keys[j]]) })
+		for _, k := range keys[:len(keys)-size+1] {
+			delete(deliveries, k)
+		}
+	}
+	deliveries[key] = now
+	return false
+}
+
+// memoryDelivery is a delivery recorded by the in-memory store
+type memoryDelivery struct {
+	key      string
+	recorded time.Time
+}
+
+// memoryDeliveryStore records deliveries in memory, so each replica of the interceptor has its own.
+// Deliveries are looked up by key and kept in the order they were recorded, oldest first, so that
+// expired and excess deliveries are forgotten from the front.
+type memoryDeliveryStore struct {
+	mutex      sync.Mutex
+	deliveries map[string]*list.Element
+	order      *list.List
+	ttl        time.Duration
+	size       int
+	now        func() time.Time
+}
+
+func newMemoryDeliveryStore(ttl time.Duration, size int) *memoryDeliveryStore {
+	return &memoryDeliveryStore{deliveries: map[string]*list.Element{}, order: list.New(), ttl: ttl, size: size, now: time.Now}
+}
+
+func (s *memoryDeliveryStore) record(key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	for oldest := s.order.Front(); oldest != nil && now.Sub(oldest.Value.(memoryDelivery).recorded) >= s.ttl; oldest = s.order.Front() {
+		s.forget(oldest)
+	}
+	if _, ok := s.deliveries[key]; ok {
+		return true, nil
+	}
+	for s.order.Len() >= s.size {
+		s.forget(s.order.Front())
+	}
+	s.deliveries[key] = s.order.PushBack(memoryDelivery{key: key, recorded: now})
+	return false, nil
+}
+
+func (s *memoryDeliveryStore) forget(element *list.Element) {
+	delete(s.deliveries, element.Value.(memoryDelivery).key)
+	s.order.Remove(element)
+}
+
+// configMapDeliveryStore records deliveries in a ConfigMap, keyed by delivery key with the time the delivery
+// was recorded as the value. Concurrent updates by other replicas are detected by the resource version of
+// the ConfigMap, and retried.
+type configMapDeliveryStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	ttl       time.Duration
+	size      int
+	now       func() time.Time
+}
+
+func (s *configMapDeliveryStore) record(key string) (bool, error) {
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	for i := 0; i < deliveryStoreRetries; i++ {
+		now := s.now()
+		configMap, err := configMaps.Get(s.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.name, Namespace: s.namespace},
+				Data:       map[string]string{key: now.UTC().Format(time.RFC3339)},
+			}
+			_, err = configMaps.Create(configMap)
+			if errors.IsAlreadyExists(err) {
+				continue
+			}
+			return false, err
+		}
+		if err != nil {
+			return false, err
+		}
+
+		deliveries := map[string]time.Time{}
+		for k, value := range configMap.Data {
+			// Unreadable times are treated as expired
+			recorded, _ := time.Parse(time.RFC3339, value)
+			deliveries[k] = recorded
+		}
+		if recordDelivery(deliveries, key, now, s.ttl, s.size) {
+			return true, nil
+		}
+		configMap.Data = map[string]string{}
+		for k, recorded := range deliveries {
+			configMap.Data[k] = recorded.UTC().Format(time.RFC3339)
+		}
+		_, err = configMaps.Update(configMap)
+		if errors.IsConflict(err) {
+			continue
+		}
+		return false, err
+	}
+	return false, fmt.Errorf("error recording delivery in ConfigMap %s: updated concurrently %d times", s.name, deliveryStoreRetries)
+}
@@ -0,0 +1,137 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDeliveryKey(t *testing.T) {
+	if deliveryKey("trigger-a", "id") == deliveryKey("trigger-b", "id") {
+		t.Error("Deliveries to different triggers have the same key")
+	}
+	if deliveryKey("trigger-a", "id") != deliveryKey("trigger-a", "id") {
+		t.Error("Deliveries to the same trigger have different keys")
+	}
+	if key := deliveryKey("trigger-a", "{0a1b2c3d-uuid}"); len(key) != 64 {
+		t.Errorf("Unexpected delivery key %s", key)
+	}
+}
+
+// testDeliveryStore checks a store with a ttl of an hour and a size of 3, whose clock is advanced by advance
+func testDeliveryStore(t *testing.T, store deliveryStore, advance func(time.Duration)) {
+	record := func(key string, expected bool) {
+		t.Helper()
+		duplicate, err := store.record(key)
+		if err != nil {
+			t.Fatalf("Error recording %s: %s", key, err)
+		}
+		if duplicate != expected {
+			t.Errorf("Recording %s returned duplicate %t, expected %t", key, duplicate, expected)
+		}
+	}
+
+	record("a", false)
+	record("a", true)
+	advance(time.Minute)
+	record("b", false)
+	advance(time.Minute)
+	record("c", false)
+	record("b", true)
+
+	// The oldest delivery is forgotten when the store is full
+	advance(time.Minute)
+	record("d", false)
+	record("a", false)
+	record("c", true)
+
+	// Deliveries are forgotten after the ttl
+	advance(time.Hour)
+	record("c", false)
+	record("d", false)
+}
+
+func TestMemoryDeliveryStore(t *testing.T) {
+	now := time.Date(2019, 11, 1, 12, 0, 0, 0, time.UTC)
+	store := newMemoryDeliveryStore(time.Hour, 3)
+	store.now = func() time.Time { return now }
+	testDeliveryStore(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	// A full store forgets the oldest deliveries as new ones are recorded
+	store = newMemoryDeliveryStore(time.Hour, defaultDeliveryStoreSize)
+	store.now = func() time.Time { return now }
+	for i := 0; i < 2*defaultDeliveryStoreSize; i++ {
+		if duplicate, _ := store.record(fmt.Sprint(i)); duplicate {
+			t.Fatalf("Recording %d returned duplicate", i)
+		}
+	}
+	if len(store.deliveries) != defaultDeliveryStoreSize || store.order.Len() != defaultDeliveryStoreSize {
+		t.Errorf("Expected %d deliveries, got %d recorded in order %d", defaultDeliveryStoreSize, len(store.deliveries), store.order.Len())
+	}
+	if duplicate, _ := store.record(fmt.Sprint(defaultDeliveryStoreSize)); !duplicate {
+		t.Error("Expected the newest deliveries to be kept")
+	}
+	if duplicate, _ := store.record(fmt.Sprint(defaultDeliveryStoreSize - 1)); duplicate {
+		t.Error("Expected the oldest deliveries to be forgotten")
+	}
+}
+
+func TestConfigMapDeliveryStore(t *testing.T) {
+	now := time.Date(2019, 11, 1, 12, 0, 0, 0, time.UTC)
+	client := fakek8sclientset.NewSimpleClientset()
+	store := &configMapDeliveryStore{client: client, namespace: "tekton-pipelines", name: "deliveries", ttl: time.Hour, size: 3, now: func() time.Time { return now }}
+	testDeliveryStore(t, store, func(d time.Duration) { now = now.Add(d) })
+
+	configMap, err := client.CoreV1().ConfigMaps("tekton-pipelines").Get("deliveries", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting delivery ConfigMap: %s", err)
+	}
+	if len(configMap.Data) != 2 || configMap.Data["d"] != "2019-11-01T13:03:00Z" {
+		t.Errorf("Unexpected deliveries in ConfigMap: %v", configMap.Data)
+	}
+}
+
+func TestNewDeliveryStore(t *testing.T) {
+	defer os.Unsetenv(envDeliveryStore)
+	defer os.Unsetenv(envDeliveryTTL)
+	defer os.Unsetenv(envDeliveryStoreSize)
+	clientset := fakek8sclientset.NewSimpleClientset()
+
+	store, err := newDeliveryStore(clientset)
+	if memory, ok := store.(*memoryDeliveryStore); err != nil || !ok || memory.ttl != defaultDeliveryTTL || memory.size != defaultDeliveryStoreSize {
+		t.Errorf("Expected the default in-memory store, got %+v, %v", store, err)
+	}
+
+	os.Setenv(envDeliveryStore, "configmap")
+	os.Setenv(envDeliveryTTL, "30m")
+	os.Setenv(envDeliveryStoreSize, "100")
+	store, err = newDeliveryStore(clientset)
+	if configMap, ok := store.(*configMapDeliveryStore); err != nil || !ok || configMap.name != defaultDeliveryConfigMap || configMap.ttl != 30*time.Minute || configMap.size != 100 {
+		t.Errorf("Expected a ConfigMap store, got %+v, %v", store, err)
+	}
+
+	for name, value := range map[string]string{envDeliveryStore: "redis", envDeliveryTTL: "-1h", envDeliveryStoreSize: "lots"} {
+		os.Setenv(name, value)
+		if _, err := newDeliveryStore(clientset); err == nil {
+			t.Errorf("Expected an error for %s %s", name, value)
+		}
+		os.Unsetenv(name)
+	}
+}
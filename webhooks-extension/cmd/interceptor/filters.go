@@ -0,0 +1,121 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// PushCommits holds the commits of GitHub, GitLab, Gitea and Gogs push payloads
+// with the files they changed. Bitbucket push payloads do not list the files.
+type PushCommits struct {
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+}
+
+// changedFiles returns the files changed by the commits
+func (p PushCommits) changedFiles() []string {
+	files := []string{}
+	for _, commit := range p.Commits {
+		files = append(files, commit.Added...)
+		files = append(files, commit.Removed...)
+		files = append(files, commit.Modified...)
+	}
+	return files
+}
+
+// checkFilters checks a push event against the branch and path filters in the
+// Wext-Branches, Wext-Exclude-Branches, Wext-Paths and Wext-Exclude-Paths headers,
+// returning an error describing why the event was filtered out
+func checkFilters(header http.Header, incoming gitEvent) error {
+	if incoming.Event != "push" {
+		return nil
+	}
+	branches, excludeBranches := getPatterns(header, "Wext-Branches"), getPatterns(header, "Wext-Exclude-Branches")
+	paths, excludePaths := getPatterns(header, "Wext-Paths"), getPatterns(header, "Wext-Exclude-Paths")
+
+	if len(branches) > 0 || len(excludeBranches) > 0 {
+		// A push of a tag is not a push of a branch, so matches no branches
+		branch := ""
+		if strings.HasPrefix(incoming.Ref, "refs/heads/") {
+			branch = strings.TrimPrefix(incoming.Ref, "refs/heads/")
+		}
+		if len(branches) > 0 && (branch == "" || !matchesAny(branches, branch)) {
+			return fmt.Errorf("ref %s does not match the branches %s", incoming.Ref, strings.Join(branches, ","))
+		}
+		if branch != "" && matchesAny(excludeBranches, branch) {
+			return fmt.Errorf("branch %s matches the excluded branches %s", branch, strings.Join(excludeBranches, ","))
+		}
+	}
+
+	if len(paths) > 0 || len(excludePaths) > 0 {
+		for _, file := range incoming.ChangedFiles {
+			if (len(paths) == 0 || matchesAny(paths, file)) && !matchesAny(excludePaths, file) {
+				return nil
+			}
+		}
+		return fmt.Errorf("none of the %d files changed matches the paths %s excluding %s",
+			len(incoming.ChangedFiles), strings.Join(paths, ","), strings.Join(excludePaths, ","))
+	}
+	return nil
+}
+
+// getPatterns returns the comma separated glob patterns of the header
+func getPatterns(header http.Header, name string) []string {
+	value := header.Get(name)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// matchesAny returns whether the name matches any of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches the path segments of a name against those of a glob pattern,
+// where a ** segment matches any number of segments and other segments are
+// matched as by path.Match
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlob(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if matched, err := path.Match(pattern[0], name[0]); err != nil || !matched {
+		return false
+	}
+	return matchGlob(pattern[1:], name[1:])
+}
@@ -0,0 +1,102 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"main", "main", true},
+		{"main", "maintenance", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/fix", false},
+		{"release/**", "release/1.0/fix", true},
+		{"docs/**", "docs", true},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/README.md", true},
+		{"**/*.md", "docs/guide/main.go", false},
+		{"src/**/test/*.go", "src/a/b/test/x.go", true},
+		{"*.go", "cmd/main.go", false},
+	}
+	for _, tt := range tests {
+		if got := matchesAny([]string{tt.pattern}, tt.name); got != tt.want {
+			t.Errorf("matchesAny(%s, %s) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		incoming gitEvent
+		pass     bool
+	}{
+		{name: "no filters", incoming: gitEvent{Event: "push", Ref: "refs/heads/feature"}, pass: true},
+		{name: "branch", headers: map[string]string{"Wext-Branches": "main,release/*"}, incoming: gitEvent{Event: "push", Ref: "refs/heads/release/1.0"}, pass: true},
+		{name: "other branch", headers: map[string]string{"Wext-Branches": "main,release/*"}, incoming: gitEvent{Event: "push", Ref: "refs/heads/feature"}, pass: false},
+		{name: "tag with branches", headers: map[string]string{"Wext-Branches": "main"}, incoming: gitEvent{Event: "push", Ref: "refs/tags/main"}, pass: false},
+		{name: "excluded branch", headers: map[string]string{"Wext-Exclude-Branches": "wip/*"}, incoming: gitEvent{Event: "push", Ref: "refs/heads/wip/x"}, pass: false},
+		{name: "tag with excluded branches", headers: map[string]string{"Wext-Exclude-Branches": "*"}, incoming: gitEvent{Event: "push", Ref: "refs/tags/v1.0"}, pass: true},
+		{name: "path", headers: map[string]string{"Wext-Paths": "src/**"}, incoming: gitEvent{Event: "push", ChangedFiles: []string{"README.md", "src/main.go"}}, pass: true},
+		{name: "other paths", headers: map[string]string{"Wext-Paths": "src/**"}, incoming: gitEvent{Event: "push", ChangedFiles: []string{"README.md"}}, pass: false},
+		{name: "only excluded paths", headers: map[string]string{"Wext-Exclude-Paths": "docs/**,**/*.md"}, incoming: gitEvent{Event: "push", ChangedFiles: []string{"README.md", "docs/guide.txt"}}, pass: false},
+		{name: "some paths not excluded", headers: map[string]string{"Wext-Exclude-Paths": "docs/**"}, incoming: gitEvent{Event: "push", ChangedFiles: []string{"docs/guide.md", "main.go"}}, pass: true},
+		{name: "no files changed", headers: map[string]string{"Wext-Exclude-Paths": "docs/**"}, incoming: gitEvent{Event: "push"}, pass: false},
+		{name: "not a push", headers: map[string]string{"Wext-Branches": "main"}, incoming: gitEvent{Event: "pull_request"}, pass: true},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for name, value := range tt.headers {
+			header.Set(name, value)
+		}
+		err := checkFilters(header, tt.incoming)
+		if tt.pass && err != nil {
+			t.Errorf("%s: checkFilters() returned %s, expected the event to pass", tt.name, err)
+		}
+		if !tt.pass && err == nil {
+			t.Errorf("%s: checkFilters() passed the event, expected it to be filtered out", tt.name)
+		}
+	}
+}
+
+func TestValidateGiteaEventChangedFiles(t *testing.T) {
+	payload := `{
+		"ref": "refs/heads/main",
+		"commits": [
+			{"added": ["a.go"], "removed": [], "modified": ["b.go"]},
+			{"added": [], "removed": ["c.go"], "modified": []}
+		],
+		"repository": {"clone_url": "https://gitea.example.com/owner/repo.git"}
+	}`
+	request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+	request.Header.Set("X-Gitea-Event", "push")
+	request.Header.Set("X-Gitea-Signature", signGiteaPayload(payload, "mySecret"))
+
+	incoming, err := validateGiteaEvent("gitea", request, []byte("mySecret"))
+	if err != nil {
+		t.Fatalf("Error in validateGiteaEvent %s", err)
+	}
+	if incoming.Ref != "refs/heads/main" || !reflect.DeepEqual(incoming.ChangedFiles, []string{"a.go", "b.go", "c.go"}) {
+		t.Errorf("Ref and changed files not read as expected, got %s and %v", incoming.Ref, incoming.ChangedFiles)
+	}
+}
@@ -0,0 +1,396 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+)
+
+// forkPermissions are the permissions of authors on a repository in increasing order
+var forkPermissions = []string{"read", "write", "admin"}
+
+// gitLabAccessLevels are the GitLab access levels granting each permission
+var gitLabAccessLevels = map[string]int{"read": 20, "write": 30, "admin": 40}
+
+// pullRequest is the provider independent view of a pull request event needed to apply the fork policy
+type pullRequest struct {
+	Provider string
+	Number   int
+	Author   string
+	// AuthorID is the ID of the GitLab user who opened the merge request
+	AuthorID int
+	// Fork is whether the head of the pull request is in another repository
+	Fork bool
+	// Repository is the full name of a GitHub or Gitea repository, or the ID of a GitLab project
+	Repository string
+	Owner      string
+	// API is the API URL of the git provider
+	API string
+}
+
+// GitHubPullRequest holds the fields of GitHub and Gitea pull_request payloads needed to apply the fork policy
+type GitHubPullRequest struct {
+	Number      int `json:"number"`
+	PullRequest struct {
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Head struct {
+			Repo *struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+		Owner    struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+}
+
+// GitLabMergeRequest holds the fields of GitLab merge request payloads needed to apply the fork policy
+type GitLabMergeRequest struct {
+	// User is the user who triggered the event, not necessarily the author of the merge request
+	User struct {
+		ID       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		WebURL string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		IID             int `json:"iid"`
+		AuthorID        int `json:"author_id"`
+		SourceProjectID int `json:"source_project_id"`
+		TargetProjectID int `json:"target_project_id"`
+	} `json:"object_attributes"`
+}
+
+// BitbucketPullRequest holds the fields of Bitbucket Server and Bitbucket Cloud pull request payloads
+// needed to apply the fork policy
+type BitbucketPullRequest struct {
+	PullRequest struct {
+		ID     int `json:"id"`
+		Author struct {
+			User struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"author"`
+		FromRef struct {
+			Repository struct {
+				ID int `json:"id"`
+			} `json:"repository"`
+		} `json:"fromRef"`
+		ToRef struct {
+			Repository struct {
+				ID int `json:"id"`
+			} `json:"repository"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+	CloudPullRequest struct {
+		ID     int `json:"id"`
+		Author struct {
+			Nickname string `json:"nickname"`
+		} `json:"author"`
+		Source struct {
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		} `json:"source"`
+		Destination struct {
+			Repository struct {
+				FullName string `json:"full_name"`
+			} `json:"repository"`
+		} `json:"destination"`
+	} `json:"pullrequest"`
+}
+
+// getPullRequest reads the pull request of a pull_request event
+func getPullRequest(incoming gitEvent) (pullRequest, error) {
+	pr := pullRequest{Provider: incoming.Provider}
+	switch incoming.Provider {
+	case "github", "gitea", "gogs":
+		var p GitHubPullRequest
+		if err := json.Unmarshal(incoming.Payload, &p); err != nil {
+			return pr, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+		}
+		pr.Number, pr.Author = p.Number, p.PullRequest.User.Login
+		// The head repository of a pull request from a deleted fork is null
+		pr.Fork = p.PullRequest.Head.Repo == nil || p.PullRequest.Head.Repo.FullName != p.Repository.FullName
+		pr.Repository, pr.Owner = p.Repository.FullName, p.Repository.Owner.Login
+		if incoming.Provider == "github" {
+			api, err := getGitHubAPI(p.Repository.HTMLURL)
+			if err != nil {
+				return pr, err
+			}
+			pr.API = api
+		} else {
+			pr.API = strings.TrimSuffix(p.Repository.HTMLURL, "/"+p.Repository.FullName) + "/api/v1"
+		}
+	case "gitlab":
+		var p GitLabMergeRequest
+		if err := json.Unmarshal(incoming.Payload, &p); err != nil {
+			return pr, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+		}
+		pr.Number, pr.AuthorID = p.ObjectAttributes.IID, p.ObjectAttributes.AuthorID
+		// Events of merge requests updated, reopened or approved by another user only carry the author's ID
+		if p.User.ID == p.ObjectAttributes.AuthorID {
+			pr.Author = p.User.Username
+		}
+		pr.Fork = p.ObjectAttributes.SourceProjectID != p.ObjectAttributes.TargetProjectID
+		pr.Repository = fmt.Sprint(p.ObjectAttributes.TargetProjectID)
+		u, err := url.Parse(p.Project.WebURL)
+		if err != nil {
+			return pr, fmt.Errorf("error parsing project URL %s: %s", p.Project.WebURL, err)
+		}
+		pr.API = fmt.Sprintf("%s://%s/api/v4", u.Scheme, u.Host)
+	case "bitbucket":
+		var p BitbucketPullRequest
+		if err := json.Unmarshal(incoming.Payload, &p); err != nil {
+			return pr, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+		}
+		if p.PullRequest.ID != 0 {
+			pr.Number, pr.Author = p.PullRequest.ID, p.PullRequest.Author.User.Name
+			pr.Fork = p.PullRequest.FromRef.Repository.ID != p.PullRequest.ToRef.Repository.ID
+		} else {
+			pr.Number, pr.Author = p.CloudPullRequest.ID, p.CloudPullRequest.Author.Nickname
+			pr.Fork = p.CloudPullRequest.Source.Repository.FullName != p.CloudPullRequest.Destination.Repository.FullName
+		}
+	default:
+		return pr, fmt.Errorf("unsupported git provider %s", incoming.Provider)
+	}
+	return pr, nil
+}
+
+// enforceForkPolicy rejects pull_request events not allowed by the fork policy of the trigger, explaining the
+// rejection in a comment on newly opened pull requests when the Wext-Fork-Comment header is set
+func enforceForkPolicy(ctx context.Context, header http.Header, incoming gitEvent, accessToken string) error {
+	pr, err := getPullRequest(incoming)
+	if err != nil {
+		return err
+	}
+	reason := checkForkPolicy(header, pr, accessToken)
+	if reason == nil {
+		return nil
+	}
+	if header.Get("Wext-Fork-Comment") == "true" && (incoming.Action == "opened" || incoming.Action == "reopened") {
+		if err := commentOnPullRequest(pr, reason, accessToken); err != nil {
+			logging.FromContext(ctx).Errorf("Failed to comment on pull request %d: %s", pr.Number, err.Error())
+		}
+	}
+	return reason
+}
+
+// checkForkPolicy applies the fork policy in the Wext-Fork-Policy, Wext-Fork-Permission and Wext-Fork-Team
+// headers to a pull_request event, returning an error explaining why the pull request was rejected
+func checkForkPolicy(header http.Header, pr pullRequest, accessToken string) error {
+	policy := header.Get("Wext-Fork-Policy")
+	if policy == "" || policy == "all" || !pr.Fork {
+		return nil
+	}
+	if policy == "samerepository" {
+		return fmt.Errorf("pull request %d is from a fork, only pull requests from branches of the repository run the pipeline", pr.Number)
+	}
+	if policy != "trusted" {
+		return fmt.Errorf("unknown fork policy %s", policy)
+	}
+
+	if team := header.Get("Wext-Fork-Team"); team != "" && pr.Provider == "github" {
+		inTeam, err := isGitHubTeamMember(pr, team, accessToken)
+		if err != nil {
+			return err
+		}
+		if inTeam {
+			return nil
+		}
+	}
+	if pr.Provider == "gitlab" && pr.Author == "" {
+		username, err := getGitLabUsername(pr, accessToken)
+		if err != nil {
+			return err
+		}
+		pr.Author = username
+	}
+	wanted := header.Get("Wext-Fork-Permission")
+	permission, err := getAuthorPermission(pr, accessToken)
+	if err != nil {
+		return err
+	}
+	if permissionRank(permission) < permissionRank(wanted) {
+		return fmt.Errorf("pull request %d is from a fork by %s, who has %s permission rather than the %s permission needed to run the pipeline", pr.Number, pr.Author, permission, wanted)
+	}
+	return nil
+}
+
+// permissionRank returns the position of the permission in forkPermissions, or -1 for no permission
+func permissionRank(permission string) int {
+	for i, p := range forkPermissions {
+		if p == permission {
+			return i
+		}
+	}
+	return -1
+}
+
+// getAuthorPermission returns the permission of the author of the pull request on the repository,
+// one of forkPermissions or "none"
+func getAuthorPermission(pr pullRequest, accessToken string) (string, error) {
+	switch pr.Provider {
+	case "github", "gitea", "gogs":
+		if pr.Provider != "github" && pr.Author == pr.Owner {
+			return "admin", nil
+		}
+		var result struct {
+			Permission string `json:"permission"`
+		}
+		api := fmt.Sprintf("%s/repos/%s/collaborators/%s/permission", pr.API, pr.Repository, url.PathEscape(pr.Author))
+		err := getJSON(api, http.Header{"Authorization": {"token " + accessToken}}, &result)
+		if err != nil {
+			return "", err
+		}
+		// GitHub has maintain and triage permissions, Gitea an owner permission
+		switch result.Permission {
+		case "admin", "owner", "maintain":
+			return "admin", nil
+		case "write", "read":
+			return result.Permission, nil
+		case "triage":
+			return "read", nil
+		}
+		return "none", nil
+	case "gitlab":
+		// The author is looked up by ID, as the user of the event may be another user updating the merge request
+		var member struct {
+			AccessLevel int `json:"access_level"`
+		}
+		api := fmt.Sprintf("%s/projects/%s/members/all/%d", pr.API, pr.Repository, pr.AuthorID)
+		request, err := http.NewRequest(http.MethodGet, api, nil)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("Private-Token", accessToken)
+		resp, err := apiClient.Do(request)
+		if err != nil {
+			return "", fmt.Errorf("error requesting %s: %s", api, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return "none", nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("error requesting %s. Status: %s", api, resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+			return "", err
+		}
+		permission := "none"
+		for _, p := range forkPermissions {
+			if member.AccessLevel >= gitLabAccessLevels[p] {
+				permission = p
+			}
+		}
+		return permission, nil
+	}
+	return "", fmt.Errorf("author permissions are not supported for %s", pr.Provider)
+}
+
+// getGitLabUsername returns the username of the author of the merge request
+func getGitLabUsername(pr pullRequest, accessToken string) (string, error) {
+	var user struct {
+		Username string `json:"username"`
+	}
+	api := fmt.Sprintf("%s/users/%d", pr.API, pr.AuthorID)
+	if err := getJSON(api, http.Header{"Private-Token": {accessToken}}, &user); err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
+// isGitHubTeamMember returns whether the author of the pull request is an active member of the team of the
+// organization of the repository
+func isGitHubTeamMember(pr pullRequest, team, accessToken string) (bool, error) {
+	var membership struct {
+		State string `json:"state"`
+	}
+	api := fmt.Sprintf("%s/orgs/%s/teams/%s/memberships/%s", pr.API, url.PathEscape(pr.Owner), url.PathEscape(team), url.PathEscape(pr.Author))
+	request, err := http.NewRequest(http.MethodGet, api, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Authorization", "token "+accessToken)
+	resp, err := apiClient.Do(request)
+	if err != nil {
+		return false, fmt.Errorf("error checking team membership: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("error checking team membership. Status: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&membership); err != nil {
+		return false, err
+	}
+	return membership.State == "active", nil
+}
+
+// commentOnPullRequest explains the rejection of the pull request in a comment on it
+func commentOnPullRequest(pr pullRequest, reason error, accessToken string) error {
+	body := map[string]string{}
+	var api string
+	header := http.Header{"Content-Type": {"application/json"}}
+	message := fmt.Sprintf("The pipeline was not run: %s.", reason)
+	switch pr.Provider {
+	case "github", "gitea", "gogs":
+		api = fmt.Sprintf("%s/repos/%s/issues/%d/comments", pr.API, pr.Repository, pr.Number)
+		header.Set("Authorization", "token "+accessToken)
+		body["body"] = message + " A member of the repository can run it by commenting /ok-to-test."
+	case "gitlab":
+		api = fmt.Sprintf("%s/projects/%s/merge_requests/%d/notes", pr.API, pr.Repository, pr.Number)
+		header.Set("Private-Token", accessToken)
+		body["body"] = message + " A member of the project can run it by commenting /ok-to-test."
+	default:
+		return fmt.Errorf("comments are not supported for %s", pr.Provider)
+	}
+
+	marshalled, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, api, bytes.NewReader(marshalled))
+	if err != nil {
+		return err
+	}
+	request.Header = header
+	resp, err := apiClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error commenting on pull request %d: %s", pr.Number, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("error commenting on pull request %d. Status: %s", pr.Number, resp.Status)
+	}
+	return nil
+}
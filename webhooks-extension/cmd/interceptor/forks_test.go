@@ -0,0 +1,266 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetPullRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming gitEvent
+		expected pullRequest
+	}{
+		{
+			name: "github fork",
+			incoming: gitEvent{Provider: "github", Payload: []byte(`{"number": 7,
+				"pull_request": {"user": {"login": "author"}, "head": {"repo": {"full_name": "author/repo"}}},
+				"repository": {"full_name": "owner/repo", "html_url": "https://github.com/owner/repo", "owner": {"login": "owner"}}}`)},
+			expected: pullRequest{Provider: "github", Number: 7, Author: "author", Fork: true, Repository: "owner/repo", Owner: "owner", API: "https://api.github.com"},
+		},
+		{
+			name: "github deleted fork",
+			incoming: gitEvent{Provider: "github", Payload: []byte(`{"number": 7,
+				"pull_request": {"user": {"login": "author"}, "head": {"repo": null}},
+				"repository": {"full_name": "owner/repo", "html_url": "https://github.com/owner/repo", "owner": {"login": "owner"}}}`)},
+			expected: pullRequest{Provider: "github", Number: 7, Author: "author", Fork: true, Repository: "owner/repo", Owner: "owner", API: "https://api.github.com"},
+		},
+		{
+			name: "gitea branch",
+			incoming: gitEvent{Provider: "gitea", Payload: []byte(`{"number": 7,
+				"pull_request": {"user": {"login": "author"}, "head": {"repo": {"full_name": "owner/repo"}}},
+				"repository": {"full_name": "owner/repo", "html_url": "https://gitea.example.com/owner/repo", "owner": {"login": "owner"}}}`)},
+			expected: pullRequest{Provider: "gitea", Number: 7, Author: "author", Repository: "owner/repo", Owner: "owner", API: "https://gitea.example.com/api/v1"},
+		},
+		{
+			name: "gitlab fork",
+			incoming: gitEvent{Provider: "gitlab", Payload: []byte(`{"user": {"id": 3, "username": "author"},
+				"project": {"web_url": "https://gitlab.example.com/owner/repo"},
+				"object_attributes": {"iid": 7, "author_id": 3, "source_project_id": 43, "target_project_id": 42}}`)},
+			expected: pullRequest{Provider: "gitlab", Number: 7, Author: "author", AuthorID: 3, Fork: true, Repository: "42", API: "https://gitlab.example.com/api/v4"},
+		},
+		{
+			name: "gitlab fork updated by a maintainer",
+			incoming: gitEvent{Provider: "gitlab", Payload: []byte(`{"user": {"id": 1, "username": "maintainer"},
+				"project": {"web_url": "https://gitlab.example.com/owner/repo"},
+				"object_attributes": {"iid": 7, "author_id": 3, "source_project_id": 43, "target_project_id": 42}}`)},
+			expected: pullRequest{Provider: "gitlab", Number: 7, AuthorID: 3, Fork: true, Repository: "42", API: "https://gitlab.example.com/api/v4"},
+		},
+		{
+			name: "bitbucket server branch",
+			incoming: gitEvent{Provider: "bitbucket", Payload: []byte(`{"pullRequest": {"id": 7, "author": {"user": {"name": "author"}},
+				"fromRef": {"repository": {"id": 1}}, "toRef": {"repository": {"id": 1}}}}`)},
+			expected: pullRequest{Provider: "bitbucket", Number: 7, Author: "author"},
+		},
+		{
+			name: "bitbucket cloud fork",
+			incoming: gitEvent{Provider: "bitbucket", Payload: []byte(`{"pullrequest": {"id": 7, "author": {"nickname": "author"},
+				"source": {"repository": {"full_name": "author/repo"}}, "destination": {"repository": {"full_name": "owner/repo"}}}}`)},
+			expected: pullRequest{Provider: "bitbucket", Number: 7, Author: "author", Fork: true},
+		},
+	}
+	for _, tt := range tests {
+		pr, err := getPullRequest(tt.incoming)
+		if err != nil {
+			t.Errorf("%s: getPullRequest() returned an error: %s", tt.name, err)
+			continue
+		}
+		if pr != tt.expected {
+			t.Errorf("%s: pull request read as %+v, expected %+v", tt.name, pr, tt.expected)
+		}
+	}
+}
+
+// newFakePermissionAPI returns a server for the GitHub Enterprise, Gitea and GitLab APIs, where writer has
+// write permission on every repository, reader has read permission and member is in the team reviewers.
+// Comments are recorded in comments.
+func newFakePermissionAPI(token string, comments *[]string) *httptest.Server {
+	mux := http.NewServeMux()
+	authorized := func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "token "+token || r.Header.Get("Private-Token") == token
+	}
+	permission := func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		user := strings.TrimSuffix(r.URL.Path[strings.Index(r.URL.Path, "/collaborators/")+len("/collaborators/"):], "/permission")
+		permissions := map[string]string{"writer": "write", "reader": "read", "maintainer": "maintain"}
+		if permissions[user] == "" {
+			fmt.Fprint(w, `{"permission": "none"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"permission": "%s"}`, permissions[user])
+	}
+	comment := func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		*comments = append(*comments, body["body"])
+		w.WriteHeader(http.StatusCreated)
+	}
+	mux.HandleFunc("/api/v3/repos/owner/repo/collaborators/", permission)
+	mux.HandleFunc("/api/v3/repos/owner/repo/issues/7/comments", comment)
+	mux.HandleFunc("/api/v3/orgs/owner/teams/reviewers/memberships/member", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"state": "active"}`)
+	})
+	mux.HandleFunc("/gitea/api/v1/repos/owner/repo/collaborators/", permission)
+	// GitLab users by ID, with the access level of those who are members of project 42
+	gitLabUsers := map[string]string{"1": "maintainer", "2": "writer", "3": "reader", "4": "someone"}
+	memberAccessLevels := map[string]int{"1": 40, "2": 30, "3": 20}
+	mux.HandleFunc("/api/v4/projects/42/members/all/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v4/projects/42/members/all/")
+		if !authorized(r) || memberAccessLevels[id] == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"username": gitLabUsers[id], "access_level": memberAccessLevels[id]})
+	})
+	mux.HandleFunc("/api/v4/users/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v4/users/")
+		if gitLabUsers[id] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "username": gitLabUsers[id]})
+	})
+	mux.HandleFunc("/api/v4/projects/42/merge_requests/7/notes", comment)
+	return httptest.NewServer(mux)
+}
+
+func TestCheckForkPolicy(t *testing.T) {
+	var comments []string
+	server := newFakePermissionAPI("access", &comments)
+	defer server.Close()
+
+	header := func(policy, permission, team string) http.Header {
+		h := http.Header{}
+		h.Set("Wext-Fork-Policy", policy)
+		if permission != "" {
+			h.Set("Wext-Fork-Permission", permission)
+		}
+		if team != "" {
+			h.Set("Wext-Fork-Team", team)
+		}
+		return h
+	}
+	gitHub := func(author string, fork bool) pullRequest {
+		return pullRequest{Provider: "github", Number: 7, Author: author, Fork: fork, Repository: "owner/repo", Owner: "owner", API: server.URL + "/api/v3"}
+	}
+	gitea := func(author string) pullRequest {
+		return pullRequest{Provider: "gitea", Number: 7, Author: author, Fork: true, Repository: "owner/repo", Owner: "owner", API: server.URL + "/gitea/api/v1"}
+	}
+	gitLab := func(author string, authorID int) pullRequest {
+		return pullRequest{Provider: "gitlab", Number: 7, Author: author, AuthorID: authorID, Fork: true, Repository: "42", API: server.URL + "/api/v4"}
+	}
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		pr          pullRequest
+		token       string
+		expectError bool
+	}{
+		{name: "no policy", header: http.Header{}, pr: gitHub("someone", true)},
+		{name: "all", header: header("all", "", ""), pr: gitHub("someone", true)},
+		{name: "same repository branch", header: header("samerepository", "", ""), pr: gitHub("someone", false)},
+		{name: "same repository fork", header: header("samerepository", "", ""), pr: gitHub("writer", true), expectError: true},
+		{name: "trusted branch", header: header("trusted", "admin", ""), pr: gitHub("someone", false)},
+		{name: "trusted writer", header: header("trusted", "write", ""), pr: gitHub("writer", true)},
+		{name: "trusted maintainer", header: header("trusted", "admin", ""), pr: gitHub("maintainer", true)},
+		{name: "trusted reader", header: header("trusted", "write", ""), pr: gitHub("reader", true), expectError: true},
+		{name: "trusted someone", header: header("trusted", "read", ""), pr: gitHub("someone", true), expectError: true},
+		{name: "trusted team member", header: header("trusted", "admin", "reviewers"), pr: gitHub("member", true)},
+		{name: "trusted not team member", header: header("trusted", "write", "reviewers"), pr: gitHub("someone", true), expectError: true},
+		{name: "trusted bad token", header: header("trusted", "write", ""), pr: gitHub("writer", true), token: "wrong", expectError: true},
+		{name: "gitea owner", header: header("trusted", "admin", ""), pr: gitea("owner")},
+		{name: "gitea writer", header: header("trusted", "write", ""), pr: gitea("writer")},
+		{name: "gitea reader", header: header("trusted", "write", ""), pr: gitea("reader"), expectError: true},
+		{name: "gitlab writer", header: header("trusted", "write", ""), pr: gitLab("writer", 2)},
+		{name: "gitlab reader", header: header("trusted", "write", ""), pr: gitLab("reader", 3), expectError: true},
+		{name: "gitlab reader for read", header: header("trusted", "read", ""), pr: gitLab("reader", 3)},
+		{name: "gitlab not a member", header: header("trusted", "read", ""), pr: gitLab("someone", 4), expectError: true},
+		{name: "gitlab reader's merge request updated by a maintainer", header: header("trusted", "write", ""), pr: gitLab("", 3), expectError: true},
+		{name: "unknown policy", header: header("sometimes", "", ""), pr: gitHub("writer", true), expectError: true},
+	}
+	for _, tt := range tests {
+		token := tt.token
+		if token == "" {
+			token = "access"
+		}
+		err := checkForkPolicy(tt.header, tt.pr, token)
+		if tt.expectError && err == nil {
+			t.Errorf("%s: checkForkPolicy() did not return an error", tt.name)
+		}
+		if !tt.expectError && err != nil {
+			t.Errorf("%s: checkForkPolicy() returned an error: %s", tt.name, err)
+		}
+	}
+	if len(comments) != 0 {
+		t.Errorf("checkForkPolicy() commented on pull requests: %v", comments)
+	}
+}
+
+func TestEnforceForkPolicyComments(t *testing.T) {
+	var comments []string
+	server := newFakePermissionAPI("access", &comments)
+	defer server.Close()
+
+	payload := []byte(fmt.Sprintf(`{"number": 7,
+		"pull_request": {"user": {"login": "someone"}, "head": {"repo": {"full_name": "someone/repo"}}},
+		"repository": {"full_name": "owner/repo", "html_url": "%s/owner/repo", "owner": {"login": "owner"}}}`, server.URL))
+	header := http.Header{}
+	header.Set("Wext-Fork-Policy", "samerepository")
+
+	// Rejections are only explained in comments when asked for
+	if err := enforceForkPolicy(context.Background(), header, gitEvent{Provider: "github", Action: "opened", Payload: payload}, "access"); err == nil {
+		t.Error("enforceForkPolicy() did not return an error")
+	}
+	if len(comments) != 0 {
+		t.Errorf("Expected no comments, got %v", comments)
+	}
+
+	// and only on newly opened pull requests
+	header.Set("Wext-Fork-Comment", "true")
+	for _, action := range []string{"opened", "synchronize", "reopened"} {
+		if err := enforceForkPolicy(context.Background(), header, gitEvent{Provider: "github", Action: action, Payload: payload}, "access"); err == nil {
+			t.Errorf("enforceForkPolicy() did not return an error for action %s", action)
+		}
+	}
+	if len(comments) != 2 || !strings.Contains(comments[0], "is from a fork") || !strings.Contains(comments[0], "/ok-to-test") {
+		t.Errorf("Expected two comments explaining the rejection, got %v", comments)
+	}
+
+	// The merge request of a reader, reopened by a maintainer
+	gitLabPayload := []byte(fmt.Sprintf(`{"user": {"id": 1, "username": "maintainer"}, "project": {"web_url": "%s/owner/repo"},
+		"object_attributes": {"iid": 7, "author_id": 3, "source_project_id": 43, "target_project_id": 42}}`, server.URL))
+	header.Set("Wext-Fork-Policy", "trusted")
+	header.Set("Wext-Fork-Permission", "write")
+	if err := enforceForkPolicy(context.Background(), header, gitEvent{Provider: "gitlab", Action: "opened", Payload: gitLabPayload}, "access"); err == nil {
+		t.Error("enforceForkPolicy() did not return an error for the GitLab merge request")
+	}
+	if len(comments) != 3 || !strings.Contains(comments[2], "reader, who has read permission") {
+		t.Errorf("Expected a comment on the GitLab merge request, got %v", comments)
+	}
+}
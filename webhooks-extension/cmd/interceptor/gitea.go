@@ -0,0 +1,120 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// GiteaResult holds the fields of Gitea and Gogs payloads needed to validate
+// an event and add extras to it
+type GiteaResult struct {
+	PushCommits
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Repository struct {
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	PullRequest struct {
+		Head struct {
+			Ref string `json:"ref"`
+			Sha string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// validateGiteaEvent checks the X-Gitea-Signature of a Gitea event, or the
+// X-Gogs-Signature of a Gogs event, which is the hex encoded HMAC SHA256 of the payload
+func validateGiteaEvent(provider string, request *http.Request, secretToken []byte) (gitEvent, error) {
+	headerPrefix := "X-Gitea-"
+	if provider == "gogs" {
+		headerPrefix = "X-Gogs-"
+	}
+
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return gitEvent{}, fmt.Errorf("error %s reading payload", err.Error())
+	}
+
+	signature, err := hex.DecodeString(request.Header.Get(headerPrefix + "Signature"))
+	if err != nil || len(signature) == 0 {
+		return gitEvent{}, fmt.Errorf("missing or malformed %sSignature header", headerPrefix)
+	}
+	mac := hmac.New(sha256.New, secretToken)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return gitEvent{}, errors.New("payload signature check failed")
+	}
+
+	var result GiteaResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return gitEvent{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+
+	action := result.Action
+	// Gitea and Gogs report new commits on a pull request as "synchronized"
+	if action == "synchronized" {
+		action = "synchronize"
+	}
+
+	return gitEvent{
+		Provider:     provider,
+		Event:        request.Header.Get(headerPrefix + "Event"),
+		Action:       action,
+		Ref:          result.Ref,
+		ChangedFiles: result.changedFiles(),
+		CloneURL:     result.Repository.CloneURL,
+		DeliveryID:   request.Header.Get(headerPrefix + "Delivery"),
+		Payload:      payload,
+	}, nil
+}
+
+// Adds branch and a suggested image tag to a Gitea or Gogs payload
+func addExtrasToGiteaPayload(event string, payload []byte) ([]byte, error) {
+	var result GiteaResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+
+	var ref, commit string
+	if "push" == event {
+		ref = result.Ref
+		commit = result.After
+	} else if "pull_request" == event {
+		ref = result.PullRequest.Head.Ref
+		commit = result.PullRequest.Head.Sha
+	} else {
+		return payload, nil
+	}
+	if len(commit) < 7 && !strings.HasPrefix(ref, "refs/tags/") {
+		return nil, fmt.Errorf("commit %q for ref %s is too short to suggest an image tag", commit, ref)
+	}
+
+	var toReturn map[string]interface{}
+	if err := json.Unmarshal(payload, &toReturn); err != nil {
+		return nil, err
+	}
+	toReturn["webhooks-tekton-git-branch"] = ref[strings.LastIndex(ref, "/")+1:]
+	toReturn["webhooks-tekton-image-tag"] = getSuggestedTag(ref, commit)
+	return json.Marshal(toReturn)
+}
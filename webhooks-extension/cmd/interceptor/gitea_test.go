@@ -0,0 +1,125 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const giteaPullRequestPayload = `{
+	"action": "synchronized",
+	"pull_request": {
+		"head": {"ref": "feature/foo", "sha": "9h3f39fu3hf39uh33"}
+	},
+	"repository": {"clone_url": "https://gitea.company.com/owner/repo.git"}
+}`
+
+func signGiteaPayload(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidateGiteaEvent(t *testing.T) {
+	for _, provider := range []string{"gitea", "gogs"} {
+		headerPrefix := "X-Gitea-"
+		if provider == "gogs" {
+			headerPrefix = "X-Gogs-"
+		}
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(giteaPullRequestPayload))
+		request.Header.Set(headerPrefix+"Signature", signGiteaPayload(giteaPullRequestPayload, "mySecret"))
+		request.Header.Set(headerPrefix+"Event", "pull_request")
+		request.Header.Set(headerPrefix+"Delivery", "my-delivery-id")
+
+		incoming, err := validateGiteaEvent(provider, request, []byte("mySecret"))
+		if err != nil {
+			t.Fatalf("Error in validateGiteaEvent for %s %s", provider, err)
+		}
+		if incoming.Event != "pull_request" {
+			t.Errorf("Event not read as expected for %s, event was returned as %s", provider, incoming.Event)
+		}
+		if incoming.Action != "synchronize" {
+			t.Errorf("Action not mapped as expected for %s, action was returned as %s", provider, incoming.Action)
+		}
+		if incoming.CloneURL != "https://gitea.company.com/owner/repo.git" {
+			t.Errorf("Clone URL not read as expected for %s, clone URL was returned as %s", provider, incoming.CloneURL)
+		}
+		if incoming.DeliveryID != "my-delivery-id" {
+			t.Errorf("Delivery ID not read as expected for %s, delivery ID was returned as %s", provider, incoming.DeliveryID)
+		}
+	}
+}
+
+func TestValidateGiteaEventBadSignature(t *testing.T) {
+	for _, signature := range []string{"", "not-hex", signGiteaPayload(giteaPullRequestPayload, "notMySecret")} {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(giteaPullRequestPayload))
+		request.Header.Set("X-Gitea-Signature", signature)
+		request.Header.Set("X-Gitea-Event", "pull_request")
+
+		if _, err := validateGiteaEvent("gitea", request, []byte("mySecret")); err == nil {
+			t.Errorf("validateGiteaEvent did not return an error for signature %q", signature)
+		}
+	}
+}
+
+func TestAddExtrasToGiteaPullRequestPayload(t *testing.T) {
+	bytes, err := addExtrasToGiteaPayload("pull_request", []byte(giteaPullRequestPayload))
+	if err != nil {
+		t.Errorf("Error in addExtrasToGiteaPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "foo" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "9h3f39f" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+	if "synchronized" != p["action"] {
+		t.Errorf("Original payload not preserved, action was returned as %s", p["action"])
+	}
+}
+
+func TestAddExtrasToGiteaPushPayload(t *testing.T) {
+	payload := []byte(`{"ref": "refs/tags/v1.0.0", "after": "12dee2323r2ef232ef2redw2"}`)
+
+	bytes, err := addExtrasToGiteaPayload("push", payload)
+	if err != nil {
+		t.Errorf("Error in addExtrasToGiteaPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "v1.0.0" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "v1.0.0" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+}
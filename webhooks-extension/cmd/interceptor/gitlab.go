@@ -0,0 +1,164 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type GitLabResult struct {
+	PushCommits
+	ObjectKind string `json:"object_kind"`
+	// Action is the action of a release event
+	Action  string `json:"action"`
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Action       string `json:"action"`
+		OldRev       string `json:"oldrev"`
+		SourceBranch string `json:"source_branch"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+// validateGitLabEvent checks the X-Gitlab-Token of a GitLab event and maps it
+// onto the equivalent GitHub event and action
+func validateGitLabEvent(request *http.Request, secretToken []byte) (gitEvent, error) {
+	token := request.Header.Get("X-Gitlab-Token")
+	if len(secretToken) == 0 || subtle.ConstantTimeCompare([]byte(token), secretToken) != 1 {
+		return gitEvent{}, errors.New("X-Gitlab-Token does not match the secret token")
+	}
+
+	payload, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return gitEvent{}, fmt.Errorf("error %s reading payload", err.Error())
+	}
+
+	var result GitLabResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return gitEvent{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+
+	event := getGitLabEventType(request.Header.Get("X-Gitlab-Event"))
+	var action string
+	switch event {
+	case "issue_comment":
+		// Note events are only sent for new comments
+		action = "created"
+	case "release":
+		action = getGitLabReleaseAction(result.Action)
+	default:
+		action = getGitLabAction(result.ObjectAttributes.Action, result.ObjectAttributes.OldRev)
+	}
+
+	return gitEvent{
+		Provider:     "gitlab",
+		Event:        event,
+		Action:       action,
+		Ref:          result.Ref,
+		ChangedFiles: result.changedFiles(),
+		CloneURL:     result.Project.GitHTTPURL,
+		DeliveryID:   request.Header.Get("X-Gitlab-Event-UUID"),
+		Payload:      payload,
+	}, nil
+}
+
+// getGitLabEventType returns the GitHub name of the GitLab event, unknown events are returned unchanged
+func getGitLabEventType(event string) string {
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		return "push"
+	case "Merge Request Hook":
+		return "pull_request"
+	case "Note Hook":
+		return "issue_comment"
+	case "Release Hook":
+		return "release"
+	}
+	return event
+}
+
+// getGitLabReleaseAction returns the GitHub name of the GitLab release action
+func getGitLabReleaseAction(action string) string {
+	switch action {
+	case "create":
+		return "published"
+	case "update":
+		return "edited"
+	case "delete":
+		return "deleted"
+	}
+	return action
+}
+
+// getGitLabAction returns the GitHub name of the GitLab merge request action.
+// GitLab reports new commits and edits of the merge request both as "update",
+// only the former has an oldrev.
+func getGitLabAction(action, oldRev string) string {
+	switch action {
+	case "open":
+		return "opened"
+	case "reopen":
+		return "reopened"
+	case "update":
+		if oldRev != "" {
+			return "synchronize"
+		}
+		return "edited"
+	case "close", "merge":
+		return "closed"
+	}
+	return action
+}
+
+// Adds branch and a suggested image tag to a GitLab payload
+func addExtrasToGitLabPayload(event string, payload []byte) ([]byte, error) {
+	var result GitLabResult
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, err
+	}
+
+	var ref, commit string
+	if "push" == event {
+		ref = result.Ref
+		commit = result.After
+	} else if "pull_request" == event {
+		ref = result.ObjectAttributes.SourceBranch
+		commit = result.ObjectAttributes.LastCommit.ID
+	} else {
+		return payload, nil
+	}
+	if len(commit) < 7 && !strings.HasPrefix(ref, "refs/tags/") {
+		return nil, fmt.Errorf("commit %q for ref %s is too short to suggest an image tag", commit, ref)
+	}
+
+	var toReturn map[string]interface{}
+	if err := json.Unmarshal(payload, &toReturn); err != nil {
+		return nil, err
+	}
+	toReturn["webhooks-tekton-git-branch"] = ref[strings.LastIndex(ref, "/")+1:]
+	toReturn["webhooks-tekton-image-tag"] = getSuggestedTag(ref, commit)
+	return json.Marshal(toReturn)
+}
@@ -0,0 +1,136 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const gitLabMergeRequestPayload = `{
+	"object_kind": "merge_request",
+	"project": {"git_http_url": "https://gitlab.com/owner/repo.git"},
+	"object_attributes": {
+		"action": "update",
+		"oldrev": "0a1b2c3d4e5f",
+		"source_branch": "feature/foo",
+		"last_commit": {"id": "9h3f39fu3hf39uh33"}
+	}
+}`
+
+func TestValidateGitLabEvent(t *testing.T) {
+	request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(gitLabMergeRequestPayload))
+	request.Header.Set("X-Gitlab-Token", "mySecret")
+	request.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+	request.Header.Set("X-Gitlab-Event-UUID", "my-uuid")
+
+	incoming, err := validateGitLabEvent(request, []byte("mySecret"))
+	if err != nil {
+		t.Fatalf("Error in validateGitLabEvent %s", err)
+	}
+	if incoming.Event != "pull_request" {
+		t.Errorf("Event not mapped as expected, event was returned as %s", incoming.Event)
+	}
+	if incoming.Action != "synchronize" {
+		t.Errorf("Action not mapped as expected, action was returned as %s", incoming.Action)
+	}
+	if incoming.CloneURL != "https://gitlab.com/owner/repo.git" {
+		t.Errorf("Clone URL not read as expected, clone URL was returned as %s", incoming.CloneURL)
+	}
+	if incoming.DeliveryID != "my-uuid" {
+		t.Errorf("Delivery ID not read as expected, delivery ID was returned as %s", incoming.DeliveryID)
+	}
+}
+
+func TestValidateGitLabReleaseAndNoteEvents(t *testing.T) {
+	tests := []struct {
+		hookEvent, payload, event, action string
+	}{
+		{hookEvent: "Release Hook", payload: `{"object_kind": "release", "action": "create"}`, event: "release", action: "published"},
+		{hookEvent: "Release Hook", payload: `{"object_kind": "release", "action": "update"}`, event: "release", action: "edited"},
+		{hookEvent: "Note Hook", payload: `{"object_kind": "note", "object_attributes": {"noteable_type": "MergeRequest"}}`, event: "issue_comment", action: "created"},
+	}
+	for _, tt := range tests {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tt.payload))
+		request.Header.Set("X-Gitlab-Token", "mySecret")
+		request.Header.Set("X-Gitlab-Event", tt.hookEvent)
+
+		incoming, err := validateGitLabEvent(request, []byte("mySecret"))
+		if err != nil {
+			t.Fatalf("Error in validateGitLabEvent %s", err)
+		}
+		if incoming.Event != tt.event || incoming.Action != tt.action {
+			t.Errorf("%s with payload %s mapped to event %s and action %s, expected %s and %s", tt.hookEvent, tt.payload, incoming.Event, incoming.Action, tt.event, tt.action)
+		}
+	}
+}
+
+func TestValidateGitLabEventBadToken(t *testing.T) {
+	for _, token := range []string{"", "notMySecret"} {
+		request, _ := http.NewRequest(http.MethodPost, "/", bytes.NewBufferString(gitLabMergeRequestPayload))
+		request.Header.Set("X-Gitlab-Token", token)
+		request.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+
+		if _, err := validateGitLabEvent(request, []byte("mySecret")); err == nil {
+			t.Errorf("validateGitLabEvent did not return an error for token %q", token)
+		}
+	}
+}
+
+func TestAddExtrasToGitLabPushPayload(t *testing.T) {
+	payload := []byte(`{"object_kind": "push", "ref": "refs/heads/master", "after": "12dee2323r2ef232ef2redw2"}`)
+
+	bytes, err := addExtrasToGitLabPayload("push", payload)
+	if err != nil {
+		t.Errorf("Error in addExtrasToGitLabPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "master" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "12dee23" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+	if "push" != p["object_kind"] {
+		t.Errorf("Original payload not preserved, object_kind was returned as %s", p["object_kind"])
+	}
+}
+
+func TestAddExtrasToGitLabMergeRequestPayload(t *testing.T) {
+	bytes, err := addExtrasToGitLabPayload("pull_request", []byte(gitLabMergeRequestPayload))
+	if err != nil {
+		t.Errorf("Error in addExtrasToGitLabPayload %s", err)
+	}
+
+	var p map[string]interface{}
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		t.Errorf("Error in json.Unmarshal %s", err)
+	}
+
+	if "foo" != p["webhooks-tekton-git-branch"] {
+		t.Errorf("Branch name not added as expected, branch was returned as %s", p["webhooks-tekton-git-branch"])
+	}
+	if "9h3f39f" != p["webhooks-tekton-image-tag"] {
+		t.Errorf("Suggested image tag not added as expected, tag was returned as %s", p["webhooks-tekton-image-tag"])
+	}
+}
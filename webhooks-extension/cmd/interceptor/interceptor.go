@@ -14,15 +14,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/go-github/github"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/metrics"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -32,12 +39,28 @@ const (
 )
 
 type Result struct {
+	PushCommits
 	Action     string `json:"action"`
+	Ref        string `json:"ref"`
 	Repository struct {
 		CloneURL string `json:"clone_url"`
 	} `json:"repository"`
 }
 
+// gitEvent is the provider independent view of an incoming webhook event.
+// Event and Action use the GitHub names, for example "pull_request" and "opened".
+// Ref is the ref pushed by a push event and ChangedFiles the files changed by its commits.
+type gitEvent struct {
+	Provider     string
+	Event        string
+	Action       string
+	Ref          string
+	ChangedFiles []string
+	CloneURL     string
+	DeliveryID   string
+	Payload      []byte
+}
+
 type PushPayload struct {
 	github.PushEvent
 	WebhookBranch            string `json:"webhooks-tekton-git-branch"`
@@ -51,116 +74,224 @@ type PullRequestPayload struct {
 }
 
 func main() {
-	log.Print("Interceptor started")
+	logging.Log.Info("Interceptor started")
+	metrics.RegisterKubernetesClientMetrics()
 
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		foundTriggerName := request.Header.Get("Wext-Trigger-Name")
+	shutdownTracing, err := tracing.Init("webhooks-extension-interceptor")
+	if err != nil {
+		logging.Log.Fatalf("Error setting up tracing: %s", err.Error())
+	}
 
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			log.Printf("[%s] Error creating in cluster config: %s", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
-		}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		logging.Log.Fatalf("Error creating in cluster config: %s", err.Error())
+	}
 
-		clientset, err := kubernetes.NewForConfig(config)
-		if err != nil {
-			log.Printf("[%s] Error creating new clientset: %s", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
-		}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		logging.Log.Fatalf("Error creating new clientset: %s", err.Error())
+	}
+
+	// Change the log level as the logging ConfigMap changes
+	go logging.WatchLevel(clientset, os.Getenv("INSTALLED_NAMESPACE"), make(chan struct{}))
+
+	secrets, err := newSecretCache(clientset, os.Getenv("INSTALLED_NAMESPACE"), make(chan struct{}))
+	if err != nil {
+		logging.Log.Fatalf("Error creating secret cache: %s", err.Error())
+	}
+
+	deliveries, err := newDeliveryStore(clientset)
+	if err != nil {
+		logging.Log.Fatalf("Error creating delivery store: %s", err.Error())
+	}
+
+	srvConfig, err := getServerConfig()
+	if err != nil {
+		logging.Log.Fatalf("Error reading server configuration: %s", err.Error())
+	}
+
+	mux := http.NewServeMux()
+	h := &health{}
+	registerHealthHandlers(mux, h)
+	mux.Handle("/metrics", promhttp.Handler())
 
-		foundNamespace := os.Getenv("INSTALLED_NAMESPACE")
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		foundTriggerName := request.Header.Get("Wext-Trigger-Name")
 		foundSecretName := request.Header.Get("Wext-Secret-Name")
 
-		foundSecret, err := clientset.CoreV1().Secrets(foundNamespace).Get(foundSecretName, metav1.GetOptions{})
+		// reason is why validation failed, or empty once validation passes
+		start, reason := time.Now(), reasonError
+		ctx, span := startValidationSpan(request.Context(), foundTriggerName)
+		logger := logging.Log.With("trigger", foundTriggerName)
+		defer func() {
+			recordValidation(reason, start)
+			endValidationSpan(span, reason)
+		}()
 
+		foundSecret, err := secrets.get(foundSecretName)
 		if err != nil {
-			log.Printf("[%s] Error getting the secret %s to validate: %s", foundTriggerName, foundSecretName, err.Error())
+			reason = reasonSecret
+			logger.Errorf("Error getting the secret %s to validate: %s", foundSecretName, err.Error())
 			http.Error(writer, fmt.Sprint(err), http.StatusBadRequest)
 			return
 		}
 
 		wantedRepoURL := request.Header.Get("Wext-Repository-Url")
+		provider := request.Header.Get("Wext-Git-Provider")
 
-		payload, err := github.ValidatePayload(request, foundSecret.Data["secretToken"])
-		if err != nil {
-			log.Printf("[%s] Validation FAIL (error %s validating payload)", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
-			return
-		}
-
-		var result Result
-		err = json.Unmarshal(payload, &result)
-		if err != nil {
-			log.Printf("[%s] Validation FAIL (error %s marshalling payload as JSON)", foundTriggerName, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
-			return
+		var incoming gitEvent
+		switch provider {
+		case "", "github":
+			incoming, err = validateGitHubEvent(request, foundSecret.Data["secretToken"])
+		case "gitlab":
+			incoming, err = validateGitLabEvent(request, foundSecret.Data["secretToken"])
+		case "bitbucket":
+			incoming, err = validateBitbucketEvent(request, foundSecret.Data["secretToken"])
+		case "gitea", "gogs":
+			incoming, err = validateGiteaEvent(provider, request, foundSecret.Data["secretToken"])
+		default:
+			err = fmt.Errorf("unsupported git provider %s", provider)
 		}
-
-		cloneURL := result.Repository.CloneURL
-		log.Printf("[%s] Clone URL coming in as JSON: %s", foundTriggerName, cloneURL)
-
-		id := github.DeliveryID(request)
 		if err != nil {
-			log.Printf("[%s] Error handling GitHub Event with delivery ID %s: %s", foundTriggerName, id, err.Error())
-			http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+			reason = reasonPayload
+			logger.Infof("Validation FAIL (error %s validating payload)", err.Error())
+			http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
 			return
 		}
 
-		log.Printf("[%s] Handling GitHub Event with delivery ID: %s", foundTriggerName, id)
+		setEventAttributes(span, incoming)
+		cloneURL := incoming.CloneURL
+		id := incoming.DeliveryID
+		logger = logger.With("provider", incoming.Provider, "deliveryID", id, "repository", sanitizeGitInput(cloneURL))
+		ctx = logging.WithLogger(ctx, logger)
+		logger.Debugf("Handling %s event", incoming.Event)
 
 		validationPassed := false
 
 		if sanitizeGitInput(cloneURL) == sanitizeGitInput(wantedRepoURL) {
 			if request.Header.Get("Wext-Incoming-Event") != "" {
 				wantedEvent := request.Header.Get("Wext-Incoming-Event")
-				foundEvent := request.Header.Get("X-Github-Event")
-				if wantedEvent == foundEvent { // Wanted GitHub event type provided AND repository URL matches so all is well
+				foundEvent := incoming.Event
+				if matchesEvent(wantedEvent, incoming) { // Wanted GitHub event type provided AND repository URL matches so all is well
 					wantedActions := request.Header["Wext-Incoming-Actions"]
 					if len(wantedActions) == 0 {
 						validationPassed = true
-						log.Printf("[%s] Validation PASS (repository URL, secret payload, event type checked)", foundTriggerName)
+						logger.Info("Validation PASS (repository URL, secret payload, event type checked)")
 					} else {
 						actions := strings.Split(wantedActions[0], ",")
 						for _, action := range actions {
-							if action == result.Action {
+							if action == incoming.Action {
 								validationPassed = true
-								log.Printf("[%s] Validation PASS (repository URL, secret payload, event type, action:%s checked)", foundTriggerName, action)
+								logger.Infof("Validation PASS (repository URL, secret payload, event type, action:%s checked)", action)
 							}
 						}
 					}
 				} else {
-					log.Printf("[%s] Validation FAIL (event type does not match, got %s but wanted %s)", foundTriggerName, foundEvent, wantedEvent)
+					reason = reasonEvent
+					logger.Infof("Validation FAIL (event type does not match, got %s but wanted %s)", foundEvent, wantedEvent)
 					http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
 					return
 				}
 			} else { // No wanted GitHub event type provided, but the repository URL matches so all is well
-				log.Printf("[%s] Validation PASS (repository URL and secret payload checked)", foundTriggerName)
+				logger.Info("Validation PASS (repository URL and secret payload checked)")
 				validationPassed = true
 			}
 
 			if validationPassed {
-				returnPayload, err := addExtrasToPayload(request.Header.Get("X-Github-Event"), payload)
+				if err := checkFilters(request.Header, incoming); err != nil {
+					reason = reasonFilter
+					logger.Infof("Validation FAIL (%s)", err.Error())
+					http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
+					return
+				}
+
+				if incoming.Event == "pull_request" && request.Header.Get("Wext-Fork-Policy") != "" {
+					forkCtx, forkSpan := tracing.Tracer().Start(ctx, "fork policy")
+					err := enforceForkPolicy(forkCtx, request.Header, incoming, string(foundSecret.Data["accessToken"]))
+					forkSpan.End()
+					if err != nil {
+						reason = reasonForkPolicy
+						logger.Infof("Validation FAIL (%s)", err.Error())
+						http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
+						return
+					}
+				}
+
+				var comment pullRequestComment
+				if incoming.Event == "issue_comment" {
+					_, commentSpan := tracing.Tracer().Start(ctx, "pull request comment")
+					comment, err = getPullRequestComment(incoming, foundSecret.Data["accessToken"])
+					commentSpan.End()
+					if err != nil {
+						reason = reasonComment
+						logger.Infof("Validation FAIL (%s)", err.Error())
+						http.Error(writer, fmt.Sprint(err), http.StatusExpectationFailed)
+						return
+					}
+					logger.Infof("Validation PASS (%s commented %s on pull request %d)", comment.Commenter, comment.Command, comment.Number)
+				}
+
+				var returnPayload []byte
+				switch {
+				case incoming.Event == "issue_comment":
+					returnPayload, err = addExtrasToCommentPayload(comment, incoming.Payload)
+				case incoming.Provider == "gitlab":
+					returnPayload, err = addExtrasToGitLabPayload(incoming.Event, incoming.Payload)
+				case incoming.Provider == "bitbucket":
+					returnPayload, err = addExtrasToBitbucketPayload(incoming.Event, incoming.Payload)
+				case incoming.Provider == "gitea", incoming.Provider == "gogs":
+					returnPayload, err = addExtrasToGiteaPayload(incoming.Event, incoming.Payload)
+				default:
+					returnPayload, err = addExtrasToPayload(incoming.Event, incoming.Payload)
+				}
+				if err != nil {
+					logger.Errorf("Failed to add branch to payload: %s", err.Error())
+					http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+					return
+				}
+
+				if id == "" {
+					logger.Debug("No delivery ID so not checking for a redelivery")
+				} else {
+					duplicate, err := deliveries.record(deliveryKey(foundTriggerName, id))
+					if err != nil {
+						logger.Errorf("Failed to record delivery: %s", err.Error())
+						http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
+						return
+					}
+					if duplicate {
+						reason = reasonDuplicate
+						logger.Infof("Validation FAIL (event ID %s was already delivered)", id)
+						http.Error(writer, fmt.Sprintf("event ID %s was already delivered", id), http.StatusConflict)
+						return
+					}
+				}
+
+				// The PipelineRun created by the eventlistener continues the trace from the hand-off
+				handOffCtx, handOff := tracing.Tracer().Start(ctx, "EventListener hand-off")
+				defer handOff.End()
+				returnPayload, err = addTraceParent(returnPayload, tracing.TraceParent(handOffCtx))
 				if err != nil {
-					log.Printf("[%s] Failed to add branch to payload processing Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
+					logger.Errorf("Failed to add trace context to payload: %s", err.Error())
 					http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
 					return
 				}
 
-				log.Printf("[%s] Validation PASS so writing response", foundTriggerName)
+				logger.Info("Validation PASS so writing response")
 				_, err = writer.Write(returnPayload)
 				if err != nil {
-					log.Printf("[%s] Failed to write response for Github event ID: %s. Error: %s", foundTriggerName, id, err.Error())
+					logger.Errorf("Failed to write response: %s", err.Error())
 					http.Error(writer, fmt.Sprint(err), http.StatusInternalServerError)
 					return
 				}
+				reason = ""
 			} else {
+				reason = reasonAction
 				http.Error(writer, "Validation failed", http.StatusExpectationFailed)
 			}
 		} else {
-			log.Printf("[%s] Validation FAIL (repository URL does not match, got %s but wanted %s): ",
-				foundTriggerName,
+			reason = reasonRepository
+			logger.Infof("Validation FAIL (repository URL does not match, got %s but wanted %s)",
 				sanitizeGitInput(cloneURL),
 				sanitizeGitInput(wantedRepoURL))
 
@@ -169,7 +300,46 @@ func main() {
 		}
 	})
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", 8080), nil))
+	server := newServer(srvConfig, mux)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logging.Log.Fatalf("Error listening on %s: %s", server.Addr, err.Error())
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	if err := serve(server, listener, srvConfig, h, signals); err != nil {
+		logging.Log.Fatalf("Error serving: %s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logging.Log.Errorf("Error flushing spans: %s", err.Error())
+	}
+	logging.Log.Info("Interceptor stopped")
+}
+
+// validateGitHubEvent checks the X-Hub-Signature of a GitHub event
+func validateGitHubEvent(request *http.Request, secretToken []byte) (gitEvent, error) {
+	payload, err := github.ValidatePayload(request, secretToken)
+	if err != nil {
+		return gitEvent{}, err
+	}
+
+	var result Result
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return gitEvent{}, fmt.Errorf("error %s marshalling payload as JSON", err.Error())
+	}
+
+	return gitEvent{
+		Provider:     "github",
+		Event:        github.WebHookType(request),
+		Action:       result.Action,
+		Ref:          result.Ref,
+		ChangedFiles: result.changedFiles(),
+		CloneURL:     result.Repository.CloneURL,
+		DeliveryID:   github.DeliveryID(request),
+		Payload:      payload,
+	}, nil
 }
 
 // Adds branch and a suggested image tag
@@ -206,6 +376,15 @@ func addExtrasToPayload(event string, payload []byte) ([]byte, error) {
 	}
 }
 
+// matchesEvent returns whether the incoming event is the wanted event. A tag event
+// is a push event of a tag; push events include pushes of tags.
+func matchesEvent(wanted string, incoming gitEvent) bool {
+	if wanted == "tag" {
+		return incoming.Event == "push" && strings.HasPrefix(incoming.Ref, "refs/tags/")
+	}
+	return wanted == incoming.Event
+}
+
 func sanitizeGitInput(input string) string {
 	noGitSuffix := strings.TrimSuffix(input, ".git")
 	asLower := strings.ToLower(noGitSuffix)
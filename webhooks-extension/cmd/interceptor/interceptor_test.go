@@ -147,3 +147,24 @@ func TestAddExtrasToOtherEventPayload(t *testing.T) {
 	}
 
 }
+
+func TestMatchesEvent(t *testing.T) {
+	tests := []struct {
+		wanted   string
+		incoming gitEvent
+		want     bool
+	}{
+		{wanted: "push", incoming: gitEvent{Event: "push", Ref: "refs/heads/master"}, want: true},
+		{wanted: "push", incoming: gitEvent{Event: "push", Ref: "refs/tags/v1.0"}, want: true},
+		{wanted: "tag", incoming: gitEvent{Event: "push", Ref: "refs/tags/v1.0"}, want: true},
+		{wanted: "tag", incoming: gitEvent{Event: "push", Ref: "refs/heads/master"}, want: false},
+		{wanted: "tag", incoming: gitEvent{Event: "create", Ref: "v1.0"}, want: false},
+		{wanted: "pull_request", incoming: gitEvent{Event: "push", Ref: "refs/heads/master"}, want: false},
+		{wanted: "release", incoming: gitEvent{Event: "release", Action: "published"}, want: true},
+	}
+	for _, tt := range tests {
+		if got := matchesEvent(tt.wanted, tt.incoming); got != tt.want {
+			t.Errorf("matchesEvent(%s, %+v) = %t, want %t", tt.wanted, tt.incoming, got, tt.want)
+		}
+	}
+}
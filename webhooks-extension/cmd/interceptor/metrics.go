@@ -0,0 +1,67 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons validations fail, recorded as the reason label of webhooks_interceptor_validations_total
+const (
+	reasonSecret     = "secret"
+	reasonPayload    = "payload"
+	reasonRepository = "repository"
+	reasonEvent      = "event"
+	reasonAction     = "action"
+	reasonFilter     = "filter"
+	reasonForkPolicy = "fork_policy"
+	reasonComment    = "comment"
+	reasonDuplicate  = "duplicate"
+	reasonError      = "error"
+)
+
+var (
+	validations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_interceptor_validations_total",
+		Help: "Validations of events by result, pass or fail, and the reason failed validations failed.",
+	}, []string{"result", "reason"})
+
+	validationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "webhooks_interceptor_validation_duration_seconds",
+		Help:    "Latency of validations of events.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	gitProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webhooks_interceptor_git_provider_request_duration_seconds",
+		Help:    "Latency of requests to git provider APIs, checking commenters and authors of pull requests, by HTTP status code and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(validations, validationDuration, gitProviderRequestDuration)
+}
+
+// recordValidation records a validation started at start, failed for the reason or passed if the reason is empty
+func recordValidation(reason string, start time.Time) {
+	validationDuration.Observe(time.Since(start).Seconds())
+	if reason == "" {
+		validations.WithLabelValues("pass", "").Inc()
+		return
+	}
+	validations.WithLabelValues("fail", reason).Inc()
+}
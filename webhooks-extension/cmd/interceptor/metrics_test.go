@@ -0,0 +1,63 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRecordValidation(t *testing.T) {
+	passed := testutil.ToFloat64(validations.WithLabelValues("pass", ""))
+	duplicates := testutil.ToFloat64(validations.WithLabelValues("fail", reasonDuplicate))
+
+	recordValidation("", time.Now())
+	recordValidation(reasonDuplicate, time.Now())
+	recordValidation(reasonDuplicate, time.Now())
+
+	if got := testutil.ToFloat64(validations.WithLabelValues("pass", "")); got != passed+1 {
+		t.Errorf("Expected %v passed validations, got %v", passed+1, got)
+	}
+	if got := testutil.ToFloat64(validations.WithLabelValues("fail", reasonDuplicate)); got != duplicates+2 {
+		t.Errorf("Expected %v validations failed as duplicates, got %v", duplicates+2, got)
+	}
+}
+
+func TestAPIClientMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	count := func() uint64 {
+		var metric dto.Metric
+		if err := gitProviderRequestDuration.WithLabelValues("404", "get").(prometheus.Metric).Write(&metric); err != nil {
+			t.Fatalf("Error reading histogram: %s", err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+	before := count()
+	if _, err := isMember(server.URL+"/orgs/owner/members/someone", http.Header{}); err != nil {
+		t.Fatalf("Error checking membership: %s", err)
+	}
+	if count() != before+1 {
+		t.Error("Expected the git provider API request to be recorded")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretCache serves the secrets of the install namespace from an informer cache, rather than getting
+// them from the API server for every event. The cache is kept up to date by watching the secrets, so
+// rotated tokens are used as soon as the watch event arrives.
+type secretCache struct {
+	lister corev1listers.SecretNamespaceLister
+}
+
+// newSecretCache starts an informer for the secrets of the namespace, waiting for its cache to be filled
+func newSecretCache(clientset kubernetes.Interface, namespace string, stopCh <-chan struct{}) (*secretCache, error) {
+	// No resync is needed as changes are picked up through watch events
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().Secrets()
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, newSecret := oldObj.(*corev1.Secret), newObj.(*corev1.Secret)
+			if oldSecret.ResourceVersion != newSecret.ResourceVersion {
+				logging.Log.Infof("Secret %s updated", newSecret.Name)
+			}
+		},
+	})
+	lister := informer.Lister().Secrets(namespace)
+
+	factory.Start(stopCh)
+	for _, synced := range factory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return nil, fmt.Errorf("error syncing the secrets of namespace %s", namespace)
+		}
+	}
+	return &secretCache{lister: lister}, nil
+}
+
+// get returns the secret from the cache. The secret is shared with the cache so must not be modified.
+func (c *secretCache) get(name string) (*corev1.Secret, error) {
+	return c.lister.Get(name)
+}
@@ -0,0 +1,74 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretCache(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-secret", Namespace: "tekton-pipelines"},
+		Data:       map[string][]byte{"accessToken": []byte("access"), "secretToken": []byte("secret")},
+	}
+	other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other-secret", Namespace: "other"}}
+	clientset := fakek8sclientset.NewSimpleClientset(secret, other)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secrets, err := newSecretCache(clientset, "tekton-pipelines", stopCh)
+	if err != nil {
+		t.Fatalf("Error creating secret cache: %s", err)
+	}
+
+	found, err := secrets.get("webhook-secret")
+	if err != nil || string(found.Data["secretToken"]) != "secret" {
+		t.Errorf("Expected the cached secret, got %+v, %v", found, err)
+	}
+	if _, err := secrets.get("other-secret"); !errors.IsNotFound(err) {
+		t.Errorf("Expected secrets of other namespaces not to be found, got %v", err)
+	}
+
+	// Rotated tokens are picked up through watch events
+	rotated := secret.DeepCopy()
+	rotated.Data["secretToken"] = []byte("rotated")
+	if _, err := clientset.CoreV1().Secrets("tekton-pipelines").Update(rotated); err != nil {
+		t.Fatalf("Error updating secret: %s", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		found, err := secrets.get("webhook-secret")
+		return err == nil && string(found.Data["secretToken"]) == "rotated", nil
+	})
+	if err != nil {
+		t.Error("Rotated secret token was not picked up by the cache")
+	}
+
+	if err := clientset.CoreV1().Secrets("tekton-pipelines").Delete("webhook-secret", &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Error deleting secret: %s", err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		_, err := secrets.get("webhook-secret")
+		return errors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Error("Deleted secret was not removed from the cache")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+)
+
+const (
+	envPort            = "PORT"
+	envReadTimeout     = "READ_TIMEOUT"
+	envWriteTimeout    = "WRITE_TIMEOUT"
+	envIdleTimeout     = "IDLE_TIMEOUT"
+	envShutdownTimeout = "SHUTDOWN_TIMEOUT"
+	envTLSCertFile     = "TLS_CERT_FILE"
+	envTLSKeyFile      = "TLS_KEY_FILE"
+)
+
+// serverConfig configures the HTTP server of the interceptor from environment variables
+type serverConfig struct {
+	Port            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// TLSCertFile and TLSKeyFile are the certificate and key, usually mounted from a kubernetes.io/tls secret,
+	// served when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// getServerConfig reads the server configuration. The write timeout allows for the git provider API requests
+// made validating comments and pull requests from forks, and the shutdown timeout fits within the default
+// termination grace period of 30 seconds.
+func getServerConfig() (serverConfig, error) {
+	config := serverConfig{
+		Port:            "8080",
+		ReadTimeout:     10 * time.Second,
+		WriteTimeout:    60 * time.Second,
+		IdleTimeout:     120 * time.Second,
+		ShutdownTimeout: 25 * time.Second,
+		TLSCertFile:     os.Getenv(envTLSCertFile),
+		TLSKeyFile:      os.Getenv(envTLSKeyFile),
+	}
+	if port := os.Getenv(envPort); port != "" {
+		config.Port = port
+	}
+	for name, timeout := range map[string]*time.Duration{
+		envReadTimeout:     &config.ReadTimeout,
+		envWriteTimeout:    &config.WriteTimeout,
+		envIdleTimeout:     &config.IdleTimeout,
+		envShutdownTimeout: &config.ShutdownTimeout,
+	} {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				return config, fmt.Errorf("%s %q is not a positive duration", name, value)
+			}
+			*timeout = parsed
+		}
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return config, fmt.Errorf("%s and %s must be set together", envTLSCertFile, envTLSKeyFile)
+	}
+	return config, nil
+}
+
+// health tracks whether the interceptor is ready for requests, which it is once serving until it starts
+// shutting down
+type health struct {
+	ready int32
+}
+
+func (h *health) setReady(ready bool) {
+	value := int32(0)
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&h.ready, value)
+}
+
+// registerHealthHandlers registers /healthz, which succeeds while the interceptor is serving, and /readyz,
+// which succeeds while it is ready for requests
+func registerHealthHandlers(mux *http.ServeMux, h *health) {
+	mux.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/readyz", func(writer http.ResponseWriter, request *http.Request) {
+		if atomic.LoadInt32(&h.ready) == 0 {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// newServer returns the HTTP server of the interceptor
+func newServer(config serverConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":" + config.Port,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+}
+
+// serve serves requests on the listener until a signal is received, then stops accepting requests and
+// waits up to the shutdown timeout for requests in flight to complete
+func serve(server *http.Server, listener net.Listener, config serverConfig, h *health, signals <-chan os.Signal) error {
+	errCh := make(chan error, 1)
+	go func() {
+		if config.TLSCertFile != "" {
+			errCh <- server.ServeTLS(listener, config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			errCh <- server.Serve(listener)
+		}
+	}()
+	h.setReady(true)
+
+	select {
+	case err := <-errCh:
+		h.setReady(false)
+		return err
+	case sig := <-signals:
+		logging.Log.Infof("Received %s, shutting down once requests in flight complete", sig)
+		h.setReady(false)
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
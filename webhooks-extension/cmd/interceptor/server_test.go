@@ -0,0 +1,142 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGetServerConfig(t *testing.T) {
+	names := []string{envPort, envReadTimeout, envWriteTimeout, envIdleTimeout, envShutdownTimeout, envTLSCertFile, envTLSKeyFile}
+	defer func() {
+		for _, name := range names {
+			os.Unsetenv(name)
+		}
+	}()
+
+	config, err := getServerConfig()
+	if err != nil || config.Port != "8080" || config.WriteTimeout != 60*time.Second || config.ShutdownTimeout != 25*time.Second || config.TLSCertFile != "" {
+		t.Errorf("Expected the default configuration, got %+v, %v", config, err)
+	}
+
+	os.Setenv(envPort, "8443")
+	os.Setenv(envReadTimeout, "5s")
+	os.Setenv(envShutdownTimeout, "1m")
+	os.Setenv(envTLSCertFile, "/etc/interceptor/tls/tls.crt")
+	os.Setenv(envTLSKeyFile, "/etc/interceptor/tls/tls.key")
+	config, err = getServerConfig()
+	if err != nil || config.Port != "8443" || config.ReadTimeout != 5*time.Second || config.ShutdownTimeout != time.Minute || config.TLSKeyFile != "/etc/interceptor/tls/tls.key" {
+		t.Errorf("Expected the configuration from the environment, got %+v, %v", config, err)
+	}
+
+	os.Unsetenv(envTLSKeyFile)
+	if _, err := getServerConfig(); err == nil {
+		t.Error("Expected an error for a TLS certificate without a key")
+	}
+	os.Setenv(envTLSKeyFile, "/etc/interceptor/tls/tls.key")
+	os.Setenv(envWriteTimeout, "forever")
+	if _, err := getServerConfig(); err == nil {
+		t.Error("Expected an error for a bad timeout")
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	h := &health{}
+	registerHealthHandlers(mux, h)
+
+	get := func(path string) int {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+	if get("/healthz") != http.StatusNoContent || get("/readyz") != http.StatusServiceUnavailable {
+		t.Error("Expected the interceptor to be healthy but not ready before serving")
+	}
+	h.setReady(true)
+	if get("/readyz") != http.StatusNoContent {
+		t.Error("Expected the interceptor to be ready")
+	}
+}
+
+func TestServeDrainsRequestsOnSignal(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	h := &health{}
+	registerHealthHandlers(mux, h)
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		close(entered)
+		<-release
+		writer.Write([]byte("validated"))
+	})
+
+	config := serverConfig{ReadTimeout: time.Second, WriteTimeout: 5 * time.Second, IdleTimeout: time.Second, ShutdownTimeout: 5 * time.Second}
+	server := newServer(config, mux)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	signals := make(chan os.Signal, 1)
+	served := make(chan error, 1)
+	go func() { served <- serve(server, listener, config, h, signals) }()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+
+	// The request in flight when the signal arrives completes before serve returns
+	<-entered
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-served:
+		t.Fatalf("serve returned before the request in flight completed: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if atomic.LoadInt32(&h.ready) != 0 {
+		t.Error("Expected the interceptor not to be ready once shutting down")
+	}
+	close(release)
+
+	if resp := <-responses; resp.err != nil || resp.body != "validated" {
+		t.Errorf("Expected the request in flight to complete, got %q, %v", resp.body, resp.err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve returned an error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("serve did not return after the request in flight completed")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingFlushTimeout bounds exporting the spans left on shutdown, which follows the shutdown of the server
+const tracingFlushTimeout = 5 * time.Second
+
+// startValidationSpan starts the span of the trace of an incoming event covering its validation
+func startValidationSpan(ctx context.Context, triggerName string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "interceptor validation",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("webhooks.trigger", triggerName)))
+}
+
+// setEventAttributes records the validated event on the span
+func setEventAttributes(span trace.Span, incoming gitEvent) {
+	span.SetAttributes(
+		attribute.String("webhooks.git.provider", incoming.Provider),
+		attribute.String("webhooks.git.event", incoming.Event),
+		attribute.String("webhooks.git.action", incoming.Action),
+		attribute.String("webhooks.git.delivery_id", incoming.DeliveryID),
+		attribute.String("webhooks.git.repository", sanitizeGitInput(incoming.CloneURL)))
+}
+
+// endValidationSpan ends the span of a validation, failed for the reason or passed if the reason is empty
+func endValidationSpan(span trace.Span, reason string) {
+	if reason != "" {
+		span.SetAttributes(attribute.String("webhooks.validation.reason", reason))
+		span.SetStatus(codes.Error, "validation failed: "+reason)
+	}
+	span.End()
+}
+
+// addTraceParent adds the traceparent to the payload returned to the eventlistener, for the trigger template
+// to label the PipelineRun with. The field is added even if empty, as bindings fail on missing fields.
+func addTraceParent(payload []byte, traceParent string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("error %s adding the traceparent to the payload", err.Error())
+	}
+	value, err := json.Marshal(traceParent)
+	if err != nil {
+		return nil, err
+	}
+	fields[tracing.TraceParentParam] = value
+	return json.Marshal(fields)
+}
@@ -0,0 +1,94 @@
+/*
+ Copyright 2019 The Tekton Authors
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+     http://www.apache.org/licenses/LICENSE-2.0
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestAddTraceParent(t *testing.T) {
+	payload := []byte(`{"ref":"refs/heads/master","pull_request":{"number":12345678901234}}`)
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	for _, wanted := range []string{traceParent, ""} {
+		returned, err := addTraceParent(payload, wanted)
+		if err != nil {
+			t.Fatalf("Error adding traceparent: %s", err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(returned, &fields); err != nil {
+			t.Fatalf("Error unmarshalling payload: %s", err)
+		}
+		var got string
+		if err := json.Unmarshal(fields[tracing.TraceParentParam], &got); err != nil || got != wanted {
+			t.Errorf("Expected %s to be %q, got %s", tracing.TraceParentParam, wanted, fields[tracing.TraceParentParam])
+		}
+		// Fields are passed through untouched
+		if string(fields["pull_request"]) != `{"number":12345678901234}` || string(fields["ref"]) != `"refs/heads/master"` {
+			t.Errorf("Expected the fields of the payload to be unchanged, got %s", returned)
+		}
+	}
+
+	if _, err := addTraceParent([]byte(`[]`), traceParent); err == nil {
+		t.Error("Expected an error adding the traceparent to a payload that is not an object")
+	}
+}
+
+func TestValidationSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	_, span := startValidationSpan(context.Background(), "trigger")
+	endValidationSpan(span, "")
+	ctx, span := startValidationSpan(context.Background(), "trigger")
+	setEventAttributes(span, gitEvent{Provider: "github", Event: "push", CloneURL: "https://github.com/owner/repo.git"})
+	endValidationSpan(span, reasonFilter)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Unset {
+		t.Errorf("Expected a passed validation not to be an error, got %v", spans[0].Status())
+	}
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "validation failed: filter" {
+		t.Errorf("Expected a failed validation to be an error with its reason, got %v", spans[1].Status())
+	}
+	attributes := map[string]string{}
+	for _, attribute := range spans[1].Attributes() {
+		attributes[string(attribute.Key)] = attribute.Value.Emit()
+	}
+	for key, value := range map[string]string{
+		"webhooks.trigger":           "trigger",
+		"webhooks.git.event":         "push",
+		"webhooks.git.repository":    "github.com/owner/repo",
+		"webhooks.validation.reason": reasonFilter,
+	} {
+		if attributes[key] != value {
+			t.Errorf("Expected attribute %s to be %s, got %q", key, value, attributes[key])
+		}
+	}
+	if tracing.TraceParent(ctx) == "" {
+		t.Error("Expected a traceparent for the validation span")
+	}
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupName is the API group of the Webhook custom resource
+const GroupName = "webhooks.tekton.dev"
+
+var (
+	// SchemeGroupVersion is the group version of the Webhook custom resource
+	SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
+	// WebhookResource is the resource used to access webhooks with the dynamic client
+	WebhookResource = SchemeGroupVersion.WithResource("webhooks")
+)
+
+// WebhookKind is the kind of the Webhook custom resource
+const WebhookKind = "Webhook"
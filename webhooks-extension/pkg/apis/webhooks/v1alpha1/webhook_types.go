@@ -0,0 +1,90 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Webhook runs a pipeline in its namespace on push and pull request events from a git repository.
+// The webhook is named after the webhook and created in the namespace the pipeline runs in.
+type Webhook struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebhookSpec   `json:"spec"`
+	Status WebhookStatus `json:"status,omitempty"`
+}
+
+// WebhookSpec is the webhook as created through the extension, excluding the name and namespace
+type WebhookSpec struct {
+	ServiceAccount   string              `json:"serviceaccount,omitempty"`
+	GitRepositoryURL string              `json:"gitrepositoryurl"`
+	AccessTokenRef   string              `json:"accesstoken"`
+	Pipeline         string              `json:"pipeline"`
+	DockerRegistry   string              `json:"dockerregistry,omitempty"`
+	HelmSecret       string              `json:"helmsecret,omitempty"`
+	ReleaseName      string              `json:"releasename,omitempty"`
+	PullTask         string              `json:"pulltask,omitempty"`
+	OnSuccessComment string              `json:"onsuccesscomment,omitempty"`
+	OnFailureComment string              `json:"onfailurecomment,omitempty"`
+	OnTimeoutComment string              `json:"ontimeoutcomment,omitempty"`
+	GitProvider      string              `json:"gitprovider,omitempty"`
+	EventListener    string              `json:"eventlistener,omitempty"`
+	Events           []string            `json:"events,omitempty"`
+	Actions          map[string][]string `json:"actions,omitempty"`
+	Branches         []string            `json:"branches,omitempty"`
+	ExcludeBranches  []string            `json:"excludebranches,omitempty"`
+	Paths            []string            `json:"paths,omitempty"`
+	ExcludePaths     []string            `json:"excludepaths,omitempty"`
+	ForkPolicy       string              `json:"forkpolicy,omitempty"`
+	ForkPermission   string              `json:"forkpermission,omitempty"`
+	ForkTeam         string              `json:"forkteam,omitempty"`
+	ForkComment      bool                `json:"forkcomment,omitempty"`
+}
+
+// WebhookStatus is the state of the webhook as last reconciled
+type WebhookStatus struct {
+	// HookID is the ID of the hook on the git provider, when the git provider returns one
+	HookID int64 `json:"hookid,omitempty"`
+	// Error is the error from the last attempt to reconcile the webhook, if it failed
+	Error string `json:"error,omitempty"`
+	// Creation is the progress of creating the webhook, until it is in place or rolled back
+	Creation *WebhookCreation `json:"creation,omitempty"`
+}
+
+// WebhookCreation records the steps taken creating a webhook, so that they can be rolled back
+type WebhookCreation struct {
+	// Updated is when the creation last made progress, a creation not updated for a while was interrupted
+	Updated metav1.Time `json:"updated"`
+	// Steps are in the order taken, a step is recorded before it is taken and marked done once taken
+	Steps []WebhookCreationStep `json:"steps,omitempty"`
+}
+
+// WebhookCreationStep is a step taken creating a webhook
+type WebhookCreationStep struct {
+	// Name is one of triggers, ingress, route or hook
+	Name string `json:"name"`
+	Done bool   `json:"done,omitempty"`
+	// HookID is the ID of the hook registered on the git provider by the hook step
+	HookID int64 `json:"hookid,omitempty"`
+}
+
+// WebhookList is a list of webhooks
+type WebhookList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Webhook `json:"items"`
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/*--------------------------------------
+Each request changing webhooks or credentials, or repairing drift, is audited:
+who made it, the action, its target and its outcome. Audit records are written
+as Kubernetes events on the target and appended to the audit log, a file of one
+JSON record per line at AUDIT_LOG_PATH, which is queried with
+GET /webhooks/audit. Setting AUDIT_LOG_PATH empty disables the audit log. The
+audit log is kept by each replica, so only holds the requests it served.
+
+The caller is identified from the bearer token of the request with a
+TokenReview, or else from the X-Forwarded-User, X-Forwarded-Email and
+X-Forwarded-Groups headers set by an authenticating proxy in front of the
+dashboard. How the caller was identified is recorded, as forwarded headers are
+only as trustworthy as the proxy setting them.
+---------------------------------------*/
+
+const (
+	defaultAuditLogPath = "/var/log/webhooks-extension/audit.log"
+
+	// How the caller of an audited request was identified
+	identifiedByTokenReview = "tokenreview"
+	identifiedByHeaders     = "forwarded-headers"
+	notIdentified           = "none"
+
+	// defaultAuditQueryLimit is the number of records returned by an audit query without a limit
+	defaultAuditQueryLimit = 100
+)
+
+// auditLogLock serialises writing to and reading from the audit log
+var auditLogLock sync.Mutex
+
+// caller is the identity of the caller of a request
+type caller struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// UID and Extra are given by a TokenReview, for SubjectAccessReviews of the caller
+	UID   string                                 `json:"-"`
+	Extra map[string]authenticationv1.ExtraValue `json:"-"`
+	// IdentifiedBy is how the caller was identified, tokenreview, forwarded-headers or none
+	IdentifiedBy string `json:"identifiedby"`
+}
+
+// auditRecord records a request changing a webhook or credential or repairing drift
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	caller
+	// Action is create, update or delete for webhooks and credentials, rotate for the secret token of a credential,
+	// or repair for drift
+	Action string `json:"action"`
+	// Kind is webhook, credential or drift
+	Kind      string `json:"kind"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Code      int    `json:"code"`
+	// Outcome is success or failure
+	Outcome string `json:"outcome"`
+}
+
+// newAuditRecord starts the record of a request, the handler sets the target once known
+func newAuditRecord(action, kind string) *auditRecord {
+	return &auditRecord{Time: time.Now().UTC(), Action: action, Kind: kind}
+}
+
+// getCaller identifies the caller of the request: the caller authenticated for the request if authorization is
+// required, or else with a TokenReview of the bearer token of the request if it has one, or else from the headers
+// forwarded by an authenticating proxy
+func (r Resource) getCaller(request *restful.Request) caller {
+	if c, ok := request.Attribute(callerAttribute).(caller); ok {
+		return c
+	}
+	if token := bearerToken(request); token != "" {
+		c, ok, err := r.reviewToken(token)
+		if err != nil {
+			logging.Log.Errorf("error reviewing the bearer token of the caller: %s", err)
+		} else if ok {
+			return c
+		}
+	}
+	if c, ok := forwardedCaller(request); ok {
+		return c
+	}
+	return caller{User: "unknown", IdentifiedBy: notIdentified}
+}
+
+// recordAudit completes the record of a handled request and writes it as an event and to the audit log
+func (r Resource) recordAudit(request *restful.Request, response *restful.Response, record *auditRecord) {
+	record.caller = r.getCaller(request)
+	record.Code = response.StatusCode()
+	record.Outcome = "success"
+	if record.Code >= http.StatusBadRequest {
+		record.Outcome = "failure"
+	}
+	logging.Log.Infow("audit", "user", record.User, "action", record.Action, "kind", record.Kind,
+		"name", record.Name, "namespace", record.Namespace, "code", record.Code)
+
+	r.recordAuditEvent(*record)
+	if err := appendAuditRecord(r.Defaults.AuditLogPath, *record); err != nil {
+		logging.Log.Errorf("error appending to the audit log %s: %s", r.Defaults.AuditLogPath, err)
+	}
+}
+
+// recordAuditEvent records the audit record as an event on its target: the webhook resource, the credential's
+// secret or, for drift, the installed namespace
+func (r Resource) recordAuditEvent(record auditRecord) {
+	involved := corev1.ObjectReference{Kind: "Namespace", Name: r.Defaults.Namespace}
+	switch record.Kind {
+	case "webhook":
+		involved = corev1.ObjectReference{
+			APIVersion: webhooksv1alpha1.SchemeGroupVersion.String(),
+			Kind:       webhooksv1alpha1.WebhookKind,
+			Name:       record.Name,
+			Namespace:  record.Namespace,
+		}
+	case "credential":
+		involved = corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Name: record.Name, Namespace: r.Defaults.Namespace}
+	}
+	// A webhook that could not be read has no name or namespace
+	if involved.Name == "" {
+		involved = corev1.ObjectReference{Kind: "Namespace", Name: r.Defaults.Namespace}
+	}
+	namespace := involved.Namespace
+	if namespace == "" {
+		namespace = r.Defaults.Namespace
+	}
+
+	eventType := corev1.EventTypeNormal
+	if record.Outcome != "success" {
+		eventType = corev1.EventTypeWarning
+	}
+	target := record.Kind
+	if record.Name != "" {
+		target = fmt.Sprintf("%s %s", record.Kind, record.Name)
+	}
+	now := metav1.NewTime(record.Time)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Named as by the client-go event recorder
+			Name:      fmt.Sprintf("%v.%x", involved.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         "Audit",
+		Message:        fmt.Sprintf("%s %s %s: %s (%d)", record.User, record.Action, target, record.Outcome, record.Code),
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "tekton-webhooks-extension"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := r.K8sClient.CoreV1().Events(namespace).Create(event); err != nil {
+		logging.Log.Errorf("error recording audit event for %s: %s", target, err)
+	}
+}
+
+// appendAuditRecord appends the record to the audit log at path, which is not written if path is empty
+func appendAuditRecord(path string, record auditRecord) error {
+	if path == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// auditQuery selects audit records, empty fields match all records
+type auditQuery struct {
+	User      string
+	Action    string
+	Kind      string
+	Name      string
+	Namespace string
+	Since     time.Time
+	Limit     int
+}
+
+func (q auditQuery) matches(record auditRecord) bool {
+	return (q.User == "" || q.User == record.User) &&
+		(q.Action == "" || q.Action == record.Action) &&
+		(q.Kind == "" || q.Kind == record.Kind) &&
+		(q.Name == "" || q.Name == record.Name) &&
+		(q.Namespace == "" || q.Namespace == record.Namespace) &&
+		!record.Time.Before(q.Since)
+}
+
+// queryAuditLog returns the latest records of the audit log at path matching the query, newest first
+func queryAuditLog(path string, query auditQuery) ([]auditRecord, error) {
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+	records := []auditRecord{}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logging.Log.Errorf("error reading audit record %q: %s", scanner.Text(), err)
+			continue
+		}
+		if query.matches(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	if len(records) > query.Limit {
+		records = records[:query.Limit]
+	}
+	return records, nil
+}
+
+// getAuditRecords returns the audit records matching the user, action, kind, name, namespace, since (RFC 3339)
+// and limit query parameters
+func (r Resource) getAuditRecords(request *restful.Request, response *restful.Response) {
+	if r.Defaults.AuditLogPath == "" {
+		RespondError(response, errors.New("the audit log is not enabled"), http.StatusNotFound)
+		return
+	}
+	query := auditQuery{
+		User:      request.QueryParameter("user"),
+		Action:    request.QueryParameter("action"),
+		Kind:      request.QueryParameter("kind"),
+		Name:      request.QueryParameter("name"),
+		Namespace: request.QueryParameter("namespace"),
+		Limit:     defaultAuditQueryLimit,
+	}
+	if since := request.QueryParameter("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			RespondError(response, fmt.Errorf("since %s is not an RFC 3339 time", since), http.StatusBadRequest)
+			return
+		}
+		query.Since = t
+	}
+	if limit := request.QueryParameter("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			RespondError(response, fmt.Errorf("limit %s is not a positive number", limit), http.StatusBadRequest)
+			return
+		}
+		query.Limit = l
+	}
+
+	records, err := queryAuditLog(r.Defaults.AuditLogPath, query)
+	if err != nil {
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	response.WriteEntity(records)
+}
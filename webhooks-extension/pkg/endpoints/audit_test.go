@@ -0,0 +1,277 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// reviewTokens authenticates the token as the user in TokenReviews made with the client
+func reviewTokens(client *fakek8sclientset.Clientset, token, user string, groups ...string) {
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview).DeepCopy()
+		if review.Spec.Token == token {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: user, Groups: groups},
+			}
+		}
+		return true, review, nil
+	})
+}
+
+// auditedResource returns a resource writing its audit log to a temporary directory, removed by the returned function
+func auditedResource(t *testing.T) (*Resource, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Error creating audit log directory: %s", err)
+	}
+	r := dummyResource()
+	r.Defaults.AuditLogPath = filepath.Join(dir, "audit.log")
+	return r, func() { os.RemoveAll(dir) }
+}
+
+func TestGetCaller(t *testing.T) {
+	r := dummyResource()
+	reviewTokens(r.K8sClient.(*fakek8sclientset.Clientset), "valid", "alice", "developers")
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected caller
+	}{
+		{
+			name:     "bearer token",
+			headers:  map[string]string{"Authorization": "Bearer valid", "X-Forwarded-User": "mallory"},
+			expected: caller{User: "alice", Groups: []string{"developers"}, IdentifiedBy: identifiedByTokenReview},
+		},
+		{
+			name:     "forwarded headers with an invalid token",
+			headers:  map[string]string{"Authorization": "Bearer invalid", "X-Forwarded-User": "bob", "X-Forwarded-Groups": "ops, developers"},
+			expected: caller{User: "bob", Groups: []string{"ops", "developers"}, IdentifiedBy: identifiedByHeaders},
+		},
+		{
+			name:     "forwarded email",
+			headers:  map[string]string{"X-Forwarded-Email": "bob@example.com"},
+			expected: caller{User: "bob@example.com", IdentifiedBy: identifiedByHeaders},
+		},
+		{
+			name:     "anonymous",
+			expected: caller{User: "unknown", IdentifiedBy: notIdentified},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8383/webhooks/credentials", nil)
+			for header, value := range tt.headers {
+				httpReq.Header.Set(header, value)
+			}
+			if got := r.getCaller(dummyRestfulRequest(httpReq, "")); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected caller %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestAuditCredentialChanges(t *testing.T) {
+	r, cleanup := auditedResource(t)
+	defer cleanup()
+	reviewTokens(r.K8sClient.(*fakek8sclientset.Clientset), "valid", "alice")
+
+	send := func(method, url string, body []byte, handler func(*Resource, *http.Request, *httptest.ResponseRecorder)) int {
+		httpReq := dummyHTTPRequest(method, url, bytes.NewBuffer(body))
+		httpReq.Header.Set("Authorization", "Bearer valid")
+		recorder := httptest.NewRecorder()
+		handler(r, httpReq, recorder)
+		return recorder.Code
+	}
+	cred, _ := json.Marshal(credential{Name: "token", AccessToken: "access"})
+	create := func(r *Resource, httpReq *http.Request, recorder *httptest.ResponseRecorder) {
+		r.createCredential(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(recorder))
+	}
+	remove := func(r *Resource, httpReq *http.Request, recorder *httptest.ResponseRecorder) {
+		r.deleteCredential(dummyRestfulRequest(httpReq, "token"), dummyRestfulResponse(recorder))
+	}
+	if code := send("POST", "http://wwww.dummy.com:8383/webhooks/credentials", cred, create); code != http.StatusCreated {
+		t.Fatalf("Expected credential creation to succeed, got status %d", code)
+	}
+	if code := send("DELETE", "http://wwww.dummy.com:8383/webhooks/credentials/token", nil, remove); code != http.StatusNoContent {
+		t.Fatalf("Expected credential deletion to succeed, got status %d", code)
+	}
+	if code := send("DELETE", "http://wwww.dummy.com:8383/webhooks/credentials/token", nil, remove); code != http.StatusNotFound {
+		t.Fatalf("Expected deleting a deleted credential to fail, got status %d", code)
+	}
+
+	records, err := queryAuditLog(r.Defaults.AuditLogPath, auditQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("Error querying the audit log: %s", err)
+	}
+	expected := []struct {
+		action, outcome string
+		code            int
+	}{
+		{"delete", "failure", http.StatusNotFound},
+		{"delete", "success", http.StatusNoContent},
+		{"create", "success", http.StatusCreated},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d audit records, got %+v", len(expected), records)
+	}
+	for i, e := range expected {
+		record := records[i]
+		if record.User != "alice" || record.IdentifiedBy != identifiedByTokenReview || record.Kind != "credential" || record.Name != "token" ||
+			record.Action != e.action || record.Outcome != e.outcome || record.Code != e.code {
+			t.Errorf("Expected record %d to be alice's %s of credential token with outcome %s (%d), got %+v", i, e.action, e.outcome, e.code, record)
+		}
+	}
+
+	events, err := r.K8sClient.CoreV1().Events(r.Defaults.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing events: %s", err)
+	}
+	warnings := 0
+	for _, event := range events.Items {
+		if event.Reason != "Audit" || event.InvolvedObject.Kind != "Secret" || event.InvolvedObject.Name != "token" {
+			t.Errorf("Unexpected event %+v", event)
+		}
+		if !strings.HasPrefix(event.Message, "alice ") {
+			t.Errorf("Expected the event to name the caller, got %s", event.Message)
+		}
+		if event.Type == corev1.EventTypeWarning {
+			warnings++
+		}
+	}
+	if len(events.Items) != 3 || warnings != 1 {
+		t.Errorf("Expected 3 audit events of which 1 warning, got %d events of which %d warnings", len(events.Items), warnings)
+	}
+}
+
+func TestAuditWebhookChanges(t *testing.T) {
+	r, cleanup := auditedResource(t)
+	defer cleanup()
+
+	// A webhook that can't be read has no target
+	httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8383/webhooks", bytes.NewBufferString("not a webhook"))
+	httpReq.Header.Set("X-Forwarded-User", "bob")
+	r.createWebhook(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(httptest.NewRecorder()))
+
+	httpReq = dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8383/webhooks/missing?namespace=foo", nil)
+	httpReq.Header.Set("X-Forwarded-User", "bob")
+	r.deleteWebhook(dummyRestfulRequest(httpReq, "missing"), dummyRestfulResponse(httptest.NewRecorder()))
+
+	records, err := queryAuditLog(r.Defaults.AuditLogPath, auditQuery{Kind: "webhook", Limit: 10})
+	if err != nil {
+		t.Fatalf("Error querying the audit log: %s", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 audit records, got %+v", records)
+	}
+	if records[0].Action != "delete" || records[0].Name != "missing" || records[0].Namespace != "foo" || records[0].Outcome != "failure" || records[0].User != "bob" {
+		t.Errorf("Unexpected record of the webhook deletion %+v", records[0])
+	}
+	if records[1].Action != "create" || records[1].Name != "" || records[1].Code != http.StatusBadRequest {
+		t.Errorf("Unexpected record of the webhook creation %+v", records[1])
+	}
+
+	// The event of the deletion is on the webhook resource, the event of the unreadable webhook on the namespace
+	events, err := r.K8sClient.CoreV1().Events("foo").List(metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 || events.Items[0].InvolvedObject.Kind != "Webhook" || events.Items[0].InvolvedObject.Name != "missing" {
+		t.Errorf("Expected an audit event for the webhook resource, got %+v with error %v", events, err)
+	}
+	events, err = r.K8sClient.CoreV1().Events(r.Defaults.Namespace).List(metav1.ListOptions{})
+	if err != nil || len(events.Items) != 1 || events.Items[0].InvolvedObject.Kind != "Namespace" {
+		t.Errorf("Expected an audit event for the installed namespace, got %+v with error %v", events, err)
+	}
+}
+
+func TestGetAuditRecords(t *testing.T) {
+	r, cleanup := auditedResource(t)
+	defer cleanup()
+
+	start := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, record := range []auditRecord{
+		{Time: start, caller: caller{User: "alice"}, Action: "create", Kind: "webhook", Name: "one", Namespace: "foo", Code: 201, Outcome: "success"},
+		{Time: start.Add(time.Hour), caller: caller{User: "bob"}, Action: "create", Kind: "credential", Name: "token", Code: 201, Outcome: "success"},
+		{Time: start.Add(2 * time.Hour), caller: caller{User: "alice"}, Action: "delete", Kind: "webhook", Name: "one", Namespace: "foo", Code: 201, Outcome: "success"},
+	} {
+		if err := appendAuditRecord(r.Defaults.AuditLogPath, record); err != nil {
+			t.Fatalf("Error appending record %d: %s", i, err)
+		}
+	}
+
+	query := func(params string) (int, []auditRecord) {
+		httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8383/webhooks/audit"+params, nil)
+		recorder := httptest.NewRecorder()
+		r.getAuditRecords(dummyRestfulRequest(httpReq, ""), dummyRestfulResponse(recorder))
+		records := []auditRecord{}
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&records); err != nil {
+				t.Fatalf("Error decoding records: %s", err)
+			}
+		}
+		return recorder.Code, records
+	}
+	names := func(records []auditRecord) []string {
+		result := []string{}
+		for _, record := range records {
+			result = append(result, record.Action+" "+record.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		params   string
+		expected []string
+	}{
+		{"", []string{"delete one", "create token", "create one"}},
+		{"?user=alice", []string{"delete one", "create one"}},
+		{"?kind=webhook&action=create", []string{"create one"}},
+		{"?since=2019-10-01T13:00:00Z", []string{"delete one", "create token"}},
+		{"?limit=1", []string{"delete one"}},
+		{"?namespace=bar", []string{}},
+	}
+	for _, tt := range tests {
+		code, records := query(tt.params)
+		if code != http.StatusOK || !reflect.DeepEqual(names(records), tt.expected) {
+			t.Errorf("Query %q: expected %v, got %d %v", tt.params, tt.expected, code, names(records))
+		}
+	}
+	for _, params := range []string{"?since=yesterday", "?limit=0", "?limit=many"} {
+		if code, _ := query(params); code != http.StatusBadRequest {
+			t.Errorf("Query %q: expected status 400, got %d", params, code)
+		}
+	}
+
+	r.Defaults.AuditLogPath = ""
+	if code, _ := query(""); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without an audit log, got %d", code)
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful"
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+/*--------------------------------------
+If REQUIRE_AUTHORIZATION is true, each request to the /webhooks routes is
+authenticated and authorized against the Kubernetes API as its caller:
+
+The caller is authenticated from the bearer token of the request with a
+TokenReview or, if TRUST_FORWARDED_HEADERS is true, from the X-Forwarded-User,
+X-Forwarded-Email and X-Forwarded-Groups headers of an authenticating proxy.
+
+Each route then checks with SubjectAccessReviews that the caller is allowed the
+access to Kubernetes resources the request would give them through the
+extension. For example creating a webhook runs its pipeline in the webhook's
+namespace as its service account, so needs create on pipelineruns in the
+namespace and impersonate on the service account, as well as create on
+webhooks in the namespace.
+---------------------------------------*/
+
+// callerAttribute is the request attribute holding the caller authenticated by the authenticate filter
+const callerAttribute = "caller"
+
+// Groups of the resources authorized
+const (
+	pipelinesGroup = "tekton.dev"
+	coreGroup      = ""
+)
+
+// accessFunc gives the access to Kubernetes resources the caller needs for a request
+type accessFunc func(request *restful.Request) ([]authorizationv1.ResourceAttributes, error)
+
+// bearerToken returns the bearer token of the request, empty if it has none
+func bearerToken(request *restful.Request) string {
+	authorization := request.HeaderParameter("Authorization")
+	if token := strings.TrimPrefix(authorization, "Bearer "); token != authorization {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// reviewToken identifies the caller with the token with a TokenReview, returning false if the token is not authenticated
+func (r Resource) reviewToken(token string) (caller, bool, error) {
+	review, err := r.K8sClient.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	})
+	if err != nil {
+		return caller{}, false, err
+	}
+	if !review.Status.Authenticated {
+		return caller{}, false, nil
+	}
+	user := review.Status.User
+	return caller{User: user.Username, Groups: user.Groups, UID: user.UID, Extra: user.Extra, IdentifiedBy: identifiedByTokenReview}, true, nil
+}
+
+// forwardedCaller identifies the caller from the headers forwarded by an authenticating proxy, returning false if
+// the request has none
+func forwardedCaller(request *restful.Request) (caller, bool) {
+	user := request.HeaderParameter("X-Forwarded-User")
+	if user == "" {
+		user = request.HeaderParameter("X-Forwarded-Email")
+	}
+	if user == "" {
+		return caller{}, false
+	}
+	var groups []string
+	for _, group := range strings.Split(request.HeaderParameter("X-Forwarded-Groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return caller{User: user, Groups: groups, IdentifiedBy: identifiedByHeaders}, true
+}
+
+// authenticate is a filter authenticating the caller of each request, which is set as the caller attribute
+// of the request. Requests without an authenticated caller are rejected.
+func (r Resource) authenticate(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	if !r.Defaults.RequireAuthorization {
+		chain.ProcessFilter(request, response)
+		return
+	}
+
+	var authenticated caller
+	if token := bearerToken(request); token != "" {
+		c, ok, err := r.reviewToken(token)
+		if err != nil {
+			logging.Log.Errorf("error reviewing the bearer token of the caller: %s", err)
+			RespondError(response, errors.New("the caller could not be authenticated"), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			RespondError(response, errors.New("the bearer token is not valid"), http.StatusUnauthorized)
+			return
+		}
+		authenticated = c
+	} else if c, ok := forwardedCaller(request); ok && r.Defaults.TrustForwardedHeaders {
+		authenticated = c
+	} else {
+		RespondError(response, errors.New("a bearer token is required"), http.StatusUnauthorized)
+		return
+	}
+
+	request.SetAttribute(callerAttribute, authenticated)
+	chain.ProcessFilter(request, response)
+}
+
+// authorize returns a filter rejecting requests whose caller is not allowed all the access the request needs
+func (r Resource) authorize(access accessFunc) restful.FilterFunction {
+	return func(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+		if !r.Defaults.RequireAuthorization {
+			chain.ProcessFilter(request, response)
+			return
+		}
+		c, ok := request.Attribute(callerAttribute).(caller)
+		if !ok {
+			RespondError(response, errors.New("the caller is not authenticated"), http.StatusUnauthorized)
+			return
+		}
+		needed, err := access(request)
+		if err != nil {
+			RespondError(response, err, http.StatusBadRequest)
+			return
+		}
+		for _, attributes := range needed {
+			allowed, err := r.isAllowed(c, attributes)
+			if err != nil {
+				logging.Log.Errorf("error reviewing the access of %s: %s", c.User, err)
+				RespondError(response, errors.New("the access of the caller could not be reviewed"), http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				err := fmt.Errorf("%s cannot %s", c.User, describeAccess(attributes))
+				logging.Log.Warnw("request denied", "user", c.User, "verb", attributes.Verb, "resource", attributes.Resource,
+					"name", attributes.Name, "namespace", attributes.Namespace)
+				RespondError(response, err, http.StatusForbidden)
+				return
+			}
+		}
+		chain.ProcessFilter(request, response)
+	}
+}
+
+// isAllowed reviews whether the caller is allowed the access with a SubjectAccessReview
+func (r Resource) isAllowed(c caller, attributes authorizationv1.ResourceAttributes) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range c.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review, err := r.K8sClient.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attributes,
+			User:               c.User,
+			Groups:             c.Groups,
+			UID:                c.UID,
+			Extra:              extra,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// describeAccess describes the access for an error message, for example create pipelineruns.tekton.dev in namespace default
+func describeAccess(attributes authorizationv1.ResourceAttributes) string {
+	resource := attributes.Resource
+	if attributes.Group != "" {
+		resource = attributes.Resource + "." + attributes.Group
+	}
+	if attributes.Name != "" {
+		resource = fmt.Sprintf("%s %s", resource, attributes.Name)
+	}
+	if attributes.Namespace == "" {
+		return fmt.Sprintf("%s %s in all namespaces", attributes.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in namespace %s", attributes.Verb, resource, attributes.Namespace)
+}
+
+func webhookAttributes(verb, namespace string) authorizationv1.ResourceAttributes {
+	return authorizationv1.ResourceAttributes{
+		Verb:      verb,
+		Group:     webhooksv1alpha1.WebhookResource.Group,
+		Resource:  webhooksv1alpha1.WebhookResource.Resource,
+		Namespace: namespace,
+	}
+}
+
+// authenticated is the access of requests any authenticated caller can make
+func authenticated(request *restful.Request) ([]authorizationv1.ResourceAttributes, error) {
+	return nil, nil
+}
+
+// webhookChangeAccess is the access needed to create or update the webhook in the request body: to verb webhooks and
+// create pipelineruns in the namespace of the webhook and to impersonate its service account, as its pipeline is run
+// in the namespace as the service account, and to get the secret of its credential in the installed namespace, as the
+// credential is used to register the webhook and is given to its pipeline
+func (r Resource) webhookChangeAccess(verb string) accessFunc {
+	return func(request *restful.Request) ([]authorizationv1.ResourceAttributes, error) {
+		// The body is read again by the handler
+		body, err := ioutil.ReadAll(request.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		request.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hook := webhook{}
+		if err := json.Unmarshal(body, &hook); err != nil {
+			return nil, fmt.Errorf("error reading the webhook: %s", err)
+		}
+		if hook.Namespace == "" {
+			return nil, errors.New("the webhook has no namespace")
+		}
+		serviceAccount := hook.ServiceAccount
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+		access := []authorizationv1.ResourceAttributes{
+			webhookAttributes(verb, hook.Namespace),
+			{Verb: "create", Group: pipelinesGroup, Resource: "pipelineruns", Namespace: hook.Namespace},
+			{Verb: "impersonate", Group: coreGroup, Resource: "serviceaccounts", Name: serviceAccount, Namespace: hook.Namespace},
+		}
+		// An update without a credential keeps the credential of the existing webhook
+		if hook.AccessTokenRef != "" {
+			access = append(access, authorizationv1.ResourceAttributes{
+				Verb: "get", Group: coreGroup, Resource: "secrets", Name: hook.AccessTokenRef, Namespace: r.Defaults.Namespace,
+			})
+		}
+		return access, nil
+	}
+}
+
+// webhookAccess is the access needed to verb the webhooks of the namespace query parameter
+func webhookAccess(verb string) accessFunc {
+	return func(request *restful.Request) ([]authorizationv1.ResourceAttributes, error) {
+		namespace := request.QueryParameter("namespace")
+		if namespace == "" {
+			return nil, errors.New("a namespace must be specified as a query parameter")
+		}
+		return []authorizationv1.ResourceAttributes{webhookAttributes(verb, namespace)}, nil
+	}
+}
+
+// allWebhooksAccess is the access needed to verb the webhooks of all namespaces
+func allWebhooksAccess(verb string) accessFunc {
+	return func(request *restful.Request) ([]authorizationv1.ResourceAttributes, error) {
+		return []authorizationv1.ResourceAttributes{webhookAttributes(verb, "")}, nil
+	}
+}
+
+// installedNamespaceAccess is the access needed to verb the resource in the installed namespace, where credentials
+// are kept as secrets and audit events are recorded
+func (r Resource) installedNamespaceAccess(verb, resource string) accessFunc {
+	return func(request *restful.Request) ([]authorizationv1.ResourceAttributes, error) {
+		return []authorizationv1.ResourceAttributes{
+			{Verb: verb, Group: coreGroup, Resource: resource, Namespace: r.Defaults.Namespace},
+		}, nil
+	}
+}
+
+// visibleWebhooks returns the webhooks in the namespaces the caller of the request can list webhooks in
+func (r Resource) visibleWebhooks(request *restful.Request, hooks []webhook) []webhook {
+	if !r.Defaults.RequireAuthorization {
+		return hooks
+	}
+	c, ok := request.Attribute(callerAttribute).(caller)
+	if !ok {
+		return []webhook{}
+	}
+	allowed := map[string]bool{}
+	visible := []webhook{}
+	for _, hook := range hooks {
+		if _, reviewed := allowed[hook.Namespace]; !reviewed {
+			ok, err := r.isAllowed(c, webhookAttributes("list", hook.Namespace))
+			if err != nil {
+				logging.Log.Errorf("error reviewing the access of %s to webhooks in namespace %s: %s", c.User, hook.Namespace, err)
+			}
+			allowed[hook.Namespace] = ok
+		}
+		if allowed[hook.Namespace] {
+			visible = append(visible, hook)
+		}
+	}
+	return visible
+}
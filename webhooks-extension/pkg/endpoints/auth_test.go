@@ -0,0 +1,245 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// allowAccess allows the user the accesses, described as by describeAccess, in SubjectAccessReviews made with the client
+func allowAccess(client *fakek8sclientset.Clientset, user string, accesses ...string) {
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview).DeepCopy()
+		for _, access := range accesses {
+			if review.Spec.User == user && describeAccess(*review.Spec.ResourceAttributes) == access {
+				review.Status.Allowed = true
+			}
+		}
+		return true, review, nil
+	})
+}
+
+// authorizedServer returns a container serving the extension routes, authorizing requests as alice with the token
+// valid, with the accesses allowed
+func authorizedServer(r *Resource, accesses ...string) *restful.Container {
+	r.Defaults.RequireAuthorization = true
+	client := r.K8sClient.(*fakek8sclientset.Clientset)
+	reviewTokens(client, "valid", "alice", "developers")
+	allowAccess(client, "alice", accesses...)
+	container := restful.NewContainer()
+	container.Router(restful.CurlyRouter{})
+	r.RegisterExtensionWebService(container)
+	return container
+}
+
+func TestAuthenticate(t *testing.T) {
+	r := dummyResource()
+	container := authorizedServer(r, "list secrets in namespace default")
+	client := r.K8sClient.(*fakek8sclientset.Clientset)
+	client.CoreV1().Namespaces().Create(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		trusted bool
+		code    int
+	}{
+		{name: "bearer token", headers: map[string]string{"Authorization": "Bearer valid"}, code: http.StatusOK},
+		{name: "invalid bearer token", headers: map[string]string{"Authorization": "Bearer invalid", "X-Forwarded-User": "alice"}, trusted: true, code: http.StatusUnauthorized},
+		{name: "no bearer token", code: http.StatusUnauthorized},
+		{name: "untrusted forwarded headers", headers: map[string]string{"X-Forwarded-User": "alice"}, code: http.StatusUnauthorized},
+		{name: "trusted forwarded headers", headers: map[string]string{"X-Forwarded-User": "alice"}, trusted: true, code: http.StatusOK},
+		{name: "trusted forwarded headers of another user", headers: map[string]string{"X-Forwarded-User": "bob"}, trusted: true, code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The filters read the defaults of the resource when the routes are registered
+			r.Defaults.TrustForwardedHeaders = tt.trusted
+			container := restful.NewContainer()
+			container.Router(restful.CurlyRouter{})
+			r.RegisterExtensionWebService(container)
+
+			httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/credentials", nil)
+			for header, value := range tt.headers {
+				httpReq.Header.Set(header, value)
+			}
+			recorder := httptest.NewRecorder()
+			container.ServeHTTP(recorder, httpReq)
+			if recorder.Code != tt.code {
+				t.Errorf("Expected status %d, got %d: %s", tt.code, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// Without authorization required, requests are not authenticated
+	r.Defaults.RequireAuthorization = false
+	container = restful.NewContainer()
+	r.RegisterExtensionWebService(container)
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/credentials", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status 200 without authorization required, got %d", recorder.Code)
+	}
+}
+
+func TestAuthorizeWebhookChanges(t *testing.T) {
+	r := dummyResource()
+	container := authorizedServer(r,
+		"create webhooks.webhooks.tekton.dev in namespace foo",
+		"create pipelineruns.tekton.dev in namespace foo",
+		"impersonate serviceaccounts default in namespace foo",
+		"delete webhooks.webhooks.tekton.dev in namespace foo",
+		"get secrets github-secret in namespace default",
+	)
+
+	send := func(method, url string, hook *webhook) *httptest.ResponseRecorder {
+		var body []byte
+		if hook != nil {
+			body, _ = json.Marshal(hook)
+		}
+		httpReq := dummyHTTPRequest(method, url, bytes.NewBuffer(body))
+		httpReq.Header.Set("Authorization", "Bearer valid")
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, httpReq)
+		return recorder
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		hook    *webhook
+		message string
+	}{
+		{
+			name:    "create as another service account",
+			method:  "POST",
+			url:     "http://wwww.dummy.com:8080/webhooks",
+			hook:    &webhook{Name: "hook", Namespace: "foo", ServiceAccount: "deployer"},
+			message: "alice cannot impersonate serviceaccounts deployer in namespace foo",
+		},
+		{
+			name:    "create in another namespace",
+			method:  "POST",
+			url:     "http://wwww.dummy.com:8080/webhooks",
+			hook:    &webhook{Name: "hook", Namespace: "bar"},
+			message: "alice cannot create webhooks.webhooks.tekton.dev in namespace bar",
+		},
+		{
+			name:    "create with another credential",
+			method:  "POST",
+			url:     "http://wwww.dummy.com:8080/webhooks",
+			hook:    &webhook{Name: "hook", Namespace: "foo", AccessTokenRef: "admin-secret"},
+			message: "alice cannot get secrets admin-secret in namespace default",
+		},
+		{
+			name:    "update",
+			method:  "PUT",
+			url:     "http://wwww.dummy.com:8080/webhooks/hook",
+			hook:    &webhook{Name: "hook", Namespace: "foo"},
+			message: "alice cannot update webhooks.webhooks.tekton.dev in namespace foo",
+		},
+		{
+			name:    "delete in another namespace",
+			method:  "DELETE",
+			url:     "http://wwww.dummy.com:8080/webhooks/hook?namespace=bar&repository=https://github.com/owner/repo",
+			message: "alice cannot delete webhooks.webhooks.tekton.dev in namespace bar",
+		},
+		{
+			name:    "repair drift",
+			method:  "POST",
+			url:     "http://wwww.dummy.com:8080/webhooks/drift",
+			message: "alice cannot update webhooks.webhooks.tekton.dev in all namespaces",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := send(tt.method, tt.url, tt.hook)
+			if recorder.Code != http.StatusForbidden || !strings.Contains(recorder.Body.String(), tt.message) {
+				t.Errorf("Expected status 403 with message %q, got %d: %s", tt.message, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	// Allowed requests reach the handler, which fails validating the webhook without a pipeline
+	recorder := send("POST", "http://wwww.dummy.com:8080/webhooks", &webhook{Name: "hook", Namespace: "foo", AccessTokenRef: "github-secret"})
+	if recorder.Code == http.StatusForbidden || recorder.Code == http.StatusUnauthorized {
+		t.Errorf("Expected the webhook creation to be authorized, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWebhookChangeAccess(t *testing.T) {
+	r := dummyResource()
+	body := `{"name":"hook","namespace":"foo","serviceaccount":"deployer","accesstoken":"github-secret"}`
+	request := dummyRestfulRequest(dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks", strings.NewReader(body)), "")
+	access, err := r.webhookChangeAccess("create")(request)
+	if err != nil {
+		t.Fatalf("Error getting the access: %s", err)
+	}
+	expected := []string{
+		"create webhooks.webhooks.tekton.dev in namespace foo",
+		"create pipelineruns.tekton.dev in namespace foo",
+		"impersonate serviceaccounts deployer in namespace foo",
+		"get secrets github-secret in namespace default",
+	}
+	got := []string{}
+	for _, attributes := range access {
+		got = append(got, describeAccess(attributes))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected access %v, got %v", expected, got)
+	}
+
+	// The body is left for the handler to read
+	if read, _ := ioutil.ReadAll(request.Request.Body); string(read) != body {
+		t.Errorf("Expected the body to be read again, got %s", read)
+	}
+
+	request = dummyRestfulRequest(dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks", strings.NewReader(`{"name":"hook"}`)), "")
+	if _, err := r.webhookChangeAccess("create")(request); err == nil {
+		t.Error("Expected an error for a webhook without a namespace")
+	}
+}
+
+func TestVisibleWebhooks(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.RequireAuthorization = true
+	allowAccess(r.K8sClient.(*fakek8sclientset.Clientset), "alice", "list webhooks.webhooks.tekton.dev in namespace foo")
+
+	hooks := []webhook{{Name: "one", Namespace: "foo"}, {Name: "two", Namespace: "bar"}, {Name: "three", Namespace: "foo"}}
+	request := dummyRestfulRequest(dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks", nil), "")
+	if visible := r.visibleWebhooks(request, hooks); len(visible) != 0 {
+		t.Errorf("Expected no webhooks to be visible to an unauthenticated caller, got %+v", visible)
+	}
+	request.SetAttribute(callerAttribute, caller{User: "alice"})
+	visible := r.visibleWebhooks(request, hooks)
+	if len(visible) != 2 || visible[0].Name != "one" || visible[1].Name != "three" {
+		t.Errorf("Expected the webhooks in namespace foo, got %+v", visible)
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"golang.org/x/xerrors"
+)
+
+// bitbucketHook is a repository webhook as used by both Bitbucket Cloud and Bitbucket Server.
+// Cloud identifies hooks by UUID and Server by ID, Server takes the secret in its configuration.
+type bitbucketHook struct {
+	ID            int                     `json:"id,omitempty"`
+	UUID          string                  `json:"uuid,omitempty"`
+	Name          string                  `json:"name,omitempty"`
+	Description   string                  `json:"description,omitempty"`
+	URL           string                  `json:"url"`
+	Active        bool                    `json:"active"`
+	Events        []string                `json:"events"`
+	Secret        string                  `json:"secret,omitempty"`
+	Configuration *bitbucketConfiguration `json:"configuration,omitempty"`
+}
+
+type bitbucketConfiguration struct {
+	Secret string `json:"secret,omitempty"`
+}
+
+// bitbucketHookPage is a page of hooks returned when listing hooks. Bitbucket Cloud links the next page,
+// Bitbucket Server gives the start of the next page unless it is the last page.
+type bitbucketHookPage struct {
+	Values        []bitbucketHook `json:"values"`
+	Next          string          `json:"next,omitempty"`
+	IsLastPage    bool            `json:"isLastPage,omitempty"`
+	NextPageStart int             `json:"nextPageStart,omitempty"`
+}
+
+// isBitbucketCloud returns whether the url is for Bitbucket Cloud or a Bitbucket Server
+func isBitbucketCloud(u *url.URL) bool {
+	return (u.Host == "bitbucket.org")
+}
+
+// getBitbucketHooksAPI returns the API URL for the webhooks of the Bitbucket repository
+func getBitbucketHooksAPI(u *url.URL) (string, error) {
+	pieces := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+
+	// Bitbucket Cloud API URL is "https://api.bitbucket.org/2.0/repositories/<workspace>/<repo>/hooks"
+	if isBitbucketCloud(u) {
+		if len(pieces) != 2 {
+			return "", xerrors.Errorf("Bitbucket Cloud repo URL %s should have the form https://bitbucket.org/<workspace>/<repo>", u)
+		}
+		return fmt.Sprintf("https://api.bitbucket.org/2.0/repositories/%s/%s/hooks", pieces[0], pieces[1]), nil
+	}
+
+	// Bitbucket Server API URL is "https://my.company.xyz/rest/api/1.0/projects/<project>/repos/<repo>/webhooks"
+	// for both the clone URL "https://my.company.xyz/scm/<project>/<repo>.git"
+	// and the browse URL "https://my.company.xyz/projects/<project>/repos/<repo>"
+	var project, repo string
+	if len(pieces) == 3 && pieces[0] == "scm" {
+		project, repo = pieces[1], pieces[2]
+	} else if len(pieces) >= 4 && pieces[0] == "projects" && pieces[2] == "repos" {
+		project, repo = pieces[1], pieces[3]
+	} else {
+		return "", xerrors.Errorf("Bitbucket Server repo URL %s should have the form https://<host>/scm/<project>/<repo>", u)
+	}
+	return fmt.Sprintf("%s://%s/rest/api/1.0/projects/%s/repos/%s/webhooks", u.Scheme, u.Host, project, repo), nil
+}
+
+// getBitbucketEvents returns the Bitbucket event keys for the webhook events
+func getBitbucketEvents(u *url.URL, events []string) ([]string, error) {
+	bitbucketEvents := []string{}
+	for _, event := range events {
+		switch event {
+		case "push":
+			if isBitbucketCloud(u) {
+				bitbucketEvents = append(bitbucketEvents, "repo:push")
+			} else {
+				bitbucketEvents = append(bitbucketEvents, "repo:refs_changed")
+			}
+		case "pull_request":
+			if isBitbucketCloud(u) {
+				bitbucketEvents = append(bitbucketEvents, "pullrequest:created", "pullrequest:updated")
+			} else {
+				bitbucketEvents = append(bitbucketEvents, "pr:opened", "pr:from_ref_updated")
+			}
+		default:
+			return nil, xerrors.Errorf("event %s is not supported for Bitbucket webhooks", event)
+		}
+	}
+	return bitbucketEvents, nil
+}
+
+// newBitbucketHook returns the Bitbucket webhook for the callback, signed with the secret and sending the events
+func newBitbucketHook(u *url.URL, callback, secret string, events []string) (bitbucketHook, error) {
+	bitbucketEvents, err := getBitbucketEvents(u, events)
+	if err != nil {
+		return bitbucketHook{}, err
+	}
+	hook := bitbucketHook{
+		URL:    callback,
+		Active: true,
+		Events: bitbucketEvents,
+	}
+	if isBitbucketCloud(u) {
+		hook.Description = "Tekton webhooks extension"
+		hook.Secret = secret
+	} else {
+		hook.Name = "Tekton webhooks extension"
+		hook.Configuration = &bitbucketConfiguration{Secret: secret}
+	}
+	return hook, nil
+}
+
+// getBitbucketHookURL returns the API URL of the webhook, identified by its UUID on Bitbucket Cloud and its ID on Bitbucket Server
+func getBitbucketHookURL(u *url.URL, hooksAPI string, hook bitbucketHook) string {
+	if isBitbucketCloud(u) {
+		return fmt.Sprintf("%s/%s", hooksAPI, url.PathEscape(hook.UUID))
+	}
+	return fmt.Sprintf("%s/%d", hooksAPI, hook.ID)
+}
+
+// doBitbucketHookRequest creates, removes or updates a Bitbucket repository webhook given the specified parameters
+// Bitbucket Cloud API documentation: https://developer.atlassian.com/bitbucket/api/2/reference/resource/repositories/%7Bworkspace%7D/%7Brepo_slug%7D/hooks
+// Bitbucket Server API documentation: https://docs.atlassian.com/bitbucket-server/rest/latest/bitbucket-rest.html
+// mode: "subscribe", "unsubscribe" or "update", which replaces the secret and events of the existing webhook
+// callback: the URI to receive the updates
+// secret: shared secret key used to sign the X-Hub-Signature header
+// events: the list of events to subscribe to; for example, {"push", "pull_request"}
+func doBitbucketHookRequest(client *http.Client, repoURL, mode, callback, secret string, events []string) error {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return xerrors.Errorf("error parsing Bitbucket repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getBitbucketHooksAPI(u)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "subscribe":
+		hook, err := newBitbucketHook(u, callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling Bitbucket webhook: %w", err)
+		}
+		resp, err := client.Post(hooksAPI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return xerrors.Errorf("error sending Bitbucket webhook %s request: %w", mode, err)
+		}
+		defer resp.Body.Close()
+		// Should receive 201 Created on success
+		if resp.StatusCode != http.StatusCreated {
+			return xerrors.Errorf("error sending Bitbucket webhook %s request. Status: %s", mode, resp.Status)
+		}
+		logging.Log.Debugf("Bitbucket webhook %s response: %s", mode, resp.Status)
+		return nil
+	case "unsubscribe":
+		hooks, err := listBitbucketHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			hookURL := getBitbucketHookURL(u, hooksAPI, hook)
+			req, err := http.NewRequest(http.MethodDelete, hookURL, nil)
+			if err != nil {
+				return xerrors.Errorf("error creating Bitbucket webhook %s request: %w", mode, err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending Bitbucket webhook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 204 No Content on success
+			if resp.StatusCode != http.StatusNoContent {
+				return xerrors.Errorf("error sending Bitbucket webhook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("Bitbucket webhook %s (%s) response: %s", mode, hookURL, resp.Status)
+		}
+		return nil
+	case "update":
+		hook, err := newBitbucketHook(u, callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling Bitbucket webhook: %w", err)
+		}
+		hooks, err := listBitbucketHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		updated := false
+		for _, existing := range hooks {
+			// The webhook is replaced as a whole
+			hookURL := getBitbucketHookURL(u, hooksAPI, existing)
+			req, err := http.NewRequest(http.MethodPut, hookURL, bytes.NewReader(body))
+			if err != nil {
+				return xerrors.Errorf("error creating Bitbucket webhook %s request: %w", mode, err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending Bitbucket webhook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 200 OK on success
+			if resp.StatusCode != http.StatusOK {
+				return xerrors.Errorf("error sending Bitbucket webhook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("Bitbucket webhook %s (%s) response: %s", mode, hookURL, resp.Status)
+			updated = true
+		}
+		if !updated {
+			return xerrors.Errorf("no Bitbucket webhook for %s is registered on repository %s", callback, repoURL)
+		}
+		return nil
+	default:
+		return xerrors.Errorf("unknown Bitbucket webhook mode %s", mode)
+	}
+}
+
+// listBitbucketHooks returns the webhooks for the callback registered on the Bitbucket repository, following
+// the pages of webhooks until limit webhooks are found or, if limit is 0, the pages run out
+func listBitbucketHooks(client *http.Client, hooksAPI, callback string, limit int) ([]bitbucketHook, error) {
+	hooks := []bitbucketHook{}
+	for pageURL := hooksAPI; pageURL != ""; {
+		resp, err := client.Get(pageURL)
+		if err != nil {
+			return nil, xerrors.Errorf("error listing Bitbucket webhooks: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, xerrors.Errorf("error listing Bitbucket webhooks. Status: %s", resp.Status)
+		}
+		page := bitbucketHookPage{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("error decoding Bitbucket webhooks: %w", err)
+		}
+		for _, hook := range page.Values {
+			if hook.URL == callback {
+				hooks = append(hooks, hook)
+			}
+		}
+		if limit > 0 && len(hooks) >= limit {
+			break
+		}
+		switch {
+		case page.Next != "":
+			pageURL = page.Next
+		case !page.IsLastPage && page.NextPageStart > 0:
+			pageURL = fmt.Sprintf("%s?start=%d", hooksAPI, page.NextPageStart)
+		default:
+			pageURL = ""
+		}
+	}
+	return hooks, nil
+}
+
+// isBitbucketHookRegistered returns whether a webhook with the callback is registered on the Bitbucket repository
+func isBitbucketHookRegistered(client *http.Client, repoURL, callback string) (bool, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false, xerrors.Errorf("error parsing Bitbucket repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getBitbucketHooksAPI(u)
+	if err != nil {
+		return false, err
+	}
+	hooks, err := listBitbucketHooks(client, hooksAPI, callback, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(hooks) > 0, nil
+}
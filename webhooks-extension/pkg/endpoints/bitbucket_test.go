@@ -0,0 +1,267 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	fakerestclient "k8s.io/client-go/rest/fake"
+)
+
+func Test_getBitbucketHooksAPI(t *testing.T) {
+	tests := []struct {
+		rawurl  string
+		want    string
+		wantErr bool
+	}{
+		{
+			rawurl: "https://bitbucket.org/workspace/repo",
+			want:   "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks",
+		},
+		{
+			rawurl: "https://bitbucket.org/workspace/repo.git",
+			want:   "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks",
+		},
+		{
+			rawurl: "https://bitbucket.company.com/scm/proj/repo.git",
+			want:   "https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks",
+		},
+		{
+			rawurl: "https://bitbucket.company.com/projects/PROJ/repos/repo/browse",
+			want:   "https://bitbucket.company.com/rest/api/1.0/projects/PROJ/repos/repo/webhooks",
+		},
+		{
+			rawurl:  "https://bitbucket.company.com/owner/repo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawurl, func(t *testing.T) {
+			u, err := url.Parse(tt.rawurl)
+			if err != nil {
+				t.Errorf("getBitbucketHooksAPI() error parsing rawurl %s: %s", tt.rawurl, err)
+			}
+			got, err := getBitbucketHooksAPI(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getBitbucketHooksAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getBitbucketHooksAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_doBitbucketHookRequest_subscribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		repoURL string
+		wantAPI string
+		want    bitbucketHook
+	}{
+		{
+			name:    "Bitbucket Cloud",
+			repoURL: "https://bitbucket.org/workspace/repo",
+			wantAPI: "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks",
+			want: bitbucketHook{
+				Description: "Tekton webhooks extension",
+				URL:         "https://examplecallback.com",
+				Active:      true,
+				Events:      []string{"repo:push", "pullrequest:created", "pullrequest:updated"},
+				Secret:      "mySecret",
+			},
+		},
+		{
+			name:    "Bitbucket Server",
+			repoURL: "https://bitbucket.company.com/scm/proj/repo",
+			wantAPI: "https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks",
+			want: bitbucketHook{
+				Name:          "Tekton webhooks extension",
+				URL:           "https://examplecallback.com",
+				Active:        true,
+				Events:        []string{"repo:refs_changed", "pr:opened", "pr:from_ref_updated"},
+				Configuration: &bitbucketConfiguration{Secret: "mySecret"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeBitbucketClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+				if gotAPI := request.URL.String(); gotAPI != tt.wantAPI {
+					t.Errorf("doBitbucketHookRequest() expected API URL %s; got: %s", tt.wantAPI, gotAPI)
+				}
+				hook := bitbucketHook{}
+				if err := json.NewDecoder(request.Body).Decode(&hook); err != nil {
+					t.Errorf("doBitbucketHookRequest() error decoding request body: %s", err)
+				}
+				if !reflect.DeepEqual(hook, tt.want) {
+					t.Errorf("doBitbucketHookRequest() expected hook %+v; got: %+v", tt.want, hook)
+				}
+				return &http.Response{
+					StatusCode: http.StatusCreated,
+					Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+				}, nil
+			})
+			err := doBitbucketHookRequest(fakeBitbucketClient, tt.repoURL, "subscribe", "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+			if err != nil {
+				t.Errorf("doBitbucketHookRequest() returned an error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_doBitbucketHookRequest_unsubscribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		repoURL string
+		// The webhooks are listed by page URL, the webhook for the callback is on the second page
+		pages       map[string]bitbucketHookPage
+		wantDeleted string
+	}{
+		{
+			name:    "Bitbucket Cloud",
+			repoURL: "https://bitbucket.org/workspace/repo",
+			pages: map[string]bitbucketHookPage{
+				"https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks": {
+					Values: []bitbucketHook{{UUID: "{other}", URL: "https://othercallback.com"}},
+					Next:   "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks?page=2",
+				},
+				"https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks?page=2": {
+					Values: []bitbucketHook{{UUID: "{mine}", URL: "https://examplecallback.com"}},
+				},
+			},
+			wantDeleted: "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks/%7Bmine%7D",
+		},
+		{
+			name:    "Bitbucket Server",
+			repoURL: "https://bitbucket.company.com/scm/proj/repo",
+			pages: map[string]bitbucketHookPage{
+				"https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks": {
+					Values:        []bitbucketHook{{ID: 1, URL: "https://othercallback.com"}},
+					NextPageStart: 1,
+				},
+				"https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks?start=1": {
+					Values:     []bitbucketHook{{ID: 2, URL: "https://examplecallback.com"}},
+					IsLastPage: true,
+				},
+			},
+			wantDeleted: "https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks/2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := []string{}
+			fakeBitbucketClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+				switch request.Method {
+				case http.MethodGet:
+					page, ok := tt.pages[request.URL.String()]
+					if !ok {
+						t.Errorf("doBitbucketHookRequest() unexpected URL %s", request.URL)
+					}
+					body, _ := json.Marshal(page)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+					}, nil
+				case http.MethodDelete:
+					deleted = append(deleted, request.URL.String())
+					return &http.Response{
+						StatusCode: http.StatusNoContent,
+						Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+					}, nil
+				}
+				t.Errorf("doBitbucketHookRequest() unexpected method %s", request.Method)
+				return nil, nil
+			})
+			err := doBitbucketHookRequest(fakeBitbucketClient, tt.repoURL, "unsubscribe", "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+			if err != nil {
+				t.Errorf("doBitbucketHookRequest() returned an error: %s", err)
+			}
+			if len(deleted) != 1 || deleted[0] != tt.wantDeleted {
+				t.Errorf("doBitbucketHookRequest() expected only %s to be deleted; got: %v", tt.wantDeleted, deleted)
+			}
+		})
+	}
+}
+
+func Test_doBitbucketHookRequest_update(t *testing.T) {
+	tests := []struct {
+		name        string
+		repoURL     string
+		hooks       []bitbucketHook
+		wantUpdated string
+		secret      func(bitbucketHook) string
+	}{
+		{
+			name:        "Bitbucket Cloud",
+			repoURL:     "https://bitbucket.org/workspace/repo",
+			hooks:       []bitbucketHook{{UUID: "{other}", URL: "https://othercallback.com"}, {UUID: "{mine}", URL: "https://examplecallback.com"}},
+			wantUpdated: "https://api.bitbucket.org/2.0/repositories/workspace/repo/hooks/%7Bmine%7D",
+			secret:      func(hook bitbucketHook) string { return hook.Secret },
+		},
+		{
+			name:        "Bitbucket Server",
+			repoURL:     "https://bitbucket.company.com/scm/proj/repo",
+			hooks:       []bitbucketHook{{ID: 1, URL: "https://othercallback.com"}, {ID: 2, URL: "https://examplecallback.com"}},
+			wantUpdated: "https://bitbucket.company.com/rest/api/1.0/projects/proj/repos/repo/webhooks/2",
+			secret: func(hook bitbucketHook) string {
+				if hook.Configuration == nil {
+					return ""
+				}
+				return hook.Configuration.Secret
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := []string{}
+			fakeBitbucketClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+				switch request.Method {
+				case http.MethodGet:
+					body, _ := json.Marshal(bitbucketHookPage{Values: tt.hooks})
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+					}, nil
+				case http.MethodPut:
+					hook := bitbucketHook{}
+					json.NewDecoder(request.Body).Decode(&hook)
+					if tt.secret(hook) != "newSecret" || hook.URL != "https://examplecallback.com" || len(hook.Events) == 0 {
+						t.Errorf("doBitbucketHookRequest() expected the webhook to be replaced with the new secret; got: %+v", hook)
+					}
+					updated = append(updated, request.URL.String())
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+					}, nil
+				}
+				t.Errorf("doBitbucketHookRequest() unexpected method %s", request.Method)
+				return nil, nil
+			})
+			err := doBitbucketHookRequest(fakeBitbucketClient, tt.repoURL, "update", "https://examplecallback.com", "newSecret", []string{"push", "pull_request"})
+			if err != nil {
+				t.Errorf("doBitbucketHookRequest() returned an error: %s", err)
+			}
+			if len(updated) != 1 || updated[0] != tt.wantUpdated {
+				t.Errorf("doBitbucketHookRequest() expected only %s to be updated; got: %v", tt.wantUpdated, updated)
+			}
+		})
+	}
+}
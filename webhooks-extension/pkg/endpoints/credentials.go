@@ -14,10 +14,10 @@ limitations under the License.
 package endpoints
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
 	"net/http"
-	"time"
+	"strconv"
 
 	restful "github.com/emicklei/go-restful"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
@@ -26,37 +26,59 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// 'credentials' from the webhooks-extension's point of view, are access tokens. That's the only sort we handle right now.
+// 'credentials' from the webhooks-extension's point of view, are either personal access tokens
+// or GitHub Apps, identified by an app ID and installation ID, that mint installation tokens with their private key.
 type credential struct {
-	Name        string `json:"name"`
-	AccessToken string `json:"accesstoken"`
-	SecretToken string `json:"secrettoken,omitempty"`
+	Name           string `json:"name"`
+	AccessToken    string `json:"accesstoken"`
+	SecretToken    string `json:"secrettoken,omitempty"`
+	AppID          int64  `json:"appid,omitempty"`
+	InstallationID int64  `json:"installationid,omitempty"`
+	PrivateKey     string `json:"privatekey,omitempty"`
 }
 
 /*--------------------------------------
-This file implements three endpoints from webhooks.go:
+This file implements five endpoints from webhooks.go:
 	ws.Route(ws.POST("/credentials").To(r.createCredential))
 	ws.Route(ws.GET("/credentials").To(r.getAllCredentials))
+	ws.Route(ws.PUT("/credentials/{name}").To(r.updateCredential))
+	ws.Route(ws.POST("/credentials/{name}/rotate-secret").To(r.rotateCredentialSecret))
 	ws.Route(ws.DELETE("/credentials/{name}").To(r.deleteCredential))
 ---------------------------------------*/
 
+// rotatedHook is the result of updating a git provider hook signed with a credential's rotated secret token
+type rotatedHook struct {
+	EventListener string `json:"eventlistener"`
+	Repository    string `json:"repository"`
+	Error         string `json:"error,omitempty"`
+	// RolledBack is whether the hook was signed with the previous secret token again after another hook failed
+	RolledBack bool `json:"rolledback,omitempty"`
+}
+
 func (r Resource) createCredential(request *restful.Request, response *restful.Response) {
 	logging.Log.Debug("In createCredential")
+	audit := newAuditRecord("create", "credential")
+	defer r.recordAudit(request, response, audit)
 	cred := credential{}
 
 	if err := getQueryEntity(&cred, request, response); err != nil {
 		logging.Log.Errorf("Error processing query entity: %s", err.Error())
 		return
 	}
+	audit.Name = cred.Name
+	logger := requestLogger(request, "credential", cred.Name)
 
 	if !r.verifyCredentialParameters(cred, response) {
-		logging.Log.Error("Error verifying credential parameters")
+		logger.Error("Error verifying credential parameters")
 		return
 	}
 
 	secret := r.credentialToSecret(cred, response)
+	if secret == nil {
+		return
+	}
 
-	logging.Log.Debugf("Creating credential %s in namespace %s", cred.Name, r.Defaults.Namespace)
+	logger.Debugf("Creating credential %s in namespace %s", cred.Name, r.Defaults.Namespace)
 
 	if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Create(secret); err != nil {
 		errorMessage := fmt.Sprintf("error creating secret in K8sClient: %s", err.Error())
@@ -68,10 +90,14 @@ func (r Resource) createCredential(request *restful.Request, response *restful.R
 
 func (r Resource) deleteCredential(request *restful.Request, response *restful.Response) {
 	credName := request.PathParameter("name")
+	audit := newAuditRecord("delete", "credential")
+	audit.Name = credName
+	defer r.recordAudit(request, response, audit)
+	logger := requestLogger(request, "credential", credName)
 	if !r.verifySecretExists(credName, response) {
 		return
 	}
-	logging.Log.Debugf("Deleting credential %s", credName)
+	logger.Debugf("Deleting credential %s", credName)
 	err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Delete(credName, &metav1.DeleteOptions{})
 	if err != nil {
 		errorMessage := fmt.Sprintf("error deleting secret from K8sClient: %s.", err.Error())
@@ -81,6 +107,145 @@ func (r Resource) deleteCredential(request *restful.Request, response *restful.R
 	response.WriteHeader(204)
 }
 
+// updateCredential replaces the access token, or the GitHub App, of a credential. The secret token is kept, as the
+// hooks registered with the credential are signed with it, and is changed with rotateCredentialSecret.
+func (r Resource) updateCredential(request *restful.Request, response *restful.Response) {
+	credName := request.PathParameter("name")
+	audit := newAuditRecord("update", "credential")
+	audit.Name = credName
+	defer r.recordAudit(request, response, audit)
+	logger := requestLogger(request, "credential", credName)
+	cred := credential{}
+
+	if err := getQueryEntity(&cred, request, response); err != nil {
+		logger.Errorf("Error processing query entity: %s", err.Error())
+		return
+	}
+	if cred.Name == "" {
+		cred.Name = credName
+	}
+	if cred.Name != credName {
+		errorMessage := fmt.Sprintf("error: the credential name %s does not match the name %s in the path, credentials cannot be renamed", cred.Name, credName)
+		utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
+		return
+	}
+	if cred.SecretToken != "" {
+		errorMessage := fmt.Sprintf("error: SecretToken must not be specified, it is rotated with POST /webhooks/credentials/%s/rotate-secret", credName)
+		utils.RespondErrorMessage(response, errorMessage, http.StatusBadRequest)
+		return
+	}
+	if !r.verifyCredentialParameters(cred, response) {
+		logger.Error("Error verifying credential parameters")
+		return
+	}
+	secret, ok := r.getCredentialSecret(credName, response)
+	if !ok {
+		return
+	}
+
+	// A GitHub App's stored installation token is dropped with the rest of the data, and minted again when next used
+	replacement := r.credentialToSecret(cred, response)
+	if replacement == nil {
+		return
+	}
+	replacement.Data["secretToken"] = secret.Data["secretToken"]
+	secret.Data = replacement.Data
+
+	logger.Debugf("Updating credential %s in namespace %s", credName, r.Defaults.Namespace)
+	if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Update(secret); err != nil {
+		errorMessage := fmt.Sprintf("error updating secret in K8sClient: %s", err.Error())
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// rotateCredentialSecret generates a new secret token for a credential and updates the git provider hooks
+// registered with the credential to sign their events with it. The new secret token is only stored once every hook
+// is updated. If any hook could not be updated, the hooks already updated are signed with the previous secret token
+// again, which is kept, and HTTP code 500 is returned. The result for each hook is returned.
+func (r Resource) rotateCredentialSecret(request *restful.Request, response *restful.Response) {
+	credName := request.PathParameter("name")
+	audit := newAuditRecord("rotate", "credential")
+	audit.Name = credName
+	defer r.recordAudit(request, response, audit)
+	logger := requestLogger(request, "credential", credName)
+	// Hooks registered while rotating would be signed with either secret token
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+
+	secret, ok := r.getCredentialSecret(credName, response)
+	if !ok {
+		return
+	}
+	webhooks, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		errorMessage := fmt.Sprintf("error getting the webhooks using credential %s: %s", credName, err.Error())
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+		return
+	}
+
+	// Each hook is shared by the webhooks on its repository in an eventlistener
+	hooks := []webhook{}
+	seen := map[string]bool{}
+	for _, hook := range webhooks {
+		key := hook.EventListener + "/" + hook.GitRepositoryURL
+		if hook.AccessTokenRef == credName && !seen[key] {
+			seen[key] = true
+			hooks = append(hooks, hook)
+		}
+	}
+
+	secretToken, err := getRandomSecretToken()
+	if err != nil {
+		errorMessage := fmt.Sprintf("error generating a secret token for credential %s: %s", credName, err.Error())
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+		return
+	}
+	rotated := []rotatedHook{}
+	var failed error
+	for _, hook := range hooks {
+		result := rotatedHook{EventListener: hook.EventListener, Repository: hook.GitRepositoryURL}
+		if _, err := r.doSignedWebhookRequest(hook, "update", getHookEvents(hook), string(secretToken)); err != nil {
+			logger.Errorf("error updating the hook for repository %s in eventlistener %s with the secret token of credential %s: %s",
+				hook.GitRepositoryURL, hook.EventListener, credName, err)
+			result.Error = err.Error()
+			failed = err
+		}
+		rotated = append(rotated, result)
+		if failed != nil {
+			break
+		}
+	}
+
+	if failed == nil {
+		secret.Data["secretToken"] = secretToken
+		if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Update(secret); err != nil {
+			logger.Errorf("error updating secret in K8sClient: %s", err)
+			failed = err
+		}
+	}
+	if failed == nil {
+		response.WriteHeaderAndEntity(http.StatusOK, rotated)
+		return
+	}
+
+	// The hooks updated are signed with the secret token kept by the credential again
+	for i, hook := range hooks[:len(rotated)] {
+		if rotated[i].Error != "" {
+			continue
+		}
+		if _, err := r.doWebhookRequest(hook, "update", getHookEvents(hook)); err != nil {
+			logger.Errorf("error restoring the secret token of credential %s on the hook for repository %s in eventlistener %s: %s",
+				credName, hook.GitRepositoryURL, hook.EventListener, err)
+			rotated[i].Error = fmt.Sprintf("error restoring the previous secret token: %s", err)
+			continue
+		}
+		rotated[i].RolledBack = true
+	}
+	response.WriteHeaderAndEntity(http.StatusInternalServerError, rotated)
+}
+
 func (r Resource) getAllCredentials(request *restful.Request, response *restful.Response) {
 	// Get secrets from the resource K8sClient
 	secrets, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).List(metav1.ListOptions{})
@@ -121,7 +286,22 @@ func (r Resource) verifySecretExists(secretName string, response *restful.Respon
 	return true
 }
 
-// Convert credential struct into K8s secret struct
+// getCredentialSecret returns the secret of the credential. Sends error message 404 if there is no such credential.
+func (r Resource) getCredentialSecret(credName string, response *restful.Response) (*corev1.Secret, bool) {
+	secret, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(credName, metav1.GetOptions{})
+	if err == nil && secretToCredential(secret, true) == (credential{}) {
+		err = fmt.Errorf("secret %s is not a credential", credName)
+	}
+	if err != nil {
+		errorMessage := fmt.Sprintf("error getting credential from K8sClient: '%s'.", credName)
+		utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusNotFound)
+		return nil, false
+	}
+	return secret, true
+}
+
+// Convert credential struct into K8s secret struct, responding with HTTP code 500 and returning nil
+// if a secret token could not be generated
 func (r Resource) credentialToSecret(cred credential, response *restful.Response) *corev1.Secret {
 	// Create new secret struct
 	secret := corev1.Secret{}
@@ -129,43 +309,75 @@ func (r Resource) credentialToSecret(cred credential, response *restful.Response
 	secret.SetNamespace(r.Defaults.Namespace)
 	secret.SetName(cred.Name)
 	secret.Data = make(map[string][]byte)
-	secret.Data["accessToken"] = []byte(cred.AccessToken)
+	if cred.AppID != 0 {
+		// The access token is minted from the private key when first used
+		secret.Data[gitHubAppIDKey] = []byte(strconv.FormatInt(cred.AppID, 10))
+		secret.Data[gitHubAppInstallationIDKey] = []byte(strconv.FormatInt(cred.InstallationID, 10))
+		secret.Data[gitHubAppPrivateKeyKey] = []byte(cred.PrivateKey)
+	} else {
+		secret.Data["accessToken"] = []byte(cred.AccessToken)
+	}
 	if cred.SecretToken != "" {
 		secret.Data["secretToken"] = []byte(cred.SecretToken)
 	} else {
-		secret.Data["secretToken"] = getRandomSecretToken()
+		secretToken, err := getRandomSecretToken()
+		if err != nil {
+			errorMessage := fmt.Sprintf("error generating a secret token for credential %s: %s", cred.Name, err.Error())
+			utils.RespondMessageAndLogError(response, err, errorMessage, http.StatusInternalServerError)
+			return nil
+		}
+		secret.Data["secretToken"] = secretToken
 	}
 	return &secret
 }
 
-var (
-	src = rand.NewSource(time.Now().UnixNano())
-)
-
 const (
 	tokenBytes   = "123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	tokenIdxBits = 6                   // 6 bits = 2^6 = 64 characters in tokenBytes
 	tokenIdxMask = 1<<tokenIdxBits - 1 // All 1-bits, as many as tokenIdxBits
 )
 
-// Generate a random 20-character string, returned as []byte.
-// With thanks to https://medium.com/@kpbird/golang-generate-fixed-size-random-string-dd6dbd5e63c0
-func getRandomSecretToken() []byte {
+// Generate a random 20-character string, returned as []byte. The characters are read from crypto/rand, as
+// secret tokens sign the events of the git providers and are rotated when they may have leaked. Random bytes
+// mapping past the end of tokenBytes are discarded, so each character is equally likely.
+func getRandomSecretToken() ([]byte, error) {
 	b := make([]byte, 20)
-	for i := 0; i < 20; {
-		idx := int(src.Int63() & tokenIdxMask)
-		if idx < len(tokenBytes) {
-			b[i] = tokenBytes[idx]
-			i++
+	random := make([]byte, 32)
+	for i := 0; i < len(b); {
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		for _, r := range random {
+			idx := int(r & tokenIdxMask)
+			if idx < len(tokenBytes) && i < len(b) {
+				b[i] = tokenBytes[idx]
+				i++
+			}
 		}
 	}
-	return b
+	return b, nil
 }
 
 // Convert K8s secret struct into credential struct
 func secretToCredential(secret *corev1.Secret, mask bool) credential {
 	var cred credential
-	if secret.Data["accessToken"] != nil {
+	if isGitHubAppSecret(secret) {
+		appID, _ := strconv.ParseInt(string(secret.Data[gitHubAppIDKey]), 10, 64)
+		installationID, _ := strconv.ParseInt(string(secret.Data[gitHubAppInstallationIDKey]), 10, 64)
+		cred = credential{
+			Name:           secret.GetName(),
+			AccessToken:    string(secret.Data["accessToken"]),
+			SecretToken:    string(secret.Data["secretToken"]),
+			AppID:          appID,
+			InstallationID: installationID,
+			PrivateKey:     string(secret.Data[gitHubAppPrivateKeyKey]),
+		}
+		if mask {
+			cred.AccessToken = "********"
+			cred.SecretToken = "********"
+			cred.PrivateKey = "********"
+		}
+	} else if secret.Data["accessToken"] != nil {
 		cred = credential{
 			Name:        secret.GetName(),
 			AccessToken: string(secret.Data["accessToken"]),
@@ -193,6 +405,14 @@ func (r Resource) verifyCredentialParameters(cred credential, response *restful.
 	errorMessage := ""
 	if cred.Name == "" {
 		errorMessage = fmt.Sprintf("error: Name must be specified")
+	} else if cred.AppID != 0 || cred.InstallationID != 0 || cred.PrivateKey != "" {
+		if cred.AccessToken != "" {
+			errorMessage = fmt.Sprintf("error: AccessToken must not be specified for a GitHub App")
+		} else if cred.AppID == 0 || cred.InstallationID == 0 || cred.PrivateKey == "" {
+			errorMessage = fmt.Sprintf("error: AppID, InstallationID and PrivateKey must be specified for a GitHub App")
+		} else if _, err := parseGitHubAppPrivateKey([]byte(cred.PrivateKey)); err != nil {
+			errorMessage = fmt.Sprintf("error: PrivateKey must be a PEM encoded RSA private key")
+		}
 	} else if cred.AccessToken == "" {
 		errorMessage = fmt.Sprintf("error: AccessToken must be specified")
 	}
@@ -18,12 +18,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestCreateBadAccessToken(t *testing.T) {
@@ -49,6 +51,68 @@ func TestAccessTokenWithSecret(t *testing.T) {
 	createAndCheckCredential(accessTokenWithSecret, "", r, t)
 }
 
+func TestGitHubAppCredential(t *testing.T) {
+	r := dummyResource()
+	_, keyPEM := newTestGitHubAppKey(t)
+
+	gitHubApp := credential{
+		Name:           "github-app",
+		SecretToken:    "thisIsMySecretToken",
+		AppID:          1234,
+		InstallationID: 42,
+		PrivateKey:     keyPEM,
+	}
+	createAndCheckCredential(gitHubApp, "", r, t)
+
+	// The tokens and private key are masked
+	httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8383/webhooks/credentials", nil)
+	req := dummyRestfulRequest(httpReq, "")
+	httpWriter := httptest.NewRecorder()
+	resp := dummyRestfulResponse(httpWriter)
+	r.getAllCredentials(req, resp)
+
+	result := []credential{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&result); err != nil {
+		t.Fatalf("FAIL: ERROR - decoding response body: %s", err.Error())
+	}
+	gitHubApp.AccessToken = "********"
+	gitHubApp.SecretToken = "********"
+	gitHubApp.PrivateKey = "********"
+	testCredentials(result, []credential{gitHubApp}, t)
+}
+
+func TestCreateBadGitHubAppCredential(t *testing.T) {
+	r := dummyResource()
+	_, keyPEM := newTestGitHubAppKey(t)
+
+	missingInstallation := credential{
+		Name:       "github-app",
+		AppID:      1234,
+		PrivateKey: keyPEM,
+	}
+	createAndCheckCredential(missingInstallation, "error: AppID, InstallationID and PrivateKey must be specified for a GitHub App", r, t)
+
+	badPrivateKey := credential{
+		Name:           "github-app",
+		AppID:          1234,
+		InstallationID: 42,
+		PrivateKey:     "notAKey",
+	}
+	createAndCheckCredential(badPrivateKey, "error: PrivateKey must be a PEM encoded RSA private key", r, t)
+
+	withAccessToken := credential{
+		Name:           "github-app",
+		AccessToken:    "alongstringofcharacters",
+		AppID:          1234,
+		InstallationID: 42,
+		PrivateKey:     keyPEM,
+	}
+	createAndCheckCredential(withAccessToken, "error: AccessToken must not be specified for a GitHub App", r, t)
+
+	// Verify no credentials have been created
+	checkCredentials([]credential{}, "", r, t)
+}
+
 // Should be "default" which is r.dummyResource.namespace's value
 
 func TestAccessTokenWithNoNamespaceUsesDefault(t *testing.T) {
@@ -169,6 +233,60 @@ func TestDeleteACredentialThatDoesNotExist(t *testing.T) {
 	}
 }
 
+func TestUpdateCredential(t *testing.T) {
+	r := dummyResource()
+	createAndCheckCredential(credential{Name: "token", AccessToken: "leaked", SecretToken: "mySecretToken"}, "", r, t)
+
+	update := func(name string, cred credential) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(cred)
+		httpReq := dummyHTTPRequest("PUT", "http://wwww.dummy.com:8383/webhooks/credentials/"+name, bytes.NewBuffer(jsonBody))
+		httpWriter := httptest.NewRecorder()
+		r.updateCredential(dummyRestfulRequest(httpReq, name), dummyRestfulResponse(httpWriter))
+		return httpWriter
+	}
+
+	// The access token is replaced and the secret token kept, as hooks are signed with it
+	if resp := update("token", credential{AccessToken: "replacement"}); resp.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 updating the credential but got %d: %s", resp.Code, resp.Body.String())
+	}
+	compareCredentials(r.getK8sCredential("token"), credential{Name: "token", AccessToken: "replacement", SecretToken: "mySecretToken"}, t)
+
+	// A GitHub App replacing the access token drops it, the installation token is minted when next used
+	_, keyPEM := newTestGitHubAppKey(t)
+	if resp := update("token", credential{AppID: 1234, InstallationID: 42, PrivateKey: keyPEM}); resp.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 updating the credential to a GitHub App but got %d: %s", resp.Code, resp.Body.String())
+	}
+	compareCredentials(r.getK8sCredential("token"),
+		credential{Name: "token", SecretToken: "mySecretToken", AppID: 1234, InstallationID: 42, PrivateKey: keyPEM}, t)
+
+	r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Create(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "not-a-credential", Namespace: r.Defaults.Namespace},
+		Data:       map[string][]byte{"password": []byte("password")},
+	})
+	tests := []struct {
+		name   string
+		path   string
+		cred   credential
+		status int
+	}{
+		{name: "renamed", path: "token", cred: credential{Name: "other", AccessToken: "replacement"}, status: http.StatusBadRequest},
+		{name: "secret token", path: "token", cred: credential{AccessToken: "replacement", SecretToken: "chosen"}, status: http.StatusBadRequest},
+		{name: "no access token", path: "token", cred: credential{}, status: http.StatusBadRequest},
+		{name: "no credential", path: "missing", cred: credential{AccessToken: "replacement"}, status: http.StatusNotFound},
+		{name: "not a credential", path: "not-a-credential", cred: credential{AccessToken: "replacement"}, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := update(tt.path, tt.cred); resp.Code != tt.status {
+				t.Errorf("Expected %d but got %d: %s", tt.status, resp.Code, resp.Body.String())
+			}
+		})
+	}
+	if secret, _ := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get("not-a-credential", metav1.GetOptions{}); string(secret.Data["password"]) != "password" {
+		t.Errorf("Expected a secret that is not a credential to be left unchanged, got %+v", secret.Data)
+	}
+}
+
 //----------------------------------------
 // end of Tests. Helper functions below.
 //----------------------------------------
@@ -177,7 +295,14 @@ func TestDeleteACredentialThatDoesNotExist(t *testing.T) {
 func TestRandomStringGenerator(t *testing.T) {
 	tokens := make(map[string]bool)
 	for i := 0; i < 100; i++ {
-		token := string(getRandomSecretToken())
+		generated, err := getRandomSecretToken()
+		if err != nil {
+			t.Fatalf("Error generating token: %s", err)
+		}
+		token := string(generated)
+		if len(token) != 20 || strings.Trim(token, tokenBytes) != "" {
+			t.Fatalf("Expected twenty characters from %s, got %s", tokenBytes, token)
+		}
 		if tokens[token] == true {
 			t.Fatalf("Generated the same token twice in less than a hundred tries! map=%+v", tokens)
 		}
@@ -0,0 +1,482 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+/*--------------------------------------
+The drift check compares the webhooks recorded as webhook resources with the
+triggers in their eventlisteners, each managed eventlistener with the Ingress
+or Route exposing it, and the webhooks on each repository with the hooks
+registered with the git provider. Missing triggers, Ingresses, Routes and hooks
+are recreated. Drift is recorded as an event on the eventlistener and the drift
+found by the last check is returned by GET /webhooks/drift.
+
+Each replica of the extension runs the check periodically, so the replicas share
+the drift ConfigMap in the installed namespace: a check is only run by the
+replica holding the lease recorded in its annotations, so two replicas never
+repair the same drift, and the report of the last check is stored in its data.
+---------------------------------------*/
+
+const (
+	driftCheckPeriod = 5 * time.Minute
+	// driftLeaseDuration is how long a drift check may run before another replica may take over the lease
+	driftLeaseDuration = driftCheckPeriod
+
+	driftConfigMapName     = "webhooks-extension-drift"
+	driftHolderAnnotation  = "webhooks.tekton.dev/drift-check-holder"
+	driftExpiresAnnotation = "webhooks.tekton.dev/drift-check-expires"
+	driftReportKey         = "report"
+
+	driftTriggers = "triggers"
+	driftIngress  = "ingress"
+	driftRoute    = "route"
+	driftHook     = "hook"
+)
+
+// drift is a resource needed by the webhooks in an eventlistener that was found missing
+type drift struct {
+	EventListener string `json:"eventlistener"`
+	// Kind is the kind of resource missing, one of triggers, ingress, route or hook
+	Kind       string `json:"kind"`
+	Webhook    string `json:"webhook,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Message    string `json:"message"`
+	Repaired   bool   `json:"repaired"`
+	Error      string `json:"error,omitempty"`
+}
+
+// driftReport is the drift found by a drift check
+type driftReport struct {
+	Checked time.Time `json:"checked"`
+	Drift   []drift   `json:"drift"`
+}
+
+// RunDriftCheck checks for and repairs drift until stopCh is closed. A periodic check is
+// skipped if another replica checked for drift within half the period.
+func (r Resource) RunDriftCheck(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		ctx := context.Background()
+		if _, err := r.runDriftCheck(ctx, driftCheckPeriod/2); err != nil {
+			logging.FromContext(ctx).Errorf("error checking for drift: %s", err)
+		}
+	}, driftCheckPeriod, stopCh)
+}
+
+// runDriftCheck checks for and repairs drift holding the drift lease, storing the report in the
+// drift ConfigMap. It returns a nil report without checking if another replica holds the lease,
+// or completed a check within minInterval.
+func (r Resource) runDriftCheck(ctx context.Context, minInterval time.Duration) (*driftReport, error) {
+	holder, err := r.acquireDriftLease(minInterval)
+	if err != nil || holder == "" {
+		return nil, err
+	}
+	report := r.checkDrift(ctx)
+	return &report, r.releaseDriftLease(holder, report)
+}
+
+// acquireDriftLease records this replica as the holder of the drift lease, returning the holder
+// recorded or "" if another replica holds the lease or completed a check within minInterval
+func (r Resource) acquireDriftLease(minInterval time.Duration) (string, error) {
+	configMaps := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace)
+	now := time.Now()
+	hostname, _ := os.Hostname()
+	// Suffixed so concurrent checks requested of the same replica are told apart
+	holder := fmt.Sprintf("%s-%d", hostname, now.UnixNano())
+	annotations := map[string]string{
+		driftHolderAnnotation:  holder,
+		driftExpiresAnnotation: now.Add(driftLeaseDuration).UTC().Format(time.RFC3339),
+	}
+
+	configMap, err := configMaps.Get(driftConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: driftConfigMapName, Namespace: r.Defaults.Namespace, Annotations: annotations},
+		}
+		_, err = configMaps.Create(configMap)
+		if k8serrors.IsAlreadyExists(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return holder, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Unreadable expiry times are treated as expired
+	expires, _ := time.Parse(time.RFC3339, configMap.Annotations[driftExpiresAnnotation])
+	if configMap.Annotations[driftHolderAnnotation] != "" && now.Before(expires) {
+		return "", nil
+	}
+	if last, err := decodeDriftReport(configMap); err == nil && last != nil && now.Sub(last.Checked) < minInterval {
+		return "", nil
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	for k, v := range annotations {
+		configMap.Annotations[k] = v
+	}
+	_, err = configMaps.Update(configMap)
+	if k8serrors.IsConflict(err) {
+		// Another replica acquired the lease first
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return holder, nil
+}
+
+// releaseDriftLease stores the report in the drift ConfigMap and releases the lease, unless it
+// expired and was taken over by another replica
+func (r Resource) releaseDriftLease(holder string, report driftReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	configMaps := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(driftConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		configMap.Data[driftReportKey] = string(data)
+		if configMap.Annotations[driftHolderAnnotation] == holder {
+			delete(configMap.Annotations, driftHolderAnnotation)
+			delete(configMap.Annotations, driftExpiresAnnotation)
+		}
+		_, err = configMaps.Update(configMap)
+		return err
+	})
+}
+
+// decodeDriftReport returns the report stored in the drift ConfigMap, or nil if none is stored
+func decodeDriftReport(configMap *corev1.ConfigMap) (*driftReport, error) {
+	data, ok := configMap.Data[driftReportKey]
+	if !ok {
+		return nil, nil
+	}
+	report := driftReport{}
+	if err := json.Unmarshal([]byte(data), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// checkDrift checks for and repairs drift. The eventlisteners are checked holding
+// modifyingEventListenerLock, the hooks with the git providers without it.
+func (r Resource) checkDrift(ctx context.Context) driftReport {
+	logger := logging.FromContext(ctx)
+	report := driftReport{Checked: time.Now(), Drift: []drift{}}
+
+	modifyingEventListenerLock.Lock()
+	report.Drift = append(report.Drift, r.checkTriggerDrift(ctx)...)
+	eventListeners, err := r.getManagedEventListeners()
+	if err != nil {
+		logger.Errorf("error getting eventlisteners to check for drift: %s", err)
+	}
+	for _, el := range eventListeners {
+		report.Drift = append(report.Drift, r.checkExposureDrift(el)...)
+	}
+	modifyingEventListenerLock.Unlock()
+
+	for _, el := range eventListeners {
+		report.Drift = append(report.Drift, r.checkHookDrift(ctx, el)...)
+	}
+
+	for _, d := range report.Drift {
+		if d.Repaired {
+			logger.Infof("Repaired drift in eventlistener %s: %s", d.EventListener, d.Message)
+		} else {
+			logger.Errorf("Unable to repair drift in eventlistener %s: %s: %s", d.EventListener, d.Message, d.Error)
+		}
+		r.recordDriftEvent(d)
+	}
+	return report
+}
+
+// repaired completes the drift with the result of repairing it
+func repaired(d drift, err error) drift {
+	d.Repaired = err == nil
+	if err != nil {
+		d.Error = err.Error()
+	}
+	return d
+}
+
+// checkTriggerDrift restores the triggers of reconciled webhook resources missing from their eventlistener
+func (r Resource) checkTriggerDrift(ctx context.Context) []drift {
+	logger := logging.FromContext(ctx)
+	found := []drift{}
+	resources, err := r.getWebhookResources()
+	if err != nil {
+		logger.Errorf("error getting webhook resources to check for drift: %s", err)
+		return found
+	}
+	hooks, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		logger.Errorf("error getting webhooks to check for drift: %s", err)
+		return found
+	}
+	inEventListeners := map[string]bool{}
+	for _, hook := range hooks {
+		inEventListeners[r.getTriggerPrefix(hook)] = true
+	}
+
+	for _, wh := range resources {
+		// Webhook resources not yet reconciled, or being deleted, are left to the webhook controller
+		if !isReconciled(wh) || wh.DeletionTimestamp != nil {
+			continue
+		}
+		hook := getWebhookFromResource(wh)
+		if inEventListeners[r.getTriggerPrefix(hook)] {
+			continue
+		}
+		d := drift{
+			EventListener: r.getEventListenerName(hook),
+			Kind:          driftTriggers,
+			Webhook:       hook.Name,
+			Namespace:     hook.Namespace,
+			Repository:    hook.GitRepositoryURL,
+			Message:       fmt.Sprintf("triggers for webhook %s in namespace %s not found", hook.Name, hook.Namespace),
+		}
+		found = append(found, repaired(d, r.restoreWebhookTriggers(hook)))
+	}
+	return found
+}
+
+// restoreWebhookTriggers adds the triggers of the webhook missing from its eventlistener,
+// creating the eventlistener if it no longer exists
+func (r Resource) restoreWebhookTriggers(hook webhook) error {
+	installNs := r.Defaults.Namespace
+	hook.EventListener = r.getEventListenerName(hook)
+	// Webhook resources recorded before webhooks had events have the default events
+	if err := validateEvents(&hook); err != nil {
+		return err
+	}
+	monitorTriggerName, err := getMonitorTriggerName(hook.GitRepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.modifyEventListener(installNs, hook.EventListener, func(el *v1alpha1.EventListener) error {
+		triggers := r.newWebhookTriggers(hook)
+		for _, existing := range getWebhooksFromEventListener(*el) {
+			if existing.GitRepositoryURL == hook.GitRepositoryURL && existing.HookID != 0 {
+				for i := range triggers {
+					setHookIDHeader(&triggers[i], existing.HookID)
+				}
+			}
+		}
+		names := map[string]bool{}
+		for _, trigger := range el.Spec.Triggers {
+			names[trigger.Name] = true
+		}
+		for _, trigger := range triggers {
+			if !names[trigger.Name] {
+				el.Spec.Triggers = append(el.Spec.Triggers, trigger)
+			}
+		}
+		if !names[monitorTriggerName] {
+			el.Spec.Triggers = append(el.Spec.Triggers, r.newMonitorTrigger(hook, monitorTriggerName))
+		}
+		return nil
+	})
+	if k8serrors.IsNotFound(err) {
+		// The Ingress or Route for the eventlistener is restored by the check of the eventlistener
+		_, err = r.createEventListener(hook, installNs, monitorTriggerName)
+	}
+	return err
+}
+
+// checkExposureDrift recreates the Ingress or Route exposing the eventlistener if it is missing
+func (r Resource) checkExposureDrift(el v1alpha1.EventListener) []drift {
+	name := getEventListenerServiceName(el.Name)
+	if _, onOpenShift := os.LookupEnv("PLATFORM"); onOpenShift {
+		_, err := r.RoutesClient.RouteV1().Routes(r.Defaults.Namespace).Get(name, metav1.GetOptions{})
+		if !k8serrors.IsNotFound(err) {
+			return nil
+		}
+		d := drift{EventListener: el.Name, Kind: driftRoute, Message: fmt.Sprintf("route %s not found", name)}
+		return []drift{repaired(d, r.createOpenshiftRoute(name))}
+	}
+
+	_, err := r.K8sClient.ExtensionsV1beta1().Ingresses(r.Defaults.Namespace).Get(name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		return nil
+	}
+	d := drift{EventListener: el.Name, Kind: driftIngress, Message: fmt.Sprintf("ingress %s not found", name)}
+	return []drift{repaired(d, r.createDeleteIngress("create", r.Defaults.Namespace, el.Name))}
+}
+
+// checkHookDrift registers the hook again for each repository in the eventlistener whose hook is missing
+func (r Resource) checkHookDrift(ctx context.Context, el v1alpha1.EventListener) []drift {
+	logger := logging.FromContext(ctx)
+	found := []drift{}
+	checked := map[string]bool{}
+	for _, hook := range getWebhooksFromEventListener(el) {
+		if checked[hook.GitRepositoryURL] {
+			continue
+		}
+		checked[hook.GitRepositoryURL] = true
+
+		registered, err := r.isHookRegistered(hook)
+		if err != nil {
+			logger.Errorf("error checking hook registration for repository %s: %s", hook.GitRepositoryURL, err)
+			continue
+		}
+		if registered {
+			continue
+		}
+		d := drift{
+			EventListener: el.Name,
+			Kind:          driftHook,
+			Repository:    hook.GitRepositoryURL,
+			Message:       fmt.Sprintf("hook not registered on repository %s", hook.GitRepositoryURL),
+		}
+		hook.HookID = 0
+		hookID, err := r.doWebhookRequest(hook, "subscribe", getHookEvents(hook))
+		if err == nil {
+			err = r.keepRepairedHook(el.Name, hook, hookID)
+		}
+		found = append(found, repaired(d, err))
+	}
+	return found
+}
+
+// keepRepairedHook records the ID of a hook registered again by the drift check, or removes the hook
+// if the webhooks on its repository were deleted from the eventlistener while it was registered.
+// The hook is removed holding modifyingEventListenerLock, so it is not shared by a webhook created
+// for the repository meanwhile.
+func (r Resource) keepRepairedHook(elName string, hook webhook, hookID int64) error {
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(r.Defaults.Namespace).Get(elName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		for _, existing := range getWebhooksFromEventListener(*el) {
+			if existing.GitRepositoryURL != hook.GitRepositoryURL {
+				continue
+			}
+			if hookID == 0 {
+				return nil
+			}
+			return r.recordHookID(r.Defaults.Namespace, elName, hook.GitRepositoryURL, hookID)
+		}
+	}
+	hook.HookID = hookID
+	_, err = r.doWebhookRequest(hook, "unsubscribe", getHookEvents(hook))
+	return err
+}
+
+// recordDriftEvent records the drift as an event on the eventlistener
+func (r Resource) recordDriftEvent(d drift) {
+	eventType, reason := corev1.EventTypeNormal, "DriftRepaired"
+	message := d.Message
+	if !d.Repaired {
+		eventType, reason = corev1.EventTypeWarning, "DriftFound"
+		message = fmt.Sprintf("%s, unable to repair: %s", d.Message, d.Error)
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Named as by the client-go event recorder
+			Name:      fmt.Sprintf("%v.%x", d.EventListener, now.UnixNano()),
+			Namespace: r.Defaults.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "tekton.dev/v1alpha1",
+			Kind:       "EventListener",
+			Name:       d.EventListener,
+			Namespace:  r.Defaults.Namespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "tekton-webhooks-extension"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := r.K8sClient.CoreV1().Events(r.Defaults.Namespace).Create(event); err != nil {
+		logging.Log.Errorf("error recording drift event for eventlistener %s: %s", d.EventListener, err)
+	}
+}
+
+// getDrift returns the report of the last drift check
+func (r Resource) getDrift(request *restful.Request, response *restful.Response) {
+	logger := requestLogger(request)
+	logger.Debug("Getting the last drift report")
+	var report *driftReport
+	configMap, err := r.K8sClient.CoreV1().ConfigMaps(r.Defaults.Namespace).Get(driftConfigMapName, metav1.GetOptions{})
+	if err == nil {
+		report, err = decodeDriftReport(configMap)
+	}
+	if err != nil && !k8serrors.IsNotFound(err) {
+		logger.Errorf("error getting the last drift report: %s", err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		RespondError(response, errors.New("no drift check has completed yet"), http.StatusNotFound)
+		return
+	}
+	response.WriteEntity(report)
+}
+
+// checkDriftNow checks for and repairs drift, returning the report
+func (r Resource) checkDriftNow(request *restful.Request, response *restful.Response) {
+	defer r.recordAudit(request, response, newAuditRecord("repair", "drift"))
+	logger := requestLogger(request)
+	logger.Info("Checking for drift on request")
+	report, err := r.runDriftCheck(request.Request.Context(), 0)
+	if err != nil {
+		logger.Errorf("error checking for drift: %s", err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		RespondError(response, errors.New("a drift check is already in progress"), http.StatusConflict)
+		return
+	}
+	response.WriteEntity(report)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckDrift(t *testing.T) {
+	gitea := newFakeGiteaServer("access")
+	defer gitea.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hooks := []webhook{
+		{Name: "name1", Namespace: "foo", GitRepositoryURL: gitea.repoURL("owner", "repo"), AccessTokenRef: "token1", Pipeline: "pipeline1", GitProvider: "gitea"},
+		{Name: "name2", Namespace: "foo", GitRepositoryURL: gitea.repoURL("owner", "other"), AccessTokenRef: "token1", Pipeline: "pipeline2", GitProvider: "gitea"},
+	}
+	for _, hook := range hooks {
+		createTriggerResources(hook, r)
+		if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+			t.Fatalf("Webhook %s creation failed with status %d", hook.Name, resp.StatusCode())
+		}
+	}
+
+	// No drift
+	if report := r.checkDrift(context.Background()); len(report.Drift) != 0 {
+		t.Fatalf("Expected no drift; got: %+v", report.Drift)
+	}
+
+	// Hand edit the eventlistener, delete the ingress and remove the hook
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	triggers := []v1alpha1.EventListenerTrigger{}
+	for _, trigger := range el.Spec.Triggers {
+		if trigger.Name != "name2-foo-push-event" && trigger.Name != "name2-foo-pullrequest-event" {
+			triggers = append(triggers, trigger)
+		}
+	}
+	el.Spec.Triggers = triggers
+	if _, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Update(el); err != nil {
+		t.Fatalf("Error updating eventlistener: %s", err)
+	}
+	if err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Delete("el-"+eventListenerName, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Error deleting ingress: %s", err)
+	}
+	client, _, _ := r.getGitProviderClient(hooks[0])
+	if err := doGiteaHookRequest(client, "gitea", hooks[0].GitRepositoryURL, "unsubscribe", "http://listener.example.com", "", nil); err != nil {
+		t.Fatalf("Error removing Gitea hook: %s", err)
+	}
+
+	report, err := r.runDriftCheck(context.Background(), 0)
+	if err != nil || report == nil {
+		t.Fatalf("Expected the drift check to run; got: %+v, %v", report, err)
+	}
+	kinds := map[string]bool{}
+	for _, d := range report.Drift {
+		if !d.Repaired {
+			t.Errorf("Drift not repaired: %+v", d)
+		}
+		kinds[d.Kind] = true
+	}
+	if len(report.Drift) != 3 || !kinds[driftTriggers] || !kinds[driftIngress] || !kinds[driftHook] {
+		t.Errorf("Expected drift in the triggers, ingress and hook; got: %+v", report.Drift)
+	}
+
+	listed, err := r.getWebhooksFromEventListeners()
+	if err != nil || len(listed) != 2 {
+		t.Errorf("Expected the triggers of both webhooks to be restored; got: %+v, %v", listed, err)
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+eventListenerName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the ingress to be recreated: %s", err)
+	}
+	if len(gitea.getHooks("owner", "repo")) != 1 {
+		t.Errorf("Expected the Gitea hook to be registered again; got: %+v", gitea.getHooks("owner", "repo"))
+	}
+	events, err := r.K8sClient.CoreV1().Events(installNs).List(metav1.ListOptions{})
+	if err != nil || len(events.Items) != 3 || events.Items[0].Reason != "DriftRepaired" || events.Items[0].InvolvedObject.Name != eventListenerName {
+		t.Errorf("Expected an event for each drift repaired; got: %+v, %v", events, err)
+	}
+
+	// The last report is returned by the drift endpoint
+	wsContainer := restful.NewContainer()
+	wsContainer.Router(restful.CurlyRouter{})
+	r.RegisterExtensionWebService(wsContainer)
+	httpWriter := httptest.NewRecorder()
+	wsContainer.ServeHTTP(httpWriter, dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/drift", nil))
+	if httpWriter.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 getting drift, got %d", httpWriter.Code)
+	}
+	got := driftReport{}
+	if err := json.NewDecoder(httpWriter.Body).Decode(&got); err != nil || len(got.Drift) != 3 {
+		t.Errorf("Expected the last drift report; got: %+v, %v", got, err)
+	}
+
+	// A hook registered again for a repository whose webhooks were deleted meanwhile is removed
+	gone := webhook{GitRepositoryURL: gitea.repoURL("owner", "gone"), AccessTokenRef: "token1", GitProvider: "gitea"}
+	if _, err := r.doWebhookRequest(gone, "subscribe", getHookEvents(gone)); err != nil || len(gitea.getHooks("owner", "gone")) != 1 {
+		t.Fatalf("Error registering Gitea hook: %v", err)
+	}
+	if err := r.keepRepairedHook(eventListenerName, gone, 0); err != nil || len(gitea.getHooks("owner", "gone")) != 0 {
+		t.Errorf("Expected the Gitea hook to be removed; got: %+v, %v", gitea.getHooks("owner", "gone"), err)
+	}
+}
+
+func TestRunDriftCheckLease(t *testing.T) {
+	r := dummyResource()
+	r.Defaults = EnvDefaults{Namespace: installNs, CallbackURL: "http://listener.example.com"}
+	configMaps := r.K8sClient.CoreV1().ConfigMaps(installNs)
+
+	// Another replica holds the lease
+	lease := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      driftConfigMapName,
+			Namespace: installNs,
+			Annotations: map[string]string{
+				driftHolderAnnotation:  "other-replica",
+				driftExpiresAnnotation: time.Now().Add(time.Minute).UTC().Format(time.RFC3339),
+			},
+		},
+	}
+	if _, err := configMaps.Create(lease); err != nil {
+		t.Fatalf("Error creating drift ConfigMap: %s", err)
+	}
+	if report, err := r.runDriftCheck(context.Background(), 0); err != nil || report != nil {
+		t.Fatalf("Expected no drift check while another replica holds the lease; got: %+v, %v", report, err)
+	}
+
+	wsContainer := restful.NewContainer()
+	wsContainer.Router(restful.CurlyRouter{})
+	r.RegisterExtensionWebService(wsContainer)
+	httpWriter := httptest.NewRecorder()
+	wsContainer.ServeHTTP(httpWriter, dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/drift", nil))
+	if httpWriter.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 checking for drift while another replica holds the lease, got %d", httpWriter.Code)
+	}
+
+	// The lease expired
+	lease.Annotations[driftExpiresAnnotation] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if _, err := configMaps.Update(lease); err != nil {
+		t.Fatalf("Error updating drift ConfigMap: %s", err)
+	}
+	if report, err := r.runDriftCheck(context.Background(), driftCheckPeriod/2); err != nil || report == nil {
+		t.Fatalf("Expected the drift check to take over the expired lease; got: %+v, %v", report, err)
+	}
+	stored, err := configMaps.Get(driftConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting drift ConfigMap: %s", err)
+	}
+	if _, held := stored.Annotations[driftHolderAnnotation]; held {
+		t.Errorf("Expected the lease to be released; got: %+v", stored.Annotations)
+	}
+	if report, err := decodeDriftReport(stored); err != nil || report == nil {
+		t.Errorf("Expected the report to be stored; got: %+v, %v", report, err)
+	}
+
+	// A periodic check is skipped after a recent check, a requested one is not
+	if report, err := r.runDriftCheck(context.Background(), driftCheckPeriod/2); err != nil || report != nil {
+		t.Errorf("Expected the periodic drift check to be skipped; got: %+v, %v", report, err)
+	}
+	if report, err := r.runDriftCheck(context.Background(), 0); err != nil || report == nil {
+		t.Errorf("Expected the requested drift check to run; got: %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+)
+
+/*--------------------------------------
+Webhooks target a named eventlistener in the install namespace, by default the
+single eventlistener used before named eventlisteners were supported or, if
+EVENTLISTENER_PER_NAMESPACE is set, an eventlistener for the target namespace.
+Each eventlistener is exposed by its own Ingress or Route, with a callback URL
+derived from WEBHOOK_CALLBACK_URL.
+
+The extension may run with several replicas, so eventlisteners are modified with
+optimistic concurrency: each update is applied to the eventlistener as last read
+and retried on a resourceVersion conflict. modifyingEventListenerLock only avoids
+conflicts between requests handled by the same replica.
+---------------------------------------*/
+
+const (
+	// eventListenerName is the name of the default eventlistener
+	eventListenerName = "tekton-webhooks-eventlistener"
+
+	// Eventlisteners created by the extension are labelled as managed by it
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "tekton-webhooks-extension"
+)
+
+// errWebhookExists is returned when the triggers of a webhook are already in the eventlistener
+var errWebhookExists = errors.New("Webhook already exists in the eventlistener with the same name, targeting the same namespace")
+
+// getEventListenerName returns the name of the eventlistener the webhook targets
+func (r Resource) getEventListenerName(webhook webhook) string {
+	if webhook.EventListener != "" {
+		return webhook.EventListener
+	}
+	if r.Defaults.EventListenerPerNamespace && webhook.Namespace != "" {
+		return eventListenerName + "-" + webhook.Namespace
+	}
+	return eventListenerName
+}
+
+// validateEventListenerName checks the service created for the eventlistener will have a valid name
+func validateEventListenerName(name string) error {
+	if errs := validation.IsDNS1123Label(getEventListenerServiceName(name)); len(errs) > 0 {
+		return fmt.Errorf("the eventlistener name %s is not valid: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// getEventListenerServiceName returns the name of the service Tekton Triggers creates for the eventlistener,
+// the Ingress or Route exposing the eventlistener has the same name
+func getEventListenerServiceName(name string) string {
+	return "el-" + name
+}
+
+// getCallbackURL returns the URL of the eventlistener registered with the git provider.
+// WEBHOOK_CALLBACK_URL is the callback of the default eventlistener, the host for other
+// eventlisteners has the eventlistener name as an additional subdomain or, where the
+// host is that of the default OpenShift Route, the host of the Route for the eventlistener.
+func (r Resource) getCallbackURL(elName string) string {
+	if elName == eventListenerName || elName == "" {
+		return r.Defaults.CallbackURL
+	}
+	u, err := url.Parse(r.Defaults.CallbackURL)
+	if err != nil || u.Host == "" {
+		return r.Defaults.CallbackURL
+	}
+	defaultRoute := getEventListenerServiceName(eventListenerName)
+	if strings.HasPrefix(u.Host, defaultRoute) {
+		u.Host = getEventListenerServiceName(elName) + strings.TrimPrefix(u.Host, defaultRoute)
+	} else {
+		u.Host = elName + "." + u.Host
+	}
+	return u.String()
+}
+
+// getManagedEventListeners returns the eventlisteners in the install namespace created by the extension,
+// including the default eventlistener which was not labelled by earlier releases
+func (r Resource) getManagedEventListeners() ([]v1alpha1.EventListener, error) {
+	list, err := r.TriggersClient.TektonV1alpha1().EventListeners(r.Defaults.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	managed := []v1alpha1.EventListener{}
+	for _, el := range list.Items {
+		if el.Name == eventListenerName || el.Labels[managedByLabel] == managedByValue {
+			managed = append(managed, el)
+		}
+	}
+	return managed, nil
+}
+
+// modifyEventListener applies modify to the eventlistener and updates it, reading the
+// eventlistener again and reapplying modify if the update conflicts with another update.
+// An error returned by modify is returned without updating the eventlistener.
+func (r Resource) modifyEventListener(namespace, name string, modify func(el *v1alpha1.EventListener) error) (*v1alpha1.EventListener, error) {
+	var updated *v1alpha1.EventListener
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		el, err := r.TriggersClient.TektonV1alpha1().EventListeners(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if err := modify(el); err != nil {
+			return err
+		}
+		updated, err = r.TriggersClient.TektonV1alpha1().EventListeners(namespace).Update(el)
+		return err
+	})
+	return updated, err
+}
+
+// getWebhooksFromEventListener returns the webhooks with triggers in the eventlistener, with the events and
+// actions of their triggers
+func getWebhooksFromEventListener(el v1alpha1.EventListener) []webhook {
+	hooks := []webhook{}
+	for _, trigger := range el.Spec.Triggers {
+		event, actions := getTriggerEvent(trigger)
+		hook := getHookFromTrigger(trigger, getEventTriggerName("", event))
+		if !isWebhookTrigger(trigger, hook.Name+"-"+hook.Namespace) {
+			continue
+		}
+		hook.EventListener = el.Name
+
+		i := 0
+		for i < len(hooks) && (hooks[i].Name != hook.Name || hooks[i].Namespace != hook.Namespace) {
+			i++
+		}
+		if i == len(hooks) {
+			hook.Actions = map[string][]string{}
+			hooks = append(hooks, hook)
+		}
+		hooks[i].Events = append(hooks[i].Events, event)
+		switch event {
+		case pushEvent:
+			getFiltersFromTrigger(trigger, &hooks[i])
+		case pullRequestEvent:
+			getForkPolicyFromTrigger(trigger, &hooks[i])
+		}
+		if len(actions) > 0 {
+			hooks[i].Actions[event] = actions
+		}
+	}
+	return hooks
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	faketriggerclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned/fake"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_getEventListenerName(t *testing.T) {
+	tests := []struct {
+		name         string
+		hook         webhook
+		perNamespace bool
+		want         string
+	}{
+		{name: "default", hook: webhook{Namespace: "foo"}, want: "tekton-webhooks-eventlistener"},
+		{name: "per namespace", hook: webhook{Namespace: "foo"}, perNamespace: true, want: "tekton-webhooks-eventlistener-foo"},
+		{name: "named", hook: webhook{Namespace: "foo", EventListener: "team-a"}, perNamespace: true, want: "team-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dummyResource()
+			r.Defaults.EventListenerPerNamespace = tt.perNamespace
+			if got := r.getEventListenerName(tt.hook); got != tt.want {
+				t.Errorf("getEventListenerName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateEventListenerName(t *testing.T) {
+	for _, name := range []string{"tekton-webhooks-eventlistener", "team-a"} {
+		if err := validateEventListenerName(name); err != nil {
+			t.Errorf("validateEventListenerName() returned an error for %s: %s", name, err)
+		}
+	}
+	for _, name := range []string{"Team_A", "team.a", strings.Repeat("a", 61)} {
+		if err := validateEventListenerName(name); err == nil {
+			t.Errorf("validateEventListenerName() did not return an error for %s", name)
+		}
+	}
+}
+
+func Test_getCallbackURL(t *testing.T) {
+	tests := []struct {
+		callbackURL string
+		elName      string
+		want        string
+	}{
+		{callbackURL: "http://listener.example.com", elName: "tekton-webhooks-eventlistener", want: "http://listener.example.com"},
+		{callbackURL: "http://listener.example.com", elName: "team-a", want: "http://team-a.listener.example.com"},
+		{callbackURL: "https://listener.example.com:8443", elName: "team-a", want: "https://team-a.listener.example.com:8443"},
+		{
+			callbackURL: "http://el-tekton-webhooks-eventlistener-tekton-pipelines.apps.example.com",
+			elName:      "team-a",
+			want:        "http://el-team-a-tekton-pipelines.apps.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.elName+" "+tt.callbackURL, func(t *testing.T) {
+			r := dummyResource()
+			r.Defaults.CallbackURL = tt.callbackURL
+			if got := r.getCallbackURL(tt.elName); got != tt.want {
+				t.Errorf("getCallbackURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateAndDeleteWebhooksInEventListeners(t *testing.T) {
+	gitea := newFakeGiteaServer("access")
+	defer gitea.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hooks := []webhook{
+		{
+			Name:             "name1",
+			Namespace:        "foo",
+			GitRepositoryURL: gitea.repoURL("owner", "repo"),
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline1",
+			GitProvider:      "gitea",
+		},
+		{
+			Name:             "name2",
+			Namespace:        "foo",
+			GitRepositoryURL: gitea.repoURL("owner", "repo"),
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline2",
+			GitProvider:      "gitea",
+			EventListener:    "team-a",
+		},
+	}
+	for _, hook := range hooks {
+		createTriggerResources(hook, r)
+		if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+			t.Fatalf("Webhook %s creation failed with status %d", hook.Name, resp.StatusCode())
+		}
+	}
+
+	// Each eventlistener is labelled, exposed by its own ingress and registered with its own callback
+	for elName, host := range map[string]string{"tekton-webhooks-eventlistener": "listener.example.com", "team-a": "team-a.listener.example.com"} {
+		el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(elName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting eventlistener %s: %s", elName, err)
+		}
+		if el.Labels[managedByLabel] != managedByValue || len(el.Spec.Triggers) != 3 {
+			t.Errorf("Eventlistener %s not created as expected: %+v", elName, el)
+		}
+		ingress, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+elName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error getting ingress for eventlistener %s: %s", elName, err)
+		}
+		if ingress.Spec.Rules[0].Host != host || ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName != "el-"+elName {
+			t.Errorf("Ingress for eventlistener %s not created as expected: %+v", elName, ingress.Spec)
+		}
+	}
+	giteaHooks := gitea.getHooks("owner", "repo")
+	if len(giteaHooks) != 2 || giteaHooks[0].Config.URL != "http://listener.example.com" || giteaHooks[1].Config.URL != "http://team-a.listener.example.com" {
+		t.Errorf("Expected a Gitea hook for each eventlistener; got: %+v", giteaHooks)
+	}
+
+	listed, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		t.Fatalf("Error getting webhooks: %s", err)
+	}
+	if len(listed) != 2 || listed[0].EventListener != "tekton-webhooks-eventlistener" || listed[1].EventListener != "team-a" {
+		t.Errorf("Expected the webhooks of both eventlisteners to be listed; got: %+v", listed)
+	}
+
+	// Deleting the only webhook in an eventlistener removes the eventlistener, its ingress and its hook
+	httpReq := dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/name2?namespace=foo&repository="+url.QueryEscape(hooks[1].GitRepositoryURL), nil)
+	req := dummyRestfulRequest(httpReq, "name2")
+	deleteResp := dummyRestfulResponse(httptest.NewRecorder())
+	r.deleteWebhook(req, deleteResp)
+	if deleteResp.StatusCode() != http.StatusNoContent {
+		t.Fatalf("Webhook deletion failed with status %d", deleteResp.StatusCode())
+	}
+	if _, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get("team-a", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected eventlistener team-a to be deleted")
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-team-a", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected ingress el-team-a to be deleted")
+	}
+	giteaHooks = gitea.getHooks("owner", "repo")
+	if len(giteaHooks) != 1 || giteaHooks[0].Config.URL != "http://listener.example.com" {
+		t.Errorf("Expected only the Gitea hook for the default eventlistener to remain; got: %+v", giteaHooks)
+	}
+}
+
+// conflictOnFirstUpdate makes the first update of an eventlistener fail with a conflict, as if
+// another replica had updated it, with the eventlistener then read including the other replica's triggers
+func conflictOnFirstUpdate(t *testing.T, r *Resource, elName string) {
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(elName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener %s: %s", elName, err)
+	}
+	concurrent := el.DeepCopy()
+	triggers := r.newWebhookTriggers(webhook{Name: "other", Namespace: "bar", GitRepositoryURL: "https://github.com/owner/other", Pipeline: "pipeline", Events: defaultEvents})
+	concurrent.Spec.Triggers = append(concurrent.Spec.Triggers, triggers...)
+
+	conflicted, reread := false, false
+	client := r.TriggersClient.(*faketriggerclientset.Clientset)
+	client.PrependReactor("get", "eventlisteners", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if !conflicted || reread {
+			return false, nil, nil
+		}
+		reread = true
+		return true, concurrent, nil
+	})
+	client.PrependReactor("update", "eventlisteners", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicted {
+			return false, nil, nil
+		}
+		conflicted = true
+		return true, nil, k8serrors.NewConflict(schema.GroupResource{Group: "tekton.dev", Resource: "eventlisteners"}, elName, errors.New("the object has been modified"))
+	})
+}
+
+func TestUpdateEventListenerRetriesOnConflict(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.Namespace = installNs
+	hook1 := webhook{Name: "name1", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1", EventListener: eventListenerName}
+	hook2 := webhook{Name: "name2", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline2", EventListener: eventListenerName}
+	if _, err := r.createEventListener(hook1, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+
+	conflictOnFirstUpdate(t, r, eventListenerName)
+	el, err := r.updateEventListener(&v1alpha1.EventListener{ObjectMeta: metav1.ObjectMeta{Name: eventListenerName, Namespace: installNs}}, hook2, "github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error updating eventlistener: %s", err)
+	}
+
+	// The triggers added by the other replica are kept
+	names := []string{}
+	for _, trigger := range el.Spec.Triggers {
+		names = append(names, trigger.Name)
+	}
+	expected := []string{"name1-foo-push-event", "name1-foo-pullrequest-event", "github.com/owner/repo", "other-bar-push-event", "other-bar-pullrequest-event", "name2-foo-push-event", "name2-foo-pullrequest-event"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Eventlistener triggers after conflict were %v, expected %v", names, expected)
+	}
+}
+
+func TestUpdateEventListenerWebhookExists(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.Namespace = installNs
+	hook := webhook{Name: "name1", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1", EventListener: eventListenerName}
+	el, err := r.createEventListener(hook, installNs, "github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	if _, err := r.updateEventListener(el, hook, "github.com/owner/repo"); err != errWebhookExists {
+		t.Errorf("updateEventListener() returned %v, expected %v", err, errWebhookExists)
+	}
+}
+
+func TestDeleteFromEventListenerRetriesOnConflict(t *testing.T) {
+	r := dummyResource()
+	r.Defaults.Namespace = installNs
+	hook1 := webhook{Name: "name1", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1", EventListener: eventListenerName}
+	hook2 := webhook{Name: "name2", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline2", EventListener: eventListenerName}
+	el, err := r.createEventListener(hook1, installNs, "github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	if _, err := r.updateEventListener(el, hook2, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error updating eventlistener: %s", err)
+	}
+
+	conflictOnFirstUpdate(t, r, eventListenerName)
+	remaining, err := r.deleteFromEventListener("name1-foo", installNs, eventListenerName, "github.com/owner/repo", "https://github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error deleting from eventlistener: %s", err)
+	}
+	if remaining != 2 {
+		t.Errorf("deleteFromEventListener() returned %d triggers remaining on the repository, expected 2", remaining)
+	}
+
+	// The triggers added by the other replica are kept
+	el, err = r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	names := []string{}
+	for _, trigger := range el.Spec.Triggers {
+		names = append(names, trigger.Name)
+	}
+	expected := []string{"name2-foo-push-event", "name2-foo-pullrequest-event", "other-bar-push-event", "other-bar-pullrequest-event", "github.com/owner/repo"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Eventlistener triggers after conflict were %v, expected %v", names, expected)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"fmt"
+	"strings"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+)
+
+/*--------------------------------------
+Events use the GitHub names, the interceptor maps the events of other git
+providers onto them. A tag event is a push of a tag, which is also a push event.
+
+Each event of a webhook has its own trigger, named <name>-<namespace>-<event>-event
+and using the <pipeline>-<event>-binding trigger binding, where <event> is the
+event without underscores (for example pullrequest). The events and actions are
+validated by the interceptor from the Wext-Incoming-Event and
+Wext-Incoming-Actions headers of the trigger.
+
+Hooks are shared by the webhooks on a repository, so are registered for all the
+events the git provider supports.
+---------------------------------------*/
+
+const (
+	pushEvent         = "push"
+	pullRequestEvent  = "pull_request"
+	tagEvent          = "tag"
+	releaseEvent      = "release"
+	issueCommentEvent = "issue_comment"
+	checkSuiteEvent   = "check_suite"
+)
+
+// defaultEvents are the events of a webhook that doesn't specify any
+var defaultEvents = []string{pushEvent, pullRequestEvent}
+
+// defaultActions are the actions of an event that run the pipeline when the webhook doesn't specify any,
+// events not listed have no actions
+var defaultActions = map[string][]string{
+	pullRequestEvent:  {"opened", "reopened", "synchronize"},
+	releaseEvent:      {"published"},
+	issueCommentEvent: {"created"},
+	checkSuiteEvent:   {"requested", "rerequested"},
+}
+
+// providerEvents are the events supported by each git provider. Pull request comments run the pipeline
+// only for members of the repository, which can't be checked for Bitbucket without admin access.
+var providerEvents = map[string][]string{
+	gitHubProvider:    {pushEvent, pullRequestEvent, tagEvent, releaseEvent, issueCommentEvent, checkSuiteEvent},
+	gitLabProvider:    {pushEvent, pullRequestEvent, tagEvent, releaseEvent, issueCommentEvent},
+	bitbucketProvider: {pushEvent, pullRequestEvent, tagEvent},
+	giteaProvider:     {pushEvent, pullRequestEvent, tagEvent, releaseEvent, issueCommentEvent},
+	gogsProvider:      {pushEvent, pullRequestEvent, tagEvent, releaseEvent, issueCommentEvent},
+}
+
+// validateEvents checks the events and actions of the webhook are supported by its git provider,
+// defaulting the events and the actions of events without any
+func validateEvents(webhook *webhook) error {
+	provider := getGitProvider(*webhook)
+	if len(webhook.Events) == 0 {
+		webhook.Events = append([]string{}, defaultEvents...)
+	}
+	seen := map[string]bool{}
+	for _, event := range webhook.Events {
+		if !containsString(providerEvents[provider], event) {
+			return fmt.Errorf("event %s is not supported for %s, supported events are %s", event, provider, strings.Join(providerEvents[provider], ", "))
+		}
+		if seen[event] {
+			return fmt.Errorf("event %s is listed more than once", event)
+		}
+		seen[event] = true
+	}
+
+	actions := map[string][]string{}
+	for event, eventActions := range webhook.Actions {
+		if !seen[event] {
+			return fmt.Errorf("actions are given for event %s, which is not one of the events of the webhook", event)
+		}
+		if _, hasActions := defaultActions[event]; !hasActions {
+			return fmt.Errorf("%s events have no actions", event)
+		}
+		if len(eventActions) == 0 {
+			continue
+		}
+		for _, action := range eventActions {
+			if action == "" || strings.Contains(action, ",") {
+				return fmt.Errorf("action %q of event %s is not valid", action, event)
+			}
+		}
+		actions[event] = eventActions
+	}
+	for _, event := range webhook.Events {
+		if _, ok := actions[event]; !ok && len(defaultActions[event]) > 0 {
+			actions[event] = append([]string{}, defaultActions[event]...)
+		}
+	}
+	webhook.Actions = actions
+	return nil
+}
+
+// getHookEvents returns the events the hook for the webhook is registered for, all those supported by its git provider.
+// Tags are pushed, so the hook is not registered for tag events.
+func getHookEvents(webhook webhook) []string {
+	events := []string{}
+	for _, event := range providerEvents[getGitProvider(webhook)] {
+		if event != tagEvent {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// addsHookEvents returns whether the webhook has an event none of the other webhooks sharing its hook have.
+// Hooks registered before the event was supported were only registered for push and pull_request events.
+func addsHookEvents(webhook webhook, others []webhook) bool {
+	for _, event := range webhook.Events {
+		found := false
+		for _, other := range others {
+			if containsString(other.Events, event) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// getEventTriggerName returns the name of the trigger for the event of the webhook, given the
+// trigger prefix <name>-<namespace>
+func getEventTriggerName(prefix, event string) string {
+	return prefix + "-" + strings.Replace(event, "_", "", -1) + "-event"
+}
+
+// getEventBindingName returns the name of the trigger binding for the event of the pipeline
+func getEventBindingName(pipeline, event string) string {
+	return pipeline + "-" + strings.Replace(event, "_", "", -1) + "-binding"
+}
+
+// getTriggerEvent returns the event and actions a trigger is for, from its interceptor headers
+func getTriggerEvent(trigger v1alpha1.EventListenerTrigger) (event string, actions []string) {
+	if trigger.Interceptor == nil {
+		return "", nil
+	}
+	for _, header := range trigger.Interceptor.Header {
+		switch header.Name {
+		case "Wext-Incoming-Event":
+			event = header.Value.StringVal
+		case "Wext-Incoming-Actions":
+			actions = strings.Split(header.Value.StringVal, ",")
+		}
+	}
+	return event, actions
+}
+
+// isWebhookTrigger returns whether the trigger is one of the event triggers of the webhook with the
+// trigger prefix <name>-<namespace>. Monitor triggers are named after the repository, which webhooks can't be.
+func isWebhookTrigger(trigger v1alpha1.EventListenerTrigger, prefix string) bool {
+	event, _ := getTriggerEvent(trigger)
+	return event != "" && !strings.Contains(trigger.Name, "/") && trigger.Name == getEventTriggerName(prefix, event)
+}
+
+// getActionsHeader returns the interceptor header for the actions of an event
+func getActionsHeader(actions []string) pipelinesv1alpha1.Param {
+	return pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: strings.Join(actions, ",")}}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateEvents(t *testing.T) {
+	tests := []struct {
+		name            string
+		hook            webhook
+		expectedEvents  []string
+		expectedActions map[string][]string
+		expectError     bool
+	}{
+		{
+			name:            "defaults",
+			hook:            webhook{GitRepositoryURL: "https://github.com/owner/repo"},
+			expectedEvents:  []string{"push", "pull_request"},
+			expectedActions: map[string][]string{"pull_request": {"opened", "reopened", "synchronize"}},
+		},
+		{
+			name:           "events with default actions",
+			hook:           webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"tag", "release", "check_suite"}},
+			expectedEvents: []string{"tag", "release", "check_suite"},
+			expectedActions: map[string][]string{
+				"release":     {"published"},
+				"check_suite": {"requested", "rerequested"},
+			},
+		},
+		{
+			name: "actions given",
+			hook: webhook{
+				GitRepositoryURL: "https://github.com/owner/repo",
+				Events:           []string{"pull_request", "issue_comment"},
+				Actions:          map[string][]string{"pull_request": {"opened", "closed"}},
+			},
+			expectedEvents: []string{"pull_request", "issue_comment"},
+			expectedActions: map[string][]string{
+				"pull_request":  {"opened", "closed"},
+				"issue_comment": {"created"},
+			},
+		},
+		{
+			name:        "unknown event",
+			hook:        webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"deployment"}},
+			expectError: true,
+		},
+		{
+			name:        "event not supported by the git provider",
+			hook:        webhook{GitRepositoryURL: "https://bitbucket.org/owner/repo", GitProvider: "bitbucket", Events: []string{"release"}},
+			expectError: true,
+		},
+		{
+			name:        "event listed twice",
+			hook:        webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"push", "push"}},
+			expectError: true,
+		},
+		{
+			name:        "actions of an event not listed",
+			hook:        webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"push"}, Actions: map[string][]string{"pull_request": {"opened"}}},
+			expectError: true,
+		},
+		{
+			name:        "actions of an event without actions",
+			hook:        webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"push"}, Actions: map[string][]string{"push": {"created"}}},
+			expectError: true,
+		},
+		{
+			name:        "action with a comma",
+			hook:        webhook{GitRepositoryURL: "https://github.com/owner/repo", Events: []string{"pull_request"}, Actions: map[string][]string{"pull_request": {"opened,closed"}}},
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := tt.hook
+			err := validateEvents(&hook)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("validateEvents() did not return an error for %+v", tt.hook)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateEvents() returned an error: %s", err)
+			}
+			if !reflect.DeepEqual(hook.Events, tt.expectedEvents) {
+				t.Errorf("Events were %v, expected %v", hook.Events, tt.expectedEvents)
+			}
+			if !reflect.DeepEqual(hook.Actions, tt.expectedActions) {
+				t.Errorf("Actions were %v, expected %v", hook.Actions, tt.expectedActions)
+			}
+		})
+	}
+}
+
+func TestCreateWebhookWithEvents(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+	hook.Events = []string{"tag", "release"}
+	hook.Actions = map[string][]string{"release": {"published", "created"}}
+
+	// The trigger binding of each event is required
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("Expected webhook creation to fail with status 400 without the trigger bindings, got %d", resp.StatusCode())
+	}
+	for _, event := range hook.Events {
+		binding := v1alpha1.TriggerBinding{ObjectMeta: metav1.ObjectMeta{Name: getEventBindingName(hook.Pipeline, event), Namespace: installNs}}
+		if _, err := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Create(&binding); err != nil {
+			t.Fatalf("Error creating trigger binding: %s", err)
+		}
+	}
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	expected := map[string][]string{
+		"name1-foo-tag-event":     {"tag", ""},
+		"name1-foo-release-event": {"release", "published,created"},
+	}
+	found := 0
+	for _, trigger := range el.Spec.Triggers {
+		want, ok := expected[trigger.Name]
+		if !ok {
+			continue
+		}
+		found++
+		if trigger.Binding.Name != getEventBindingName(hook.Pipeline, want[0]) {
+			t.Errorf("Trigger %s had binding %s", trigger.Name, trigger.Binding.Name)
+		}
+		event, actions := getTriggerEvent(trigger)
+		if event != want[0] || (want[1] == "" && actions != nil) || (want[1] != "" && !reflect.DeepEqual(actions, []string{"published", "created"})) {
+			t.Errorf("Trigger %s was for event %s with actions %v, expected %s with actions %s", trigger.Name, event, actions, want[0], want[1])
+		}
+	}
+	if found != len(expected) {
+		t.Errorf("Expected triggers %v in the eventlistener, got %+v", expected, el.Spec.Triggers)
+	}
+
+	// The webhook is listed with its events and actions
+	hooks, err := r.getWebhooksFromEventListeners()
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Expected one webhook in the eventlistener; got: %+v, %v", hooks, err)
+	}
+	if !reflect.DeepEqual(hooks[0].Events, hook.Events) || !reflect.DeepEqual(hooks[0].Actions, hook.Actions) {
+		t.Errorf("Webhook listed with events %v and actions %v, expected %v and %v", hooks[0].Events, hooks[0].Actions, hook.Events, hook.Actions)
+	}
+
+	// The hook is registered for all the events Gitea supports, tags are pushed
+	giteaHooks := gitea.getHooks("owner", "repo")
+	if len(giteaHooks) != 1 || !reflect.DeepEqual(giteaHooks[0].Events, []string{"push", "pull_request", "release", "issue_comment"}) {
+		t.Errorf("Expected a hook registered for the events Gitea supports; got: %+v", giteaHooks)
+	}
+}
+
+func TestWebhookEventsUpdateExistingHook(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+	for _, event := range []string{"release", "issue_comment"} {
+		binding := v1alpha1.TriggerBinding{ObjectMeta: metav1.ObjectMeta{Name: getEventBindingName(hook.Pipeline, event), Namespace: installNs}}
+		if _, err := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Create(&binding); err != nil {
+			t.Fatalf("Error creating trigger binding: %s", err)
+		}
+	}
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	// The hook was registered before events other than push and pull_request were supported
+	registerLegacyEvents := func() {
+		gitea.mutex.Lock()
+		defer gitea.mutex.Unlock()
+		gitea.hooks["owner/repo"][0].Events = []string{"push", "pull_request"}
+	}
+	hookEvents := func() []string {
+		hooks := gitea.getHooks("owner", "repo")
+		if len(hooks) != 1 {
+			t.Fatalf("Expected the hook to be shared by the webhooks; got: %+v", hooks)
+		}
+		return hooks[0].Events
+	}
+	allEvents := []string{"push", "pull_request", "release", "issue_comment"}
+
+	// A webhook added with a new event updates the hook
+	registerLegacyEvents()
+	other := hook
+	other.Namespace = "bar"
+	other.Events = []string{"release"}
+	if resp := createWebhook(other, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	if events := hookEvents(); !reflect.DeepEqual(events, allEvents) {
+		t.Errorf("Expected the hook to be updated to the events Gitea supports adding a webhook for a new event; got: %v", events)
+	}
+
+	// Updating a webhook only updates the hook for events none of the webhooks had
+	registerLegacyEvents()
+	update := webhook{Name: hook.Name, Namespace: hook.Namespace, GitRepositoryURL: hook.GitRepositoryURL, Pipeline: hook.Pipeline, Events: []string{"push"}}
+	if status, err := r.replaceWebhook(context.Background(), hook.Name, &update); err != nil {
+		t.Fatalf("Webhook update failed with status %d: %s", status, err)
+	}
+	if events := hookEvents(); !reflect.DeepEqual(events, []string{"push", "pull_request"}) {
+		t.Errorf("Expected the hook to be left untouched updating a webhook to existing events; got: %v", events)
+	}
+	update = webhook{Name: hook.Name, Namespace: hook.Namespace, GitRepositoryURL: hook.GitRepositoryURL, Pipeline: hook.Pipeline, Events: []string{"issue_comment"}}
+	if status, err := r.replaceWebhook(context.Background(), hook.Name, &update); err != nil {
+		t.Fatalf("Webhook update failed with status %d: %s", status, err)
+	}
+	if events := hookEvents(); !reflect.DeepEqual(events, allEvents) {
+		t.Errorf("Expected the hook to be updated to the events Gitea supports updating a webhook to a new event; got: %v", events)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeGiteaServer is an in-process stand-in for the repository hook API of a Gitea server,
+// so that webhooks can be registered and removed in tests without a real git provider.
+// Any owner and repository exists, requests must be authenticated with the access token.
+type fakeGiteaServer struct {
+	*httptest.Server
+	accessToken string
+	mutex       sync.Mutex
+	nextID      int64
+	// Hooks are stored by "<owner>/<repo>"
+	hooks map[string][]giteaHook
+}
+
+// newFakeGiteaServer starts a fake Gitea server, the caller should Close it when done
+func newFakeGiteaServer(accessToken string) *fakeGiteaServer {
+	s := &fakeGiteaServer{
+		accessToken: accessToken,
+		hooks:       map[string][]giteaHook{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// repoURL returns the URL of a repository on the fake server
+func (s *fakeGiteaServer) repoURL(owner, repo string) string {
+	return fmt.Sprintf("%s/%s/%s", s.URL, owner, repo)
+}
+
+// getHooks returns a copy of the hooks registered on the repository
+func (s *fakeGiteaServer) getHooks(owner, repo string) []giteaHook {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]giteaHook{}, s.hooks[owner+"/"+repo]...)
+}
+
+func (s *fakeGiteaServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	authorization := request.Header.Get("Authorization")
+	if authorization != "Bearer "+s.accessToken && authorization != "token "+s.accessToken {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Only "/api/v1/repos/<owner>/<repo>/hooks[/<id>]" is served
+	pieces := strings.Split(strings.TrimPrefix(request.URL.Path, "/api/v1/repos/"), "/")
+	if !strings.HasPrefix(request.URL.Path, "/api/v1/repos/") || len(pieces) < 3 || len(pieces) > 4 || pieces[2] != "hooks" {
+		http.NotFound(writer, request)
+		return
+	}
+	repo := pieces[0] + "/" + pieces[1]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(pieces) == 3 {
+		switch request.Method {
+		case http.MethodGet:
+			// Hooks are listed a page at a time, at most 50 to a page as by Gitea, linking the next page
+			page, _ := strconv.Atoi(request.URL.Query().Get("page"))
+			limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+			if page < 1 {
+				page = 1
+			}
+			if limit < 1 || limit > 50 {
+				limit = 50
+			}
+			hooks := []giteaHook{}
+			if start := (page - 1) * limit; start < len(s.hooks[repo]) {
+				hooks = s.hooks[repo][start:]
+			}
+			if len(hooks) > limit {
+				hooks = hooks[:limit]
+				writer.Header().Set("Link", fmt.Sprintf(`<%s%s?page=%d&limit=%d>; rel="next"`, s.URL, request.URL.Path, page+1, limit))
+			}
+			writeFakeGiteaJSON(writer, http.StatusOK, hooks)
+		case http.MethodPost:
+			hook := giteaHook{}
+			if err := json.NewDecoder(request.Body).Decode(&hook); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if (hook.Type != "gitea" && hook.Type != "gogs") || hook.Config.URL == "" || hook.Config.ContentType == "" {
+				http.Error(writer, "Invalid hook", http.StatusUnprocessableEntity)
+				return
+			}
+			s.nextID++
+			hook.ID = s.nextID
+			s.hooks[repo] = append(s.hooks[repo], hook)
+			writeFakeGiteaJSON(writer, http.StatusCreated, hook)
+		default:
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(pieces[3], 10, 64)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	for i, hook := range s.hooks[repo] {
+		if hook.ID != id {
+			continue
+		}
+		switch request.Method {
+		case http.MethodGet:
+			writeFakeGiteaJSON(writer, http.StatusOK, hook)
+		case http.MethodDelete:
+			s.hooks[repo] = append(s.hooks[repo][:i], s.hooks[repo][i+1:]...)
+			writer.WriteHeader(http.StatusNoContent)
+		case http.MethodPatch:
+			edited := giteaHook{}
+			if err := json.NewDecoder(request.Body).Decode(&edited); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hook.Config, hook.Events, hook.Active = edited.Config, edited.Events, edited.Active
+			s.hooks[repo][i] = hook
+			writeFakeGiteaJSON(writer, http.StatusOK, hook)
+		default:
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	http.NotFound(writer, request)
+}
+
+func writeFakeGiteaJSON(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	json.NewEncoder(writer).Encode(v)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+)
+
+/*--------------------------------------
+Branch and path filters restrict the pushes that run the pipeline of a webhook.
+They are glob patterns, where * matches within a path segment and ** matches
+any number of path segments, set as headers on the push trigger and evaluated
+by the interceptor:
+
+- a push runs the pipeline if the pushed branch matches one of the branches,
+  if any, and none of the excludebranches. A push of a tag is not a push of a
+  branch, so only runs the pipeline if there are no branches.
+- a push runs the pipeline if one of the files changed by its commits matches
+  one of the paths, if any, and none of the excludepaths.
+---------------------------------------*/
+
+// filterHeaders are the interceptor headers of the push trigger for each filter of a webhook
+var filterHeaders = []struct {
+	name   string
+	filter func(webhook *webhook) *[]string
+}{
+	{"Wext-Branches", func(webhook *webhook) *[]string { return &webhook.Branches }},
+	{"Wext-Exclude-Branches", func(webhook *webhook) *[]string { return &webhook.ExcludeBranches }},
+	{"Wext-Paths", func(webhook *webhook) *[]string { return &webhook.Paths }},
+	{"Wext-Exclude-Paths", func(webhook *webhook) *[]string { return &webhook.ExcludePaths }},
+}
+
+// hasFilters returns whether the webhook has any branch or path filters
+func hasFilters(webhook webhook) bool {
+	return len(webhook.Branches) > 0 || len(webhook.ExcludeBranches) > 0 || len(webhook.Paths) > 0 || len(webhook.ExcludePaths) > 0
+}
+
+// validateFilters checks the branch and path filters of the webhook, which apply to its push events
+func validateFilters(webhook webhook) error {
+	if !hasFilters(webhook) {
+		return nil
+	}
+	if !containsString(webhook.Events, pushEvent) {
+		return errors.New("branch and path filters apply to push events, which are not events of the webhook")
+	}
+	if (len(webhook.Paths) > 0 || len(webhook.ExcludePaths) > 0) && getGitProvider(webhook) == bitbucketProvider {
+		return fmt.Errorf("path filters are not supported for %s, whose push events do not list the changed files", bitbucketProvider)
+	}
+	for _, header := range filterHeaders {
+		for _, pattern := range *header.filter(&webhook) {
+			// Patterns are matched a path segment at a time
+			if _, err := path.Match(pattern, ""); pattern == "" || strings.Contains(pattern, ",") || err != nil {
+				return fmt.Errorf("filter %q is not a valid glob pattern", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// setFilterHeaders sets the interceptor headers of the push trigger for the filters of the webhook
+func setFilterHeaders(trigger *v1alpha1.EventListenerTrigger, webhook webhook) {
+	for _, header := range filterHeaders {
+		if patterns := *header.filter(&webhook); len(patterns) > 0 {
+			trigger.Interceptor.Header = append(trigger.Interceptor.Header,
+				pipelinesv1alpha1.Param{Name: header.name, Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: strings.Join(patterns, ",")}})
+		}
+	}
+}
+
+// getFiltersFromTrigger sets the filters of the webhook from the interceptor headers of its push trigger
+func getFiltersFromTrigger(trigger v1alpha1.EventListenerTrigger, webhook *webhook) {
+	for _, header := range filterHeaders {
+		*header.filter(webhook) = nil
+		for _, param := range trigger.Interceptor.Header {
+			if param.Name == header.name && param.Value.StringVal != "" {
+				*header.filter(webhook) = strings.Split(param.Value.StringVal, ",")
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		name        string
+		hook        webhook
+		expectError bool
+	}{
+		{name: "no filters", hook: webhook{Events: []string{"pull_request"}}},
+		{name: "branches", hook: webhook{Events: []string{"push"}, Branches: []string{"main", "release/*"}, ExcludeBranches: []string{"release/old-*"}}},
+		{name: "paths", hook: webhook{Events: []string{"push"}, Paths: []string{"src/**"}, ExcludePaths: []string{"**/*.md"}}},
+		{name: "no push event", hook: webhook{Events: []string{"pull_request"}, Branches: []string{"main"}}, expectError: true},
+		{name: "bad pattern", hook: webhook{Events: []string{"push"}, Branches: []string{"release/[1-"}}, expectError: true},
+		{name: "pattern with a comma", hook: webhook{Events: []string{"push"}, Paths: []string{"a,b"}}, expectError: true},
+		{name: "empty pattern", hook: webhook{Events: []string{"push"}, ExcludePaths: []string{""}}, expectError: true},
+		{name: "bitbucket branches", hook: webhook{Events: []string{"push"}, GitProvider: "bitbucket", Branches: []string{"main"}}},
+		{name: "bitbucket paths", hook: webhook{Events: []string{"push"}, GitProvider: "bitbucket", Paths: []string{"src/**"}}, expectError: true},
+	}
+	for _, tt := range tests {
+		err := validateFilters(tt.hook)
+		if tt.expectError && err == nil {
+			t.Errorf("%s: validateFilters() did not return an error", tt.name)
+		}
+		if !tt.expectError && err != nil {
+			t.Errorf("%s: validateFilters() returned an error: %s", tt.name, err)
+		}
+	}
+}
+
+func TestCreateWebhookWithFilters(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+	hook.Branches = []string{"main", "release/*"}
+	hook.ExcludePaths = []string{"docs/**", "**/*.md"}
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+
+	// The filters are set on the push trigger only
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	for _, trigger := range el.Spec.Triggers {
+		headers := map[string]string{}
+		for _, header := range trigger.Interceptor.Header {
+			headers[header.Name] = header.Value.StringVal
+		}
+		wantBranches, wantExcludePaths := "", ""
+		if trigger.Name == "name1-foo-push-event" {
+			wantBranches, wantExcludePaths = "main,release/*", "docs/**,**/*.md"
+		}
+		if headers["Wext-Branches"] != wantBranches || headers["Wext-Exclude-Paths"] != wantExcludePaths {
+			t.Errorf("Trigger %s had filters %q and %q, expected %q and %q", trigger.Name, headers["Wext-Branches"], headers["Wext-Exclude-Paths"], wantBranches, wantExcludePaths)
+		}
+	}
+
+	// The webhook is listed with its filters
+	hooks, err := r.getWebhooks()
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Expected one webhook; got: %+v, %v", hooks, err)
+	}
+	if !reflect.DeepEqual(hooks[0].Branches, hook.Branches) || !reflect.DeepEqual(hooks[0].ExcludePaths, hook.ExcludePaths) ||
+		hooks[0].ExcludeBranches != nil || hooks[0].Paths != nil {
+		t.Errorf("Webhook listed with unexpected filters: %+v", hooks[0])
+	}
+
+	// Filters are rejected for webhooks without push events
+	other := hook
+	other.Name = "name2"
+	other.Pipeline = "pipeline2"
+	other.Events = []string{"pull_request"}
+	createTriggerResources(other, r)
+	if resp := createWebhook(other, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Errorf("Expected webhook creation to fail with status 400, got %d", resp.StatusCode())
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+)
+
+/*--------------------------------------
+The fork policy of a webhook decides which pull requests run its pipeline,
+and is set as headers on the pull_request trigger and enforced by the
+interceptor:
+
+- all, the default, runs the pipeline for all pull requests.
+- samerepository runs the pipeline only for pull requests from branches of
+  the repository, not from forks.
+- trusted also runs the pipeline for pull requests from forks opened by
+  authors with at least the forkpermission on the repository (write by
+  default) or, for GitHub, in the forkteam of the organization of the
+  repository.
+
+Pull requests rejected by the policy can be run by a member commenting
+/ok-to-test, if the webhook has the issue_comment event. With forkcomment,
+the interceptor explains the rejection in a comment on the pull request.
+---------------------------------------*/
+
+const (
+	forkPolicyAll            = "all"
+	forkPolicySameRepository = "samerepository"
+	forkPolicyTrusted        = "trusted"
+
+	defaultForkPermission = "write"
+)
+
+// forkPermissions are the permissions of authors on the repository in increasing order
+var forkPermissions = []string{"read", "write", "admin"}
+
+// validateForkPolicy checks the fork policy of the webhook, which applies to its pull_request events
+func validateForkPolicy(webhook webhook) error {
+	if webhook.ForkPolicy == "" && webhook.ForkPermission == "" && webhook.ForkTeam == "" && !webhook.ForkComment {
+		return nil
+	}
+	if !containsString(webhook.Events, pullRequestEvent) {
+		return errors.New("the fork policy applies to pull_request events, which are not events of the webhook")
+	}
+	provider := getGitProvider(webhook)
+	switch webhook.ForkPolicy {
+	case "", forkPolicyAll, forkPolicySameRepository:
+		if webhook.ForkPermission != "" || webhook.ForkTeam != "" {
+			return fmt.Errorf("forkpermission and forkteam apply to the %s fork policy", forkPolicyTrusted)
+		}
+	case forkPolicyTrusted:
+		if provider == bitbucketProvider {
+			return fmt.Errorf("the %s fork policy is not supported for %s", forkPolicyTrusted, bitbucketProvider)
+		}
+		if webhook.ForkPermission != "" && !containsString(forkPermissions, webhook.ForkPermission) {
+			return fmt.Errorf("forkpermission %s is not valid, valid permissions are read, write and admin", webhook.ForkPermission)
+		}
+		if webhook.ForkTeam != "" && provider != gitHubProvider {
+			return fmt.Errorf("forkteam is only supported for %s", gitHubProvider)
+		}
+	default:
+		return fmt.Errorf("forkpolicy %s is not valid, valid policies are %s, %s and %s", webhook.ForkPolicy, forkPolicyAll, forkPolicySameRepository, forkPolicyTrusted)
+	}
+	if webhook.ForkComment && provider == bitbucketProvider {
+		return fmt.Errorf("forkcomment is not supported for %s", bitbucketProvider)
+	}
+	return nil
+}
+
+// setForkPolicyHeaders sets the interceptor headers of the pull_request trigger for the fork policy of the webhook
+func setForkPolicyHeaders(trigger *v1alpha1.EventListenerTrigger, webhook webhook) {
+	if webhook.ForkPolicy == "" || webhook.ForkPolicy == forkPolicyAll {
+		return
+	}
+	headers := map[string]string{"Wext-Fork-Policy": webhook.ForkPolicy}
+	if webhook.ForkPolicy == forkPolicyTrusted {
+		headers["Wext-Fork-Permission"] = webhook.ForkPermission
+		if headers["Wext-Fork-Permission"] == "" {
+			headers["Wext-Fork-Permission"] = defaultForkPermission
+		}
+		headers["Wext-Fork-Team"] = webhook.ForkTeam
+	}
+	if webhook.ForkComment {
+		headers["Wext-Fork-Comment"] = strconv.FormatBool(webhook.ForkComment)
+	}
+	for _, name := range []string{"Wext-Fork-Policy", "Wext-Fork-Permission", "Wext-Fork-Team", "Wext-Fork-Comment"} {
+		if headers[name] != "" {
+			trigger.Interceptor.Header = append(trigger.Interceptor.Header,
+				pipelinesv1alpha1.Param{Name: name, Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: headers[name]}})
+		}
+	}
+}
+
+// getForkPolicyFromTrigger sets the fork policy of the webhook from the interceptor headers of its pull_request trigger
+func getForkPolicyFromTrigger(trigger v1alpha1.EventListenerTrigger, webhook *webhook) {
+	webhook.ForkPolicy, webhook.ForkPermission, webhook.ForkTeam, webhook.ForkComment = "", "", "", false
+	for _, header := range trigger.Interceptor.Header {
+		switch header.Name {
+		case "Wext-Fork-Policy":
+			webhook.ForkPolicy = header.Value.StringVal
+		case "Wext-Fork-Permission":
+			webhook.ForkPermission = header.Value.StringVal
+		case "Wext-Fork-Team":
+			webhook.ForkTeam = header.Value.StringVal
+		case "Wext-Fork-Comment":
+			webhook.ForkComment, _ = strconv.ParseBool(header.Value.StringVal)
+		}
+	}
+}
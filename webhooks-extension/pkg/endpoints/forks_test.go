@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"net/http"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateForkPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		hook        webhook
+		expectError bool
+	}{
+		{name: "no policy", hook: webhook{Events: []string{"push"}}},
+		{name: "all", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "all"}},
+		{name: "same repository", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "samerepository", ForkComment: true}},
+		{name: "trusted", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "trusted", ForkPermission: "admin", ForkTeam: "reviewers"}},
+		{name: "no pull_request event", hook: webhook{Events: []string{"push"}, ForkPolicy: "samerepository"}, expectError: true},
+		{name: "unknown policy", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "sometimes"}, expectError: true},
+		{name: "permission without trusted", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "samerepository", ForkPermission: "write"}, expectError: true},
+		{name: "unknown permission", hook: webhook{Events: []string{"pull_request"}, ForkPolicy: "trusted", ForkPermission: "owner"}, expectError: true},
+		{name: "gitlab team", hook: webhook{Events: []string{"pull_request"}, GitProvider: "gitlab", ForkPolicy: "trusted", ForkTeam: "reviewers"}, expectError: true},
+		{name: "gitlab trusted", hook: webhook{Events: []string{"pull_request"}, GitProvider: "gitlab", ForkPolicy: "trusted", ForkComment: true}},
+		{name: "bitbucket same repository", hook: webhook{Events: []string{"pull_request"}, GitProvider: "bitbucket", ForkPolicy: "samerepository"}},
+		{name: "bitbucket trusted", hook: webhook{Events: []string{"pull_request"}, GitProvider: "bitbucket", ForkPolicy: "trusted"}, expectError: true},
+		{name: "bitbucket comment", hook: webhook{Events: []string{"pull_request"}, GitProvider: "bitbucket", ForkPolicy: "samerepository", ForkComment: true}, expectError: true},
+	}
+	for _, tt := range tests {
+		err := validateForkPolicy(tt.hook)
+		if tt.expectError && err == nil {
+			t.Errorf("%s: validateForkPolicy() did not return an error", tt.name)
+		}
+		if !tt.expectError && err != nil {
+			t.Errorf("%s: validateForkPolicy() returned an error: %s", tt.name, err)
+		}
+	}
+}
+
+func TestCreateWebhookWithForkPolicy(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+	hook.ForkPolicy = "trusted"
+	hook.ForkComment = true
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+
+	// The policy is set on the pull_request trigger only, with the default permission
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	for _, trigger := range el.Spec.Triggers {
+		headers := map[string]string{}
+		for _, header := range trigger.Interceptor.Header {
+			headers[header.Name] = header.Value.StringVal
+		}
+		want := map[string]string{}
+		if trigger.Name == "name1-foo-pullrequest-event" {
+			want = map[string]string{"Wext-Fork-Policy": "trusted", "Wext-Fork-Permission": "write", "Wext-Fork-Comment": "true"}
+		}
+		for _, name := range []string{"Wext-Fork-Policy", "Wext-Fork-Permission", "Wext-Fork-Team", "Wext-Fork-Comment"} {
+			if headers[name] != want[name] {
+				t.Errorf("Trigger %s had header %s %q, expected %q", trigger.Name, name, headers[name], want[name])
+			}
+		}
+	}
+
+	// The webhook is listed with its policy
+	hooks, err := r.getWebhooks()
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Expected one webhook; got: %+v, %v", hooks, err)
+	}
+	if hooks[0].ForkPolicy != "trusted" || hooks[0].ForkPermission != "write" || hooks[0].ForkTeam != "" || !hooks[0].ForkComment {
+		t.Errorf("Webhook listed with unexpected fork policy: %+v", hooks[0])
+	}
+
+	// Teams are rejected for Gitea
+	other := hook
+	other.Name = "name2"
+	other.Pipeline = "pipeline2"
+	other.ForkTeam = "reviewers"
+	createTriggerResources(other, r)
+	if resp := createWebhook(other, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Errorf("Expected webhook creation to fail with status 400, got %d", resp.StatusCode())
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"golang.org/x/xerrors"
+)
+
+// giteaHook is the subset of a Gitea or Gogs repository hook used by the extension
+type giteaHook struct {
+	ID     int64           `json:"id,omitempty"`
+	Type   string          `json:"type"`
+	Config giteaHookConfig `json:"config"`
+	Events []string        `json:"events"`
+	Active bool            `json:"active"`
+}
+
+type giteaHookConfig struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Secret      string `json:"secret,omitempty"`
+}
+
+// getGiteaHooksAPI returns the API URL for the hooks of the Gitea or Gogs repository.
+// Both may be served from a sub path, for example "https://my.company.xyz/gitea/<owner>/<repo>",
+// in which case the API is served from "https://my.company.xyz/gitea/api/v1"
+func getGiteaHooksAPI(u *url.URL) (string, error) {
+	pieces := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	if len(pieces) < 2 {
+		return "", xerrors.Errorf("Gitea repo URL %s should have the form https://<host>/<owner>/<repo>", u)
+	}
+	owner, repo := pieces[len(pieces)-2], pieces[len(pieces)-1]
+	root := strings.Join(pieces[:len(pieces)-2], "/")
+	if root != "" {
+		root = "/" + root
+	}
+	return fmt.Sprintf("%s://%s%s/api/v1/repos/%s/%s/hooks", u.Scheme, u.Host, root, owner, repo), nil
+}
+
+// newGiteaHook returns the Gitea or Gogs hook for the callback, signed with the secret and sending the events
+func newGiteaHook(hookType, callback, secret string, events []string) (giteaHook, error) {
+	for _, event := range events {
+		switch event {
+		case "push", "pull_request", "release", "issue_comment":
+		default:
+			return giteaHook{}, xerrors.Errorf("event %s is not supported for Gitea hooks", event)
+		}
+	}
+	return giteaHook{
+		Type: hookType,
+		Config: giteaHookConfig{
+			URL:         callback,
+			ContentType: "json",
+			Secret:      secret,
+		},
+		Events: events,
+		Active: true,
+	}, nil
+}
+
+// doGiteaHookRequest creates, removes or updates a Gitea or Gogs repository hook given the specified parameters
+// Gitea API documentation: https://try.gitea.io/api/swagger#/repository/repoCreateHook
+// hookType: "gitea" or "gogs", the format of the payloads sent by the hook
+// mode: "subscribe", "unsubscribe" or "update", which replaces the secret and events of the existing hook
+// callback: the URI to receive the updates
+// secret: shared secret key used to sign the X-Gitea-Signature or X-Gogs-Signature header
+// events: the list of events to subscribe to; for example, {"push", "pull_request"}
+func doGiteaHookRequest(client *http.Client, hookType, repoURL, mode, callback, secret string, events []string) error {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return xerrors.Errorf("error parsing Gitea repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getGiteaHooksAPI(u)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case "subscribe":
+		hook, err := newGiteaHook(hookType, callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling Gitea hook: %w", err)
+		}
+		resp, err := client.Post(hooksAPI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return xerrors.Errorf("error sending Gitea hook %s request: %w", mode, err)
+		}
+		defer resp.Body.Close()
+		// Should receive 201 Created on success
+		if resp.StatusCode != http.StatusCreated {
+			return xerrors.Errorf("error sending Gitea hook %s request. Status: %s", mode, resp.Status)
+		}
+		logging.Log.Debugf("Gitea hook %s response: %s", mode, resp.Status)
+		return nil
+	case "unsubscribe":
+		hooks, err := listGiteaHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			hookURL := fmt.Sprintf("%s/%d", hooksAPI, hook.ID)
+			req, err := http.NewRequest(http.MethodDelete, hookURL, nil)
+			if err != nil {
+				return xerrors.Errorf("error creating Gitea hook %s request: %w", mode, err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending Gitea hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 204 No Content on success
+			if resp.StatusCode != http.StatusNoContent {
+				return xerrors.Errorf("error sending Gitea hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("Gitea hook %s (%s) response: %s", mode, hookURL, resp.Status)
+		}
+		return nil
+	case "update":
+		hook, err := newGiteaHook(hookType, callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling Gitea hook: %w", err)
+		}
+		hooks, err := listGiteaHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		updated := false
+		for _, existing := range hooks {
+			hookURL := fmt.Sprintf("%s/%d", hooksAPI, existing.ID)
+			req, err := http.NewRequest(http.MethodPatch, hookURL, bytes.NewReader(body))
+			if err != nil {
+				return xerrors.Errorf("error creating Gitea hook %s request: %w", mode, err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending Gitea hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 200 OK on success
+			if resp.StatusCode != http.StatusOK {
+				return xerrors.Errorf("error sending Gitea hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("Gitea hook %s (%s) response: %s", mode, hookURL, resp.Status)
+			updated = true
+		}
+		if !updated {
+			return xerrors.Errorf("no Gitea hook for %s is registered on repository %s", callback, repoURL)
+		}
+		return nil
+	default:
+		return xerrors.Errorf("unknown Gitea hook mode %s", mode)
+	}
+}
+
+// listGiteaHooks returns the hooks for the callback registered on the Gitea or Gogs repository, following the
+// pages of hooks until limit hooks are found or, if limit is 0, the pages run out. Gogs lists all hooks at once.
+func listGiteaHooks(client *http.Client, hooksAPI, callback string, limit int) ([]giteaHook, error) {
+	hooks := []giteaHook{}
+	for pageURL := hooksAPI + "?limit=50"; pageURL != ""; {
+		resp, err := client.Get(pageURL)
+		if err != nil {
+			return nil, xerrors.Errorf("error listing Gitea hooks: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, xerrors.Errorf("error listing Gitea hooks. Status: %s", resp.Status)
+		}
+		pageHooks := []giteaHook{}
+		err = json.NewDecoder(resp.Body).Decode(&pageHooks)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("error decoding Gitea hooks: %w", err)
+		}
+		for _, hook := range pageHooks {
+			if hook.Config.URL == callback {
+				hooks = append(hooks, hook)
+			}
+		}
+		if limit > 0 && len(hooks) >= limit {
+			break
+		}
+		pageURL = getNextPageLink(resp.Header)
+	}
+	return hooks, nil
+}
+
+// isGiteaHookRegistered returns whether a hook with the callback is registered on the Gitea or Gogs repository
+func isGiteaHookRegistered(client *http.Client, repoURL, callback string) (bool, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false, xerrors.Errorf("error parsing Gitea repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getGiteaHooksAPI(u)
+	if err != nil {
+		return false, err
+	}
+	hooks, err := listGiteaHooks(client, hooksAPI, callback, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(hooks) > 0, nil
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_getGiteaHooksAPI(t *testing.T) {
+	tests := []struct {
+		rawurl  string
+		want    string
+		wantErr bool
+	}{
+		{
+			rawurl: "https://gitea.company.com/owner/repo",
+			want:   "https://gitea.company.com/api/v1/repos/owner/repo/hooks",
+		},
+		{
+			rawurl: "http://gitea.company.com:3000/owner/repo.git",
+			want:   "http://gitea.company.com:3000/api/v1/repos/owner/repo/hooks",
+		},
+		{
+			rawurl: "https://company.com/gitea/owner/repo",
+			want:   "https://company.com/gitea/api/v1/repos/owner/repo/hooks",
+		},
+		{
+			rawurl:  "https://gitea.company.com/repo",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawurl, func(t *testing.T) {
+			u, err := url.Parse(tt.rawurl)
+			if err != nil {
+				t.Errorf("getGiteaHooksAPI() error parsing rawurl %s: %s", tt.rawurl, err)
+			}
+			got, err := getGiteaHooksAPI(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getGiteaHooksAPI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getGiteaHooksAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_doGiteaHookRequest(t *testing.T) {
+	gitea := newFakeGiteaServer("myAccessToken")
+	defer gitea.Close()
+	client := createOAuth2Client(context.Background(), "myAccessToken")
+	repoURL := gitea.repoURL("owner", "repo")
+
+	for _, callback := range []string{"https://othercallback.com", "https://examplecallback.com"} {
+		err := doGiteaHookRequest(client, "gitea", repoURL, "subscribe", callback, "mySecret", []string{"push", "pull_request"})
+		if err != nil {
+			t.Fatalf("doGiteaHookRequest() returned an error subscribing: %s", err)
+		}
+	}
+	hooks := gitea.getHooks("owner", "repo")
+	if len(hooks) != 2 {
+		t.Fatalf("doGiteaHookRequest() expected 2 hooks after subscribing; got: %+v", hooks)
+	}
+	want := giteaHook{
+		ID:     2,
+		Type:   "gitea",
+		Config: giteaHookConfig{URL: "https://examplecallback.com", ContentType: "json", Secret: "mySecret"},
+		Events: []string{"push", "pull_request"},
+		Active: true,
+	}
+	if !reflect.DeepEqual(hooks[1], want) {
+		t.Errorf("doGiteaHookRequest() expected hook %+v; got: %+v", want, hooks[1])
+	}
+
+	err := doGiteaHookRequest(client, "gitea", repoURL, "unsubscribe", "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+	if err != nil {
+		t.Fatalf("doGiteaHookRequest() returned an error unsubscribing: %s", err)
+	}
+	hooks = gitea.getHooks("owner", "repo")
+	if len(hooks) != 1 || hooks[0].Config.URL != "https://othercallback.com" {
+		t.Errorf("doGiteaHookRequest() expected only the hook for https://othercallback.com to remain; got: %+v", hooks)
+	}
+}
+
+func Test_doGiteaHookRequest_error(t *testing.T) {
+	gitea := newFakeGiteaServer("myAccessToken")
+	defer gitea.Close()
+	repoURL := gitea.repoURL("owner", "repo")
+
+	// Unauthorized
+	client := createOAuth2Client(context.Background(), "notMyAccessToken")
+	for _, mode := range []string{"subscribe", "unsubscribe"} {
+		if err := doGiteaHookRequest(client, "gitea", repoURL, mode, "https://examplecallback.com", "mySecret", []string{"push"}); err == nil {
+			t.Errorf("doGiteaHookRequest() did not return an error when unauthorized to %s", mode)
+		}
+	}
+
+	// Unsupported event
+	client = createOAuth2Client(context.Background(), "myAccessToken")
+	if err := doGiteaHookRequest(client, "gitea", repoURL, "subscribe", "https://examplecallback.com", "mySecret", []string{"issues"}); err == nil {
+		t.Errorf("doGiteaHookRequest() did not return an error for an unsupported event")
+	}
+	if hooks := gitea.getHooks("owner", "repo"); len(hooks) != 0 {
+		t.Errorf("doGiteaHookRequest() expected no hooks to be created; got: %+v", hooks)
+	}
+}
+
+func TestCreateAndDeleteGiteaWebhook(t *testing.T) {
+	gitea := newFakeGiteaServer("access")
+	defer gitea.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: gitea.repoURL("owner", "repo"),
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+		GitProvider:      "gitea",
+	}
+	createTriggerResources(hook, r)
+
+	resp := createWebhook(hook, r)
+	if resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Gitea webhook creation failed with status %d", resp.StatusCode())
+	}
+	hooks := gitea.getHooks("owner", "repo")
+	if len(hooks) != 1 || hooks[0].Config.URL != r.Defaults.CallbackURL || hooks[0].Config.Secret != "secret" {
+		t.Errorf("Expected a single Gitea hook for %s signed with the secret token; got: %+v", r.Defaults.CallbackURL, hooks)
+	}
+
+	httpReq := dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/name1?namespace=foo&repository="+url.QueryEscape(hook.GitRepositoryURL), nil)
+	req := dummyRestfulRequest(httpReq, "name1")
+	httpWriter := httptest.NewRecorder()
+	deleteResp := dummyRestfulResponse(httpWriter)
+	r.deleteWebhook(req, deleteResp)
+	if deleteResp.StatusCode() != http.StatusNoContent {
+		t.Fatalf("Gitea webhook deletion failed with status %d", deleteResp.StatusCode())
+	}
+	if hooks := gitea.getHooks("owner", "repo"); len(hooks) != 0 {
+		t.Errorf("Expected the Gitea hook to be removed; got: %+v", hooks)
+	}
+}
+
+func TestRotateCredentialSecretUpdatesHooks(t *testing.T) {
+	gitea := newFakeGiteaServer("access")
+	defer gitea.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	// Both webhooks share the hook on the repository
+	for _, namespace := range []string{"foo", "bar"} {
+		hook := webhook{
+			Name:             "name1",
+			Namespace:        namespace,
+			GitRepositoryURL: gitea.repoURL("owner", "repo"),
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline1",
+			GitProvider:      "gitea",
+		}
+		createTriggerResources(hook, r)
+		if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+			t.Fatalf("Gitea webhook creation failed with status %d", resp.StatusCode())
+		}
+	}
+
+	rotate := func(name string) *httptest.ResponseRecorder {
+		httpReq := dummyHTTPRequest("POST", "http://wwww.dummy.com:8080/webhooks/credentials/"+name+"/rotate-secret", nil)
+		httpWriter := httptest.NewRecorder()
+		r.rotateCredentialSecret(dummyRestfulRequest(httpReq, name), dummyRestfulResponse(httpWriter))
+		return httpWriter
+	}
+	resp := rotate("token1")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected 200 rotating the secret token but got %d: %s", resp.Code, resp.Body.String())
+	}
+	rotated := []rotatedHook{}
+	if err := json.NewDecoder(resp.Body).Decode(&rotated); err != nil {
+		t.Fatalf("Error decoding the rotated hooks: %s", err)
+	}
+	expected := []rotatedHook{{EventListener: eventListenerName, Repository: gitea.repoURL("owner", "repo")}}
+	if !reflect.DeepEqual(rotated, expected) {
+		t.Errorf("Expected the rotated hooks %+v, got %+v", expected, rotated)
+	}
+
+	secretToken := r.getK8sCredential("token1").SecretToken
+	if secretToken == "secret" || len(secretToken) != 20 {
+		t.Errorf("Expected a new secret token, got %s", secretToken)
+	}
+	hooks := gitea.getHooks("owner", "repo")
+	if len(hooks) != 1 || hooks[0].Config.Secret != secretToken || hooks[0].Config.URL != r.Defaults.CallbackURL {
+		t.Errorf("Expected the Gitea hook to be signed with the new secret token %s; got: %+v", secretToken, hooks)
+	}
+
+	// A hook that is no longer registered can't be updated, so the rotation is rolled back: the hooks already
+	// updated are signed with the previous secret token again, which is kept
+	other := webhook{
+		Name:             "name2",
+		Namespace:        "foo",
+		GitRepositoryURL: gitea.repoURL("owner", "other"),
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+		GitProvider:      "gitea",
+	}
+	if resp := createWebhook(other, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Gitea webhook creation failed with status %d", resp.StatusCode())
+	}
+	gitea.mutex.Lock()
+	delete(gitea.hooks, "owner/other")
+	gitea.mutex.Unlock()
+	resp = rotate("token1")
+	if resp.Code != http.StatusInternalServerError || !strings.Contains(resp.Body.String(), "no Gitea hook") {
+		t.Errorf("Expected 500 with the hook error rotating the secret token of an unregistered hook but got %d: %s", resp.Code, resp.Body.String())
+	}
+	rotated = []rotatedHook{}
+	if err := json.NewDecoder(resp.Body).Decode(&rotated); err != nil {
+		t.Fatalf("Error decoding the rotated hooks: %s", err)
+	}
+	for _, hook := range rotated {
+		if hook.Repository == gitea.repoURL("owner", "repo") && !hook.RolledBack {
+			t.Errorf("Expected the updated hook to be rolled back, got %+v", hook)
+		}
+	}
+	if r.getK8sCredential("token1").SecretToken != secretToken {
+		t.Error("Expected the secret token to be kept when a hook could not be updated")
+	}
+	hooks = gitea.getHooks("owner", "repo")
+	if len(hooks) != 1 || hooks[0].Config.Secret != secretToken {
+		t.Errorf("Expected the Gitea hook to be signed with the previous secret token %s; got: %+v", secretToken, hooks)
+	}
+
+	if resp := rotate("missing"); resp.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 rotating the secret token of a missing credential but got %d", resp.Code)
+	}
+}
+
+func Test_isGiteaHookRegistered(t *testing.T) {
+	gitea := newFakeGiteaServer("myAccessToken")
+	defer gitea.Close()
+	client := createOAuth2Client(context.Background(), "myAccessToken")
+	repoURL := gitea.repoURL("owner", "repo")
+
+	// The hook for the callback is listed on the second page of hooks
+	gitea.mutex.Lock()
+	for i := 0; i < 60; i++ {
+		gitea.nextID++
+		gitea.hooks["owner/repo"] = append(gitea.hooks["owner/repo"], giteaHook{ID: gitea.nextID, Type: "gitea", Config: giteaHookConfig{URL: fmt.Sprintf("https://othercallback%d.com", i)}})
+	}
+	gitea.mutex.Unlock()
+	if err := doGiteaHookRequest(client, "gitea", repoURL, "subscribe", "https://examplecallback.com", "mySecret", []string{"push"}); err != nil {
+		t.Fatalf("doGiteaHookRequest() returned an error subscribing: %s", err)
+	}
+	for callback, want := range map[string]bool{"https://examplecallback.com": true, "https://missingcallback.com": false} {
+		got, err := isGiteaHookRegistered(client, repoURL, callback)
+		if err != nil {
+			t.Errorf("isGiteaHookRegistered() returned an error: %s", err)
+		}
+		if got != want {
+			t.Errorf("isGiteaHookRegistered() for %s = %v, want %v", callback, got, want)
+		}
+	}
+
+	if err := doGiteaHookRequest(client, "gitea", repoURL, "unsubscribe", "https://examplecallback.com", "mySecret", []string{"push"}); err != nil {
+		t.Fatalf("doGiteaHookRequest() returned an error unsubscribing: %s", err)
+	}
+	if hooks := gitea.getHooks("owner", "repo"); len(hooks) != 60 {
+		t.Errorf("doGiteaHookRequest() expected only the hook on the second page to be removed; got %d hooks", len(hooks))
+	}
+}
@@ -14,9 +14,12 @@ limitations under the License.
 package endpoints
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	"golang.org/x/xerrors"
@@ -27,49 +30,237 @@ func isGitHubEnterprise(u *url.URL) bool {
 	return (u.Host != "github.com")
 }
 
-// getGitHubHubbubAPI returns the API URL for the GitHub PubSubHubbub API
-func getGitHubHubbubAPI(u *url.URL) string {
-	// Public GitHub PubSubHubbub API URL is "https://api.github.com/hub"
-	hubbubAPI := "https://api.github.com/hub"
-
-	// Enterprise GitHub API URL is "https://my.company.xyz/api/v3/hub"
+// getGitHubAPI returns the root of the GitHub API for the repository URL
+func getGitHubAPI(u *url.URL) string {
+	// Public GitHub API URL is "https://api.github.com"
+	// Enterprise GitHub API URL is "https://my.company.xyz/api/v3"
 	if isGitHubEnterprise(u) {
-		hubbubAPI = fmt.Sprintf("%s://%s/api/v3/hub", u.Scheme, u.Host)
+		return fmt.Sprintf("%s://%s/api/v3", u.Scheme, u.Host)
+	}
+	return "https://api.github.com"
+}
+
+// gitHubHook is the subset of a GitHub repository hook used by the extension
+type gitHubHook struct {
+	ID     int64            `json:"id,omitempty"`
+	Name   string           `json:"name"`
+	Active bool             `json:"active"`
+	Events []string         `json:"events"`
+	Config gitHubHookConfig `json:"config"`
+}
+
+type gitHubHookConfig struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Secret      string `json:"secret,omitempty"`
+}
+
+// getGitHubHooksAPI returns the API URL for the hooks of the GitHub repository
+func getGitHubHooksAPI(u *url.URL) (string, error) {
+	pieces := strings.Split(strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"), "/")
+	if len(pieces) != 2 {
+		return "", xerrors.Errorf("GitHub repo URL %s should have the form https://<host>/<owner>/<repo>", u)
 	}
 
-	return hubbubAPI
+	// Public GitHub API URL is "https://api.github.com/repos/<owner>/<repo>/hooks"
+	// Enterprise GitHub API URL is "https://my.company.xyz/api/v3/repos/<owner>/<repo>/hooks"
+	return fmt.Sprintf("%s/repos/%s/%s/hooks", getGitHubAPI(u), pieces[0], pieces[1]), nil
 }
 
-// doGitHubHubbubRequest executes a GitHub PubSubHubbub request given the specified parameters
-// GitHub PubSubHubbub API documentation: https://developer.github.com/v3/repos/hooks/#pubsubhubbub
-// mode: "subscribe" or "unsubscribe"
+// doGitHubHookRequest creates, removes or updates a GitHub repository hook given the specified parameters
+// GitHub Repository Hooks API documentation: https://developer.github.com/v3/repos/hooks/
+// mode: "subscribe", "unsubscribe" or "update", which replaces the secret and events of the existing hook
 // callback: the URI to receive the updates
 // secret: shared secret key to authenticate event messages
-// events: the list of events to subscribe to or unsubscribe from; for example, {"push", "pull_request"}
-func doGitHubHubbubRequest(client *http.Client, repoURL, mode, callback, secret string, events []string) error {
-	// Get GitHub PubSubHubbub API URL
+// hookID: the ID of the hook to remove or update, if 0 any hook for the callback is removed or updated
+// events: the list of events to subscribe to; for example, {"push", "pull_request"}
+// Returns the ID of the hook created when subscribing.
+func doGitHubHookRequest(client *http.Client, repoURL, mode, callback, secret string, hookID int64, events []string) (int64, error) {
 	u, err := url.Parse(repoURL)
 	if err != nil {
-		return xerrors.Errorf("error parsing GitHub repo URL %s. Error was: %w", repoURL, err)
+		return 0, xerrors.Errorf("error parsing GitHub repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getGitHubHooksAPI(u)
+	if err != nil {
+		return 0, err
 	}
-	hubbubAPI := getGitHubHubbubAPI(u)
-
-	// Send request for each event type (example event type: "push")
-	for _, event := range events {
-		resp, err := client.PostForm(hubbubAPI, url.Values{
-			"hub.mode":     {mode},
-			"hub.topic":    {fmt.Sprintf("%s/events/%s", repoURL, event)},
-			"hub.callback": {callback},
-			"hub.secret":   {secret},
+
+	switch mode {
+	case "subscribe":
+		hook := gitHubHook{
+			Name:   "web",
+			Active: true,
+			Events: events,
+			Config: gitHubHookConfig{
+				URL:         callback,
+				ContentType: "json",
+				Secret:      secret,
+			},
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return 0, xerrors.Errorf("error marshalling GitHub hook: %w", err)
+		}
+		resp, err := client.Post(hooksAPI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return 0, xerrors.Errorf("error sending GitHub hook %s request: %w", mode, err)
+		}
+		defer resp.Body.Close()
+		// Should receive 201 Created on success
+		if resp.StatusCode != http.StatusCreated {
+			return 0, xerrors.Errorf("error sending GitHub hook %s request. Status: %s", mode, resp.Status)
+		}
+		created := gitHubHook{}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+			return 0, xerrors.Errorf("error decoding GitHub hook: %w", err)
+		}
+		logging.Log.Debugf("GitHub hook %s response: %s, hook ID: %d", mode, resp.Status, created.ID)
+		return created.ID, nil
+	case "unsubscribe":
+		hookIDs, err := getGitHubHookIDs(client, hooksAPI, callback, hookID)
+		if err != nil {
+			return 0, err
+		}
+		for _, id := range hookIDs {
+			hookURL := fmt.Sprintf("%s/%d", hooksAPI, id)
+			req, err := http.NewRequest(http.MethodDelete, hookURL, nil)
+			if err != nil {
+				return 0, xerrors.Errorf("error creating GitHub hook %s request: %w", mode, err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return 0, xerrors.Errorf("error sending GitHub hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 204 No Content on success, 404 Not Found means the hook is already gone
+			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+				return 0, xerrors.Errorf("error sending GitHub hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("GitHub hook %s (%s) response: %s", mode, hookURL, resp.Status)
+		}
+		return hookID, nil
+	case "update":
+		hookIDs, err := getGitHubHookIDs(client, hooksAPI, callback, hookID)
+		if err != nil {
+			return 0, err
+		}
+		if len(hookIDs) == 0 {
+			return 0, xerrors.Errorf("no GitHub hook for %s is registered on repository %s", callback, repoURL)
+		}
+		// The config of a hook is replaced as a whole
+		body, err := json.Marshal(gitHubHook{
+			Name:   "web",
+			Active: true,
+			Events: events,
+			Config: gitHubHookConfig{URL: callback, ContentType: "json", Secret: secret},
 		})
 		if err != nil {
-			return xerrors.Errorf("error sending PubSubHubbub %s (%s) request: %w", mode, event, err)
+			return 0, xerrors.Errorf("error marshalling GitHub hook: %w", err)
 		}
-		// Should receive 204 No Content on success
-		if resp.StatusCode != http.StatusNoContent {
-			return xerrors.Errorf("error sending PubSubHubbub %s (%s) request. Status: %s", mode, event, resp.Status)
+		for _, id := range hookIDs {
+			hookURL := fmt.Sprintf("%s/%d", hooksAPI, id)
+			req, err := http.NewRequest(http.MethodPatch, hookURL, bytes.NewReader(body))
+			if err != nil {
+				return 0, xerrors.Errorf("error creating GitHub hook %s request: %w", mode, err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return 0, xerrors.Errorf("error sending GitHub hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 200 OK on success
+			if resp.StatusCode != http.StatusOK {
+				return 0, xerrors.Errorf("error sending GitHub hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("GitHub hook %s (%s) response: %s", mode, hookURL, resp.Status)
 		}
-		logging.Log.Debugf("PubSubHubbub %s (%s) response: %s", mode, event, resp)
+		return hookID, nil
+	default:
+		return 0, xerrors.Errorf("unknown GitHub hook mode %s", mode)
+	}
+}
+
+// getGitHubHookIDs returns the hook ID if known, or else the IDs of the hooks for the callback, as webhooks
+// registered before hook IDs were recorded are found by their callback
+func getGitHubHookIDs(client *http.Client, hooksAPI, callback string, hookID int64) ([]int64, error) {
+	if hookID != 0 {
+		return []int64{hookID}, nil
+	}
+	hooks, err := listGitHubHooks(client, hooksAPI, callback, 0)
+	if err != nil {
+		return nil, err
+	}
+	hookIDs := []int64{}
+	for _, hook := range hooks {
+		hookIDs = append(hookIDs, hook.ID)
+	}
+	return hookIDs, nil
+}
+
+// listGitHubHooks returns the hooks for the callback registered on the GitHub repository, following the
+// pages of hooks until limit hooks are found or, if limit is 0, the pages run out
+func listGitHubHooks(client *http.Client, hooksAPI, callback string, limit int) ([]gitHubHook, error) {
+	hooks := []gitHubHook{}
+	for pageURL := hooksAPI + "?per_page=100"; pageURL != ""; {
+		resp, err := client.Get(pageURL)
+		if err != nil {
+			return nil, xerrors.Errorf("error listing GitHub hooks: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, xerrors.Errorf("error listing GitHub hooks. Status: %s", resp.Status)
+		}
+		pageHooks := []gitHubHook{}
+		err = json.NewDecoder(resp.Body).Decode(&pageHooks)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("error decoding GitHub hooks: %w", err)
+		}
+		for _, hook := range pageHooks {
+			if hook.Config.URL == callback {
+				hooks = append(hooks, hook)
+			}
+		}
+		if limit > 0 && len(hooks) >= limit {
+			break
+		}
+		pageURL = getNextPageLink(resp.Header)
+	}
+	return hooks, nil
+}
+
+// isGitHubHookRegistered returns whether the hook for the callback is registered on the GitHub repository,
+// looking the hook up by its ID or, if the ID is 0, by its callback
+func isGitHubHookRegistered(client *http.Client, repoURL, callback string, hookID int64) (bool, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false, xerrors.Errorf("error parsing GitHub repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI, err := getGitHubHooksAPI(u)
+	if err != nil {
+		return false, err
+	}
+
+	if hookID == 0 {
+		hooks, err := listGitHubHooks(client, hooksAPI, callback, 1)
+		if err != nil {
+			return false, err
+		}
+		return len(hooks) > 0, nil
+	}
+
+	resp, err := client.Get(fmt.Sprintf("%s/%d", hooksAPI, hookID))
+	if err != nil {
+		return false, xerrors.Errorf("error getting GitHub hook %d: %w", hookID, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, xerrors.Errorf("error getting GitHub hook %d. Status: %s", hookID, resp.Status)
 	}
-	return nil
 }
@@ -14,12 +14,23 @@ limitations under the License.
 package endpoints
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
 
+	"github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	faketriggerclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	fakerestclient "k8s.io/client-go/rest/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func Test_isGitHubEnterprise(t *testing.T) {
@@ -70,228 +81,522 @@ func Test_isGitHubEnterprise(t *testing.T) {
 	}
 }
 
-func Test_getGitHubHubbubAPI(t *testing.T) {
-	type args struct {
-		rawurl string
-	}
+func Test_getGitHubHooksAPI(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want string
+		rawurl  string
+		want    string
+		wantErr bool
 	}{
 		{
-			args: args{
-				rawurl: "https://github.com/owner/repo",
-			},
-			want: "https://api.github.com/hub",
-		},
-		{
-			args: args{
-				rawurl: "https://github.com/owner/repo.git",
-			},
-			want: "https://api.github.com/hub",
+			rawurl: "https://github.com/owner/repo",
+			want:   "https://api.github.com/repos/owner/repo/hooks",
 		},
 		{
-			args: args{
-				rawurl: "https://github.company.com/owner/repo",
-			},
-			want: "https://github.company.com/api/v3/hub",
+			rawurl: "https://github.com/owner/repo.git",
+			want:   "https://api.github.com/repos/owner/repo/hooks",
 		},
 		{
-			args: args{
-				rawurl: "https://github.company.com/owner/repo.git",
-			},
-			want: "https://github.company.com/api/v3/hub",
+			rawurl: "https://github.company.com/owner/repo",
+			want:   "https://github.company.com/api/v3/repos/owner/repo/hooks",
 		},
 		{
-			args: args{
-				rawurl: "https://my.company.xyz/owner/repo",
-			},
-			want: "https://my.company.xyz/api/v3/hub",
+			rawurl: "https://my.company.xyz/owner/repo.git",
+			want:   "https://my.company.xyz/api/v3/repos/owner/repo/hooks",
 		},
 		{
-			args: args{
-				rawurl: "https://hostname/owner/repo",
-			},
-			want: "https://hostname/api/v3/hub",
+			rawurl: "http://hostname/owner/repo",
+			want:   "http://hostname/api/v3/repos/owner/repo/hooks",
 		},
 		{
-			args: args{
-				rawurl: "http://hostname/owner/repo",
-			},
-			want: "http://hostname/api/v3/hub",
+			rawurl:  "https://github.com/owner",
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			u, err := url.Parse(tt.args.rawurl)
+		t.Run(tt.rawurl, func(t *testing.T) {
+			u, err := url.Parse(tt.rawurl)
 			if err != nil {
-				t.Errorf("getGitHubHubbubAPI() error parsing rawurl %s: %s", tt.args.rawurl, err)
+				t.Errorf("getGitHubHooksAPI() error parsing rawurl %s: %s", tt.rawurl, err)
+			}
+			got, err := getGitHubHooksAPI(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getGitHubHooksAPI() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if got := getGitHubHubbubAPI(u); got != tt.want {
-				t.Errorf("getGitHubHubbubAPI() = %v, want %v", got, tt.want)
+			if got != tt.want {
+				t.Errorf("getGitHubHooksAPI() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_doGitHubHubbubRequest(t *testing.T) {
-	type hubParams = struct {
-		mode     string
-		callback string
-		secret   string
-		events   []string
-	}
+func Test_doGitHubHookRequest_subscribe(t *testing.T) {
 	tests := []struct {
 		name    string
 		repoURL string
-		params  hubParams
+		events  []string
 		wantAPI string
 	}{
-		{
-			name:    "subscribe to no events",
-			repoURL: "https://github.com/owner/repo",
-			params: hubParams{
-				mode:     "subscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{},
-			},
-			wantAPI: "https://api.github.com/hub",
-		},
-		{
-			name:    "subscribe to one event",
-			repoURL: "https://github.com/owner/repo",
-			params: hubParams{
-				mode:     "subscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{"push"},
-			},
-			wantAPI: "https://api.github.com/hub",
-		},
 		{
 			name:    "subscribe public push and pull_request",
 			repoURL: "https://github.com/owner/repo",
-			params: hubParams{
-				mode:     "subscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{"push", "pull_request"},
-			},
-			wantAPI: "https://api.github.com/hub",
-		},
-		{
-			name:    "subscribe ghe push and pull_request",
-			repoURL: "https://my.company.com/owner/repo",
-			params: hubParams{
-				mode:     "subscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{"push", "pull_request"},
-			},
-			wantAPI: "https://my.company.com/api/v3/hub",
+			events:  []string{"push", "pull_request"},
+			wantAPI: "https://api.github.com/repos/owner/repo/hooks",
 		},
 		{
-			name:    "unsubscribe ghe push and pull_request",
+			name:    "subscribe ghe push",
 			repoURL: "https://my.company.com/owner/repo",
-			params: hubParams{
-				mode:     "unsubscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{"push", "pull_request"},
-			},
-			wantAPI: "https://my.company.com/api/v3/hub",
-		},
-		{
-			name:    "unsubscribe ghe push and pull_request",
-			repoURL: "https://my.company.com/owner/repo",
-			params: hubParams{
-				mode:     "unsubscribe",
-				callback: "https://examplecallback.com",
-				secret:   "mySecret",
-				events:   []string{"push", "pull_request"},
-			},
-			wantAPI: "https://my.company.com/api/v3/hub",
+			events:  []string{"push"},
+			wantAPI: "https://my.company.com/api/v3/repos/owner/repo/hooks",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create client
 			fakeGitHubClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
-				// Check API URL
-				if gotAPI := request.URL.String(); gotAPI != tt.wantAPI {
-					t.Errorf("doGitHubHubbubRequest() expected API URL %s; got: %s", tt.wantAPI, gotAPI)
+				if request.Method != http.MethodPost {
+					t.Errorf("doGitHubHookRequest() expected method POST; got: %s", request.Method)
 				}
-				// Check mode
-				if gotMode := request.FormValue("hub.mode"); gotMode != tt.params.mode {
-					t.Errorf("doGitHubHubbubRequest() expected hub.mode %s; got: %s", tt.params.mode, gotMode)
-				}
-				// Check callback
-				if gotCallback := request.FormValue("hub.callback"); gotCallback != tt.params.callback {
-					t.Errorf("doGitHubHubbubRequest() expected hub.callback %s; got: %s", tt.params.callback, gotCallback)
+				if gotAPI := request.URL.String(); gotAPI != tt.wantAPI {
+					t.Errorf("doGitHubHookRequest() expected API URL %s; got: %s", tt.wantAPI, gotAPI)
 				}
-				// Check secret
-				if gotSecret := request.FormValue("hub.secret"); gotSecret != tt.params.secret {
-					t.Errorf("doGitHubHubbubRequest() expected hub.secret %s; got: %s", tt.params.secret, gotSecret)
+				hook := gitHubHook{}
+				if err := json.NewDecoder(request.Body).Decode(&hook); err != nil {
+					t.Errorf("doGitHubHookRequest() error decoding request body: %s", err)
 				}
-				// Check topic (event & repoURL)
-				gotTopic := request.FormValue("hub.topic")
-				correctTopic := false
-				for _, event := range tt.params.events {
-					wantTopic := fmt.Sprintf("%s/events/%s", tt.repoURL, event)
-					if gotTopic == wantTopic {
-						correctTopic = true
-					}
+				want := gitHubHook{
+					Name:   "web",
+					Active: true,
+					Events: tt.events,
+					Config: gitHubHookConfig{URL: "https://examplecallback.com", ContentType: "json", Secret: "mySecret"},
 				}
-				if !correctTopic {
-					t.Errorf("doGitHubHubbubRequest() expected topic with format %s/events/<event>; got: %s", tt.repoURL, gotTopic)
+				if !reflect.DeepEqual(hook, want) {
+					t.Errorf("doGitHubHookRequest() expected hook %+v; got: %+v", want, hook)
 				}
 				return &http.Response{
-					StatusCode: http.StatusNoContent,
+					StatusCode: http.StatusCreated,
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"id": 1234}`)),
 				}, nil
 			})
-			err := doGitHubHubbubRequest(fakeGitHubClient, tt.repoURL, tt.params.mode, tt.params.callback, tt.params.secret, tt.params.events)
+			hookID, err := doGitHubHookRequest(fakeGitHubClient, tt.repoURL, "subscribe", "https://examplecallback.com", "mySecret", 0, tt.events)
+			if err != nil {
+				t.Errorf("doGitHubHookRequest() returned an error: %s", err)
+			}
+			if hookID != 1234 {
+				t.Errorf("doGitHubHookRequest() expected hook ID 1234; got: %d", hookID)
+			}
+		})
+	}
+}
+
+func Test_doGitHubHookRequest_unsubscribe(t *testing.T) {
+	tests := []struct {
+		name        string
+		hookID      int64
+		wantDeleted []string
+	}{
+		{
+			name:        "unsubscribe by hook ID",
+			hookID:      1234,
+			wantDeleted: []string{"https://api.github.com/repos/owner/repo/hooks/1234"},
+		},
+		{
+			name:        "unsubscribe by callback without hook ID",
+			hookID:      0,
+			wantDeleted: []string{"https://api.github.com/repos/owner/repo/hooks/2", "https://api.github.com/repos/owner/repo/hooks/3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := []string{}
+			fakeGitHubClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+				switch request.Method {
+				case http.MethodGet:
+					if tt.hookID != 0 {
+						t.Errorf("doGitHubHookRequest() listed hooks when the hook ID was known")
+					}
+					hooks := []gitHubHook{
+						{ID: 1, Config: gitHubHookConfig{URL: "https://othercallback.com"}},
+						{ID: 2, Config: gitHubHookConfig{URL: "https://examplecallback.com"}},
+						{ID: 3, Config: gitHubHookConfig{URL: "https://examplecallback.com"}},
+					}
+					body, _ := json.Marshal(hooks)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+					}, nil
+				case http.MethodDelete:
+					deleted = append(deleted, request.URL.String())
+					return &http.Response{
+						StatusCode: http.StatusNoContent,
+						Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+					}, nil
+				}
+				t.Errorf("doGitHubHookRequest() unexpected method %s", request.Method)
+				return nil, nil
+			})
+			_, err := doGitHubHookRequest(fakeGitHubClient, "https://github.com/owner/repo", "unsubscribe", "https://examplecallback.com", "mySecret", tt.hookID, []string{"push", "pull_request"})
 			if err != nil {
-				t.Errorf("doGitHubHubbubRequest() returned an error: %s", err)
+				t.Errorf("doGitHubHookRequest() returned an error: %s", err)
+			}
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("doGitHubHookRequest() expected %v to be deleted; got: %v", tt.wantDeleted, deleted)
 			}
 		})
 	}
 }
 
-func Test_doGitHubHubbubRequest_error(t *testing.T) {
-	// doGitHubHubbubRequest should return an error when the status of the response is not 204
-	testStatusCode := func(t *testing.T, statusCode int) {
-		t.Run(fmt.Sprintf("statusCode: %d", statusCode), func(t *testing.T) {
+func Test_doGitHubHookRequest_error(t *testing.T) {
+	// doGitHubHookRequest should return an error when the status of the response is unexpected
+	testStatusCode := func(t *testing.T, mode string, statusCode int) {
+		t.Run(fmt.Sprintf("%s statusCode: %d", mode, statusCode), func(t *testing.T) {
 			fakeGitHubClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
 				return &http.Response{
 					StatusCode: statusCode,
 					Status:     http.StatusText(statusCode),
+					Body:       ioutil.NopCloser(bytes.NewBufferString(`{"id": 1234}`)),
 				}, nil
 			})
 			repoURL := "https://my.company.com/owner/repo"
-			mode := "unsubscribe"
 			callback := "https://examplecallback.com"
 			secret := "mySecret"
 			events := []string{"push", "pull_request"}
-			err := doGitHubHubbubRequest(fakeGitHubClient, repoURL, mode, callback, secret, events)
+			_, err := doGitHubHookRequest(fakeGitHubClient, repoURL, mode, callback, secret, 1234, events)
 			if err == nil {
-				t.Errorf("doGitHubHubbubRequest() did not return an error when expected for statusCode %d: %s", statusCode, err)
+				t.Errorf("doGitHubHookRequest() did not return an error when expected for %s statusCode %d", mode, statusCode)
 			}
 		})
 	}
 	for i := 200; i < 209; i++ {
+		if i != 201 {
+			testStatusCode(t, "subscribe", i)
+		}
 		if i != 204 {
-			testStatusCode(t, i)
+			testStatusCode(t, "unsubscribe", i)
+		}
+		if i != 200 {
+			testStatusCode(t, "update", i)
 		}
 	}
 	for i := 300; i < 309; i++ {
-		testStatusCode(t, i)
+		testStatusCode(t, "subscribe", i)
+		testStatusCode(t, "unsubscribe", i)
+		testStatusCode(t, "update", i)
+	}
+}
+
+func Test_doGitHubHookRequest_update(t *testing.T) {
+	tests := []struct {
+		name        string
+		hookID      int64
+		wantUpdated []string
+	}{
+		{
+			name:        "update by hook ID",
+			hookID:      1234,
+			wantUpdated: []string{"https://api.github.com/repos/owner/repo/hooks/1234"},
+		},
+		{
+			name:        "update by callback without hook ID",
+			hookID:      0,
+			wantUpdated: []string{"https://api.github.com/repos/owner/repo/hooks/2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := []string{}
+			fakeGitHubClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+				switch request.Method {
+				case http.MethodGet:
+					body, _ := json.Marshal([]gitHubHook{
+						{ID: 1, Config: gitHubHookConfig{URL: "https://othercallback.com"}},
+						{ID: 2, Config: gitHubHookConfig{URL: "https://examplecallback.com"}},
+					})
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+					}, nil
+				case http.MethodPatch:
+					hook := gitHubHook{}
+					if err := json.NewDecoder(request.Body).Decode(&hook); err != nil {
+						t.Errorf("doGitHubHookRequest() sent an invalid hook: %s", err)
+					}
+					if hook.Config.URL != "https://examplecallback.com" || hook.Config.Secret != "newSecret" || hook.Config.ContentType != "json" {
+						t.Errorf("doGitHubHookRequest() expected the config to be replaced with the new secret; got: %+v", hook.Config)
+					}
+					updated = append(updated, request.URL.String())
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+					}, nil
+				}
+				t.Errorf("doGitHubHookRequest() unexpected method %s", request.Method)
+				return nil, nil
+			})
+			_, err := doGitHubHookRequest(fakeGitHubClient, "https://github.com/owner/repo", "update", "https://examplecallback.com", "newSecret", tt.hookID, []string{"push", "pull_request"})
+			if err != nil {
+				t.Errorf("doGitHubHookRequest() returned an error: %s", err)
+			}
+			if !reflect.DeepEqual(updated, tt.wantUpdated) {
+				t.Errorf("doGitHubHookRequest() expected %v to be updated; got: %v", tt.wantUpdated, updated)
+			}
+		})
+	}
+
+	// A hook that is not registered can't be updated
+	_, err := doGitHubHookRequest(fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewBufferString("[]"))}, nil
+	}), "https://github.com/owner/repo", "update", "https://examplecallback.com", "newSecret", 0, []string{"push"})
+	if err == nil {
+		t.Error("doGitHubHookRequest() did not return an error updating a hook that is not registered")
+	}
+}
+
+func Test_isGitHubHookRegistered(t *testing.T) {
+	fakeGitHubClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		var status int
+		var body []byte
+		header := http.Header{}
+		// The hooks are listed 100 at a time, the hook for the callback is on the second page
+		switch request.URL.Path {
+		case "/repos/owner/repo/hooks":
+			status = http.StatusOK
+			header.Set("Link", `<https://api.github.com/repositories/1/hooks?per_page=100&page=2>; rel="next", <https://api.github.com/repositories/1/hooks?per_page=100&page=2>; rel="last"`)
+			body, _ = json.Marshal([]gitHubHook{{ID: 1, Config: gitHubHookConfig{URL: "https://othercallback.com"}}})
+		case "/repositories/1/hooks":
+			status = http.StatusOK
+			body, _ = json.Marshal([]gitHubHook{{ID: 2, Config: gitHubHookConfig{URL: "https://examplecallback.com"}}})
+		case "/repos/owner/repo/hooks/2":
+			status = http.StatusOK
+			body, _ = json.Marshal(gitHubHook{ID: 2, Config: gitHubHookConfig{URL: "https://examplecallback.com"}})
+		case "/repos/owner/repo/hooks/3":
+			status = http.StatusNotFound
+		default:
+			status = http.StatusInternalServerError
+		}
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+		}, nil
+	})
+	tests := []struct {
+		name     string
+		repoURL  string
+		callback string
+		hookID   int64
+		want     bool
+		wantErr  bool
+	}{
+		{name: "registered hook ID", repoURL: "https://github.com/owner/repo", callback: "https://examplecallback.com", hookID: 2, want: true},
+		{name: "removed hook ID", repoURL: "https://github.com/owner/repo", callback: "https://examplecallback.com", hookID: 3, want: false},
+		{name: "registered callback", repoURL: "https://github.com/owner/repo", callback: "https://examplecallback.com", want: true},
+		{name: "unregistered callback", repoURL: "https://github.com/owner/repo", callback: "https://nocallback.com", want: false},
+		{name: "error", repoURL: "https://github.com/owner/other", callback: "https://examplecallback.com", hookID: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isGitHubHookRegistered(fakeGitHubClient, tt.repoURL, tt.callback, tt.hookID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("isGitHubHookRegistered() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isGitHubHookRegistered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetGitHubWebhookStatus(t *testing.T) {
+	// A GitHub Enterprise stand-in serving hook 1234 of owner/repo until it is removed
+	registered := true
+	gitHub := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == http.MethodPost && request.URL.Path == "/api/v3/repos/owner/repo/hooks":
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(`{"id": 1234}`))
+		case request.Method == http.MethodGet && request.URL.Path == "/api/v3/repos/owner/repo/hooks/1234" && registered:
+			writer.Write([]byte(`{"id": 1234}`))
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gitHub.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: gitHub.URL + "/owner/repo",
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+	}
+	createTriggerResources(hook, r)
+	createTriggerResources(webhook{Pipeline: "monitor-task"}, r)
+	r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Create(&v1alpha1.TriggerBinding{ObjectMeta: metav1.ObjectMeta{Name: "monitor-task-binding"}})
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("GitHub webhook creation failed with status %d", resp.StatusCode())
+	}
+
+	getStatus := func() webhookStatus {
+		httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/name1?namespace=foo&repository="+url.QueryEscape(hook.GitRepositoryURL), nil)
+		req := dummyRestfulRequest(httpReq, "name1")
+		httpWriter := httptest.NewRecorder()
+		resp := dummyRestfulResponse(httpWriter)
+		r.getWebhook(req, resp)
+		if resp.StatusCode() != http.StatusOK {
+			t.Fatalf("Get webhook failed with status %d", resp.StatusCode())
+		}
+		got := webhookWithStatus{}
+		if err := json.NewDecoder(httpWriter.Body).Decode(&got); err != nil {
+			t.Fatalf("Error decoding result into webhookWithStatus{}: %s", err)
+		}
+		if got.Name != "name1" || got.Pipeline != "pipeline1" || got.HookID != 1234 {
+			t.Errorf("Get webhook returned unexpected webhook %+v", got.webhook)
+		}
+		return got.Status
+	}
+
+	status := getStatus()
+	if !status.TriggersFound || !status.TriggerResourcesFound || status.HookRegistered == nil || !*status.HookRegistered || len(status.Problems) != 0 {
+		t.Errorf("Expected a healthy webhook; got status: %+v", status)
+	}
+
+	registered = false
+	r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Delete("pipeline1-template", &metav1.DeleteOptions{})
+	status = getStatus()
+	if !status.TriggersFound || status.TriggerResourcesFound || status.HookRegistered == nil || *status.HookRegistered || len(status.Problems) != 2 {
+		t.Errorf("Expected a missing trigger template and hook to be reported; got status: %+v", status)
+	}
+}
+
+func TestCreateAndDeleteGitHubWebhookRecordsHookID(t *testing.T) {
+	// A GitHub Enterprise stand-in serving the hooks of owner/repo
+	deleted := []string{}
+	gitHub := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == http.MethodPost && request.URL.Path == "/api/v3/repos/owner/repo/hooks":
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(`{"id": 1234}`))
+		case request.Method == http.MethodDelete:
+			deleted = append(deleted, request.URL.Path)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected GitHub request %s %s", request.Method, request.URL)
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gitHub.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hooks := []webhook{
+		{
+			Name:             "name1",
+			Namespace:        "foo",
+			GitRepositoryURL: gitHub.URL + "/owner/repo",
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline1",
+		},
+		{
+			Name:             "name2",
+			Namespace:        "foo",
+			GitRepositoryURL: gitHub.URL + "/owner/repo",
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline2",
+		},
+	}
+	for _, hook := range hooks {
+		createTriggerResources(hook, r)
+		if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+			t.Fatalf("GitHub webhook %s creation failed with status %d", hook.Name, resp.StatusCode())
+		}
+	}
+
+	// Both webhooks share the hook created for the first
+	created, err := r.getHooksForRepo(gitHub.URL + "/owner/repo")
+	if err != nil || len(created) != 2 {
+		t.Fatalf("Expected 2 webhooks for the repository; got: %+v, error: %v", created, err)
+	}
+	for _, hook := range created {
+		if hook.HookID != 1234 {
+			t.Errorf("Expected webhook %s to have hook ID 1234; got: %d", hook.Name, hook.HookID)
+		}
+	}
+
+	for _, hook := range hooks {
+		httpReq := dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/"+hook.Name+"?namespace=foo&repository="+url.QueryEscape(hook.GitRepositoryURL), nil)
+		req := dummyRestfulRequest(httpReq, hook.Name)
+		deleteResp := dummyRestfulResponse(httptest.NewRecorder())
+		r.deleteWebhook(req, deleteResp)
+		if deleteResp.StatusCode() != http.StatusNoContent {
+			t.Fatalf("GitHub webhook %s deletion failed with status %d", hook.Name, deleteResp.StatusCode())
+		}
+	}
+	if !reflect.DeepEqual(deleted, []string{"/api/v3/repos/owner/repo/hooks/1234"}) {
+		t.Errorf("Expected only the hook with ID 1234 to be deleted; got: %v", deleted)
+	}
+}
+
+func TestCreateGitHubWebhookFailsRecordingHookID(t *testing.T) {
+	deleted := []string{}
+	gitHub := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == http.MethodPost && request.URL.Path == "/api/v3/repos/owner/repo/hooks":
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(`{"id": 1234}`))
+		case request.Method == http.MethodGet && request.URL.Path == "/api/v3/repos/owner/repo/hooks/1234" && len(deleted) == 0:
+			writer.Write([]byte(`{"id": 1234}`))
+		case request.Method == http.MethodDelete:
+			deleted = append(deleted, request.URL.Path)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected GitHub request %s %s", request.Method, request.URL)
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer gitHub.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: gitHub.URL + "/owner/repo",
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+	}
+	createTriggerResources(hook, r)
+
+	// The eventlistener can't be updated with the hook ID once the hook is registered
+	failed := false
+	r.TriggersClient.(*faketriggerclientset.Clientset).PrependReactor("update", "eventlisteners", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failed || len(deleted) > 0 {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, errors.New("eventlistener update failed")
+	})
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusInternalServerError {
+		t.Fatalf("Expected webhook creation to fail with status 500 when the hook ID can't be recorded, got %d", resp.StatusCode())
+	}
+	if !failed {
+		t.Fatal("Expected the hook ID to be recorded on the eventlistener")
 	}
-	for i := 400; i < 452; i++ {
-		testStatusCode(t, i)
+	// The creation is rolled back, removing the hook by its ID
+	if !reflect.DeepEqual(deleted, []string{"/api/v3/repos/owner/repo/hooks/1234"}) {
+		t.Errorf("Expected the hook with ID 1234 to be deleted; got: %v", deleted)
 	}
-	for i := 500; i < 512; i++ {
-		testStatusCode(t, i)
+	if hooks, err := r.getWebhooksFromEventListeners(); err != nil || len(hooks) != 0 {
+		t.Errorf("Expected no webhooks in the eventlisteners; got: %+v, %v", hooks, err)
 	}
 }
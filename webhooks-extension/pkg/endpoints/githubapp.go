@@ -0,0 +1,217 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"golang.org/x/xerrors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+/*--------------------------------------
+GitHub App credentials store the app ID, installation ID and private key of the app
+in the secret. Installation tokens are minted with the private key and stored as the
+'accessToken' of the secret, so they are used for hook registration and by the monitor
+task in the same way as a personal access token. Tokens are refreshed before they expire.
+---------------------------------------*/
+
+const (
+	gitHubAppIDKey             = "appId"
+	gitHubAppInstallationIDKey = "installationId"
+	gitHubAppPrivateKeyKey     = "privateKey"
+	// Recorded when a token is minted, the refresher only refreshes tokens in use
+	gitHubAppTokenExpiryKey = "accessTokenExpiry"
+	gitHubAppAPIURLKey      = "gitHubApiUrl"
+
+	// Installation tokens are valid for an hour, they are refreshed when they have less than this left
+	gitHubAppTokenRefreshMargin = 15 * time.Minute
+	gitHubAppTokenRefreshPeriod = 5 * time.Minute
+)
+
+// isGitHubAppSecret returns whether the secret holds GitHub App credentials
+func isGitHubAppSecret(secret *corev1.Secret) bool {
+	return secret.Data[gitHubAppIDKey] != nil
+}
+
+// parseGitHubAppPrivateKey parses the PEM encoded PKCS1 or PKCS8 RSA private key of a GitHub App
+func parseGitHubAppPrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, xerrors.New("GitHub App private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, xerrors.Errorf("error parsing GitHub App private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, xerrors.New("GitHub App private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// newGitHubAppJWT returns the RS256 signed JWT authenticating as the GitHub App
+// GitHub Apps authentication documentation: https://developer.github.com/apps/building-github-apps/authenticating-with-github-apps/
+func newGitHubAppJWT(appID int64, privateKey *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	// Issued in the past to allow for clock drift, GitHub allows at most 10 minutes until expiry
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", xerrors.Errorf("error signing GitHub App JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// mintGitHubInstallationToken creates an installation access token for the GitHub App installation
+func mintGitHubInstallationToken(client *http.Client, apiURL, jwt string, installationID int64) (token string, expiresAt time.Time, err error) {
+	tokensAPI := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiURL, installationID)
+	req, err := http.NewRequest(http.MethodPost, tokensAPI, nil)
+	if err != nil {
+		return "", time.Time{}, xerrors.Errorf("error creating GitHub App installation token request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github.machine-man-preview+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, xerrors.Errorf("error sending GitHub App installation token request: %w", err)
+	}
+	defer resp.Body.Close()
+	// Should receive 201 Created on success
+	if resp.StatusCode != http.StatusCreated {
+		return "", time.Time{}, xerrors.Errorf("error sending GitHub App installation token request. Status: %s", resp.Status)
+	}
+	result := struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, xerrors.Errorf("error decoding GitHub App installation token: %w", err)
+	}
+	return result.Token, result.ExpiresAt, nil
+}
+
+// getGitHubAppAccessToken returns the installation token of the GitHub App credential in the secret,
+// minting a new one and storing it in the secret if there is none for the API or it is about to expire.
+// accessToken is returned unchanged for secrets holding a personal access token.
+func (r Resource) getGitHubAppAccessToken(secretName, repoURL, accessToken string) (string, error) {
+	secret, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return "", xerrors.Errorf("error getting Webhook secret. Error was: %w", err)
+	}
+	if !isGitHubAppSecret(secret) {
+		return accessToken, nil
+	}
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", xerrors.Errorf("error parsing GitHub repo URL %s. Error was: %w", repoURL, err)
+	}
+	return r.refreshGitHubAppToken(secret, getGitHubAPI(u), time.Now())
+}
+
+// refreshGitHubAppToken returns the installation token stored in the secret for the API,
+// minting and storing a new one unless the stored token is valid beyond the refresh margin
+func (r Resource) refreshGitHubAppToken(secret *corev1.Secret, apiURL string, now time.Time) (string, error) {
+	expiry, err := time.Parse(time.RFC3339, string(secret.Data[gitHubAppTokenExpiryKey]))
+	if err == nil && string(secret.Data[gitHubAppAPIURLKey]) == apiURL && len(secret.Data["accessToken"]) > 0 &&
+		expiry.After(now.Add(gitHubAppTokenRefreshMargin)) {
+		return string(secret.Data["accessToken"]), nil
+	}
+
+	appID, err := strconv.ParseInt(string(secret.Data[gitHubAppIDKey]), 10, 64)
+	if err != nil {
+		return "", xerrors.Errorf("error parsing GitHub App ID of secret %s: %w", secret.Name, err)
+	}
+	installationID, err := strconv.ParseInt(string(secret.Data[gitHubAppInstallationIDKey]), 10, 64)
+	if err != nil {
+		return "", xerrors.Errorf("error parsing GitHub App installation ID of secret %s: %w", secret.Name, err)
+	}
+	privateKey, err := parseGitHubAppPrivateKey(secret.Data[gitHubAppPrivateKeyKey])
+	if err != nil {
+		return "", err
+	}
+	jwt, err := newGitHubAppJWT(appID, privateKey, now)
+	if err != nil {
+		return "", err
+	}
+	token, expiresAt, err := mintGitHubInstallationToken(http.DefaultClient, apiURL, jwt, installationID)
+	if err != nil {
+		return "", err
+	}
+
+	secret.Data["accessToken"] = []byte(token)
+	secret.Data[gitHubAppTokenExpiryKey] = []byte(expiresAt.Format(time.RFC3339))
+	secret.Data[gitHubAppAPIURLKey] = []byte(apiURL)
+	if _, err := r.K8sClient.CoreV1().Secrets(secret.Namespace).Update(secret); err != nil {
+		return "", xerrors.Errorf("error storing GitHub App installation token in secret %s: %w", secret.Name, err)
+	}
+	logging.Log.Debugf("Minted GitHub App installation token for secret %s, expires at %s", secret.Name, expiresAt)
+	return token, nil
+}
+
+// refreshGitHubAppTokens refreshes the installation tokens of all GitHub App credentials that have been used
+func (r Resource) refreshGitHubAppTokens() {
+	secrets, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		logging.Log.Errorf("error listing secrets to refresh GitHub App installation tokens: %s", err)
+		return
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		apiURL := string(secret.Data[gitHubAppAPIURLKey])
+		if !isGitHubAppSecret(secret) || apiURL == "" {
+			continue
+		}
+		if _, err := r.refreshGitHubAppToken(secret, apiURL, time.Now()); err != nil {
+			logging.Log.Errorf("error refreshing GitHub App installation token of secret %s: %s", secret.Name, err)
+		}
+	}
+}
+
+// RefreshGitHubAppTokens periodically refreshes the installation tokens of GitHub App credentials
+// until stopCh is closed, so that the tokens used by the monitor task do not expire
+func (r Resource) RefreshGitHubAppTokens(stopCh <-chan struct{}) {
+	wait.Until(r.refreshGitHubAppTokens, gitHubAppTokenRefreshPeriod, stopCh)
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newTestGitHubAppKey returns a new RSA private key and its PEM encoding
+func newTestGitHubAppKey(t *testing.T) (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %s", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return key, string(keyPEM)
+}
+
+// verifyTestGitHubAppJWT checks the signature of the JWT and returns its claims
+func verifyTestGitHubAppJWT(t *testing.T, jwt string, key *rsa.PrivateKey) map[string]int64 {
+	pieces := strings.Split(jwt, ".")
+	if len(pieces) != 3 {
+		t.Fatalf("JWT %s does not have three parts", jwt)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(pieces[2])
+	hashed := sha256.Sum256([]byte(pieces[0] + "." + pieces[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], signature); err != nil {
+		t.Errorf("JWT signature verification failed: %s", err)
+	}
+	claims := map[string]int64{}
+	payload, _ := base64.RawURLEncoding.DecodeString(pieces[1])
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Errorf("Error decoding JWT claims: %s", err)
+	}
+	return claims
+}
+
+// newFakeGitHubAppServer returns a GitHub Enterprise stand-in minting tokens for installation 42 of app 1234
+func newFakeGitHubAppServer(t *testing.T, key *rsa.PrivateKey, minted *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.URL.Path != "/api/v3/app/installations/42/access_tokens" {
+			t.Errorf("Unexpected GitHub request %s %s", request.Method, request.URL)
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		claims := verifyTestGitHubAppJWT(t, strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer "), key)
+		if claims["iss"] != 1234 {
+			t.Errorf("Expected JWT issued by app 1234; got: %d", claims["iss"])
+		}
+		*minted++
+		writer.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(writer, `{"token": "installation-token-%d", "expires_at": "%s"}`, *minted, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+}
+
+func newTestGitHubAppSecret(name, keyPEM string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: installNs,
+		},
+		Data: map[string][]byte{
+			gitHubAppIDKey:             []byte("1234"),
+			gitHubAppInstallationIDKey: []byte("42"),
+			gitHubAppPrivateKeyKey:     []byte(keyPEM),
+			"secretToken":              []byte("mySecret"),
+		},
+	}
+}
+
+func Test_newGitHubAppJWT(t *testing.T) {
+	key, _ := newTestGitHubAppKey(t)
+	now := time.Now()
+	jwt, err := newGitHubAppJWT(1234, key, now)
+	if err != nil {
+		t.Fatalf("newGitHubAppJWT() returned an error: %s", err)
+	}
+	claims := verifyTestGitHubAppJWT(t, jwt, key)
+	if claims["iss"] != 1234 {
+		t.Errorf("newGitHubAppJWT() expected iss 1234; got: %d", claims["iss"])
+	}
+	if claims["exp"]-now.Unix() > 10*60 || claims["iat"] > now.Unix() {
+		t.Errorf("newGitHubAppJWT() expected to be issued before now and expire within 10 minutes; got: %+v", claims)
+	}
+}
+
+func Test_parseGitHubAppPrivateKey(t *testing.T) {
+	key, keyPEM := newTestGitHubAppKey(t)
+	if _, err := parseGitHubAppPrivateKey([]byte(keyPEM)); err != nil {
+		t.Errorf("parseGitHubAppPrivateKey() returned an error for a PKCS1 key: %s", err)
+	}
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+	if _, err := parseGitHubAppPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})); err != nil {
+		t.Errorf("parseGitHubAppPrivateKey() returned an error for a PKCS8 key: %s", err)
+	}
+	if _, err := parseGitHubAppPrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("parseGitHubAppPrivateKey() did not return an error for an invalid key")
+	}
+}
+
+func Test_getGitHubAppAccessToken(t *testing.T) {
+	key, keyPEM := newTestGitHubAppKey(t)
+	minted := 0
+	gitHub := newFakeGitHubAppServer(t, key, &minted)
+	defer gitHub.Close()
+
+	r := dummyResource()
+	r.Defaults.Namespace = installNs
+	if _, err := r.K8sClient.CoreV1().Secrets(installNs).Create(newTestGitHubAppSecret("app", keyPEM)); err != nil {
+		t.Fatalf("Error creating secret: %s", err)
+	}
+
+	// Minted on first use and stored in the secret
+	token, err := r.getGitHubAppAccessToken("app", gitHub.URL+"/owner/repo", "")
+	if err != nil {
+		t.Fatalf("getGitHubAppAccessToken() returned an error: %s", err)
+	}
+	if token != "installation-token-1" {
+		t.Errorf("getGitHubAppAccessToken() expected installation-token-1; got: %s", token)
+	}
+	secret, _ := r.K8sClient.CoreV1().Secrets(installNs).Get("app", metav1.GetOptions{})
+	if string(secret.Data["accessToken"]) != "installation-token-1" || string(secret.Data[gitHubAppAPIURLKey]) != gitHub.URL+"/api/v3" {
+		t.Errorf("getGitHubAppAccessToken() expected the token and API URL to be stored in the secret; got: %+v", secret.Data)
+	}
+
+	// Reused while valid
+	if token, _ = r.getGitHubAppAccessToken("app", gitHub.URL+"/owner/repo", ""); token != "installation-token-1" || minted != 1 {
+		t.Errorf("getGitHubAppAccessToken() expected the stored token to be reused; got: %s after minting %d tokens", token, minted)
+	}
+
+	// Refreshed when about to expire
+	secret.Data[gitHubAppTokenExpiryKey] = []byte(time.Now().Add(gitHubAppTokenRefreshMargin / 2).UTC().Format(time.RFC3339))
+	r.K8sClient.CoreV1().Secrets(installNs).Update(secret)
+	r.refreshGitHubAppTokens()
+	secret, _ = r.K8sClient.CoreV1().Secrets(installNs).Get("app", metav1.GetOptions{})
+	if string(secret.Data["accessToken"]) != "installation-token-2" {
+		t.Errorf("refreshGitHubAppTokens() expected the token about to expire to be refreshed; got: %s", secret.Data["accessToken"])
+	}
+}
+
+func Test_getGitHubAppAccessToken_personalAccessToken(t *testing.T) {
+	r := dummyResource()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pat"},
+		Data:       map[string][]byte{"accessToken": []byte("myAccessToken")},
+	}
+	if _, err := r.K8sClient.CoreV1().Secrets(r.Defaults.Namespace).Create(secret); err != nil {
+		t.Fatalf("Error creating secret: %s", err)
+	}
+	token, err := r.getGitHubAppAccessToken("pat", "https://github.com/owner/repo", "myAccessToken")
+	if err != nil || token != "myAccessToken" {
+		t.Errorf("getGitHubAppAccessToken() expected the personal access token to be returned unchanged; got: %s, error: %v", token, err)
+	}
+}
+
+func TestCreateGitHubWebhookWithGitHubApp(t *testing.T) {
+	key, keyPEM := newTestGitHubAppKey(t)
+	minted := 0
+	tokenServer := newFakeGitHubAppServer(t, key, &minted)
+	// Hooks are registered with the installation token
+	gitHub := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/api/v3/repos/owner/repo/hooks" {
+			if auth := request.Header.Get("Authorization"); auth != "Bearer installation-token-1" {
+				t.Errorf("Expected the hook to be registered with the installation token; got: %s", auth)
+			}
+			writer.WriteHeader(http.StatusCreated)
+			writer.Write([]byte(`{"id": 1}`))
+			return
+		}
+		tokenServer.Config.Handler.ServeHTTP(writer, request)
+	}))
+	defer tokenServer.Close()
+	defer gitHub.Close()
+
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: gitHub.URL + "/owner/repo",
+		AccessTokenRef:   "app",
+		Pipeline:         "pipeline1",
+	}
+	createTriggerResources(hook, r)
+	// Replace the personal access token secret with a GitHub App
+	r.K8sClient.CoreV1().Secrets(installNs).Delete("app", &metav1.DeleteOptions{})
+	r.K8sClient.CoreV1().Secrets(installNs).Create(newTestGitHubAppSecret("app", keyPEM))
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("GitHub webhook creation failed with status %d", resp.StatusCode())
+	}
+	if minted != 1 {
+		t.Errorf("Expected a single installation token to be minted; got: %d", minted)
+	}
+}
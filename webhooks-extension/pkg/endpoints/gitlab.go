@@ -0,0 +1,215 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"golang.org/x/xerrors"
+)
+
+// gitLabHook is the subset of a GitLab project hook used by the extension
+type gitLabHook struct {
+	ID                    int    `json:"id,omitempty"`
+	URL                   string `json:"url"`
+	Token                 string `json:"token,omitempty"`
+	PushEvents            bool   `json:"push_events"`
+	TagPushEvents         bool   `json:"tag_push_events"`
+	MergeRequestsEvents   bool   `json:"merge_requests_events"`
+	NoteEvents            bool   `json:"note_events"`
+	ReleasesEvents        bool   `json:"releases_events"`
+	EnableSSLVerification bool   `json:"enable_ssl_verification"`
+}
+
+// getGitLabProjectHooksAPI returns the API URL for the hooks of the GitLab project
+// Both gitlab.com and self-hosted GitLab serve the API from "<scheme>://<host>/api/v4"
+func getGitLabProjectHooksAPI(u *url.URL) string {
+	// The project is identified by its URL encoded path, for example "owner%2Frepo"
+	project := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	return fmt.Sprintf("%s://%s/api/v4/projects/%s/hooks", u.Scheme, u.Host, url.PathEscape(project))
+}
+
+// newGitLabHook returns the GitLab project hook for the callback, signed with the secret and sending the events
+func newGitLabHook(callback, secret string, events []string) (gitLabHook, error) {
+	hook := gitLabHook{
+		URL:                   callback,
+		Token:                 secret,
+		EnableSSLVerification: strings.HasPrefix(callback, "https://"),
+	}
+	for _, event := range events {
+		switch event {
+		case "push":
+			hook.PushEvents = true
+			hook.TagPushEvents = true
+		case "pull_request":
+			hook.MergeRequestsEvents = true
+		case "issue_comment":
+			hook.NoteEvents = true
+		case "release":
+			hook.ReleasesEvents = true
+		default:
+			return hook, xerrors.Errorf("event %s is not supported for GitLab project hooks", event)
+		}
+	}
+	return hook, nil
+}
+
+// doGitLabHookRequest creates, removes or updates a GitLab project hook given the specified parameters
+// GitLab project hooks API documentation: https://docs.gitlab.com/ee/api/projects.html#hooks
+// mode: "subscribe", "unsubscribe" or "update", which replaces the secret and events of the existing hook
+// callback: the URI to receive the updates
+// secret: shared secret sent by GitLab in the X-Gitlab-Token header
+// events: the list of events to subscribe to; for example, {"push", "pull_request"}
+func doGitLabHookRequest(client *http.Client, repoURL, mode, callback, secret string, events []string) error {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return xerrors.Errorf("error parsing GitLab repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooksAPI := getGitLabProjectHooksAPI(u)
+
+	switch mode {
+	case "subscribe":
+		hook, err := newGitLabHook(callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling GitLab project hook: %w", err)
+		}
+		resp, err := client.Post(hooksAPI, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return xerrors.Errorf("error sending GitLab project hook %s request: %w", mode, err)
+		}
+		defer resp.Body.Close()
+		// Should receive 201 Created on success
+		if resp.StatusCode != http.StatusCreated {
+			return xerrors.Errorf("error sending GitLab project hook %s request. Status: %s", mode, resp.Status)
+		}
+		logging.Log.Debugf("GitLab project hook %s response: %s", mode, resp.Status)
+		return nil
+	case "unsubscribe":
+		hooks, err := listGitLabProjectHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/%d", hooksAPI, hook.ID), nil)
+			if err != nil {
+				return xerrors.Errorf("error creating GitLab project hook %s request: %w", mode, err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending GitLab project hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 204 No Content on success
+			if resp.StatusCode != http.StatusNoContent {
+				return xerrors.Errorf("error sending GitLab project hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("GitLab project hook %s (%d) response: %s", mode, hook.ID, resp.Status)
+		}
+		return nil
+	case "update":
+		hook, err := newGitLabHook(callback, secret, events)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(hook)
+		if err != nil {
+			return xerrors.Errorf("error marshalling GitLab project hook: %w", err)
+		}
+		hooks, err := listGitLabProjectHooks(client, hooksAPI, callback, 0)
+		if err != nil {
+			return err
+		}
+		updated := false
+		for _, existing := range hooks {
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%d", hooksAPI, existing.ID), bytes.NewReader(body))
+			if err != nil {
+				return xerrors.Errorf("error creating GitLab project hook %s request: %w", mode, err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := client.Do(req)
+			if err != nil {
+				return xerrors.Errorf("error sending GitLab project hook %s request: %w", mode, err)
+			}
+			resp.Body.Close()
+			// Should receive 200 OK on success
+			if resp.StatusCode != http.StatusOK {
+				return xerrors.Errorf("error sending GitLab project hook %s request. Status: %s", mode, resp.Status)
+			}
+			logging.Log.Debugf("GitLab project hook %s (%d) response: %s", mode, existing.ID, resp.Status)
+			updated = true
+		}
+		if !updated {
+			return xerrors.Errorf("no GitLab project hook for %s is registered on repository %s", callback, repoURL)
+		}
+		return nil
+	default:
+		return xerrors.Errorf("unknown GitLab project hook mode %s", mode)
+	}
+}
+
+// listGitLabProjectHooks returns the hooks for the callback registered on the GitLab project, following the
+// pages of hooks until limit hooks are found or, if limit is 0, the pages run out
+func listGitLabProjectHooks(client *http.Client, hooksAPI, callback string, limit int) ([]gitLabHook, error) {
+	hooks := []gitLabHook{}
+	for page := "1"; page != ""; {
+		resp, err := client.Get(fmt.Sprintf("%s?per_page=100&page=%s", hooksAPI, page))
+		if err != nil {
+			return nil, xerrors.Errorf("error listing GitLab project hooks: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, xerrors.Errorf("error listing GitLab project hooks. Status: %s", resp.Status)
+		}
+		pageHooks := []gitLabHook{}
+		err = json.NewDecoder(resp.Body).Decode(&pageHooks)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("error decoding GitLab project hooks: %w", err)
+		}
+		for _, hook := range pageHooks {
+			if hook.URL == callback {
+				hooks = append(hooks, hook)
+			}
+		}
+		if limit > 0 && len(hooks) >= limit {
+			break
+		}
+		// GitLab returns the number of the next page, which is empty on the last page
+		page = resp.Header.Get("X-Next-Page")
+	}
+	return hooks, nil
+}
+
+// isGitLabHookRegistered returns whether a hook with the callback is registered on the GitLab project
+func isGitLabHookRegistered(client *http.Client, repoURL, callback string) (bool, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false, xerrors.Errorf("error parsing GitLab repo URL %s. Error was: %w", repoURL, err)
+	}
+	hooks, err := listGitLabProjectHooks(client, getGitLabProjectHooksAPI(u), callback, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(hooks) > 0, nil
+}
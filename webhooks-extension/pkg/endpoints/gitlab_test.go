@@ -0,0 +1,222 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	fakerestclient "k8s.io/client-go/rest/fake"
+)
+
+func Test_getGitLabProjectHooksAPI(t *testing.T) {
+	tests := []struct {
+		rawurl string
+		want   string
+	}{
+		{
+			rawurl: "https://gitlab.com/owner/repo",
+			want:   "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks",
+		},
+		{
+			rawurl: "https://gitlab.com/owner/repo.git",
+			want:   "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks",
+		},
+		{
+			rawurl: "https://gitlab.company.com/group/subgroup/repo",
+			want:   "https://gitlab.company.com/api/v4/projects/group%2Fsubgroup%2Frepo/hooks",
+		},
+		{
+			rawurl: "http://hostname/owner/repo",
+			want:   "http://hostname/api/v4/projects/owner%2Frepo/hooks",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawurl, func(t *testing.T) {
+			u, err := url.Parse(tt.rawurl)
+			if err != nil {
+				t.Errorf("getGitLabProjectHooksAPI() error parsing rawurl %s: %s", tt.rawurl, err)
+			}
+			if got := getGitLabProjectHooksAPI(u); got != tt.want {
+				t.Errorf("getGitLabProjectHooksAPI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_doGitLabHookRequest_subscribe(t *testing.T) {
+	wantAPI := "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks"
+	fakeGitLabClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		if request.Method != http.MethodPost {
+			t.Errorf("doGitLabHookRequest() expected method POST; got: %s", request.Method)
+		}
+		if gotAPI := request.URL.String(); gotAPI != wantAPI {
+			t.Errorf("doGitLabHookRequest() expected API URL %s; got: %s", wantAPI, gotAPI)
+		}
+		hook := gitLabHook{}
+		if err := json.NewDecoder(request.Body).Decode(&hook); err != nil {
+			t.Errorf("doGitLabHookRequest() error decoding request body: %s", err)
+		}
+		want := gitLabHook{
+			URL:                   "https://examplecallback.com",
+			Token:                 "mySecret",
+			PushEvents:            true,
+			TagPushEvents:         true,
+			MergeRequestsEvents:   true,
+			EnableSSLVerification: true,
+		}
+		if hook != want {
+			t.Errorf("doGitLabHookRequest() expected hook %+v; got: %+v", want, hook)
+		}
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+		}, nil
+	})
+	err := doGitLabHookRequest(fakeGitLabClient, "https://gitlab.com/owner/repo", "subscribe", "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+	if err != nil {
+		t.Errorf("doGitLabHookRequest() returned an error: %s", err)
+	}
+}
+
+func Test_doGitLabHookRequest_unsubscribe(t *testing.T) {
+	hooksAPI := "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks"
+	deleted := []string{}
+	fakeGitLabClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		switch request.Method {
+		case http.MethodGet:
+			hooks := []gitLabHook{
+				{ID: 1, URL: "https://othercallback.com"},
+				{ID: 2, URL: "https://examplecallback.com"},
+			}
+			body, _ := json.Marshal(hooks)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			}, nil
+		case http.MethodDelete:
+			deleted = append(deleted, request.URL.String())
+			return &http.Response{
+				StatusCode: http.StatusNoContent,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		}
+		t.Errorf("doGitLabHookRequest() unexpected method %s", request.Method)
+		return nil, nil
+	})
+	err := doGitLabHookRequest(fakeGitLabClient, "https://gitlab.com/owner/repo", "unsubscribe", "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+	if err != nil {
+		t.Errorf("doGitLabHookRequest() returned an error: %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != hooksAPI+"/2" {
+		t.Errorf("doGitLabHookRequest() expected only %s/2 to be deleted; got: %v", hooksAPI, deleted)
+	}
+}
+
+func Test_doGitLabHookRequest_update(t *testing.T) {
+	hooksAPI := "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks"
+	updated := []gitLabHook{}
+	urls := []string{}
+	fakeGitLabClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		switch request.Method {
+		case http.MethodGet:
+			body, _ := json.Marshal([]gitLabHook{
+				{ID: 1, URL: "https://othercallback.com"},
+				{ID: 2, URL: "https://examplecallback.com"},
+			})
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+			}, nil
+		case http.MethodPut:
+			hook := gitLabHook{}
+			json.NewDecoder(request.Body).Decode(&hook)
+			updated = append(updated, hook)
+			urls = append(urls, request.URL.String())
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			}, nil
+		}
+		t.Errorf("doGitLabHookRequest() unexpected method %s", request.Method)
+		return nil, nil
+	})
+	err := doGitLabHookRequest(fakeGitLabClient, "https://gitlab.com/owner/repo", "update", "https://examplecallback.com", "newSecret", []string{"push", "pull_request"})
+	if err != nil {
+		t.Errorf("doGitLabHookRequest() returned an error: %s", err)
+	}
+	if len(urls) != 1 || urls[0] != hooksAPI+"/2" {
+		t.Fatalf("doGitLabHookRequest() expected only %s/2 to be updated; got: %v", hooksAPI, urls)
+	}
+	if updated[0].Token != "newSecret" || updated[0].URL != "https://examplecallback.com" || !updated[0].PushEvents || !updated[0].MergeRequestsEvents {
+		t.Errorf("doGitLabHookRequest() expected the hook to be updated with the new secret; got: %+v", updated[0])
+	}
+}
+
+func Test_isGitLabHookRegistered(t *testing.T) {
+	// The hooks are listed 100 at a time, the hook for the callback is on the second page
+	requested := []string{}
+	fakeGitLabClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		requested = append(requested, request.URL.String())
+		header := http.Header{}
+		hooks := []gitLabHook{{ID: 1, URL: "https://othercallback.com"}}
+		switch request.URL.String() {
+		case "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks?per_page=100&page=1":
+			header.Set("X-Next-Page", "2")
+		case "https://gitlab.com/api/v4/projects/owner%2Frepo/hooks?per_page=100&page=2":
+			hooks = []gitLabHook{{ID: 2, URL: "https://examplecallback.com"}}
+		default:
+			t.Errorf("isGitLabHookRegistered() unexpected URL %s", request.URL)
+		}
+		body, _ := json.Marshal(hooks)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(body)),
+		}, nil
+	})
+	for callback, want := range map[string]bool{"https://examplecallback.com": true, "https://missingcallback.com": false} {
+		requested = nil
+		got, err := isGitLabHookRegistered(fakeGitLabClient, "https://gitlab.com/owner/repo", callback)
+		if err != nil {
+			t.Errorf("isGitLabHookRegistered() returned an error: %s", err)
+		}
+		if got != want {
+			t.Errorf("isGitLabHookRegistered() for %s = %v, want %v", callback, got, want)
+		}
+		if len(requested) != 2 {
+			t.Errorf("isGitLabHookRegistered() for %s expected to list both pages of hooks; got: %v", callback, requested)
+		}
+	}
+}
+
+func Test_doGitLabHookRequest_error(t *testing.T) {
+	fakeGitLabClient := fakerestclient.CreateHTTPClient(func(request *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Status:     http.StatusText(http.StatusUnauthorized),
+			Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+		}, nil
+	})
+	for _, mode := range []string{"subscribe", "unsubscribe", "update"} {
+		err := doGitLabHookRequest(fakeGitLabClient, "https://gitlab.com/owner/repo", mode, "https://examplecallback.com", "mySecret", []string{"push", "pull_request"})
+		if err == nil {
+			t.Errorf("doGitLabHookRequest() did not return an error when expected for mode %s", mode)
+		}
+	}
+}
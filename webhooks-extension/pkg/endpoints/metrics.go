@@ -0,0 +1,49 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"strconv"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	webhookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_extension_webhook_requests_total",
+		Help: "Requests to create, update and delete webhooks by operation and HTTP status code.",
+	}, []string{"operation", "code"})
+
+	gitProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webhooks_extension_git_provider_request_duration_seconds",
+		Help:    "Latency of requests to git provider APIs, registering and checking hooks, by provider, HTTP status code and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider", "code", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(webhookRequests, gitProviderRequestDuration)
+}
+
+// RegisterMetrics registers the Prometheus metrics endpoint
+func (r Resource) RegisterMetrics(container *restful.Container) {
+	container.Handle("/metrics", promhttp.Handler())
+}
+
+// recordWebhookRequest counts a request to create, update or delete a webhook by the status code of its response
+func recordWebhookRequest(operation string, response *restful.Response) {
+	webhookRequests.WithLabelValues(operation, strconv.Itoa(response.StatusCode())).Inc()
+}
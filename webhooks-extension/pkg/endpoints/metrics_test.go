@@ -0,0 +1,92 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// gitProviderRequests returns the number of requests to the git provider recorded in the latency histogram
+func gitProviderRequests(t *testing.T, provider string) uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %s", err)
+	}
+	var count uint64
+	for _, family := range families {
+		if family.GetName() != "webhooks_extension_git_provider_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "provider" && label.GetValue() == provider {
+					count += metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	created := testutil.ToFloat64(webhookRequests.WithLabelValues("create", "201"))
+	rejected := testutil.ToFloat64(webhookRequests.WithLabelValues("create", "400"))
+	requests := gitProviderRequests(t, "gitea")
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	if got := testutil.ToFloat64(webhookRequests.WithLabelValues("create", "201")); got != created+1 {
+		t.Errorf("Expected one more successful create request recorded, got %v rather than %v", got, created+1)
+	}
+	if gitProviderRequests(t, "gitea") <= requests {
+		t.Error("Expected the requests registering the hook with Gitea to be recorded")
+	}
+
+	// Creating the same webhook again is rejected
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Fatalf("Expected webhook creation to fail with status 400, got %d", resp.StatusCode())
+	}
+	if got := testutil.ToFloat64(webhookRequests.WithLabelValues("create", "400")); got != rejected+1 {
+		t.Errorf("Expected one more rejected create request recorded, got %v rather than %v", got, rejected+1)
+	}
+
+	// The metrics are served on /metrics
+	container := restful.NewContainer()
+	r.RegisterMetrics(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Error getting metrics: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, name := range []string{"webhooks_extension_webhook_requests_total", "webhooks_extension_git_provider_request_duration_seconds"} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("Metric %s not served on /metrics", name)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	fakeclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned/fake"
 	faketriggerclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned/fake"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
 	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
 	"knative.dev/pkg/apis"
@@ -95,6 +96,11 @@ func dummyRoutesClientset() *fakeroutesclientset.Clientset {
 	return result
 }
 
+func dummyDynamicClient() *fakedynamic.FakeDynamicClient {
+	result := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	return result
+}
+
 func dummyHTTPRequest(method string, url string, body io.Reader) *http.Request {
 	httpReq, _ := http.NewRequest(method, url, body)
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -128,6 +134,7 @@ func updateResourceDefaults(r *Resource, newDefaults EnvDefaults) *Resource {
 		K8sClient:      r.K8sClient,
 		TektonClient:   r.TektonClient,
 		TriggersClient: r.TriggersClient,
+		DynamicClient:  r.DynamicClient,
 		Defaults:       newDefaults,
 	}
 	return &newResource
@@ -139,6 +146,7 @@ func dummyResource() *Resource {
 		TektonClient:   dummyClientset(),
 		TriggersClient: dummyTriggersClientset(),
 		RoutesClient:   dummyRoutesClientset(),
+		DynamicClient:  dummyDynamicClient(),
 		Defaults:       dummyDefaults(),
 	}
 
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	restful "github.com/emicklei/go-restful"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	tektoninformers "github.com/tektoncd/pipeline/pkg/client/informers/externalversions"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"knative.dev/pkg/apis"
+)
+
+/*--------------------------------------
+The trace of an incoming event, started by the interceptor, ends with the
+PipelineRun the eventlistener created for it, labelled with the traceparent of
+the interceptor's hand-off span. The extension watches labelled PipelineRuns
+and records their spans once they complete, timed from the PipelineRun
+resource: the PipelineRun from its creation to its completion, the wait from
+its creation until it started and each of its TaskRuns. PipelineRuns completing
+while the extension is not running are not recorded.
+---------------------------------------*/
+
+// startWebhookSpan starts the span of a request to create, update or delete a webhook
+func startWebhookSpan(operation string, request *restful.Request) trace.Span {
+	_, span := tracing.Tracer().Start(request.Request.Context(), operation+" webhook",
+		trace.WithSpanKind(trace.SpanKindServer))
+	return span
+}
+
+// endWebhookSpan ends the span of a request to create, update or delete a webhook with the status code of its response
+func endWebhookSpan(span trace.Span, response *restful.Response) {
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode()))
+	if response.StatusCode() >= 400 {
+		span.SetStatus(codes.Error, "")
+	}
+	span.End()
+}
+
+// RunPipelineRunTracing records the spans of PipelineRuns labelled with a traceparent as they complete,
+// until stopCh is closed
+func (r Resource) RunPipelineRunTracing(stopCh <-chan struct{}) {
+	factory := tektoninformers.NewSharedInformerFactoryWithOptions(r.TektonClient, 0,
+		tektoninformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = tracing.TraceParentLabel
+		}))
+	informer := factory.Tekton().V1alpha1().PipelineRuns().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok := oldObj.(*pipelinesv1alpha1.PipelineRun)
+			if !ok {
+				return
+			}
+			pr, ok := newObj.(*pipelinesv1alpha1.PipelineRun)
+			if !ok {
+				return
+			}
+			if !old.IsDone() && pr.IsDone() {
+				recordPipelineRunSpans(pr)
+			}
+		},
+	})
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		logging.Log.Error("error syncing the PipelineRun cache, PipelineRuns will not be traced")
+	}
+}
+
+// recordPipelineRunSpans records the spans of the completed PipelineRun in the trace of its traceparent label,
+// returning whether it had a valid traceparent
+func recordPipelineRunSpans(pr *pipelinesv1alpha1.PipelineRun) bool {
+	ctx := tracing.WithTraceParent(context.Background(), pr.Labels[tracing.TraceParentLabel])
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return false
+	}
+
+	created := pr.CreationTimestamp.Time
+	ctx, span := tracing.Tracer().Start(ctx, "PipelineRun",
+		trace.WithTimestamp(created),
+		trace.WithAttributes(
+			attribute.String("tekton.namespace", pr.Namespace),
+			attribute.String("tekton.pipelinerun", pr.Name),
+			attribute.String("tekton.pipeline", pr.Spec.PipelineRef.Name)))
+	if condition := pr.Status.GetCondition(apis.ConditionSucceeded); condition != nil {
+		span.SetAttributes(attribute.String("tekton.reason", condition.Reason))
+		if condition.IsFalse() {
+			span.SetStatus(codes.Error, condition.Message)
+		}
+	}
+
+	if pr.HasStarted() {
+		_, pending := tracing.Tracer().Start(ctx, "PipelineRun pending", trace.WithTimestamp(created))
+		pending.End(trace.WithTimestamp(pr.Status.StartTime.Time))
+	}
+
+	// TaskRuns are recorded in the order they started
+	taskRuns := []*pipelinesv1alpha1.PipelineRunTaskRunStatus{}
+	for _, taskRun := range pr.Status.TaskRuns {
+		if taskRun != nil && taskRun.Status != nil && taskRun.Status.StartTime != nil {
+			taskRuns = append(taskRuns, taskRun)
+		}
+	}
+	sort.Slice(taskRuns, func(i, j int) bool {
+		return taskRuns[i].Status.StartTime.Before(taskRuns[j].Status.StartTime)
+	})
+	for _, taskRun := range taskRuns {
+		_, taskSpan := tracing.Tracer().Start(ctx, "TaskRun "+taskRun.PipelineTaskName,
+			trace.WithTimestamp(taskRun.Status.StartTime.Time))
+		if condition := taskRun.Status.GetCondition(apis.ConditionSucceeded); condition != nil && condition.IsFalse() {
+			taskSpan.SetStatus(codes.Error, condition.Message)
+		}
+		taskSpan.End(trace.WithTimestamp(completionTime(taskRun.Status.CompletionTime)))
+	}
+
+	span.End(trace.WithTimestamp(completionTime(pr.Status.CompletionTime)))
+	return true
+}
+
+// completionTime returns the time of a completion time, which may be missing for runs failing before they started
+func completionTime(completed *metav1.Time) time.Time {
+	if completed == nil {
+		return time.Now()
+	}
+	return completed.Time
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/tracing"
+	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"knative.dev/pkg/apis"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// recordSpans records the spans ended until the returned function is called
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder, func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) }
+}
+
+// completedPipelineRun returns a PipelineRun labelled with the traceparent, created at created and
+// completing 10 minutes later, with a TaskRun failing
+func completedPipelineRun(name, traceParent string, created time.Time) *pipelinesv1alpha1.PipelineRun {
+	pr := &pipelinesv1alpha1.PipelineRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			Labels:            map[string]string{tracing.TraceParentLabel: traceParent},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: pipelinesv1alpha1.PipelineRunSpec{PipelineRef: pipelinesv1alpha1.PipelineRef{Name: "simple-pipeline"}},
+	}
+	pr.Status.StartTime = &metav1.Time{Time: created.Add(4 * time.Minute)}
+	pr.Status.CompletionTime = &metav1.Time{Time: created.Add(10 * time.Minute)}
+	pr.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Reason: "Failed", Message: "build failed"})
+
+	build := &pipelinesv1alpha1.TaskRunStatus{
+		StartTime:      &metav1.Time{Time: created.Add(4 * time.Minute)},
+		CompletionTime: &metav1.Time{Time: created.Add(6 * time.Minute)},
+	}
+	deploy := &pipelinesv1alpha1.TaskRunStatus{
+		StartTime:      &metav1.Time{Time: created.Add(6 * time.Minute)},
+		CompletionTime: &metav1.Time{Time: created.Add(10 * time.Minute)},
+	}
+	deploy.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionFalse, Message: "deploy failed"})
+	pr.Status.TaskRuns = map[string]*pipelinesv1alpha1.PipelineRunTaskRunStatus{
+		name + "-deploy": {PipelineTaskName: "deploy", Status: deploy},
+		name + "-build":  {PipelineTaskName: "build", Status: build},
+	}
+	return pr
+}
+
+func TestRecordPipelineRunSpans(t *testing.T) {
+	recorder, stop := recordSpans()
+	defer stop()
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if !recordPipelineRunSpans(completedPipelineRun("run", testTraceParent, created)) {
+		t.Fatal("Expected the spans of a PipelineRun with a valid traceparent to be recorded")
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	order := []string{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		order = append(order, span.Name())
+	}
+	expected := []string{"PipelineRun pending", "TaskRun build", "TaskRun deploy", "PipelineRun"}
+	if len(order) != len(expected) {
+		t.Fatalf("Expected spans %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("Expected spans %v, got %v", expected, order)
+		}
+	}
+
+	run := spans["PipelineRun"]
+	if run.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || run.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the PipelineRun span to continue the trace of its traceparent, got parent %v", run.Parent())
+	}
+	if !run.StartTime().Equal(created) || !run.EndTime().Equal(created.Add(10*time.Minute)) {
+		t.Errorf("Expected the PipelineRun span from its creation to its completion, got %s to %s", run.StartTime(), run.EndTime())
+	}
+	if run.Status().Code != codes.Error || run.Status().Description != "build failed" {
+		t.Errorf("Expected the failed PipelineRun span to be an error, got %v", run.Status())
+	}
+
+	pending := spans["PipelineRun pending"]
+	if pending.Parent().SpanID() != run.SpanContext().SpanID() {
+		t.Error("Expected the pending span to be a child of the PipelineRun span")
+	}
+	if pending.EndTime().Sub(pending.StartTime()) != 4*time.Minute {
+		t.Errorf("Expected the PipelineRun to be pending for 4 minutes, got %s", pending.EndTime().Sub(pending.StartTime()))
+	}
+	if spans["TaskRun build"].Status().Code != codes.Unset || spans["TaskRun deploy"].Status().Code != codes.Error {
+		t.Error("Expected only the failed TaskRun span to be an error")
+	}
+
+	// PipelineRuns without a valid traceparent, such as those labelled while tracing is disabled, are not recorded
+	if recordPipelineRunSpans(completedPipelineRun("untraced", "", created)) {
+		t.Error("Expected the spans of a PipelineRun without a traceparent not to be recorded")
+	}
+	if len(recorder.Ended()) != len(expected) {
+		t.Errorf("Expected no more spans, got %d spans", len(recorder.Ended()))
+	}
+}
+
+func TestRunPipelineRunTracing(t *testing.T) {
+	recorder, stop := recordSpans()
+	defer stop()
+
+	r := dummyResource()
+	pr := completedPipelineRun("run", testTraceParent, time.Now().Add(-time.Hour))
+	running := pr.DeepCopy()
+	running.Status.CompletionTime = nil
+	running.Status.SetCondition(&apis.Condition{Type: apis.ConditionSucceeded, Status: corev1.ConditionUnknown})
+	if _, err := r.TektonClient.TektonV1alpha1().PipelineRuns("default").Create(running); err != nil {
+		t.Fatalf("Error creating PipelineRun: %s", err)
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	r.RunPipelineRunTracing(stopCh)
+
+	if _, err := r.TektonClient.TektonV1alpha1().PipelineRuns("default").Update(pr); err != nil {
+		t.Fatalf("Error updating PipelineRun: %s", err)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return len(recorder.Ended()) == 4, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the spans of the completed PipelineRun to be recorded, got %d spans", len(recorder.Ended()))
+	}
+}
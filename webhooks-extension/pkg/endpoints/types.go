@@ -15,11 +15,13 @@ package endpoints
 
 import (
 	"os"
+	"strconv"
 
 	routeclientset "github.com/openshift/client-go/route/clientset/versioned"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	tektoncdclientset "github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
 	triggersclientset "github.com/tektoncd/triggers/pkg/client/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	k8sclientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -30,7 +32,9 @@ type Resource struct {
 	K8sClient      k8sclientset.Interface
 	TriggersClient triggersclientset.Interface
 	RoutesClient   routeclientset.Interface
-	Defaults       EnvDefaults
+	// DynamicClient is used for webhook resources, which have no generated clientset
+	DynamicClient dynamic.Interface
+	Defaults      EnvDefaults
 }
 
 // NewResource returns a new Resource instantiated with its clientsets
@@ -70,21 +74,40 @@ func NewResource() (Resource, error) {
 		return Resource{}, err
 	}
 
+	// Setup dynamic client
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		logging.Log.Errorf("error building dynamic client: %s.", err.Error())
+		return Resource{}, err
+	}
+
 	defaults := EnvDefaults{
 		Namespace:      os.Getenv("INSTALLED_NAMESPACE"),
 		DockerRegistry: os.Getenv("DOCKER_REGISTRY_LOCATION"),
 		CallbackURL:    os.Getenv("WEBHOOK_CALLBACK_URL"),
 	}
+	// Webhooks without an eventlistener target one for their namespace rather than the default
+	defaults.EventListenerPerNamespace, _ = strconv.ParseBool(os.Getenv("EVENTLISTENER_PER_NAMESPACE"))
 	if defaults.Namespace == "" {
 		// If no namespace provided, use "default"
 		defaults.Namespace = "default"
 	}
+	// Requests are only authorized if enabled, as the dashboard does not forward the caller's bearer token
+	defaults.RequireAuthorization, _ = strconv.ParseBool(os.Getenv("REQUIRE_AUTHORIZATION"))
+	defaults.TrustForwardedHeaders, _ = strconv.ParseBool(os.Getenv("TRUST_FORWARDED_HEADERS"))
+	// The audit log is disabled by setting AUDIT_LOG_PATH empty
+	auditLogPath, set := os.LookupEnv("AUDIT_LOG_PATH")
+	if !set {
+		auditLogPath = defaultAuditLogPath
+	}
+	defaults.AuditLogPath = auditLogPath
 
 	r := Resource{
 		K8sClient:      k8sClient,
 		TektonClient:   tektonClient,
 		TriggersClient: triggersClient,
 		RoutesClient:   routesClient,
+		DynamicClient:  dynamicClient,
 		Defaults:       defaults,
 	}
 	return r, nil
@@ -105,13 +128,61 @@ type webhook struct {
 	OnSuccessComment string `json:"onsuccesscomment,omitempty"`
 	OnFailureComment string `json:"onfailurecomment,omitempty"`
 	OnTimeoutComment string `json:"ontimeoutcomment,omitempty"`
+	GitProvider      string `json:"gitprovider,omitempty"`
+	HookID           int64  `json:"hookid,omitempty"`
+	EventListener    string `json:"eventlistener,omitempty"`
+	// Events run the pipeline, by default push and pull_request, see events.go
+	Events []string `json:"events,omitempty"`
+	// Actions of each event that run the pipeline, events not listed take the default actions for the event
+	Actions map[string][]string `json:"actions,omitempty"`
+	// Branch and path filters of push events, see filters.go
+	Branches        []string `json:"branches,omitempty"`
+	ExcludeBranches []string `json:"excludebranches,omitempty"`
+	Paths           []string `json:"paths,omitempty"`
+	ExcludePaths    []string `json:"excludepaths,omitempty"`
+	// Fork policy of pull_request events, see forks.go
+	ForkPolicy     string `json:"forkpolicy,omitempty"`
+	ForkPermission string `json:"forkpermission,omitempty"`
+	ForkTeam       string `json:"forkteam,omitempty"`
+	ForkComment    bool   `json:"forkcomment,omitempty"`
 }
 
+// webhookStatus is the live status of a webhook derived from the eventlistener,
+// the trigger resources and the git provider
+type webhookStatus struct {
+	TriggersFound         bool `json:"triggersfound"`
+	TriggerResourcesFound bool `json:"triggerresourcesfound"`
+	// Not set when the registration could not be checked
+	HookRegistered *bool    `json:"hookregistered,omitempty"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// webhookWithStatus is the webhook returned for a single webhook, including its status
+type webhookWithStatus struct {
+	webhook
+	Status webhookStatus `json:"status"`
+}
+
+// Supported git providers, a webhook without a provider is a GitHub webhook
+const (
+	gitHubProvider    = "github"
+	gitLabProvider    = "gitlab"
+	bitbucketProvider = "bitbucket"
+	giteaProvider     = "gitea"
+	gogsProvider      = "gogs"
+)
+
 // ConfigMapName ... the name of the ConfigMap to create
 const ConfigMapName = "githubwebhook"
 
 type EnvDefaults struct {
-	Namespace      string `json:"namespace"`
-	DockerRegistry string `json:"dockerregistry"`
-	CallbackURL    string `json:"endpointurl"`
+	Namespace                 string `json:"namespace"`
+	DockerRegistry            string `json:"dockerregistry"`
+	CallbackURL               string `json:"endpointurl"`
+	EventListenerPerNamespace bool   `json:"eventlistenerpernamespace"`
+	// AuditLogPath is the file audit records are appended to, see audit.go
+	AuditLogPath string `json:"-"`
+	// RequireAuthorization and TrustForwardedHeaders configure the authorization of requests, see auth.go
+	RequireAuthorization  bool `json:"-"`
+	TrustForwardedHeaders bool `json:"-"`
 }
@@ -26,127 +26,137 @@ import (
 
 	restful "github.com/emicklei/go-restful"
 	routesv1 "github.com/openshift/api/route/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
 	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	"github.com/tektoncd/experimental/webhooks-extension/pkg/metrics"
 	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
+	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 	"golang.org/x/xerrors"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
 )
 
 var (
 	modifyingEventListenerLock sync.Mutex
-	actions                    = pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "opened,reopened,synchronize"}}
-)
-
-const (
-	eventListenerName = "tekton-webhooks-eventlistener"
-	routeName         = "el-" + eventListenerName
+	// monitorActions are the pull request actions the monitor task runs for
+	monitorActions = getActionsHeader(defaultActions[pullRequestEvent])
 )
 
 /*
-	Creation of the eventlistener, called when no eventlistener exists at
-	the point of webhook creation.
+Creation of the eventlistener, called when no eventlistener exists at
+the point of webhook creation.
 */
 func (r Resource) createEventListener(webhook webhook, namespace, monitorTriggerName string) (*v1alpha1.EventListener, error) {
-	hookParams, monitorParams := r.getParams(webhook)
-
-	pushTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-push-event",
-		webhook.Pipeline+"-push-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"push",
-		webhook.AccessTokenRef,
-		hookParams)
-
-	pullRequestTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-pullrequest-event",
-		webhook.Pipeline+"-pullrequest-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"pull_request",
-		webhook.AccessTokenRef,
-		hookParams)
-	pullRequestTrigger.Interceptor.Header = append(pullRequestTrigger.Interceptor.Header, actions)
-
-	monitorTrigger := r.newTrigger(monitorTriggerName,
-		webhook.PullTask+"-binding",
-		webhook.PullTask+"-template",
-		webhook.GitRepositoryURL,
-		"pull_request",
-		webhook.AccessTokenRef,
-		monitorParams)
-	monitorTrigger.Interceptor.Header = append(monitorTrigger.Interceptor.Header, actions)
-
-	triggers := []v1alpha1.EventListenerTrigger{pushTrigger, pullRequestTrigger, monitorTrigger}
+	triggers := r.newWebhookTriggers(webhook)
+	monitorTrigger := r.newMonitorTrigger(webhook, monitorTriggerName)
 
 	eventListener := v1alpha1.EventListener{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      eventListenerName,
+			Name:      r.getEventListenerName(webhook),
 			Namespace: namespace,
+			Labels:    map[string]string{managedByLabel: managedByValue},
 		},
 		Spec: v1alpha1.EventListenerSpec{
 			ServiceAccountName: "tekton-webhooks-extension-eventlistener",
-			Triggers:           triggers,
+			Triggers:           append(triggers, monitorTrigger),
 		},
 	}
 	return r.TriggersClient.TektonV1alpha1().EventListeners(namespace).Create(&eventListener)
 }
 
 /*
-	Update of the eventlistener, called when adding additional webhooks as we
-	run with a single eventlistener.
+Update of the eventlistener, called when adding additional webhooks to an
+existing eventlistener. The eventlistener is read again before it is updated
+as it may have been changed by another replica.
 */
 func (r Resource) updateEventListener(eventListener *v1alpha1.EventListener, webhook webhook, monitorTriggerName string) (*v1alpha1.EventListener, error) {
-	hookParams, monitorParams := r.getParams(webhook)
-	newPushTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-push-event",
-		webhook.Pipeline+"-push-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"push",
-		webhook.AccessTokenRef,
-		hookParams)
-
-	newPullRequestTrigger := r.newTrigger(webhook.Name+"-"+webhook.Namespace+"-pullrequest-event",
-		webhook.Pipeline+"-pullrequest-binding",
-		webhook.Pipeline+"-template",
-		webhook.GitRepositoryURL,
-		"pull_request",
-		webhook.AccessTokenRef,
-		hookParams)
-	newPullRequestTrigger.Interceptor.Header = append(newPullRequestTrigger.Interceptor.Header, actions)
+	return r.modifyEventListener(eventListener.GetNamespace(), eventListener.GetName(), func(el *v1alpha1.EventListener) error {
+		newTriggers := r.newWebhookTriggers(webhook)
+		for _, hook := range getWebhooksFromEventListener(*el) {
+			// The hook on the git provider may have been created since the webhooks were listed
+			if hook.GitRepositoryURL == webhook.GitRepositoryURL && hook.HookID != 0 {
+				for i := range newTriggers {
+					setHookIDHeader(&newTriggers[i], hook.HookID)
+				}
+			}
+		}
 
-	eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newPushTrigger)
-	eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newPullRequestTrigger)
+		existingMonitorFound := false
+		for _, trigger := range el.Spec.Triggers {
+			if isWebhookTrigger(trigger, webhook.Name+"-"+webhook.Namespace) {
+				return errWebhookExists
+			}
+			if trigger.Name == monitorTriggerName {
+				existingMonitorFound = true
+			}
+		}
 
-	existingMonitorFound := false
-	for _, trigger := range eventListener.Spec.Triggers {
-		if trigger.Name == monitorTriggerName {
-			existingMonitorFound = true
-			break
+		el.Spec.Triggers = append(el.Spec.Triggers, newTriggers...)
+		if !existingMonitorFound {
+			el.Spec.Triggers = append(el.Spec.Triggers, r.newMonitorTrigger(webhook, monitorTriggerName))
 		}
+		return nil
+	})
+}
+
+// newWebhookTriggers returns the triggers running the pipeline of the webhook, one for each of its events
+func (r Resource) newWebhookTriggers(webhook webhook) []v1alpha1.EventListenerTrigger {
+	hookParams, _ := r.getParams(webhook)
+	triggers := []v1alpha1.EventListenerTrigger{}
+	// Webhooks recorded before webhooks had events have the default events and actions
+	if len(webhook.Events) == 0 {
+		webhook.Events, webhook.Actions = defaultEvents, defaultActions
 	}
-	if !existingMonitorFound {
-		newMonitor := r.newTrigger(monitorTriggerName,
-			webhook.PullTask+"-binding",
-			webhook.PullTask+"-template",
+	for _, event := range webhook.Events {
+		trigger := r.newTrigger(getEventTriggerName(webhook.Name+"-"+webhook.Namespace, event),
+			getEventBindingName(webhook.Pipeline, event),
+			webhook.Pipeline+"-template",
 			webhook.GitRepositoryURL,
-			"pull_request",
+			event,
 			webhook.AccessTokenRef,
-			monitorParams)
-		newMonitor.Interceptor.Header = append(newMonitor.Interceptor.Header, actions)
-
-		eventListener.Spec.Triggers = append(eventListener.Spec.Triggers, newMonitor)
+			webhook.GitProvider,
+			hookParams)
+		if actions := webhook.Actions[event]; len(actions) > 0 {
+			trigger.Interceptor.Header = append(trigger.Interceptor.Header, getActionsHeader(actions))
+		}
+		switch event {
+		case pushEvent:
+			setFilterHeaders(&trigger, webhook)
+		case pullRequestEvent:
+			setForkPolicyHeaders(&trigger, webhook)
+		}
+		setHookIDHeader(&trigger, webhook.HookID)
+		triggers = append(triggers, trigger)
 	}
+	return triggers
+}
 
-	return r.TriggersClient.TektonV1alpha1().EventListeners(eventListener.GetNamespace()).Update(eventListener)
+// newMonitorTrigger returns the trigger running the pull task shared by all webhooks on the repository
+func (r Resource) newMonitorTrigger(webhook webhook, monitorTriggerName string) v1alpha1.EventListenerTrigger {
+	_, monitorParams := r.getParams(webhook)
+	monitorTrigger := r.newTrigger(monitorTriggerName,
+		webhook.PullTask+"-binding",
+		webhook.PullTask+"-template",
+		webhook.GitRepositoryURL,
+		"pull_request",
+		webhook.AccessTokenRef,
+		webhook.GitProvider,
+		monitorParams)
+	monitorTrigger.Interceptor.Header = append(monitorTrigger.Interceptor.Header, monitorActions)
+	setHookIDHeader(&monitorTrigger, webhook.HookID)
+	return monitorTrigger
 }
 
-func (r Resource) newTrigger(name, bindingName, templateName, repoURL, event, secretName string, params []pipelinesv1alpha1.Param) v1alpha1.EventListenerTrigger {
-	return v1alpha1.EventListenerTrigger{
+func (r Resource) newTrigger(name, bindingName, templateName, repoURL, event, secretName, provider string, params []pipelinesv1alpha1.Param) v1alpha1.EventListenerTrigger {
+	trigger := v1alpha1.EventListenerTrigger{
 		Name: name,
 		Binding: v1alpha1.EventListenerBinding{
 			Name:       bindingName,
@@ -171,11 +181,52 @@ func (r Resource) newTrigger(name, bindingName, templateName, repoURL, event, se
 			},
 		},
 	}
+	// Triggers without a provider header are validated as GitHub events by the interceptor
+	if provider != "" {
+		trigger.Interceptor.Header = append(trigger.Interceptor.Header,
+			pipelinesv1alpha1.Param{Name: "Wext-Git-Provider", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: provider}})
+	}
+	return trigger
+}
+
+// setHookIDHeader records the ID of the git provider hook for the repository on the trigger,
+// replacing any ID already recorded. Nothing is recorded for an ID of 0.
+func setHookIDHeader(trigger *v1alpha1.EventListenerTrigger, hookID int64) {
+	if hookID == 0 || trigger.Interceptor == nil {
+		return
+	}
+	header := pipelinesv1alpha1.Param{Name: "Wext-Hook-Id", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: strconv.FormatInt(hookID, 10)}}
+	for i, existing := range trigger.Interceptor.Header {
+		if existing.Name == header.Name {
+			trigger.Interceptor.Header[i] = header
+			return
+		}
+	}
+	trigger.Interceptor.Header = append(trigger.Interceptor.Header, header)
+}
+
+// recordHookID records the ID of the git provider hook on all the triggers for the repository in the eventlistener
+func (r Resource) recordHookID(installNs, elName, repoURL string, hookID int64) error {
+	_, err := r.modifyEventListener(installNs, elName, func(el *v1alpha1.EventListener) error {
+		for i := range el.Spec.Triggers {
+			if el.Spec.Triggers[i].Interceptor == nil {
+				continue
+			}
+			for _, header := range el.Spec.Triggers[i].Interceptor.Header {
+				if header.Name == "Wext-Repository-Url" && header.Value.StringVal == repoURL {
+					setHookIDHeader(&el.Spec.Triggers[i], hookID)
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return err
 }
 
 /*
-	Processing of the inputs into the required structure for
-	the eventlistener.
+Processing of the inputs into the required structure for
+the eventlistener.
 */
 func (r Resource) getParams(webhook webhook) (webhookParams, monitorParams []pipelinesv1alpha1.Param) {
 	saName := webhook.ServiceAccount
@@ -285,8 +336,8 @@ func (r Resource) getDashboardURL(installNs string) string {
 }
 
 /*
-	Processes a git URL into component parts, all of which are lowercased
-	to try and avoid problems matching strings.
+Processes a git URL into component parts, all of which are lowercased
+to try and avoid problems matching strings.
 */
 func getGitValues(url string) (gitServer, gitOwner, gitRepo string, err error) {
 	repoURL := ""
@@ -319,21 +370,9 @@ func getGitValues(url string) (gitServer, gitOwner, gitRepo string, err error) {
 	return gitServer, gitOwner, gitRepo, nil
 }
 
-// Creates a webhook for a given repository and populates (creating if doesn't yet exist) an eventlistener
-func (r Resource) createWebhook(request *restful.Request, response *restful.Response) {
-	modifyingEventListenerLock.Lock()
-	defer modifyingEventListenerLock.Unlock()
-
-	logging.Log.Infof("Webhook creation request received with request: %+v.", request)
-	installNs := r.Defaults.Namespace
-
-	webhook := webhook{}
-	if err := request.ReadEntity(&webhook); err != nil {
-		logging.Log.Errorf("error trying to read request entity as webhook: %s.", err)
-		RespondError(response, err, http.StatusBadRequest)
-		return
-	}
-
+// validateWebhook sanitizes the webhook, applying defaults, and checks the values
+// required to create or update it are valid
+func (r Resource) validateWebhook(webhook *webhook) error {
 	// Sanitize GitRepositoryURL
 	webhook.GitRepositoryURL = strings.TrimSuffix(webhook.GitRepositoryURL, ".git")
 
@@ -341,14 +380,13 @@ func (r Resource) createWebhook(request *restful.Request, response *restful.Resp
 		webhook.PullTask = "monitor-task"
 	}
 
-	if webhook.Name != "" {
-		if len(webhook.Name) > 57 {
-			tooLongMessage := fmt.Sprintf("requested release name (%s) must be less than 58 characters", webhook.Name)
-			err := errors.New(tooLongMessage)
-			logging.Log.Errorf("error: %s", err.Error())
-			RespondError(response, err, http.StatusBadRequest)
-			return
-		}
+	webhook.GitProvider = strings.ToLower(webhook.GitProvider)
+	if !isSupportedGitProvider(webhook.GitProvider) {
+		return fmt.Errorf("the supplied gitprovider %s is not supported", webhook.GitProvider)
+	}
+
+	if len(webhook.Name) > 57 {
+		return fmt.Errorf("requested release name (%s) must be less than 58 characters", webhook.Name)
 	}
 
 	dockerRegDefault := r.Defaults.DockerRegistry
@@ -360,163 +398,543 @@ func (r Resource) createWebhook(request *restful.Request, response *restful.Resp
 	}
 	logging.Log.Debugf("Docker registry location is: %s", webhook.DockerRegistry)
 
-	namespace := webhook.Namespace
-	if namespace == "" {
-		err := errors.New("a namespace for creating a webhook is required, but none was given")
-		logging.Log.Errorf("error: %s.", err.Error())
-		RespondError(response, err, http.StatusBadRequest)
-		return
+	if webhook.Namespace == "" {
+		return errors.New("a namespace for creating a webhook is required, but none was given")
+	}
+
+	webhook.EventListener = r.getEventListenerName(*webhook)
+	if err := validateEventListenerName(webhook.EventListener); err != nil {
+		return err
 	}
 
 	if !strings.HasPrefix(webhook.GitRepositoryURL, "http") {
-		err := errors.New("the supplied GitRepositoryURL does not specify the protocol http:// or https://")
-		logging.Log.Errorf("error: %s", err.Error())
-		RespondError(response, err, http.StatusBadRequest)
-		return
+		return errors.New("the supplied GitRepositoryURL does not specify the protocol http:// or https://")
 	}
 
 	pieces := strings.Split(webhook.GitRepositoryURL, "/")
 	if len(pieces) < 4 {
-		logging.Log.Errorf("error creating webhook: GitRepositoryURL format error (%+v).", webhook.GitRepositoryURL)
-		RespondError(response, errors.New("GitRepositoryURL format error"), http.StatusBadRequest)
+		logging.Log.Errorf("GitRepositoryURL format error (%+v).", webhook.GitRepositoryURL)
+		return errors.New("GitRepositoryURL format error")
+	}
+	if err := validateEvents(webhook); err != nil {
+		return err
+	}
+	if err := validateFilters(*webhook); err != nil {
+		return err
+	}
+	return validateForkPolicy(*webhook)
+}
+
+// checkTriggerResources checks the trigger template for the pipeline, and its trigger binding for each event,
+// exist in the install namespace
+func (r Resource) checkTriggerResources(pipeline string, events []string) error {
+	installNs := r.Defaults.Namespace
+	_, err := r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Get(pipeline+"-template", metav1.GetOptions{})
+	expected := []string{pipeline + "-template"}
+	for _, event := range events {
+		binding := getEventBindingName(pipeline, event)
+		if _, bindingErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(binding, metav1.GetOptions{}); bindingErr != nil {
+			err = bindingErr
+		}
+		expected = append(expected, binding)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not find the required trigger template or trigger bindings in namespace: %s. Expected to find: %s", installNs, strings.Join(expected, ", "))
+	}
+	return nil
+}
+
+// getMonitorTriggerName returns the name of the single monitor trigger for all triggers on a repo
+func getMonitorTriggerName(repoURL string) (string, error) {
+	gitServer, gitOwner, gitRepo, err := getGitValues(repoURL)
+	monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
+	return strings.TrimPrefix(monitorTriggerName, "https://"), err
+}
+
+// Creates a webhook for a given repository and populates (creating if doesn't yet exist) an eventlistener
+func (r Resource) createWebhook(request *restful.Request, response *restful.Response) {
+	span := startWebhookSpan("create", request)
+	defer endWebhookSpan(span, response)
+	audit := newAuditRecord("create", "webhook")
+	defer r.recordAudit(request, response, audit)
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+	defer recordWebhookRequest("create", response)
+
+	logging.Log.Infof("Webhook creation request received with request: %+v.", request)
+
+	webhook := webhook{}
+	if err := request.ReadEntity(&webhook); err != nil {
+		logging.Log.Errorf("error trying to read request entity as webhook: %s.", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	audit.Name, audit.Namespace = webhook.Name, webhook.Namespace
+	logger := requestLogger(request, "webhook", webhook.Name, "namespace", webhook.Namespace, "repository", webhook.GitRepositoryURL)
+
+	// Webhook resources are named after the webhook, so a webhook name can only be used once in a namespace
+	if existing, err := r.getWebhookResource(webhook.Name, webhook.Namespace); err == nil &&
+		existing.Spec.GitRepositoryURL != strings.TrimSuffix(webhook.GitRepositoryURL, ".git") {
+		err := fmt.Errorf("a webhook named %s already exists in namespace %s for repository %s", webhook.Name, webhook.Namespace, existing.Spec.GitRepositoryURL)
+		logger.Errorf("error creating webhook: %s", err)
+		RespondError(response, err, http.StatusBadRequest)
 		return
 	}
 
-	hooks, err := r.getHooksForRepo(webhook.GitRepositoryURL)
-	if len(hooks) > 0 {
-		for _, hook := range hooks {
-			if hook.Name == webhook.Name && hook.Namespace == webhook.Namespace {
-				logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v with the Name %s and Namespace %s.", webhook.GitRepositoryURL, webhook.Name, webhook.Namespace)
-				RespondError(response, errors.New("Webhook already exists for the specified Git repository with the same name, targeting the same namespace"), http.StatusBadRequest)
-				return
-			}
-			if hook.Pipeline == webhook.Pipeline && hook.Namespace == webhook.Namespace {
-				logging.Log.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v, running pipeline %s in namespace %s.", webhook.GitRepositoryURL, webhook.Pipeline, webhook.Namespace)
-				RespondError(response, errors.New("Webhook already exists for the specified Git repository, running the same pipeline in the same namespace"), http.StatusBadRequest)
-				return
-			}
-			if hook.PullTask != webhook.PullTask {
-				msg := fmt.Sprintf("PullTask mismatch. Webhooks on a repository must use the same PullTask existing webhooks use %s not %s.", hook.PullTask, webhook.PullTask)
-				logging.Log.Errorf("error creating webhook: " + msg)
-				RespondError(response, errors.New(msg), http.StatusBadRequest)
-				return
+	// The webhook resource is only created for a webhook known to be valid
+	ctx := request.Request.Context()
+	if statusCode, err := r.validateNewWebhook(ctx, &webhook); err != nil {
+		RespondError(response, err, statusCode)
+		return
+	}
+
+	// The webhook resource is created first, for the steps creating the webhook to be recorded on it. It is
+	// created with its creation in progress, so the webhook controller of another replica leaves it alone.
+	creation, err := r.createPendingWebhookResource(webhook)
+	if err != nil {
+		logger.Errorf("error creating webhook resource for webhook %s in namespace %s: %s", webhook.Name, webhook.Namespace, err)
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+
+	var statusCode int
+	if creation != nil {
+		statusCode, err = r.completeWebhookCreation(ctx, &webhook, creation)
+	} else {
+		statusCode, err = r.addWebhook(ctx, &webhook)
+	}
+	if err != nil {
+		if creation != nil {
+			if err := r.discardWebhookResource(webhook.Name, webhook.Namespace); err != nil {
+				logger.Errorf("error deleting webhook resource for webhook %s in namespace %s: %s", webhook.Name, webhook.Namespace, err)
 			}
 		}
+		RespondError(response, err, statusCode)
+		return
 	}
 
-	_, templateErr := r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Get(webhook.Pipeline+"-template", metav1.GetOptions{})
-	_, pushErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(webhook.Pipeline+"-push-binding", metav1.GetOptions{})
-	_, pullrequestErr := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(webhook.Pipeline+"-pullrequest-binding", metav1.GetOptions{})
-	if templateErr != nil || pushErr != nil || pullrequestErr != nil {
-		msg := fmt.Sprintf("Could not find the required trigger template or trigger bindings in namespace: %s. Expected to find: %s, %s and %s", installNs, webhook.Pipeline+"-template", webhook.Pipeline+"-push-binding", webhook.Pipeline+"-pullrequest-binding")
-		logging.Log.Errorf("%s", msg)
-		RespondError(response, errors.New(msg), http.StatusBadRequest)
-		return
+	if err := r.updateWebhookResource(webhook); err != nil {
+		logger.Errorf("error recording webhook %s in namespace %s as a webhook resource: %s", webhook.Name, webhook.Namespace, err)
+	}
+	response.WriteHeader(statusCode)
+}
+
+// addWebhook adds the triggers of the webhook to its eventlistener, creating the eventlistener if it doesn't yet
+// exist, and registers the hook with the git provider. The webhook is validated and defaulted, and the hook ID set.
+// The steps taken are recorded on the webhook resource, which must exist, and are rolled back if a step fails.
+// The HTTP status code to respond with is returned with any error.
+func (r Resource) addWebhook(ctx context.Context, webhook *webhook) (int, error) {
+	logger := logging.FromContext(ctx)
+	creation, err := r.beginWebhookCreation(webhook.Name, webhook.Namespace)
+	if err == errWebhookBeingCreated {
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		msg := fmt.Sprintf("error recording the creation of webhook %s on its webhook resource: %s", webhook.Name, err)
+		logger.Errorf("%s", msg)
+		return http.StatusInternalServerError, errors.New(msg)
+	}
+	return r.completeWebhookCreation(ctx, webhook, creation)
+}
+
+// completeWebhookCreation takes the steps creating the webhook, recording them with the creation begun on its
+// webhook resource, and rolls them back if a step fails
+func (r Resource) completeWebhookCreation(ctx context.Context, webhook *webhook, creation *webhookCreation) (int, error) {
+	logger := logging.FromContext(ctx)
+	statusCode, err := r.addWebhookInSteps(ctx, webhook, creation)
+	if err != nil {
+		if rollbackErr := creation.rollback(); rollbackErr != nil {
+			msg := fmt.Sprintf("error creating webhook. Also failed to roll back the webhook creation, which will be retried. Errors were: %s and %s", err, rollbackErr)
+			logger.Errorf("%s", msg)
+			return http.StatusInternalServerError, errors.New(msg)
+		}
+		return statusCode, err
+	}
+	return statusCode, nil
+}
+
+// validateNewWebhook validates and defaults the webhook, and checks it does not conflict with the webhooks
+// already on its repository. The HTTP status code to respond with is returned with any error.
+func (r Resource) validateNewWebhook(ctx context.Context, webhook *webhook) (int, error) {
+	logger := logging.FromContext(ctx)
+	if err := r.validateWebhook(webhook); err != nil {
+		logger.Errorf("error: %s", err.Error())
+		return http.StatusBadRequest, err
+	}
+
+	hooks, _ := r.getHooksForRepo(webhook.GitRepositoryURL)
+	for _, hook := range hooks {
+		if hook.Name == webhook.Name && hook.Namespace == webhook.Namespace {
+			logger.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v with the Name %s and Namespace %s.", webhook.GitRepositoryURL, webhook.Name, webhook.Namespace)
+			return http.StatusBadRequest, errors.New("Webhook already exists for the specified Git repository with the same name, targeting the same namespace")
+		}
+		if hook.Pipeline == webhook.Pipeline && hook.Namespace == webhook.Namespace {
+			logger.Errorf("error creating webhook: A webhook already exists for GitRepositoryURL %+v, running pipeline %s in namespace %s.", webhook.GitRepositoryURL, webhook.Pipeline, webhook.Namespace)
+			return http.StatusBadRequest, errors.New("Webhook already exists for the specified Git repository, running the same pipeline in the same namespace")
+		}
+		if getGitProvider(hook) != getGitProvider(*webhook) {
+			msg := fmt.Sprintf("GitProvider mismatch. Webhooks on a repository must use the same GitProvider existing webhooks use %s not %s.", getGitProvider(hook), getGitProvider(*webhook))
+			logger.Errorf("error creating webhook: " + msg)
+			return http.StatusBadRequest, errors.New(msg)
+		}
+		if hook.PullTask != webhook.PullTask {
+			msg := fmt.Sprintf("PullTask mismatch. Webhooks on a repository must use the same PullTask existing webhooks use %s not %s.", hook.PullTask, webhook.PullTask)
+			logger.Errorf("error creating webhook: " + msg)
+			return http.StatusBadRequest, errors.New(msg)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// addWebhookInSteps validates the webhook and takes the steps creating it, leaving any rollback to the caller
+func (r Resource) addWebhookInSteps(ctx context.Context, webhook *webhook, creation *webhookCreation) (int, error) {
+	logger := logging.FromContext(ctx)
+	installNs := r.Defaults.Namespace
+
+	if statusCode, err := r.validateNewWebhook(ctx, webhook); err != nil {
+		return statusCode, err
 	}
 
-	eventListener, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	// The hook on the git provider is shared by all webhooks on the repository in the eventlistener
+	hooks, _ := r.getHooksForRepo(webhook.GitRepositoryURL)
+	hooksInEventListener := getHooksInEventListener(hooks, webhook.EventListener)
+	webhook.HookID = 0
+	if len(hooksInEventListener) > 0 {
+		webhook.HookID = hooksInEventListener[0].HookID
+	}
+
+	if err := r.checkTriggerResources(webhook.Pipeline, webhook.Events); err != nil {
+		logger.Errorf("%s", err)
+		return http.StatusBadRequest, err
+	}
+
+	eventListener, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(webhook.EventListener, metav1.GetOptions{})
 	if err != nil && !k8serrors.IsNotFound(err) {
 		msg := fmt.Sprintf("unable to create webhook due to error listing Tekton eventlistener: %s", err)
-		logging.Log.Errorf("%s", msg)
-		RespondError(response, errors.New(msg), http.StatusInternalServerError)
-		return
+		logger.Errorf("%s", msg)
+		return http.StatusInternalServerError, errors.New(msg)
 	}
 
 	gitServer, gitOwner, gitRepo, err := getGitValues(webhook.GitRepositoryURL)
 	if err != nil {
-		logging.Log.Errorf("error parsing git repository URL %s in getGitValues(): %s", webhook.GitRepositoryURL, err)
-		RespondError(response, errors.New("error parsing GitRepositoryURL, check pod logs for more details"), http.StatusInternalServerError)
-		return
+		logger.Errorf("error parsing git repository URL %s in getGitValues(): %s", webhook.GitRepositoryURL, err)
+		return http.StatusInternalServerError, errors.New("error parsing GitRepositoryURL, check pod logs for more details")
 	}
 	sanitisedURL := gitServer + "/" + gitOwner + "/" + gitRepo
 	// Single monitor trigger for all triggers on a repo - thus name to use for monitor is
 	monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
 	monitorTriggerName = strings.TrimPrefix(monitorTriggerName, "https://")
 
-	if eventListener != nil && eventListener.GetName() != "" {
-		_, err := r.updateEventListener(eventListener, webhook, monitorTriggerName)
-		if err != nil {
-			msg := fmt.Sprintf("error creating webhook due to error updating eventlistener: %s", err)
-			logging.Log.Errorf("%s", msg)
-			RespondError(response, errors.New(msg), http.StatusInternalServerError)
-			return
-		}
-	} else {
-		logging.Log.Info("No existing eventlistener found, creating a new one...")
-		_, err := r.createEventListener(webhook, installNs, monitorTriggerName)
-		if err != nil {
-			msg := fmt.Sprintf("error creating webhook due to error creating eventlistener. Error was: %s", err)
-			logging.Log.Errorf("%s", msg)
-			RespondError(response, errors.New(msg), http.StatusInternalServerError)
-			return
+	// The validated and defaulted webhook is recorded with the steps, for them to be undone
+	creation.hook = *webhook
+
+	// Another replica may create or delete the eventlistener after it was read, so creation falls back to
+	// an update and an update falls back to creation
+	var updatedEventListener *v1alpha1.EventListener
+	createdEventListener := false
+	err = creation.do(creationStepTriggers, func(step *webhooksv1alpha1.WebhookCreationStep) error {
+		var err error
+		if eventListener != nil && eventListener.GetName() != "" {
+			updatedEventListener, err = r.updateEventListener(eventListener, *webhook, monitorTriggerName)
+			if k8serrors.IsNotFound(err) {
+				logger.Infof("Eventlistener %s was deleted while adding the webhook, creating a new one...", webhook.EventListener)
+				updatedEventListener, err = r.createEventListener(*webhook, installNs, monitorTriggerName)
+				createdEventListener = err == nil
+			}
+		} else {
+			logger.Infof("No existing eventlistener %s found, creating a new one...", webhook.EventListener)
+			updatedEventListener, err = r.createEventListener(*webhook, installNs, monitorTriggerName)
+			if k8serrors.IsAlreadyExists(err) {
+				logger.Infof("Eventlistener %s was created while adding the webhook, updating it...", webhook.EventListener)
+				existing := &v1alpha1.EventListener{ObjectMeta: metav1.ObjectMeta{Name: webhook.EventListener, Namespace: installNs}}
+				updatedEventListener, err = r.updateEventListener(existing, *webhook, monitorTriggerName)
+			} else {
+				createdEventListener = err == nil
+			}
 		}
+		return err
+	})
+	if err == errWebhookExists {
+		logger.Errorf("error creating webhook %s: %s", webhook.Name, err)
+		return http.StatusBadRequest, err
+	}
+	if err != nil {
+		msg := fmt.Sprintf("error creating webhook due to error updating eventlistener: %s", err)
+		logger.Errorf("%s", msg)
+		return http.StatusInternalServerError, errors.New(msg)
+	}
+
+	if createdEventListener {
 		_, varexists := os.LookupEnv("PLATFORM")
 		if !varexists {
-			err = r.createDeleteIngress("create", installNs)
+			err = creation.do(creationStepIngress, func(step *webhooksv1alpha1.WebhookCreationStep) error {
+				return r.createDeleteIngress("create", installNs, webhook.EventListener)
+			})
 			if err != nil {
 				msg := fmt.Sprintf("error creating webhook due to error creating ingress. Error was: %s", err)
-				logging.Log.Errorf("%s", msg)
-				logging.Log.Debugf("Deleting eventlistener as failed creating Ingress")
-				err2 := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Delete(eventListenerName, &metav1.DeleteOptions{})
-				if err2 != nil {
-					updatedMsg := fmt.Sprintf("error creating webhook due to error creating ingress. Also failed to cleanup and delete eventlistener. Errors were: %s and %s", err, err2)
-					RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-					return
-				}
-				RespondError(response, errors.New(msg), http.StatusInternalServerError)
-				return
-			} else {
-				logging.Log.Debug("ingress creation succeeded")
+				logger.Errorf("%s", msg)
+				return http.StatusInternalServerError, errors.New(msg)
 			}
+			logger.Debug("ingress creation succeeded")
 		} else {
-			if err := r.createOpenshiftRoute(routeName); err != nil {
-				logging.Log.Debug("Failed to create Route, deleting EventListener...")
-				err2 := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Delete(eventListenerName, &metav1.DeleteOptions{})
-				if err2 != nil {
-					updatedMsg := fmt.Sprintf("Error creating webhook due to error creating route. Also failed to cleanup and delete eventlistener. Errors were: %s and %s", err, err2)
-					RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-					return
-				}
-				RespondError(response, err, http.StatusInternalServerError)
-				return
+			err = creation.do(creationStepRoute, func(step *webhooksv1alpha1.WebhookCreationStep) error {
+				return r.createOpenshiftRoute(getEventListenerServiceName(webhook.EventListener))
+			})
+			if err != nil {
+				logger.Errorf("error creating webhook due to error creating route: %s", err)
+				return http.StatusInternalServerError, err
 			}
 		}
+	}
 
+	// The hook on the git provider is created by the first webhook on the repository to be added
+	// to the eventlistener, which may not be the one expected when the webhooks were listed
+	hooksInEventListener = nil
+	for _, hook := range getWebhooksFromEventListener(*updatedEventListener) {
+		if hook.GitRepositoryURL == webhook.GitRepositoryURL && (hook.Name != webhook.Name || hook.Namespace != webhook.Namespace) {
+			hooksInEventListener = append(hooksInEventListener, hook)
+		}
 	}
 
-	if len(hooks) == 0 {
+	if len(hooksInEventListener) == 0 {
 		// Create webhook
-		err = r.doGitHubWebhookRequest(webhook, "subscribe", []string{"push", "pull_request"})
+		err = creation.do(creationStepHook, func(step *webhooksv1alpha1.WebhookCreationStep) error {
+			hookID, err := r.doWebhookRequest(*webhook, "subscribe", getHookEvents(*webhook))
+			if err != nil {
+				return err
+			}
+			step.HookID = hookID
+			webhook.HookID = hookID
+			return nil
+		})
 		if err != nil {
-			// Handle cleanup if it fails
-			// - remove from EventListener
-			// - delete EventListener?
-			err2 := r.deleteFromEventListener(webhook.Name+"-"+webhook.Namespace, installNs, monitorTriggerName, webhook.GitRepositoryURL)
-			if err2 != nil {
-				updatedMsg := fmt.Sprintf("error creating webhook. Also failed to cleanup and delete entry from eventlistener. Errors were: %s and %s", err, err2)
-				RespondError(response, errors.New(updatedMsg), http.StatusInternalServerError)
-				return
+			logger.Errorf("error creating webhook due to error registering the hook with the git provider: %s", err)
+			return http.StatusInternalServerError, err
+		}
+		if webhook.HookID != 0 {
+			// Without the recorded ID the hook is found by its callback URL when deleting, so the creation
+			// is rolled back rather than leaving a hook other hooks for the callback could be mistaken for
+			if err := r.recordHookID(installNs, webhook.EventListener, webhook.GitRepositoryURL, webhook.HookID); err != nil {
+				msg := fmt.Sprintf("error creating webhook due to error recording hook ID %d on the eventlistener: %s", webhook.HookID, err)
+				logger.Errorf("%s", msg)
+				return http.StatusInternalServerError, errors.New(msg)
 			}
-			RespondError(response, err, http.StatusInternalServerError)
-			return
 		}
-		logging.Log.Debug("webhook creation succeeded")
+		logger.Debug("webhook creation succeeded")
 	} else {
-		logging.Log.Debugf("webhook already exists for repository %s - not creating new hook in GitHub", sanitisedURL)
+		logger.Debugf("webhook already exists for repository %s in eventlistener %s - not creating new hook in the git provider", sanitisedURL, webhook.EventListener)
+		if addsHookEvents(*webhook, hooksInEventListener) {
+			// The existing hook is updated through the webhook sharing it, whose hook ID may be recorded
+			hook := *webhook
+			hook.HookID = hooksInEventListener[0].HookID
+			if _, err := r.doWebhookRequest(hook, "update", getHookEvents(hook)); err != nil {
+				logger.Errorf("error creating webhook due to error updating the events of the hook with the git provider: %s", err)
+				return http.StatusInternalServerError, err
+			}
+			logger.Debugf("events of the hook for repository %s updated", sanitisedURL)
+		}
+	}
+
+	return http.StatusCreated, nil
+}
+
+// Updates the triggers of an existing webhook in place, the hook in the git provider is only updated for new events
+func (r Resource) updateWebhook(request *restful.Request, response *restful.Response) {
+	span := startWebhookSpan("update", request)
+	defer endWebhookSpan(span, response)
+	audit := newAuditRecord("update", "webhook")
+	defer r.recordAudit(request, response, audit)
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+	defer recordWebhookRequest("update", response)
+
+	logging.Log.Infof("Webhook update request received with request: %+v.", request)
+	name := request.PathParameter("name")
+	audit.Name = name
+
+	webhook := webhook{}
+	if err := request.ReadEntity(&webhook); err != nil {
+		logging.Log.Errorf("error trying to read request entity as webhook: %s.", err)
+		RespondError(response, err, http.StatusBadRequest)
+		return
+	}
+	audit.Namespace = webhook.Namespace
+	logger := requestLogger(request, "webhook", name, "namespace", webhook.Namespace, "repository", webhook.GitRepositoryURL)
+	if statusCode, err := r.replaceWebhook(request.Request.Context(), name, &webhook); err != nil {
+		RespondError(response, err, statusCode)
+		return
+	}
+
+	if err := r.updateWebhookResource(webhook); err != nil {
+		logger.Errorf("error recording webhook %s in namespace %s as a webhook resource: %s", webhook.Name, webhook.Namespace, err)
+	}
+	response.WriteEntity(webhook)
+}
+
+// replaceWebhook replaces the triggers of the webhook with the given name in place. The webhook is validated
+// and defaulted from the existing webhook. The HTTP status code to respond with is returned with any error.
+func (r Resource) replaceWebhook(ctx context.Context, name string, webhook *webhook) (int, error) {
+	logger := logging.FromContext(ctx)
+	installNs := r.Defaults.Namespace
+
+	if webhook.Name == "" {
+		webhook.Name = name
+	}
+	if webhook.Name != name {
+		err := fmt.Errorf("the webhook name %s does not match the name %s in the path, webhooks cannot be renamed", webhook.Name, name)
+		logger.Errorf("error: %s", err.Error())
+		return http.StatusBadRequest, err
+	}
+	if webhook.Namespace == "" || webhook.GitRepositoryURL == "" {
+		err := errors.New("bad request information provided, a namespace and a gitrepositoryurl must be specified to identify the webhook")
+		logger.Error(err)
+		return http.StatusBadRequest, err
+	}
+	webhook.GitRepositoryURL = strings.TrimSuffix(webhook.GitRepositoryURL, ".git")
+
+	hooks, err := r.getHooksForRepo(webhook.GitRepositoryURL)
+	if err != nil {
+		logger.Errorf("error getting webhooks for repository %s: %s", webhook.GitRepositoryURL, err)
+		return http.StatusInternalServerError, err
+	}
+	found := -1
+	for i, hook := range hooks {
+		if hook.Name == webhook.Name && hook.Namespace == webhook.Namespace {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		err := fmt.Errorf("no webhook found for repo %s with name %s associated with namespace %s", webhook.GitRepositoryURL, webhook.Name, webhook.Namespace)
+		logger.Error(err)
+		return http.StatusNotFound, err
+	}
+	existing := hooks[found]
+
+	// The hook in the git provider is shared by all webhooks on the repository and
+	// signed with the secret token of the access token, so these cannot be changed
+	if webhook.GitProvider == "" {
+		webhook.GitProvider = existing.GitProvider
+	}
+	if webhook.PullTask == "" {
+		webhook.PullTask = existing.PullTask
+	}
+	if webhook.AccessTokenRef == "" {
+		webhook.AccessTokenRef = existing.AccessTokenRef
+	}
+	if webhook.EventListener == "" {
+		webhook.EventListener = existing.EventListener
+	}
+	if len(webhook.Events) == 0 {
+		webhook.Events = existing.Events
+		if webhook.Actions == nil {
+			webhook.Actions = existing.Actions
+		}
+	}
+	if err := r.validateWebhook(webhook); err != nil {
+		logger.Errorf("error: %s", err.Error())
+		return http.StatusBadRequest, err
+	}
+	if getGitProvider(*webhook) != getGitProvider(existing) || webhook.PullTask != existing.PullTask || webhook.AccessTokenRef != existing.AccessTokenRef ||
+		webhook.EventListener != existing.EventListener {
+		err := errors.New("the gitprovider, pulltask, accesstoken and eventlistener of a webhook cannot be changed, delete and recreate the webhook instead")
+		logger.Errorf("error: %s", err.Error())
+		return http.StatusBadRequest, err
 	}
+	webhook.GitProvider = existing.GitProvider
+	webhook.HookID = existing.HookID
 
-	response.WriteHeader(http.StatusCreated)
+	for _, hook := range hooks {
+		if hook.Name != webhook.Name && hook.Pipeline == webhook.Pipeline && hook.Namespace == webhook.Namespace {
+			logger.Errorf("error updating webhook: A webhook already exists for GitRepositoryURL %+v, running pipeline %s in namespace %s.", webhook.GitRepositoryURL, webhook.Pipeline, webhook.Namespace)
+			return http.StatusBadRequest, errors.New("Webhook already exists for the specified Git repository, running the same pipeline in the same namespace")
+		}
+	}
+
+	if err := r.checkTriggerResources(webhook.Pipeline, webhook.Events); err != nil {
+		logger.Errorf("%s", err)
+		return http.StatusBadRequest, err
+	}
+
+	monitorTriggerName, err := getMonitorTriggerName(webhook.GitRepositoryURL)
+	if err != nil {
+		logger.Errorf("error parsing git repository URL %s in getGitValues(): %s", webhook.GitRepositoryURL, err)
+		return http.StatusInternalServerError, errors.New("error parsing GitRepositoryURL, check pod logs for more details")
+	}
+
+	// The hook is updated before the triggers, so that the triggers for new events are never left without events
+	sharing := append(hooks[:0:0], existing)
+	for _, hook := range hooks {
+		if hook.EventListener == webhook.EventListener && (hook.Name != webhook.Name || hook.Namespace != webhook.Namespace) {
+			sharing = append(sharing, hook)
+		}
+	}
+	if addsHookEvents(*webhook, sharing) {
+		if _, err := r.doWebhookRequest(*webhook, "update", getHookEvents(*webhook)); err != nil {
+			logger.Errorf("error updating webhook due to error updating the events of the hook with the git provider: %s", err)
+			return http.StatusInternalServerError, err
+		}
+		logger.Debugf("events of the hook for repository %s updated", webhook.GitRepositoryURL)
+	}
+
+	// Replace the triggers in place, the pull request comments are set on the monitor
+	// trigger which is shared by all webhooks on the repository
+	errWebhookRemoved := fmt.Errorf("webhook %s was removed from eventlistener %s while being updated", webhook.Name, webhook.EventListener)
+	_, err = r.modifyEventListener(installNs, webhook.EventListener, func(el *v1alpha1.EventListener) error {
+		prefix := webhook.Name + "-" + webhook.Namespace
+		found := false
+		for _, trigger := range el.Spec.Triggers {
+			if isWebhookTrigger(trigger, prefix) {
+				// The hook ID may have been recorded since the webhooks were listed
+				webhook.HookID = getHookFromTrigger(trigger, "").HookID
+				found = true
+			}
+		}
+		if !found {
+			return errWebhookRemoved
+		}
+		// The triggers for the events of the webhook replace its existing triggers, where the first of them was
+		triggers := []v1alpha1.EventListenerTrigger{}
+		replaced := false
+		for _, trigger := range el.Spec.Triggers {
+			switch {
+			case isWebhookTrigger(trigger, prefix):
+				if !replaced {
+					triggers = append(triggers, r.newWebhookTriggers(*webhook)...)
+					replaced = true
+				}
+			case trigger.Name == monitorTriggerName:
+				triggers = append(triggers, r.newMonitorTrigger(*webhook, monitorTriggerName))
+			default:
+				triggers = append(triggers, trigger)
+			}
+		}
+		el.Spec.Triggers = triggers
+		return nil
+	})
+	if err == errWebhookRemoved || k8serrors.IsNotFound(err) {
+		logger.Error(err)
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		msg := fmt.Sprintf("error updating webhook due to error updating eventlistener: %s", err)
+		logger.Errorf("%s", msg)
+		return http.StatusInternalServerError, errors.New(msg)
+	}
+	logger.Debugf("webhook %s for repository %s updated", webhook.Name, webhook.GitRepositoryURL)
+
+	return http.StatusOK, nil
 }
 
-func (r Resource) createDeleteIngress(mode, installNS string) error {
+func (r Resource) createDeleteIngress(mode, installNS, elName string) error {
 	if mode == "create" {
 		// Unlike webhook creation, the ingress does not need a protocol specified
-		callback := strings.TrimPrefix(r.Defaults.CallbackURL, "http://")
+		callback := strings.TrimPrefix(r.getCallbackURL(elName), "http://")
 		callback = strings.TrimPrefix(callback, "https://")
 
 		ingress := &v1beta1.Ingress{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      "el-" + eventListenerName,
+				Name:      getEventListenerServiceName(elName),
 				Namespace: installNS,
 			},
 			Spec: v1beta1.IngressSpec{
@@ -528,7 +946,7 @@ func (r Resource) createDeleteIngress(mode, installNS string) error {
 								Paths: []v1beta1.HTTPIngressPath{
 									{
 										Backend: v1beta1.IngressBackend{
-											ServiceName: "el-" + eventListenerName,
+											ServiceName: getEventListenerServiceName(elName),
 											ServicePort: intstr.IntOrString{
 												Type:   intstr.Int,
 												IntVal: 8080,
@@ -549,7 +967,7 @@ func (r Resource) createDeleteIngress(mode, installNS string) error {
 		logging.Log.Debug("Ingress has been created")
 		return nil
 	} else if mode == "delete" {
-		err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Delete("el-"+eventListenerName, &metav1.DeleteOptions{})
+		err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNS).Delete(getEventListenerServiceName(elName), &metav1.DeleteOptions{})
 		if err != nil {
 			return err
 		}
@@ -563,13 +981,20 @@ func (r Resource) createDeleteIngress(mode, installNS string) error {
 
 // Removes from Eventlistener, removes the webhook
 func (r Resource) deleteWebhook(request *restful.Request, response *restful.Response) {
+	span := startWebhookSpan("delete", request)
+	defer endWebhookSpan(span, response)
+	audit := newAuditRecord("delete", "webhook")
+	defer r.recordAudit(request, response, audit)
 	modifyingEventListenerLock.Lock()
 	defer modifyingEventListenerLock.Unlock()
-	logging.Log.Debug("In deleteWebhook")
+	defer recordWebhookRequest("delete", response)
 	name := request.PathParameter("name")
 	repo := request.QueryParameter("repository")
 	namespace := request.QueryParameter("namespace")
+	logger := requestLogger(request, "webhook", name, "namespace", namespace, "repository", repo)
+	logger.Debug("In deleteWebhook")
 	deletePipelineRuns := request.QueryParameter("deletepipelineruns")
+	audit.Name, audit.Namespace = name, namespace
 
 	var toDeletePipelineRuns = false
 	var err error
@@ -578,7 +1003,7 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 		toDeletePipelineRuns, err = strconv.ParseBool(deletePipelineRuns)
 		if err != nil {
 			theError := errors.New("bad request information provided, cannot handle deletepipelineruns query (should be set to true or not provided)")
-			logging.Log.Error(theError)
+			logger.Error(theError)
 			RespondError(response, theError, http.StatusInternalServerError)
 			return
 		}
@@ -586,164 +1011,273 @@ func (r Resource) deleteWebhook(request *restful.Request, response *restful.Resp
 
 	if namespace == "" || repo == "" {
 		theError := errors.New("bad request information provided, a namespace and a repository must be specified as query parameters")
-		logging.Log.Error(theError)
+		logger.Error(theError)
 		RespondError(response, theError, http.StatusBadRequest)
 		return
 	}
 
-	logging.Log.Debugf("in deleteWebhook, name: %s, repo: %s, delete pipeline runs: %s", name, repo, deletePipelineRuns)
+	logger.Debugf("in deleteWebhook, name: %s, repo: %s, delete pipeline runs: %s", name, repo, deletePipelineRuns)
 
-	webhooks, err := r.getHooksForRepo(repo)
+	statusCode, err := r.removeWebhook(request.Request.Context(), name, namespace, repo, toDeletePipelineRuns)
 	if err != nil {
-		RespondError(response, err, http.StatusNotFound)
+		RespondError(response, err, statusCode)
 		return
 	}
 
-	logging.Log.Debugf("Found %d webhooks/pipelines registered against repo %s", len(webhooks), repo)
+	if err := r.deleteWebhookResource(name, namespace); err != nil {
+		logger.Errorf("error deleting the webhook resource for webhook %s in namespace %s: %s", name, namespace, err)
+	}
+	response.WriteHeader(statusCode)
+}
+
+// removeWebhook removes the triggers of the webhook from its eventlistener, deleting the eventlistener if no triggers
+// remain, and removes the hook from the git provider if no other webhooks on the repository remain in the eventlistener.
+// The HTTP status code to respond with is returned with any error.
+func (r Resource) removeWebhook(ctx context.Context, name, namespace, repo string, toDeletePipelineRuns bool) (int, error) {
+	logger := logging.FromContext(ctx)
+	webhooks, err := r.getHooksForRepo(repo)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	logger.Debugf("Found %d webhooks/pipelines registered against repo %s", len(webhooks), repo)
 	if len(webhooks) < 1 {
 		err := fmt.Errorf("no webhook found for repo %s", repo)
-		logging.Log.Error(err)
-		RespondError(response, err, http.StatusBadRequest)
-		return
+		logger.Error(err)
+		return http.StatusBadRequest, err
 	}
 
-	gitServer, gitOwner, gitRepo, err := getGitValues(repo)
-	// Single monitor trigger for all triggers on a repo - thus name to use for monitor is
-	monitorTriggerName := strings.TrimPrefix(gitServer+"/"+gitOwner+"/"+gitRepo, "http://")
-	monitorTriggerName = strings.TrimPrefix(monitorTriggerName, "https://")
+	monitorTriggerName, err := getMonitorTriggerName(repo)
 
-	found := false
 	for _, hook := range webhooks {
 		if hook.Name == name && hook.Namespace == namespace {
-			found = true
-			if len(webhooks) == 1 {
-				logging.Log.Debug("No other pipelines triggered by this GitHub webhook, deleting webhook")
+			// Whether other webhooks share the git provider webhook is decided by the triggers left
+			// in the eventlistener, as webhooks may have been added by another replica since listing
+			eventListenerEntryPrefix := name + "-" + namespace
+			triggersRemainingOnRepo, err := r.deleteFromEventListener(eventListenerEntryPrefix, r.Defaults.Namespace, hook.EventListener, monitorTriggerName, repo)
+			if err != nil {
+				logger.Error(err)
+				theError := errors.New("error deleting webhook from eventlistener.")
+				return http.StatusInternalServerError, theError
+			}
+			if triggersRemainingOnRepo == 0 {
+				logger.Debug("No other pipelines triggered by this git provider webhook, deleting webhook")
 				// Delete webhook
-				err := r.doGitHubWebhookRequest(hook, "unsubscribe", []string{"push", "pull_request"})
+				_, err := r.doWebhookRequest(hook, "unsubscribe", getHookEvents(hook))
 				if err != nil {
-					RespondError(response, err, http.StatusInternalServerError)
-					return
+					logger.Errorf("error deleting git provider webhook for repository %s after removing it from the eventlistener: %s", repo, err)
+					return http.StatusInternalServerError, err
 				}
-				logging.Log.Debug("Webhook deletion succeeded")
+				logger.Debug("Webhook deletion succeeded")
 			}
 			if toDeletePipelineRuns {
 				r.deletePipelineRuns(repo, namespace, hook.Pipeline)
 			}
-			eventListenerEntryPrefix := name + "-" + namespace
-			err = r.deleteFromEventListener(eventListenerEntryPrefix, r.Defaults.Namespace, monitorTriggerName, repo)
-			if err != nil {
-				logging.Log.Error(err)
-				theError := errors.New("error deleting webhook from eventlistener.")
-				RespondError(response, theError, http.StatusInternalServerError)
-				return
-			}
 
-			response.WriteHeader(204)
+			return http.StatusNoContent, nil
 		}
 	}
 
-	if !found {
-		err := fmt.Errorf("no webhook found for repo %s with name %s associated with namespace %s", repo, name, namespace)
-		logging.Log.Error(err)
-		RespondError(response, err, http.StatusNotFound)
-		return
-	}
-
+	err = fmt.Errorf("no webhook found for repo %s with name %s associated with namespace %s", repo, name, namespace)
+	logger.Error(err)
+	return http.StatusNotFound, err
 }
 
-func (r Resource) deleteFromEventListener(name, installNS, monitorTriggerName, repoOnParams string) error {
-	logging.Log.Debugf("Deleting triggers for %s from the eventlistener", name)
-	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Get(eventListenerName, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
+// deleteFromEventListener removes the triggers of the webhook from the eventlistener, deleting the
+// eventlistener if no triggers remain. Returns the number of triggers remaining for the repository.
+func (r Resource) deleteFromEventListener(name, installNS, elName, monitorTriggerName, repoOnParams string) (int, error) {
+	logging.Log.Debugf("Deleting triggers for %s from the eventlistener %s", name, elName)
 
-	toRemove := []string{name + "-push-event", name + "-pullrequest-event"}
+	triggersRemainingOnRepo := 0
+	deleteEventListener := false
+	var uid types.UID
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Get(elName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
 
-	newTriggers := []v1alpha1.EventListenerTrigger{}
-	currentTriggers := el.Spec.Triggers
+		newTriggers := []v1alpha1.EventListenerTrigger{}
+		currentTriggers := el.Spec.Triggers
 
-	monitorTrigger := v1alpha1.EventListenerTrigger{}
-	triggersOnRepo := 0
-	triggersDeleted := 0
+		monitorTrigger := v1alpha1.EventListenerTrigger{}
+		triggersOnRepo := 0
+		triggersDeleted := 0
 
-	for _, t := range currentTriggers {
-		if t.Name == monitorTriggerName {
-			monitorTrigger = t
-		} else {
-			interceptorParams := t.Interceptor.Header
-			for _, p := range interceptorParams {
-				if p.Name == "Wext-Repository-Url" && p.Value.StringVal == repoOnParams {
-					triggersOnRepo++
+		for _, t := range currentTriggers {
+			if t.Name == monitorTriggerName {
+				monitorTrigger = t
+			} else {
+				interceptorParams := t.Interceptor.Header
+				for _, p := range interceptorParams {
+					if p.Name == "Wext-Repository-Url" && p.Value.StringVal == repoOnParams {
+						triggersOnRepo++
+					}
 				}
-			}
-			found := false
-			for _, triggerName := range toRemove {
-				if triggerName == t.Name {
+				if isWebhookTrigger(t, name) {
 					triggersDeleted++
-					found = true
-					break
+				} else {
+					newTriggers = append(newTriggers, t)
 				}
 			}
-			if !found {
-				newTriggers = append(newTriggers, t)
-			}
 		}
-	}
 
-	if triggersOnRepo > triggersDeleted {
-		newTriggers = append(newTriggers, monitorTrigger)
-	}
-
-	if len(newTriggers) == 0 {
-		err = r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Delete(el.GetName(), &metav1.DeleteOptions{})
-		if err != nil {
-			return err
+		triggersRemainingOnRepo = triggersOnRepo - triggersDeleted
+		if triggersRemainingOnRepo > 0 {
+			newTriggers = append(newTriggers, monitorTrigger)
 		}
 
-		_, varExists := os.LookupEnv("PLATFORM")
-		if !varExists {
-			err = r.createDeleteIngress("delete", installNS)
-			if err != nil {
-				logging.Log.Errorf("error deleting ingress: %s", err)
-				return err
-			} else {
-				logging.Log.Debug("Ingress deleted")
-				return nil
-			}
-		} else {
-			if err := r.deleteOpenshiftRoute(routeName); err != nil {
-				msg := fmt.Sprintf("error deleting webhook due to error deleting route. Error was: %s", err)
-				logging.Log.Errorf("%s", msg)
-				return err
-			}
-			logging.Log.Debug("route deletion succeeded")
+		// An eventlistener must have triggers, so it is deleted instead
+		deleteEventListener = len(newTriggers) == 0
+		if deleteEventListener {
+			uid = el.GetUID()
+			return nil
 		}
-	} else {
 		el.Spec.Triggers = newTriggers
 		_, err = r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Update(el)
-		if err != nil {
-			logging.Log.Errorf("error updating eventlistener: %s", err)
-			return err
+		return err
+	})
+	if err != nil {
+		logging.Log.Errorf("error updating eventlistener: %s", err)
+		return 0, err
+	}
+	if !deleteEventListener {
+		return triggersRemainingOnRepo, nil
+	}
+
+	// Only the eventlistener that was read is deleted, not one recreated by another replica since
+	err = r.TriggersClient.TektonV1alpha1().EventListeners(installNS).Delete(elName, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil {
+		return 0, err
+	}
+
+	_, varExists := os.LookupEnv("PLATFORM")
+	if !varExists {
+		// The Ingress or Route may never have been created, or may have been removed since
+		err = r.createDeleteIngress("delete", installNS, elName)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logging.Log.Errorf("error deleting ingress: %s", err)
+			return 0, err
 		}
+		logging.Log.Debug("Ingress deleted")
+	} else {
+		if err := r.deleteOpenshiftRoute(getEventListenerServiceName(elName)); err != nil && !k8serrors.IsNotFound(err) {
+			msg := fmt.Sprintf("error deleting webhook due to error deleting route. Error was: %s", err)
+			logging.Log.Errorf("%s", msg)
+			return 0, err
+		}
+		logging.Log.Debug("route deletion succeeded")
 	}
-	return err
+	return 0, nil
 }
 
 func (r Resource) getAllWebhooks(request *restful.Request, response *restful.Response) {
 	logging.Log.Debugf("Get all webhooks")
-	webhooks, err := r.getWebhooksFromEventListener()
+	webhooks, err := r.getWebhooks()
 	if err != nil {
 		logging.Log.Errorf("error trying to get webhooks: %s.", err.Error())
 		RespondError(response, err, http.StatusInternalServerError)
 		return
 	}
-	response.WriteEntity(webhooks)
+	response.WriteEntity(r.visibleWebhooks(request, webhooks))
+}
+
+// Returns a single webhook identified by its name, namespace and repository, with its live status
+func (r Resource) getWebhook(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	repo := strings.TrimSuffix(request.QueryParameter("repository"), ".git")
+	namespace := request.QueryParameter("namespace")
+	logging.Log.Debugf("Get webhook %s for repo %s in namespace %s", name, repo, namespace)
+
+	if namespace == "" || repo == "" {
+		theError := errors.New("bad request information provided, a namespace and a repository must be specified as query parameters")
+		logging.Log.Error(theError)
+		RespondError(response, theError, http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := r.getWebhooks()
+	if err != nil {
+		logging.Log.Errorf("error trying to get webhooks: %s.", err.Error())
+		RespondError(response, err, http.StatusInternalServerError)
+		return
+	}
+	for _, hook := range hooks {
+		if hook.Name == name && hook.Namespace == namespace && hook.GitRepositoryURL == repo {
+			response.WriteEntity(webhookWithStatus{webhook: hook, Status: r.getWebhookStatus(hook)})
+			return
+		}
+	}
+
+	err = fmt.Errorf("no webhook found for repo %s with name %s associated with namespace %s", repo, name, namespace)
+	logging.Log.Error(err)
+	RespondError(response, err, http.StatusNotFound)
+}
+
+// getWebhookStatus checks the triggers and trigger resources of the webhook exist and,
+// for GitHub, that the hook is still registered on the repository
+func (r Resource) getWebhookStatus(hook webhook) webhookStatus {
+	status := webhookStatus{TriggersFound: true, TriggerResourcesFound: true}
+	installNs := r.Defaults.Namespace
+
+	monitorTriggerName, _ := getMonitorTriggerName(hook.GitRepositoryURL)
+	triggerNames := []string{}
+	for _, event := range hook.Events {
+		triggerNames = append(triggerNames, getEventTriggerName(hook.Name+"-"+hook.Namespace, event))
+	}
+	triggerNames = append(triggerNames, monitorTriggerName)
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(hook.EventListener, metav1.GetOptions{})
+	if err != nil {
+		status.TriggersFound = false
+		status.Problems = append(status.Problems, fmt.Sprintf("error getting eventlistener %s: %s", hook.EventListener, err))
+	} else {
+		for _, name := range triggerNames {
+			found := false
+			for _, trigger := range el.Spec.Triggers {
+				if trigger.Name == name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				status.TriggersFound = false
+				status.Problems = append(status.Problems, fmt.Sprintf("trigger %s not found in eventlistener %s", name, hook.EventListener))
+			}
+		}
+	}
+
+	for _, name := range []string{hook.Pipeline + "-template", hook.PullTask + "-template"} {
+		if _, err := r.TriggersClient.TektonV1alpha1().TriggerTemplates(installNs).Get(name, metav1.GetOptions{}); err != nil {
+			status.TriggerResourcesFound = false
+			status.Problems = append(status.Problems, fmt.Sprintf("trigger template %s not found in namespace %s", name, installNs))
+		}
+	}
+	bindingNames := []string{}
+	for _, event := range hook.Events {
+		bindingNames = append(bindingNames, getEventBindingName(hook.Pipeline, event))
+	}
+	for _, name := range append(bindingNames, hook.PullTask+"-binding") {
+		if _, err := r.TriggersClient.TektonV1alpha1().TriggerBindings(installNs).Get(name, metav1.GetOptions{}); err != nil {
+			status.TriggerResourcesFound = false
+			status.Problems = append(status.Problems, fmt.Sprintf("trigger binding %s not found in namespace %s", name, installNs))
+		}
+	}
+
+	registered, err := r.isHookRegistered(hook)
+	if err == nil {
+		status.HookRegistered = &registered
+		if !registered {
+			status.Problems = append(status.Problems, fmt.Sprintf("hook not registered on repository %s", hook.GitRepositoryURL))
+		}
+	} else {
+		status.Problems = append(status.Problems, fmt.Sprintf("error checking hook registration: %s", err))
+	}
+	return status
 }
 
 func (r Resource) getHooksForRepo(gitURL string) ([]webhook, error) {
 	hooksForRepo := []webhook{}
-	allHooks, err := r.getWebhooksFromEventListener()
+	allHooks, err := r.getWebhooksFromEventListeners()
 	if err != nil {
 		return nil, err
 	}
@@ -757,36 +1291,34 @@ func (r Resource) getHooksForRepo(gitURL string) ([]webhook, error) {
 	return hooksForRepo, nil
 }
 
-func (r Resource) getWebhooksFromEventListener() ([]webhook, error) {
-	logging.Log.Debugf("Getting webhooks from eventlistener")
-	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(r.Defaults.Namespace).Get(eventListenerName, metav1.GetOptions{})
+func (r Resource) getWebhooksFromEventListeners() ([]webhook, error) {
+	logging.Log.Debugf("Getting webhooks from eventlisteners")
+	eventListeners, err := r.getManagedEventListeners()
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			return []webhook{}, nil
-		}
 		return nil, err
 	}
 	hooks := []webhook{}
-	var hook webhook
-	for _, trigger := range el.Spec.Triggers {
-		checkHook := false
-		if strings.HasSuffix(trigger.Name, "-push-event") {
-			hook = getHookFromTrigger(trigger, "-push-event")
-			checkHook = true
-		} else if strings.HasSuffix(trigger.Name, "-pullrequest-event") {
-			hook = getHookFromTrigger(trigger, "-pullrequest-event")
-			checkHook = true
-		}
-		if checkHook && !containedInArray(hooks, hook) {
-			hooks = append(hooks, hook)
-		}
+	for _, el := range eventListeners {
+		hooks = append(hooks, getWebhooksFromEventListener(el)...)
 	}
 	return hooks, nil
 }
 
+// getHooksInEventListener returns the webhooks in the eventlistener
+func getHooksInEventListener(hooks []webhook, elName string) []webhook {
+	hooksInEventListener := []webhook{}
+	for _, hook := range hooks {
+		if hook.EventListener == elName {
+			hooksInEventListener = append(hooksInEventListener, hook)
+		}
+	}
+	return hooksInEventListener
+}
+
 func getHookFromTrigger(t v1alpha1.EventListenerTrigger, suffix string) webhook {
 
-	var releaseName, namespace, serviceaccount, pulltask, dockerreg, helmsecret, repo, gitSecret string
+	var releaseName, namespace, serviceaccount, pulltask, dockerreg, helmsecret, repo, gitSecret, provider string
+	var hookID int64
 	for _, param := range t.Params {
 		switch param.Name {
 		case "webhooks-tekton-release-name":
@@ -810,6 +1342,10 @@ func getHookFromTrigger(t v1alpha1.EventListenerTrigger, suffix string) webhook
 			repo = header.Value.StringVal
 		case "Wext-Secret-Name":
 			gitSecret = header.Value.StringVal
+		case "Wext-Git-Provider":
+			provider = header.Value.StringVal
+		case "Wext-Hook-Id":
+			hookID, _ = strconv.ParseInt(header.Value.StringVal, 10, 64)
 		}
 	}
 
@@ -824,20 +1360,13 @@ func getHookFromTrigger(t v1alpha1.EventListenerTrigger, suffix string) webhook
 		ServiceAccount:   serviceaccount,
 		ReleaseName:      releaseName,
 		AccessTokenRef:   gitSecret,
+		GitProvider:      provider,
+		HookID:           hookID,
 	}
 
 	return triggerAsHook
 }
 
-func containedInArray(array []webhook, hook webhook) bool {
-	for _, item := range array {
-		if item == hook {
-			return true
-		}
-	}
-	return false
-}
-
 func (r Resource) deletePipelineRuns(gitRepoURL, namespace, pipeline string) error {
 	logging.Log.Debugf("Looking for PipelineRuns in namespace %s with repository URL %s for pipeline %s", namespace, gitRepoURL, pipeline)
 
@@ -905,6 +1434,17 @@ func RespondErrorAndMessage(response *restful.Response, err error, message strin
 	response.WriteErrorString(statusCode, message)
 }
 
+// requestLogger returns Log with the fields, and the user of the caller if authenticated, carrying it in the
+// context of the request for the functions handling the request
+func requestLogger(request *restful.Request, fields ...interface{}) *zap.SugaredLogger {
+	if c, ok := request.Attribute(callerAttribute).(caller); ok {
+		fields = append(fields, "user", c.User)
+	}
+	logger := logging.Log.With(fields...)
+	request.Request = request.Request.WithContext(logging.WithLogger(request.Request.Context(), logger))
+	return logger
+}
+
 // RegisterExtensionWebService registers the webhook webservice
 func (r Resource) RegisterExtensionWebService(container *restful.Container) {
 	ws := new(restful.WebService)
@@ -913,14 +1453,24 @@ func (r Resource) RegisterExtensionWebService(container *restful.Container) {
 		Consumes(restful.MIME_JSON, restful.MIME_JSON).
 		Produces(restful.MIME_JSON, restful.MIME_JSON)
 
-	ws.Route(ws.POST("/").To(r.createWebhook))
-	ws.Route(ws.GET("/").To(r.getAllWebhooks))
-	ws.Route(ws.GET("/defaults").To(r.getDefaults))
-	ws.Route(ws.DELETE("/{name}").To(r.deleteWebhook))
-
-	ws.Route(ws.POST("/credentials").To(r.createCredential))
-	ws.Route(ws.GET("/credentials").To(r.getAllCredentials))
-	ws.Route(ws.DELETE("/credentials/{name}").To(r.deleteCredential))
+	// Each route is authorized for the access to Kubernetes resources it gives its caller, see auth.go
+	ws.Filter(r.authenticate)
+
+	ws.Route(ws.POST("/").Filter(r.authorize(r.webhookChangeAccess("create"))).To(r.createWebhook))
+	ws.Route(ws.GET("/").Filter(r.authorize(authenticated)).To(r.getAllWebhooks))
+	ws.Route(ws.GET("/defaults").Filter(r.authorize(authenticated)).To(r.getDefaults))
+	ws.Route(ws.GET("/drift").Filter(r.authorize(allWebhooksAccess("list"))).To(r.getDrift))
+	ws.Route(ws.POST("/drift").Filter(r.authorize(allWebhooksAccess("update"))).To(r.checkDriftNow))
+	ws.Route(ws.GET("/audit").Filter(r.authorize(r.installedNamespaceAccess("list", "events"))).To(r.getAuditRecords))
+	ws.Route(ws.GET("/{name}").Filter(r.authorize(webhookAccess("get"))).To(r.getWebhook))
+	ws.Route(ws.PUT("/{name}").Filter(r.authorize(r.webhookChangeAccess("update"))).To(r.updateWebhook))
+	ws.Route(ws.DELETE("/{name}").Filter(r.authorize(webhookAccess("delete"))).To(r.deleteWebhook))
+
+	ws.Route(ws.POST("/credentials").Filter(r.authorize(r.installedNamespaceAccess("create", "secrets"))).To(r.createCredential))
+	ws.Route(ws.GET("/credentials").Filter(r.authorize(r.installedNamespaceAccess("list", "secrets"))).To(r.getAllCredentials))
+	ws.Route(ws.PUT("/credentials/{name}").Filter(r.authorize(r.installedNamespaceAccess("update", "secrets"))).To(r.updateCredential))
+	ws.Route(ws.POST("/credentials/{name}/rotate-secret").Filter(r.authorize(r.installedNamespaceAccess("update", "secrets"))).To(r.rotateCredentialSecret))
+	ws.Route(ws.DELETE("/credentials/{name}").Filter(r.authorize(r.installedNamespaceAccess("delete", "secrets"))).To(r.deleteCredential))
 
 	container.Add(ws)
 }
@@ -968,21 +1518,110 @@ func createOAuth2Client(ctx context.Context, accessToken string) *http.Client {
 	return oauth2.NewClient(ctx, ts)
 }
 
-// doGitHubWebhookRequest executes a GitHub PubSubHubbub request for the specified webhook.
-// hubMode: "subscribe" or "unsubscribe"
-// events: the list of events to subscribe to or unsubscribe from; for example, {"push", "pull_request"}
-func (r Resource) doGitHubWebhookRequest(webhook webhook, hubMode string, events []string) error {
+// isSupportedGitProvider returns whether webhooks can be registered with the git provider
+func isSupportedGitProvider(provider string) bool {
+	switch provider {
+	case "", gitHubProvider, gitLabProvider, bitbucketProvider, giteaProvider, gogsProvider:
+		return true
+	}
+	return false
+}
+
+// getGitProvider returns the git provider of the webhook, defaulting to GitHub
+func getGitProvider(webhook webhook) string {
+	if webhook.GitProvider == "" {
+		return gitHubProvider
+	}
+	return webhook.GitProvider
+}
+
+// getGitProviderClient returns a client authenticated with the access token of the webhook
+// and the secret token hooks for the webhook are signed with
+func (r Resource) getGitProviderClient(webhook webhook) (client *http.Client, secretToken string, err error) {
 	// Access token is stored as 'accessToken' and secret as 'secretToken'
 	accessToken, secretToken, err := r.getWebhookSecretTokens(webhook.AccessTokenRef)
 	if err != nil {
-		return err
+		return nil, "", err
+	}
+	if getGitProvider(webhook) == gitHubProvider {
+		// GitHub App credentials mint an installation token rather than storing a personal access token
+		accessToken, err = r.getGitHubAppAccessToken(webhook.AccessTokenRef, webhook.GitRepositoryURL, accessToken)
+		if err != nil {
+			return nil, "", err
+		}
 	}
 
-	// Create http client
+	// Create http client, recording the latency of its requests
 	ctx := context.Background()
-	client := createOAuth2Client(ctx, accessToken)
+	duration := gitProviderRequestDuration.MustCurryWith(prometheus.Labels{"provider": getGitProvider(webhook)})
+	return metrics.InstrumentClient(createOAuth2Client(ctx, accessToken), duration), secretToken, nil
+}
+
+// doWebhookRequest registers or removes the webhook with the git provider hosting the repository.
+// hubMode: "subscribe", "unsubscribe" or "update", which replaces the secret the hook is signed with and its events
+// events: the list of events to subscribe to or unsubscribe from; for example, {"push", "pull_request"}
+// Returns the ID of the hook registered with providers that identify hooks by an ID, otherwise 0.
+func (r Resource) doWebhookRequest(webhook webhook, hubMode string, events []string) (int64, error) {
+	return r.doSignedWebhookRequest(webhook, hubMode, events, "")
+}
+
+// doSignedWebhookRequest is doWebhookRequest with the hook signed with the secret token, unless it is empty,
+// rather than the secret token of the webhook's credential
+func (r Resource) doSignedWebhookRequest(webhook webhook, hubMode string, events []string, secretToken string) (int64, error) {
+	client, credentialSecretToken, err := r.getGitProviderClient(webhook)
+	if err != nil {
+		return 0, err
+	}
+	if secretToken == "" {
+		secretToken = credentialSecretToken
+	}
+	callback := r.getCallbackURL(r.getEventListenerName(webhook))
+
+	switch getGitProvider(webhook) {
+	case gitLabProvider:
+		return 0, doGitLabHookRequest(client, webhook.GitRepositoryURL, hubMode, callback, secretToken, events)
+	case bitbucketProvider:
+		return 0, doBitbucketHookRequest(client, webhook.GitRepositoryURL, hubMode, callback, secretToken, events)
+	case giteaProvider, gogsProvider:
+		// Gogs serves the same hook API as Gitea, only the payload format of the hook differs
+		return 0, doGiteaHookRequest(client, getGitProvider(webhook), webhook.GitRepositoryURL, hubMode, callback, secretToken, events)
+	default:
+		return doGitHubHookRequest(client, webhook.GitRepositoryURL, hubMode, callback, secretToken, webhook.HookID, events)
+	}
+}
 
-	return doGitHubHubbubRequest(client, webhook.GitRepositoryURL, hubMode, r.Defaults.CallbackURL, secretToken, events)
+// isHookRegistered returns whether the hook for the webhook's eventlistener is registered on the repository
+func (r Resource) isHookRegistered(webhook webhook) (bool, error) {
+	client, _, err := r.getGitProviderClient(webhook)
+	if err != nil {
+		return false, err
+	}
+	callback := r.getCallbackURL(r.getEventListenerName(webhook))
+
+	switch getGitProvider(webhook) {
+	case gitLabProvider:
+		return isGitLabHookRegistered(client, webhook.GitRepositoryURL, callback)
+	case bitbucketProvider:
+		return isBitbucketHookRegistered(client, webhook.GitRepositoryURL, callback)
+	case giteaProvider, gogsProvider:
+		return isGiteaHookRegistered(client, webhook.GitRepositoryURL, callback)
+	default:
+		return isGitHubHookRegistered(client, webhook.GitRepositoryURL, callback, webhook.HookID)
+	}
+}
+
+// getNextPageLink returns the URL of the next page of results from the Link header of a page,
+// as GitHub and Gitea paginate lists, or an empty string on the last page
+func getNextPageLink(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+	return ""
 }
 
 // createOpenshiftRoute attempts to create an Openshift Route on the service.
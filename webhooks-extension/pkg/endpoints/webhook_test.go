@@ -29,6 +29,7 @@ import (
 	restful "github.com/emicklei/go-restful"
 	"github.com/google/go-cmp/cmp"
 	routesv1 "github.com/openshift/api/route/v1"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 	pipelinesv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	v1alpha1 "github.com/tektoncd/triggers/pkg/apis/triggers/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
@@ -97,7 +98,7 @@ func TestNewTrigger(t *testing.T) {
 		{Name: "My-Param2", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "myParam2Value"}},
 	}
 
-	trigger := r.newTrigger("myName", "myBindingName", "myTemplateName", "myRepoURL", "myEvent", "mySecretName", params)
+	trigger := r.newTrigger("myName", "myBindingName", "myTemplateName", "myRepoURL", "myEvent", "mySecretName", "", params)
 	expectedTrigger := createTrigger("myName", "myBindingName", "myTemplateName", "myRepoURL", "myEvent", "mySecretName", params, r)
 
 	if !reflect.DeepEqual(trigger, expectedTrigger) {
@@ -385,7 +386,7 @@ func TestDeleteFromEventListener(t *testing.T) {
 		t.Errorf("expected: %+v", expectedTriggers)
 	}
 
-	err = r.deleteFromEventListener(hooks[1].Name+"-"+hooks[1].Namespace, "install-namespace", hooks[1].GitRepositoryURL[strings.LastIndex(hooks[1].GitRepositoryURL, ":")+3:], "https://github.com/owner/repo")
+	_, err = r.deleteFromEventListener(hooks[1].Name+"-"+hooks[1].Namespace, "install-namespace", eventListenerName, hooks[1].GitRepositoryURL[strings.LastIndex(hooks[1].GitRepositoryURL, ":")+3:], "https://github.com/owner/repo")
 	if err != nil {
 		t.Errorf("Error deleting entry from eventlistener: %s", err)
 	}
@@ -458,6 +459,161 @@ func TestCreateAndDeleteWebhook(t *testing.T) {
 	testGetAllWebhooks([]webhook{}, r, t)
 }
 
+func TestUpdateWebhook(t *testing.T) {
+	r := dummyResource()
+	r.Defaults = EnvDefaults{Namespace: installNs}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: "https://github.com/owner/repo",
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+		ReleaseName:      "repo",
+		PullTask:         "monitor-task",
+		HookID:           7,
+		EventListener:    eventListenerName,
+	}
+	createTriggerResources(hook, r)
+	createTriggerResources(webhook{Pipeline: "pipeline2", AccessTokenRef: "token1"}, r)
+	if _, err := r.createEventListener(hook, installNs, "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+
+	// The repository identifies the webhook, fields not specified are reset to their defaults
+	update := webhook{
+		Namespace:        "foo",
+		GitRepositoryURL: "https://github.com/owner/repo.git",
+		Pipeline:         "pipeline2",
+		ServiceAccount:   "my-sa",
+		OnSuccessComment: "Passed",
+	}
+	resp := updateWebhook("name1", update, r)
+	if resp.StatusCode() != http.StatusOK {
+		t.Fatalf("Webhook update failed with status %d", resp.StatusCode())
+	}
+
+	expected := hook
+	expected.Pipeline = "pipeline2"
+	expected.ServiceAccount = "my-sa"
+	expected.Events = defaultEvents
+	expected.Actions = map[string][]string{pullRequestEvent: defaultActions[pullRequestEvent]}
+	hooks, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		t.Fatalf("Error getting webhooks: %s", err)
+	}
+	if len(hooks) != 1 || !reflect.DeepEqual(hooks[0], expected) {
+		t.Errorf("Webhook not updated as expected, expected: %+v, got: %+v", expected, hooks)
+	}
+
+	el, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting eventlistener: %s", err)
+	}
+	if len(el.Spec.Triggers) != 3 || el.Spec.Triggers[2].Name != "github.com/owner/repo" {
+		t.Fatalf("Expected the triggers to be replaced in place, got: %+v", el.Spec.Triggers)
+	}
+	for _, param := range el.Spec.Triggers[2].Params {
+		if param.Name == "commentsuccess" && param.Value.StringVal != "Passed" {
+			t.Errorf("Monitor trigger success comment was %s, expected Passed", param.Value.StringVal)
+		}
+	}
+}
+
+func TestUpdateWebhookBadRequest(t *testing.T) {
+	r := dummyResource()
+	r.Defaults = EnvDefaults{Namespace: installNs}
+	hooks := []webhook{
+		{
+			Name:             "name1",
+			Namespace:        "foo",
+			GitRepositoryURL: "https://github.com/owner/repo",
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline1",
+			ReleaseName:      "repo",
+			PullTask:         "monitor-task",
+			EventListener:    eventListenerName,
+			Events:           defaultEvents,
+			Actions:          map[string][]string{pullRequestEvent: defaultActions[pullRequestEvent]},
+		},
+		{
+			Name:             "name2",
+			Namespace:        "foo",
+			GitRepositoryURL: "https://github.com/owner/repo",
+			AccessTokenRef:   "token1",
+			Pipeline:         "pipeline2",
+			ReleaseName:      "repo",
+			PullTask:         "monitor-task",
+			EventListener:    eventListenerName,
+			Events:           defaultEvents,
+			Actions:          map[string][]string{pullRequestEvent: defaultActions[pullRequestEvent]},
+		},
+	}
+	for _, hook := range hooks {
+		createTriggerResources(hook, r)
+	}
+	el, err := r.createEventListener(hooks[0], installNs, "github.com/owner/repo")
+	if err != nil {
+		t.Fatalf("Error creating eventlistener: %s", err)
+	}
+	if _, err := r.updateEventListener(el, hooks[1], "github.com/owner/repo"); err != nil {
+		t.Fatalf("Error updating eventlistener: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		hookName string
+		update   webhook
+		want     int
+	}{
+		{name: "not found", hookName: "name3", update: webhook{Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1"}, want: http.StatusNotFound},
+		{name: "other namespace", hookName: "name1", update: webhook{Namespace: "bar", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1"}, want: http.StatusNotFound},
+		{name: "no repository", hookName: "name1", update: webhook{Namespace: "foo", Pipeline: "pipeline1"}, want: http.StatusBadRequest},
+		{name: "renamed", hookName: "name1", update: webhook{Name: "other", Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline1"}, want: http.StatusBadRequest},
+		{name: "access token changed", hookName: "name1", update: webhook{Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", AccessTokenRef: "token2", Pipeline: "pipeline1"}, want: http.StatusBadRequest},
+		{name: "provider changed", hookName: "name1", update: webhook{Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", GitProvider: "gitlab", Pipeline: "pipeline1"}, want: http.StatusBadRequest},
+		{name: "pipeline of other webhook", hookName: "name1", update: webhook{Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline2"}, want: http.StatusBadRequest},
+		{name: "no trigger resources", hookName: "name1", update: webhook{Namespace: "foo", GitRepositoryURL: "https://github.com/owner/repo", Pipeline: "pipeline3"}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := updateWebhook(tt.hookName, tt.update, r); resp.StatusCode() != tt.want {
+				t.Errorf("Webhook update returned status %d, expected %d", resp.StatusCode(), tt.want)
+			}
+		})
+	}
+
+	actual, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		t.Fatalf("Error getting webhooks: %s", err)
+	}
+	if !reflect.DeepEqual(actual, hooks) {
+		t.Errorf("Webhooks changed by rejected updates, expected: %+v, got: %+v", hooks, actual)
+	}
+}
+
+func TestGetWebhookNotFound(t *testing.T) {
+	setUpServer()
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/webhooks/foo", want: http.StatusBadRequest},
+		{path: "/webhooks/foo?repository=bar", want: http.StatusBadRequest},
+		{path: "/webhooks/foo?namespace=foo", want: http.StatusBadRequest},
+		{path: "/webhooks/foo?namespace=foo&repository=https://github.com/owner/repo", want: http.StatusNotFound},
+		{path: "/webhooks/defaults", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		response, err := http.Get(server.URL + tt.path)
+		if err != nil {
+			t.Fatalf("Error getting %s: %s", tt.path, err)
+		}
+		if response.StatusCode != tt.want {
+			t.Errorf("Status code for %s was %d, expected %d", tt.path, response.StatusCode, tt.want)
+		}
+	}
+}
+
 func TestDockerRegUnset(t *testing.T) {
 	r := dummyResource()
 	// Get the docker registry using the endpoint, expect ""
@@ -601,7 +757,7 @@ func getExpectedParams(hook webhook, r *Resource) (expectedHookParams, expectedM
 }
 
 func getExpectedTriggers(hook webhook, monitorTriggerName string, r *Resource) []v1alpha1.EventListenerTrigger {
-	actions = pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "opened,reopened,synchronize"}}
+	actions := pipelinesv1alpha1.Param{Name: "Wext-Incoming-Actions", Value: pipelinesv1alpha1.ArrayOrString{Type: pipelinesv1alpha1.ParamTypeString, StringVal: "opened,reopened,synchronize"}}
 	expectedHookParams, expectedMonitorParams := getExpectedParams(hook, r)
 	push := createTrigger(hook.Name+"-"+hook.Namespace+"-push-event",
 		hook.Pipeline+"-push-binding",
@@ -695,6 +851,22 @@ func createWebhook(webhook webhook, r *Resource) (response *restful.Response) {
 	return resp
 }
 
+func updateWebhook(name string, webhook webhook, r *Resource) (response *restful.Response) {
+
+	b, err := json.Marshal(webhook)
+	if err != nil {
+		fmt.Println(fmt.Errorf("Marshal error when updating webhook, data is: %s, error is: %s", b, err))
+		return nil
+	}
+
+	httpReq := dummyHTTPRequest("PUT", "http://wwww.dummy.com:8080/webhooks/"+name, bytes.NewBuffer(b))
+	req := dummyRestfulRequest(httpReq, name)
+	httpWriter := httptest.NewRecorder()
+	resp := dummyRestfulResponse(httpWriter)
+	r.updateWebhook(req, resp)
+	return resp
+}
+
 func testGetAllWebhooks(expectedWebhooks []webhook, r *Resource, t *testing.T) {
 	httpReq := dummyHTTPRequest("GET", "http://wwww.dummy.com:8080/webhooks/", nil)
 	req := dummyRestfulRequest(httpReq, "")
@@ -716,8 +888,8 @@ func testGetAllWebhooks(expectedWebhooks []webhook, r *Resource, t *testing.T) {
 	}
 
 	// Now compare the arrays expectedWebhooks and actualWebhooks by turning them into maps
-	expected := map[webhook]bool{}
-	actual := map[webhook]bool{}
+	expected := map[string]bool{}
+	actual := map[string]bool{}
 	for i := range expectedWebhooks {
 		if expectedWebhooks[i].DockerRegistry == "" {
 			expectedWebhooks[i].DockerRegistry = defaultRegistry
@@ -725,8 +897,15 @@ func testGetAllWebhooks(expectedWebhooks []webhook, r *Resource, t *testing.T) {
 		if expectedWebhooks[i].PullTask == "" {
 			expectedWebhooks[i].PullTask = "monitor-task"
 		}
-		expected[expectedWebhooks[i]] = true
-		actual[actualWebhooks[i]] = true
+		if expectedWebhooks[i].EventListener == "" {
+			expectedWebhooks[i].EventListener = eventListenerName
+		}
+		if len(expectedWebhooks[i].Events) == 0 {
+			expectedWebhooks[i].Events = defaultEvents
+			expectedWebhooks[i].Actions = map[string][]string{pullRequestEvent: defaultActions[pullRequestEvent]}
+		}
+		expected[fmt.Sprintf("%+v", expectedWebhooks[i])] = true
+		actual[fmt.Sprintf("%+v", actualWebhooks[i])] = true
 	}
 
 	if !reflect.DeepEqual(expected, actual) {
@@ -985,3 +1164,15 @@ func Test_deleteOpenshiftRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	request := dummyRestfulRequest(dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/hook", nil), "hook")
+	request.SetAttribute(callerAttribute, caller{User: "alice"})
+	logger := requestLogger(request, "webhook", "hook", "namespace", "foo")
+	if logging.FromContext(request.Request.Context()) != logger {
+		t.Error("Expected the request logger to be carried by the context of the request")
+	}
+	if logger == logging.Log {
+		t.Error("Expected the request logger to have the fields of the request")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+/*--------------------------------------
+A webhook is created in steps: its triggers are added to the eventlistener
+(creating the eventlistener if needed), the Ingress or Route exposing a new
+eventlistener is created, and the hook is registered with the git provider.
+Each step is recorded on the webhook resource before it is taken and marked
+done once taken. If a step fails, the steps taken are undone in reverse order.
+
+A creation not updated for webhookCreationTimeout was interrupted, for example
+by the pod restarting. The webhook controller rolls back its recorded steps,
+undoing those not marked done in case they were taken, before creating the
+webhook again or, if the webhook resource is being deleted, removing it. A
+rollback that fails leaves the steps not yet undone recorded, and is retried
+by the controller once the creation times out.
+---------------------------------------*/
+
+const (
+	creationStepTriggers = "triggers"
+	creationStepIngress  = "ingress"
+	creationStepRoute    = "route"
+	creationStepHook     = "hook"
+
+	webhookCreationTimeout = 2 * time.Minute
+)
+
+var errWebhookBeingCreated = errors.New("the webhook is already being created")
+
+// webhookCreation is the creation of a webhook, recorded on its webhook resource
+type webhookCreation struct {
+	r Resource
+	// hook is the webhook being created, as recorded in the spec of the webhook resource
+	hook  webhook
+	steps []webhooksv1alpha1.WebhookCreationStep
+}
+
+// isCreationInProgress returns whether the webhook is being created and the creation has not timed out
+func isCreationInProgress(wh webhooksv1alpha1.Webhook) bool {
+	return wh.Status.Creation != nil && time.Since(wh.Status.Creation.Updated.Time) < webhookCreationTimeout
+}
+
+// beginWebhookCreation starts recording the creation of the webhook on its webhook resource,
+// first rolling back any interrupted creation of the webhook
+func (r Resource) beginWebhookCreation(name, namespace string) (*webhookCreation, error) {
+	wh, err := r.getWebhookResource(name, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if isCreationInProgress(wh) {
+		return nil, errWebhookBeingCreated
+	}
+	if wh.Status.Creation != nil {
+		if err := r.rollBackWebhookCreation(wh); err != nil {
+			return nil, err
+		}
+	}
+	creation := &webhookCreation{r: r, hook: getWebhookFromResource(wh), steps: []webhooksv1alpha1.WebhookCreationStep{}}
+	return creation, creation.record()
+}
+
+// rollBackWebhookCreation rolls back the interrupted creation recorded on the webhook resource
+func (r Resource) rollBackWebhookCreation(wh webhooksv1alpha1.Webhook) error {
+	logging.Log.Infof("Rolling back the interrupted creation of webhook %s in namespace %s", wh.Name, wh.Namespace)
+	creation := &webhookCreation{r: r, hook: getWebhookFromResource(wh), steps: wh.Status.Creation.Steps}
+	return creation.rollback()
+}
+
+// record records the steps on the webhook resource, together with the webhook being created
+func (c *webhookCreation) record() error {
+	return c.r.modifyWebhookResource(c.hook.Name, c.hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+		wh.Spec = getWebhookSpec(c.hook)
+		if !hasWebhookFinalizer(*wh) {
+			wh.Finalizers = append(wh.Finalizers, webhookFinalizer)
+		}
+		wh.Status.Creation = &webhooksv1alpha1.WebhookCreation{Updated: metav1.Now(), Steps: c.steps}
+	})
+}
+
+// do takes the step, recording it before and after. A step that fails is expected to have made no changes.
+func (c *webhookCreation) do(name string, take func(step *webhooksv1alpha1.WebhookCreationStep) error) error {
+	c.steps = append(c.steps, webhooksv1alpha1.WebhookCreationStep{Name: name})
+	if err := c.record(); err != nil {
+		c.steps = c.steps[:len(c.steps)-1]
+		return fmt.Errorf("error recording the %s step creating the webhook: %s", name, err)
+	}
+	step := &c.steps[len(c.steps)-1]
+	if err := take(step); err != nil {
+		c.steps = c.steps[:len(c.steps)-1]
+		return err
+	}
+	step.Done = true
+	// The step is undone on rolling back whether or not it was recorded as done
+	if err := c.record(); err != nil {
+		logging.Log.Errorf("error recording the %s step creating webhook %s as done: %s", name, c.hook.Name, err)
+	}
+	return nil
+}
+
+// rollback undoes the steps in reverse order, recording the steps not undone if undoing a step fails
+func (c *webhookCreation) rollback() error {
+	for len(c.steps) > 0 {
+		step := c.steps[len(c.steps)-1]
+		if err := c.r.undoWebhookCreationStep(c.hook, step); err != nil {
+			if recordErr := c.record(); recordErr != nil {
+				logging.Log.Errorf("error recording the steps creating webhook %s left to roll back: %s", c.hook.Name, recordErr)
+			}
+			return fmt.Errorf("error undoing the %s step creating the webhook: %s", step.Name, err)
+		}
+		logging.Log.Debugf("Undid the %s step creating webhook %s in namespace %s", step.Name, c.hook.Name, c.hook.Namespace)
+		c.steps = c.steps[:len(c.steps)-1]
+	}
+	err := c.r.modifyWebhookResource(c.hook.Name, c.hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+		wh.Status.Creation = nil
+	})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// undoWebhookCreationStep undoes a step creating the webhook, including a step that may not have been taken
+func (r Resource) undoWebhookCreationStep(hook webhook, step webhooksv1alpha1.WebhookCreationStep) error {
+	installNs := r.Defaults.Namespace
+	var err error
+	switch step.Name {
+	case creationStepTriggers:
+		var monitorTriggerName string
+		if monitorTriggerName, err = getMonitorTriggerName(hook.GitRepositoryURL); err != nil {
+			return err
+		}
+		// Deletes the eventlistener, with its Ingress or Route, if no other triggers remain
+		_, err = r.deleteFromEventListener(hook.Name+"-"+hook.Namespace, installNs, hook.EventListener, monitorTriggerName, hook.GitRepositoryURL)
+	case creationStepIngress:
+		err = r.createDeleteIngress("delete", installNs, hook.EventListener)
+	case creationStepRoute:
+		err = r.deleteOpenshiftRoute(getEventListenerServiceName(hook.EventListener))
+	case creationStepHook:
+		// Without the ID the hook is found by its callback URL
+		hook.HookID = step.HookID
+		// The hook may not have been registered, or may have been removed since
+		if registered, checkErr := r.isHookRegistered(hook); checkErr == nil && !registered {
+			return nil
+		}
+		_, err = r.doWebhookRequest(hook, "unsubscribe", getHookEvents(hook))
+	default:
+		return fmt.Errorf("unknown step %s", step.Name)
+	}
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
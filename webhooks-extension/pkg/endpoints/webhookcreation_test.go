@@ -0,0 +1,178 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCreateWebhookRollsBackOnFailure(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+	// Eventlisteners are exposed by an Ingress
+	os.Unsetenv("PLATFORM")
+
+	// The hook can't be registered on a repository of a git provider that is down
+	down := newFakeGiteaServer("access")
+	down.Close()
+	failing := hook
+	failing.Name = "name2"
+	failing.GitRepositoryURL = down.repoURL("owner", "repo")
+	failing.Pipeline = "pipeline2"
+	createTriggerResources(failing, r)
+
+	// The eventlistener and its ingress created for the webhook are deleted
+	if resp := createWebhook(failing, r); resp.StatusCode() != http.StatusInternalServerError {
+		t.Fatalf("Expected webhook creation to fail with status 500, got %d", resp.StatusCode())
+	}
+	if _, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the eventlistener created for the webhook to be deleted")
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+eventListenerName, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the ingress created for the webhook to be deleted")
+	}
+	if _, err := r.getWebhookResource(failing.Name, failing.Namespace); err == nil {
+		t.Errorf("Expected the webhook resource to be deleted")
+	}
+
+	// The triggers added to an existing eventlistener are removed, leaving those of other webhooks
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	if resp := createWebhook(failing, r); resp.StatusCode() != http.StatusInternalServerError {
+		t.Fatalf("Expected webhook creation to fail with status 500, got %d", resp.StatusCode())
+	}
+	hooks, err := r.getWebhooksFromEventListeners()
+	if err != nil || len(hooks) != 1 || hooks[0].Name != hook.Name {
+		t.Errorf("Expected only the triggers of the existing webhook in the eventlistener; got: %+v, %v", hooks, err)
+	}
+	if _, err := r.K8sClient.ExtensionsV1beta1().Ingresses(installNs).Get("el-"+eventListenerName, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the ingress of the existing eventlistener to remain: %s", err)
+	}
+}
+
+func TestCreateWebhookValidatesBeforeCreatingWebhookResource(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	invalid := hook
+	invalid.Namespace = ""
+	if resp := createWebhook(invalid, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Errorf("Expected webhook creation without a namespace to fail with status 400, got %d", resp.StatusCode())
+	}
+	invalid = hook
+	invalid.GitProvider = "unsupported"
+	if resp := createWebhook(invalid, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Errorf("Expected webhook creation with an unsupported git provider to fail with status 400, got %d", resp.StatusCode())
+	}
+	if _, err := r.getWebhookResource(invalid.Name, invalid.Namespace); err == nil {
+		t.Errorf("Expected no webhook resource for an invalid webhook")
+	}
+
+	// The webhook resource of a webhook being created through the API is left alone by the webhook controller
+	creation, err := r.createPendingWebhookResource(hook)
+	if err != nil || creation == nil {
+		t.Fatalf("Error creating pending webhook resource: %v", err)
+	}
+	if err := r.reconcileWebhookResource(hook.Name, hook.Namespace); err != nil {
+		t.Fatalf("Error reconciling webhook resource: %s", err)
+	}
+	if hooks, err := r.getWebhooksFromEventListeners(); err != nil || len(hooks) != 0 {
+		t.Errorf("Expected the controller not to add the webhook being created; got: %+v, %v", hooks, err)
+	}
+}
+
+// interruptCreation makes the webhook resource of a webhook in place look as if the pod restarted
+// just before the webhook was recorded as created
+func interruptCreation(t *testing.T, r *Resource, hook webhook, updated time.Time) {
+	wh, err := r.getWebhookResource(hook.Name, hook.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting webhook resource: %s", err)
+	}
+	err = r.modifyWebhookResource(hook.Name, hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+		delete(wh.Annotations, appliedSpecAnnotation)
+		wh.Status.Creation = &webhooksv1alpha1.WebhookCreation{
+			Updated: metav1.NewTime(updated),
+			Steps: []webhooksv1alpha1.WebhookCreationStep{
+				{Name: creationStepTriggers, Done: true},
+				{Name: creationStepIngress, Done: true},
+				{Name: creationStepHook, HookID: wh.Status.HookID},
+			},
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error updating webhook resource %s: %s", wh.Name, err)
+	}
+}
+
+func TestRollBackInterruptedWebhookCreation(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	interruptedHooks := gitea.getHooks("owner", "repo")
+
+	// A creation in progress is left alone
+	interruptCreation(t, r, hook, time.Now())
+	r.reconcileWebhookResources()
+	if wh, _ := r.getWebhookResource(hook.Name, hook.Namespace); wh.Status.Creation == nil {
+		t.Fatalf("Expected the creation in progress to be left alone")
+	}
+
+	// An interrupted creation is rolled back and the webhook created again
+	interruptCreation(t, r, hook, time.Now().Add(-webhookCreationTimeout))
+	r.reconcileWebhookResources()
+	wh, err := r.getWebhookResource(hook.Name, hook.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting webhook resource: %s", err)
+	}
+	if wh.Status.Creation != nil || !isReconciled(wh) {
+		t.Errorf("Expected the webhook resource to be recorded as reconciled; got: %+v", wh)
+	}
+	giteaHooks := gitea.getHooks("owner", "repo")
+	if len(interruptedHooks) != 1 || len(giteaHooks) != 1 || giteaHooks[0].ID == interruptedHooks[0].ID {
+		t.Errorf("Expected the hook of the interrupted creation to be replaced; got: %+v", giteaHooks)
+	}
+	if hooks, err := r.getWebhooksFromEventListeners(); err != nil || len(hooks) != 1 {
+		t.Errorf("Expected the triggers of the webhook in the eventlistener; got: %+v, %v", hooks, err)
+	}
+
+	// An interrupted creation of a deleted webhook resource is rolled back and the resource deleted
+	interruptCreation(t, r, hook, time.Now().Add(-webhookCreationTimeout))
+	err = r.modifyWebhookResource(hook.Name, hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+		now := metav1.Now()
+		wh.DeletionTimestamp = &now
+	})
+	if err != nil {
+		t.Fatalf("Error updating webhook resource: %s", err)
+	}
+	r.reconcileWebhookResources()
+	if _, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the eventlistener to be deleted")
+	}
+	if len(gitea.getHooks("owner", "repo")) != 0 {
+		t.Errorf("Expected the hook to be removed from Gitea")
+	}
+	if _, err := r.getWebhookResource(hook.Name, hook.Namespace); err == nil {
+		t.Errorf("Expected the webhook resource to be deleted")
+	}
+}
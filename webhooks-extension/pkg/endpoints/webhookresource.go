@@ -0,0 +1,483 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+/*--------------------------------------
+Webhooks are recorded as Webhook resources, named after the webhook in the
+namespace the pipeline runs in. The resource is the source of truth for the
+webhook, as the eventlistener triggers don't hold all of its fields.
+
+Webhooks created through the extension API are recorded before their triggers
+and git provider hook are created, for the steps creating them to be recorded,
+see webhookcreation.go. The controller reconciles the eventlistener triggers
+and git provider hook of webhook resources created, edited or deleted directly,
+and records webhooks created before they were recorded as resources. A webhook
+resource with the applied spec annotation has been reconciled at least once,
+the annotation being the spec last reconciled.
+---------------------------------------*/
+
+const (
+	// webhookFinalizer keeps a webhook resource until its triggers and hook are removed
+	webhookFinalizer = "webhooks.tekton.dev"
+
+	// appliedSpecAnnotation is the webhook spec as last reconciled
+	appliedSpecAnnotation = "webhooks.tekton.dev/applied-spec"
+
+	webhookResyncPeriod = 30 * time.Second
+)
+
+// getWebhookSpec returns the spec of the webhook resource for the webhook
+func getWebhookSpec(hook webhook) webhooksv1alpha1.WebhookSpec {
+	return webhooksv1alpha1.WebhookSpec{
+		ServiceAccount:   hook.ServiceAccount,
+		GitRepositoryURL: hook.GitRepositoryURL,
+		AccessTokenRef:   hook.AccessTokenRef,
+		Pipeline:         hook.Pipeline,
+		DockerRegistry:   hook.DockerRegistry,
+		HelmSecret:       hook.HelmSecret,
+		ReleaseName:      hook.ReleaseName,
+		PullTask:         hook.PullTask,
+		OnSuccessComment: hook.OnSuccessComment,
+		OnFailureComment: hook.OnFailureComment,
+		OnTimeoutComment: hook.OnTimeoutComment,
+		GitProvider:      hook.GitProvider,
+		EventListener:    hook.EventListener,
+		Events:           hook.Events,
+		Actions:          hook.Actions,
+		Branches:         hook.Branches,
+		ExcludeBranches:  hook.ExcludeBranches,
+		Paths:            hook.Paths,
+		ExcludePaths:     hook.ExcludePaths,
+		ForkPolicy:       hook.ForkPolicy,
+		ForkPermission:   hook.ForkPermission,
+		ForkTeam:         hook.ForkTeam,
+		ForkComment:      hook.ForkComment,
+	}
+}
+
+// getWebhookFromResource returns the webhook recorded by the webhook resource
+func getWebhookFromResource(wh webhooksv1alpha1.Webhook) webhook {
+	return webhook{
+		Name:             wh.Name,
+		Namespace:        wh.Namespace,
+		ServiceAccount:   wh.Spec.ServiceAccount,
+		GitRepositoryURL: wh.Spec.GitRepositoryURL,
+		AccessTokenRef:   wh.Spec.AccessTokenRef,
+		Pipeline:         wh.Spec.Pipeline,
+		DockerRegistry:   wh.Spec.DockerRegistry,
+		HelmSecret:       wh.Spec.HelmSecret,
+		ReleaseName:      wh.Spec.ReleaseName,
+		PullTask:         wh.Spec.PullTask,
+		OnSuccessComment: wh.Spec.OnSuccessComment,
+		OnFailureComment: wh.Spec.OnFailureComment,
+		OnTimeoutComment: wh.Spec.OnTimeoutComment,
+		GitProvider:      wh.Spec.GitProvider,
+		HookID:           wh.Status.HookID,
+		EventListener:    wh.Spec.EventListener,
+		Events:           wh.Spec.Events,
+		Actions:          wh.Spec.Actions,
+		Branches:         wh.Spec.Branches,
+		ExcludeBranches:  wh.Spec.ExcludeBranches,
+		Paths:            wh.Spec.Paths,
+		ExcludePaths:     wh.Spec.ExcludePaths,
+		ForkPolicy:       wh.Spec.ForkPolicy,
+		ForkPermission:   wh.Spec.ForkPermission,
+		ForkTeam:         wh.Spec.ForkTeam,
+		ForkComment:      wh.Spec.ForkComment,
+	}
+}
+
+// getAppliedSpec returns the value of the applied spec annotation for the spec
+func getAppliedSpec(spec webhooksv1alpha1.WebhookSpec) string {
+	applied, _ := json.Marshal(spec)
+	return string(applied)
+}
+
+// isReconciled returns whether the webhook resource has been reconciled at least once
+func isReconciled(wh webhooksv1alpha1.Webhook) bool {
+	_, reconciled := wh.Annotations[appliedSpecAnnotation]
+	return reconciled
+}
+
+func hasWebhookFinalizer(wh webhooksv1alpha1.Webhook) bool {
+	for _, finalizer := range wh.Finalizers {
+		if finalizer == webhookFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func fromUnstructuredWebhook(u *unstructured.Unstructured) (webhooksv1alpha1.Webhook, error) {
+	wh := webhooksv1alpha1.Webhook{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &wh)
+	return wh, err
+}
+
+func toUnstructuredWebhook(wh webhooksv1alpha1.Webhook) (*unstructured.Unstructured, error) {
+	wh.APIVersion = webhooksv1alpha1.SchemeGroupVersion.String()
+	wh.Kind = webhooksv1alpha1.WebhookKind
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&wh)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+// setReconciled records the webhook as reconciled on the webhook resource
+func setReconciled(wh *webhooksv1alpha1.Webhook, hook webhook) {
+	wh.Spec = getWebhookSpec(hook)
+	if wh.Annotations == nil {
+		wh.Annotations = map[string]string{}
+	}
+	wh.Annotations[appliedSpecAnnotation] = getAppliedSpec(wh.Spec)
+	if !hasWebhookFinalizer(*wh) {
+		wh.Finalizers = append(wh.Finalizers, webhookFinalizer)
+	}
+	wh.Status = webhooksv1alpha1.WebhookStatus{HookID: hook.HookID}
+}
+
+// getWebhookResources returns the webhook resources in all namespaces
+func (r Resource) getWebhookResources() ([]webhooksv1alpha1.Webhook, error) {
+	list, err := r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	resources := []webhooksv1alpha1.Webhook{}
+	for i := range list.Items {
+		wh, err := fromUnstructuredWebhook(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, wh)
+	}
+	return resources, nil
+}
+
+func (r Resource) getWebhookResource(name, namespace string) (webhooksv1alpha1.Webhook, error) {
+	u, err := r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return webhooksv1alpha1.Webhook{}, err
+	}
+	return fromUnstructuredWebhook(u)
+}
+
+// modifyWebhookResource applies modify to the webhook resource and updates it, retrying on a conflict
+func (r Resource) modifyWebhookResource(name, namespace string, modify func(wh *webhooksv1alpha1.Webhook)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		wh, err := r.getWebhookResource(name, namespace)
+		if err != nil {
+			return err
+		}
+		modify(&wh)
+		u, err := toUnstructuredWebhook(wh)
+		if err != nil {
+			return err
+		}
+		_, err = r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(namespace).Update(u, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// createWebhookResource records the webhook, which is in place, as a webhook resource
+func (r Resource) createWebhookResource(hook webhook) error {
+	wh := webhooksv1alpha1.Webhook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hook.Name,
+			Namespace: hook.Namespace,
+		},
+	}
+	setReconciled(&wh, hook)
+	u, err := toUnstructuredWebhook(wh)
+	if err != nil {
+		return err
+	}
+	_, err = r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(hook.Namespace).Create(u, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		return r.modifyWebhookResource(hook.Name, hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+			setReconciled(wh, hook)
+		})
+	}
+	return err
+}
+
+// createPendingWebhookResource creates the webhook resource for a valid webhook about to be created, with its
+// creation begun so the webhook controller leaves it to the request creating it. The creation is returned, or
+// nil if the webhook resource already exists.
+func (r Resource) createPendingWebhookResource(hook webhook) (*webhookCreation, error) {
+	u, err := toUnstructuredWebhook(webhooksv1alpha1.Webhook{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       hook.Name,
+			Namespace:  hook.Namespace,
+			Finalizers: []string{webhookFinalizer},
+		},
+		Spec: getWebhookSpec(hook),
+		Status: webhooksv1alpha1.WebhookStatus{
+			Creation: &webhooksv1alpha1.WebhookCreation{Updated: metav1.Now(), Steps: []webhooksv1alpha1.WebhookCreationStep{}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(hook.Namespace).Create(u, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhookCreation{r: r, hook: hook, steps: []webhooksv1alpha1.WebhookCreationStep{}}, nil
+}
+
+// discardWebhookResource deletes the webhook resource of a webhook that could not be created. If steps creating
+// the webhook remain to be rolled back, the finalizer keeps the resource until the controller has rolled them back.
+func (r Resource) discardWebhookResource(name, namespace string) error {
+	wh, err := r.getWebhookResource(name, namespace)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if wh.Status.Creation == nil {
+		return r.deleteWebhookResource(name, namespace)
+	}
+	return r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(namespace).Delete(name, &metav1.DeleteOptions{})
+}
+
+// updateWebhookResource records the webhook, which is in place, on its webhook resource
+func (r Resource) updateWebhookResource(hook webhook) error {
+	err := r.modifyWebhookResource(hook.Name, hook.Namespace, func(wh *webhooksv1alpha1.Webhook) {
+		setReconciled(wh, hook)
+	})
+	if k8serrors.IsNotFound(err) {
+		return r.createWebhookResource(hook)
+	}
+	return err
+}
+
+// recordWebhookResourceError records the error reconciling the webhook on its webhook resource, returning the error
+func (r Resource) recordWebhookResourceError(name, namespace string, reconcileErr error) error {
+	err := r.modifyWebhookResource(name, namespace, func(wh *webhooksv1alpha1.Webhook) {
+		wh.Status.Error = reconcileErr.Error()
+	})
+	if err != nil {
+		logging.Log.Errorf("error recording the error reconciling webhook %s in namespace %s: %s", name, namespace, err)
+	}
+	return reconcileErr
+}
+
+// deleteWebhookResource deletes the webhook resource of a webhook that has been removed
+func (r Resource) deleteWebhookResource(name, namespace string) error {
+	err := r.modifyWebhookResource(name, namespace, func(wh *webhooksv1alpha1.Webhook) {
+		finalizers := []string{}
+		for _, finalizer := range wh.Finalizers {
+			if finalizer != webhookFinalizer {
+				finalizers = append(finalizers, finalizer)
+			}
+		}
+		wh.Finalizers = finalizers
+	})
+	if err == nil {
+		err = r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(namespace).Delete(name, &metav1.DeleteOptions{})
+	}
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// getTriggerPrefix identifies the triggers of a webhook in all eventlisteners
+func (r Resource) getTriggerPrefix(hook webhook) string {
+	return r.getEventListenerName(hook) + "/" + hook.Name + "-" + hook.Namespace
+}
+
+// getWebhooks returns the webhooks recorded as webhook resources, together with any webhooks only found as
+// eventlistener triggers, created before webhooks were recorded as resources. The hook ID, events and actions
+// are taken from the triggers, which are what is used to validate events.
+func (r Resource) getWebhooks() ([]webhook, error) {
+	fromTriggers, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := r.getWebhookResources()
+	if err != nil {
+		logging.Log.Errorf("error getting webhook resources, returning the webhooks in the eventlisteners: %s", err)
+		return fromTriggers, nil
+	}
+
+	recorded := map[string]webhook{}
+	for _, wh := range resources {
+		hook := getWebhookFromResource(wh)
+		recorded[r.getTriggerPrefix(hook)] = hook
+	}
+	hooks := []webhook{}
+	for _, hook := range fromTriggers {
+		prefix := r.getTriggerPrefix(hook)
+		if recordedHook, ok := recorded[prefix]; ok {
+			recordedHook.HookID = hook.HookID
+			recordedHook.EventListener = hook.EventListener
+			recordedHook.Events = hook.Events
+			recordedHook.Actions = hook.Actions
+			recordedHook.Branches = hook.Branches
+			recordedHook.ExcludeBranches = hook.ExcludeBranches
+			recordedHook.Paths = hook.Paths
+			recordedHook.ExcludePaths = hook.ExcludePaths
+			recordedHook.ForkPolicy = hook.ForkPolicy
+			recordedHook.ForkPermission = hook.ForkPermission
+			recordedHook.ForkTeam = hook.ForkTeam
+			recordedHook.ForkComment = hook.ForkComment
+			hook = recordedHook
+			delete(recorded, prefix)
+		}
+		hooks = append(hooks, hook)
+	}
+	// Webhook resources not yet reconciled
+	for _, wh := range resources {
+		hook := getWebhookFromResource(wh)
+		if _, ok := recorded[r.getTriggerPrefix(hook)]; ok {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// RunWebhookController reconciles the eventlistener triggers and git provider hooks with the webhook resources until stopCh is closed
+func (r Resource) RunWebhookController(stopCh <-chan struct{}) {
+	wait.Until(r.reconcileWebhookResources, webhookResyncPeriod, stopCh)
+}
+
+func (r Resource) reconcileWebhookResources() {
+	resources, err := r.getWebhookResources()
+	if err != nil {
+		logging.Log.Errorf("error getting webhook resources: %s", err)
+		return
+	}
+	for _, wh := range resources {
+		if err := r.reconcileWebhookResource(wh.Name, wh.Namespace); err != nil {
+			logging.Log.Errorf("error reconciling webhook %s in namespace %s: %s", wh.Name, wh.Namespace, err)
+		}
+	}
+	if err := r.recordUnrecordedWebhooks(); err != nil {
+		logging.Log.Errorf("error recording webhooks as webhook resources: %s", err)
+	}
+}
+
+// reconcileWebhookResource adds, replaces or removes the triggers and hook of a webhook resource that was created,
+// edited or deleted other than through the extension API
+func (r Resource) reconcileWebhookResource(name, namespace string) error {
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+
+	// The webhook resource is read again as it may have been changed through the extension API since listing
+	wh, err := r.getWebhookResource(name, namespace)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	hook := getWebhookFromResource(wh)
+	logger := logging.Log.With("webhook", name, "namespace", namespace, "repository", hook.GitRepositoryURL)
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	switch {
+	case wh.DeletionTimestamp != nil:
+		if !hasWebhookFinalizer(wh) || isCreationInProgress(wh) {
+			return nil
+		}
+		if wh.Status.Creation != nil {
+			if err := r.rollBackWebhookCreation(wh); err != nil {
+				return r.recordWebhookResourceError(name, namespace, err)
+			}
+			return r.deleteWebhookResource(name, namespace)
+		}
+		logger.Infof("Removing deleted webhook %s in namespace %s", name, namespace)
+		hooks, err := r.getHooksForRepo(hook.GitRepositoryURL)
+		if err != nil {
+			return err
+		}
+		for _, existing := range hooks {
+			if existing.Name == hook.Name && existing.Namespace == hook.Namespace {
+				if _, err := r.removeWebhook(ctx, hook.Name, hook.Namespace, hook.GitRepositoryURL, false); err != nil {
+					return r.recordWebhookResourceError(name, namespace, err)
+				}
+			}
+		}
+		return r.deleteWebhookResource(name, namespace)
+	case isCreationInProgress(wh):
+		// Being created by another replica
+		return nil
+	case !isReconciled(wh):
+		// Any interrupted creation of the webhook is rolled back before it is added again
+		logger.Infof("Adding webhook %s in namespace %s", name, namespace)
+		if _, err := r.addWebhook(ctx, &hook); err != nil {
+			return r.recordWebhookResourceError(name, namespace, err)
+		}
+		return r.updateWebhookResource(hook)
+	case wh.Annotations[appliedSpecAnnotation] != getAppliedSpec(wh.Spec):
+		logger.Infof("Updating edited webhook %s in namespace %s", name, namespace)
+		if _, err := r.replaceWebhook(ctx, hook.Name, &hook); err != nil {
+			return r.recordWebhookResourceError(name, namespace, err)
+		}
+		return r.updateWebhookResource(hook)
+	}
+	return nil
+}
+
+// recordUnrecordedWebhooks records the webhooks only found as eventlistener triggers as webhook resources
+func (r Resource) recordUnrecordedWebhooks() error {
+	modifyingEventListenerLock.Lock()
+	defer modifyingEventListenerLock.Unlock()
+
+	hooks, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		return err
+	}
+	resources, err := r.getWebhookResources()
+	if err != nil {
+		return err
+	}
+	// Webhooks with the same name and namespace in different eventlisteners can't all be recorded
+	recorded := map[string]bool{}
+	for _, wh := range resources {
+		recorded[wh.Namespace+"/"+wh.Name] = true
+	}
+	for _, hook := range hooks {
+		if recorded[hook.Namespace+"/"+hook.Name] {
+			continue
+		}
+		recorded[hook.Namespace+"/"+hook.Name] = true
+		logging.Log.Infof("Recording webhook %s in namespace %s as a webhook resource", hook.Name, hook.Namespace)
+		if err := r.createWebhookResource(hook); err != nil {
+			logging.Log.Errorf("error recording webhook %s in namespace %s as a webhook resource: %s", hook.Name, hook.Namespace, err)
+		}
+	}
+	return nil
+}
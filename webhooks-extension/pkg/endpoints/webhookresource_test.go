@@ -0,0 +1,185 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	webhooksv1alpha1 "github.com/tektoncd/experimental/webhooks-extension/pkg/apis/webhooks/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newWebhookResourceTestResource(t *testing.T) (*Resource, *fakeGiteaServer, webhook) {
+	gitea := newFakeGiteaServer("access")
+	r := dummyResource()
+	r.Defaults = EnvDefaults{
+		Namespace:   installNs,
+		CallbackURL: "http://listener.example.com",
+	}
+	hook := webhook{
+		Name:             "name1",
+		Namespace:        "foo",
+		GitRepositoryURL: gitea.repoURL("owner", "repo"),
+		AccessTokenRef:   "token1",
+		Pipeline:         "pipeline1",
+		GitProvider:      "gitea",
+		OnSuccessComment: "passed",
+		OnFailureComment: "failed",
+	}
+	createTriggerResources(hook, r)
+	return r, gitea, hook
+}
+
+func TestCreateWebhookRecordsWebhookResource(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	wh, err := r.getWebhookResource("name1", "foo")
+	if err != nil {
+		t.Fatalf("Error getting webhook resource: %s", err)
+	}
+	if !hasWebhookFinalizer(wh) || wh.Annotations[appliedSpecAnnotation] != getAppliedSpec(wh.Spec) {
+		t.Errorf("Webhook resource not recorded as reconciled: %+v", wh)
+	}
+
+	// The comments are not held by the triggers, only by the webhook resource
+	hooks, err := r.getWebhooks()
+	if err != nil {
+		t.Fatalf("Error getting webhooks: %s", err)
+	}
+	if len(hooks) != 1 || hooks[0].OnSuccessComment != "passed" || hooks[0].OnFailureComment != "failed" || hooks[0].PullTask != "monitor-task" {
+		t.Errorf("Expected the webhook as recorded by the webhook resource; got: %+v", hooks)
+	}
+
+	// A webhook with the same name in the same namespace can't be created for another repository
+	other := hook
+	other.GitRepositoryURL = gitea.repoURL("owner", "other")
+	other.Pipeline = "pipeline2"
+	createTriggerResources(other, r)
+	if resp := createWebhook(other, r); resp.StatusCode() != http.StatusBadRequest {
+		t.Errorf("Expected creation of a webhook with the same name to fail with status 400, got %d", resp.StatusCode())
+	}
+
+	httpReq := dummyHTTPRequest("DELETE", "http://wwww.dummy.com:8080/webhooks/name1?namespace=foo&repository="+url.QueryEscape(hook.GitRepositoryURL), nil)
+	deleteResp := dummyRestfulResponse(httptest.NewRecorder())
+	r.deleteWebhook(dummyRestfulRequest(httpReq, "name1"), deleteResp)
+	if deleteResp.StatusCode() != http.StatusNoContent {
+		t.Fatalf("Webhook deletion failed with status %d", deleteResp.StatusCode())
+	}
+	if _, err := r.getWebhookResource("name1", "foo"); err == nil {
+		t.Errorf("Expected the webhook resource to be deleted")
+	}
+}
+
+func TestReconcileWebhookResource(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	// A webhook resource created directly is added
+	u, err := toUnstructuredWebhook(webhooksv1alpha1.Webhook{
+		ObjectMeta: metav1.ObjectMeta{Name: hook.Name, Namespace: hook.Namespace},
+		Spec:       getWebhookSpec(hook),
+	})
+	if err != nil {
+		t.Fatalf("Error converting webhook resource: %s", err)
+	}
+	if _, err := r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(hook.Namespace).Create(u, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Error creating webhook resource: %s", err)
+	}
+	r.reconcileWebhookResources()
+
+	fromTriggers, err := r.getWebhooksFromEventListeners()
+	if err != nil {
+		t.Fatalf("Error getting webhooks: %s", err)
+	}
+	if len(fromTriggers) != 1 || fromTriggers[0].Name != hook.Name || fromTriggers[0].Pipeline != "pipeline1" {
+		t.Fatalf("Expected the triggers of the webhook resource to be added; got: %+v", fromTriggers)
+	}
+	if len(gitea.getHooks("owner", "repo")) != 1 {
+		t.Errorf("Expected the hook to be registered with Gitea")
+	}
+	wh, err := r.getWebhookResource(hook.Name, hook.Namespace)
+	if err != nil {
+		t.Fatalf("Error getting webhook resource: %s", err)
+	}
+	if !hasWebhookFinalizer(wh) || wh.Spec.PullTask != "monitor-task" || wh.Spec.EventListener != eventListenerName {
+		t.Errorf("Expected the webhook resource to be recorded as reconciled with the defaulted webhook; got: %+v", wh)
+	}
+
+	// An edited webhook resource is updated
+	wh.Spec.ServiceAccount = "pipeline-sa"
+	if u, err = toUnstructuredWebhook(wh); err != nil {
+		t.Fatalf("Error converting webhook resource: %s", err)
+	}
+	if _, err := r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(hook.Namespace).Update(u, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Error updating webhook resource: %s", err)
+	}
+	r.reconcileWebhookResources()
+	if fromTriggers, _ = r.getWebhooksFromEventListeners(); len(fromTriggers) != 1 || fromTriggers[0].ServiceAccount != "pipeline-sa" {
+		t.Errorf("Expected the triggers of the edited webhook resource to be updated; got: %+v", fromTriggers)
+	}
+
+	// A deleted webhook resource is removed, the finalizer keeping the resource until then
+	wh, _ = r.getWebhookResource(hook.Name, hook.Namespace)
+	now := metav1.Now()
+	wh.DeletionTimestamp = &now
+	if u, err = toUnstructuredWebhook(wh); err != nil {
+		t.Fatalf("Error converting webhook resource: %s", err)
+	}
+	if _, err := r.DynamicClient.Resource(webhooksv1alpha1.WebhookResource).Namespace(hook.Namespace).Update(u, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Error updating webhook resource: %s", err)
+	}
+	r.reconcileWebhookResources()
+	if _, err := r.TriggersClient.TektonV1alpha1().EventListeners(installNs).Get(eventListenerName, metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected the eventlistener to be deleted with the only webhook")
+	}
+	if len(gitea.getHooks("owner", "repo")) != 0 {
+		t.Errorf("Expected the hook to be removed from Gitea")
+	}
+	if _, err := r.getWebhookResource(hook.Name, hook.Namespace); err == nil {
+		t.Errorf("Expected the webhook resource to be deleted")
+	}
+}
+
+func TestRecordUnrecordedWebhooks(t *testing.T) {
+	r, gitea, hook := newWebhookResourceTestResource(t)
+	defer gitea.Close()
+
+	if resp := createWebhook(hook, r); resp.StatusCode() != http.StatusCreated {
+		t.Fatalf("Webhook creation failed with status %d", resp.StatusCode())
+	}
+	// As if the webhook was created before webhooks were recorded as resources
+	if err := r.deleteWebhookResource(hook.Name, hook.Namespace); err != nil {
+		t.Fatalf("Error deleting webhook resource: %s", err)
+	}
+	hooks, err := r.getWebhooks()
+	if err != nil || len(hooks) != 1 {
+		t.Fatalf("Expected the webhook in the eventlistener to be returned without a webhook resource; got: %+v, %v", hooks, err)
+	}
+
+	r.reconcileWebhookResources()
+	wh, err := r.getWebhookResource(hook.Name, hook.Namespace)
+	if err != nil {
+		t.Fatalf("Expected the webhook to be recorded as a webhook resource: %s", err)
+	}
+	if !hasWebhookFinalizer(wh) || wh.Spec.Pipeline != hook.Pipeline || wh.Spec.GitRepositoryURL != hook.GitRepositoryURL {
+		t.Errorf("Webhook not recorded as expected: %+v", wh)
+	}
+}
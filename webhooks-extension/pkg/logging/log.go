@@ -14,19 +14,154 @@ limitations under the License.
 package logging
 
 import (
+	"context"
 	"fmt"
+	"os"
+
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+/*--------------------------------------
+The extension and the interceptor log with the same logger, configured by
+environment variables:
+
+LOG_FORMAT is json (the default) or console
+LOG_LEVEL is debug, info (the default), warn or error
+
+The level can be changed while running with the loglevel key of the ConfigMap
+named by LOG_CONFIGMAP, webhooks-extension-logging by default, in the installed
+namespace. Removing the key or the ConfigMap restores LOG_LEVEL.
+---------------------------------------*/
+
+const (
+	envFormat    = "LOG_FORMAT"
+	envLevel     = "LOG_LEVEL"
+	envConfigMap = "LOG_CONFIGMAP"
+
+	defaultConfigMap = "webhooks-extension-logging"
+	levelKey         = "loglevel"
 )
 
+// level is the level of Log, changed by the logging ConfigMap
+var level = zap.NewAtomicLevel()
+
 // Log is our logger for use elsewhere
 var Log = loggerInit()
 
+type contextKey struct{}
+
 func loggerInit() *zap.SugaredLogger {
-	Logger := zap.NewExample().Sugar()
-	defer Logger.Sync()
-	if Logger == nil {
-		fmt.Print("expected a non-nil logger")
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "ts"
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	var encoder zapcore.Encoder
+	format := os.Getenv(envFormat)
+	switch format {
+	case "console":
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	default:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	logger := zap.New(zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), level), zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr))).Sugar()
+	if format != "" && format != "json" && format != "console" {
+		logger.Warnf("unknown %s %s, logging as json", envFormat, format)
+	}
+	if err := resetLevel(); err != nil {
+		logger.Warnf("%s, logging at info level", err)
+	}
+	return logger
+}
+
+// SetLevel sets the level of Log to the level named by text
+func SetLevel(text string) error {
+	var l zapcore.Level
+	if err := l.UnmarshalText([]byte(text)); err != nil {
+		return fmt.Errorf("unknown log level %s", text)
+	}
+	level.SetLevel(l)
+	return nil
+}
+
+// resetLevel sets the level of Log to LOG_LEVEL, or info if it is not set or not a level
+func resetLevel() error {
+	text := os.Getenv(envLevel)
+	if text == "" {
+		level.SetLevel(zapcore.InfoLevel)
+		return nil
+	}
+	if err := SetLevel(text); err != nil {
+		level.SetLevel(zapcore.InfoLevel)
+		return fmt.Errorf("unknown %s %s", envLevel, text)
+	}
+	return nil
+}
+
+// WithLogger returns ctx carrying the logger, usually Log with the fields of a request
+func WithLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Log if it carries none
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return Log
+}
+
+// WatchLevel sets the level of Log from the logging ConfigMap in the namespace as it changes, until stopCh is closed
+func WatchLevel(client kubernetes.Interface, namespace string, stopCh <-chan struct{}) {
+	name := os.Getenv(envConfigMap)
+	if name == "" {
+		name = defaultConfigMap
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			cm, ok := obj.(*corev1.ConfigMap)
+			return ok && cm.Name == name
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { setLevelFromConfigMap(obj.(*corev1.ConfigMap)) },
+			UpdateFunc: func(_, obj interface{}) { setLevelFromConfigMap(obj.(*corev1.ConfigMap)) },
+			DeleteFunc: func(interface{}) { setLevelFromConfigMap(nil) },
+		},
+	})
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		Log.Errorf("error syncing the logging ConfigMap %s, the log level will not change", name)
+	}
+}
+
+// setLevelFromConfigMap sets the level of Log from the logging ConfigMap, which is nil once deleted
+func setLevelFromConfigMap(cm *corev1.ConfigMap) {
+	if cm == nil || cm.Data[levelKey] == "" {
+		if err := resetLevel(); err != nil {
+			Log.Warnf("%s, logging at info level", err)
+		}
+		Log.Infof("log level reset to %s", level.Level())
+		return
+	}
+	if err := SetLevel(cm.Data[levelKey]); err != nil {
+		Log.Errorf("error setting the log level from ConfigMap %s: %s", cm.Name, err)
+		return
 	}
-	Logger.Info("constructed a logger")
-	return Logger
+	Log.Infof("log level set to %s by ConfigMap %s", level.Level(), cm.Name)
 }
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	fakek8sclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer resetLevel()
+
+	if err := SetLevel("debug"); err != nil {
+		t.Fatalf("Error setting the log level: %s", err)
+	}
+	if !Log.Desugar().Core().Enabled(zapcore.DebugLevel) {
+		t.Error("Expected debug logs to be enabled at debug level")
+	}
+	if err := SetLevel("warn"); err != nil {
+		t.Fatalf("Error setting the log level: %s", err)
+	}
+	if Log.Desugar().Core().Enabled(zapcore.InfoLevel) {
+		t.Error("Expected info logs to be disabled at warn level")
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("Expected an error setting an unknown log level")
+	}
+	if level.Level() != zapcore.WarnLevel {
+		t.Errorf("Expected an unknown level to leave the level unchanged, got %s", level.Level())
+	}
+
+	os.Setenv(envLevel, "error")
+	defer os.Unsetenv(envLevel)
+	if err := resetLevel(); err != nil || level.Level() != zapcore.ErrorLevel {
+		t.Errorf("Expected the level to be reset to %s error, got %s with error %v", envLevel, level.Level(), err)
+	}
+	os.Setenv(envLevel, "verbose")
+	if err := resetLevel(); err == nil || level.Level() != zapcore.InfoLevel {
+		t.Errorf("Expected an unknown %s to reset the level to info with an error, got %s with error %v", envLevel, level.Level(), err)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != Log {
+		t.Error("Expected Log from a context without a logger")
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core).Sugar().With("trigger", "trigger", "deliveryID", "1234")
+	FromContext(WithLogger(context.Background(), logger)).Info("handled")
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 log entry, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["trigger"] != "trigger" || fields["deliveryID"] != "1234" {
+		t.Errorf("Expected the request fields to be logged, got %v", fields)
+	}
+}
+
+func TestWatchLevel(t *testing.T) {
+	defer resetLevel()
+
+	client := fakek8sclientset.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	WatchLevel(client, "tekton-pipelines", stopCh)
+
+	waitForLevel := func(expected zapcore.Level) {
+		t.Helper()
+		err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return level.Level() == expected, nil
+		})
+		if err != nil {
+			t.Fatalf("Expected log level %s, got %s", expected, level.Level())
+		}
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultConfigMap, Namespace: "tekton-pipelines"},
+		Data:       map[string]string{levelKey: "debug"},
+	}
+	if _, err := client.CoreV1().ConfigMaps("tekton-pipelines").Create(cm); err != nil {
+		t.Fatalf("Error creating ConfigMap: %s", err)
+	}
+	waitForLevel(zapcore.DebugLevel)
+
+	cm.Data[levelKey] = "error"
+	if _, err := client.CoreV1().ConfigMaps("tekton-pipelines").Update(cm); err != nil {
+		t.Fatalf("Error updating ConfigMap: %s", err)
+	}
+	waitForLevel(zapcore.ErrorLevel)
+
+	if err := client.CoreV1().ConfigMaps("tekton-pipelines").Delete(defaultConfigMap, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Error deleting ConfigMap: %s", err)
+	}
+	waitForLevel(zapcore.InfoLevel)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	k8smetrics "k8s.io/client-go/tools/metrics"
+)
+
+// Metrics shared by the extension and the interceptor, each served on /metrics of its own process
+var (
+	kubernetesRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "webhooks_kubernetes_client_request_duration_seconds",
+		Help:    "Latency of requests to the Kubernetes API server by verb.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"verb"})
+
+	kubernetesRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhooks_kubernetes_client_requests_total",
+		Help: "Requests to the Kubernetes API server by HTTP status code and method.",
+	}, []string{"code", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(kubernetesRequestDuration, kubernetesRequests)
+}
+
+// RegisterKubernetesClientMetrics records the latency and results of the requests made by all Kubernetes clients
+// of the process. Only the first call has any effect.
+func RegisterKubernetesClientMetrics() {
+	k8smetrics.Register(kubernetesLatency{}, kubernetesResult{})
+}
+
+type kubernetesLatency struct{}
+
+// Observe records the latency of a request, the URL is not recorded as it names individual resources
+func (kubernetesLatency) Observe(verb string, u url.URL, latency time.Duration) {
+	kubernetesRequestDuration.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+type kubernetesResult struct{}
+
+func (kubernetesResult) Increment(code, method, host string) {
+	kubernetesRequests.WithLabelValues(code, method).Inc()
+}
+
+// InstrumentClient returns a copy of the client recording the latency of its requests in the histogram,
+// which must have code and method labels
+func InstrumentClient(client *http.Client, histogram prometheus.ObserverVec) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	instrumented := *client
+	instrumented.Transport = promhttp.InstrumentRoundTripperDuration(histogram, transport)
+	return &instrumented
+}
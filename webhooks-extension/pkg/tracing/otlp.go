@@ -0,0 +1,321 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+/*--------------------------------------
+Spans are exported with OTLP over HTTP encoded as JSON, as defined by the OTLP
+specification, rather than with the OTLP exporters of OpenTelemetry, whose gRPC
+and protobuf dependencies cannot be vendored with dep.
+---------------------------------------*/
+
+const (
+	otlpTracesPath     = "/v1/traces"
+	otlpDefaultTimeout = 10 * time.Second
+)
+
+// otlpExporter exports spans to the traces endpoint of an OTLP over HTTP receiver
+type otlpExporter struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+}
+
+// newOTLPExporter returns an exporter configured by the OTEL_EXPORTER_OTLP_* environment variables
+func newOTLPExporter() (*otlpExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + otlpTracesPath
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP endpoint %s is not an http or https URL", endpoint)
+	}
+
+	headers := map[string]string{}
+	for _, header := range strings.Split(otlpEnv("HEADERS"), ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("OTLP header %s is not a key=value pair", header)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("OTLP header %s: %s", parts[0], err)
+		}
+		headers[strings.TrimSpace(parts[0])] = value
+	}
+
+	timeout := otlpDefaultTimeout
+	if value := otlpEnv("TIMEOUT"); value != "" {
+		millis, err := strconv.Atoi(value)
+		if err != nil || millis <= 0 {
+			return nil, fmt.Errorf("OTLP timeout %s is not a positive number of milliseconds", value)
+		}
+		timeout = time.Duration(millis) * time.Millisecond
+	}
+
+	return &otlpExporter{client: &http.Client{Timeout: timeout}, endpoint: endpoint, headers: headers}, nil
+}
+
+// otlpEnv returns the OTEL_EXPORTER_OTLP_TRACES_ variable of the setting, or the OTEL_EXPORTER_OTLP_ one if it is not set
+func otlpEnv(setting string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + setting); value != "" {
+		return value
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + setting)
+}
+
+// ExportSpans sends the spans to the traces endpoint
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(newOTLPRequest(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint %s returned status %d exporting %d spans", e.endpoint, resp.StatusCode, len(spans))
+	}
+	return nil
+}
+
+// Shutdown closes the idle connections to the traces endpoint
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// The JSON encoding of the OTLP ExportTraceServiceRequest message, in which trace and span IDs
+// are hex encoded and 64 bit integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+// newOTLPRequest groups the spans by their resource and instrumentation library
+func newOTLPRequest(spans []sdktrace.ReadOnlySpan) otlpRequest {
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{}}
+	resources := map[attribute.Distinct]int{}
+	scopes := map[attribute.Distinct]map[otlpScope]int{}
+	for _, span := range spans {
+		resource := span.Resource().Equivalent()
+		i, ok := resources[resource]
+		if !ok {
+			i = len(request.ResourceSpans)
+			resources[resource] = i
+			scopes[resource] = map[otlpScope]int{}
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: otlpAttributes(span.Resource().Attributes())},
+				ScopeSpans: []otlpScopeSpans{},
+			})
+		}
+		library := span.InstrumentationLibrary()
+		scope := otlpScope{Name: library.Name, Version: library.Version}
+		j, ok := scopes[resource][scope]
+		if !ok {
+			j = len(request.ResourceSpans[i].ScopeSpans)
+			scopes[resource][scope] = j
+			request.ResourceSpans[i].ScopeSpans = append(request.ResourceSpans[i].ScopeSpans, otlpScopeSpans{Scope: scope})
+		}
+		request.ResourceSpans[i].ScopeSpans[j].Spans = append(request.ResourceSpans[i].ScopeSpans[j].Spans, newOTLPSpan(span))
+	}
+	return request
+}
+
+func newOTLPSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	s := otlpSpan{
+		TraceID:           span.SpanContext().TraceID().String(),
+		SpanID:            span.SpanContext().SpanID().String(),
+		Name:              span.Name(),
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: otlpTime(span.StartTime()),
+		EndTimeUnixNano:   otlpTime(span.EndTime()),
+		Attributes:        otlpAttributes(span.Attributes()),
+		Status:            otlpStatus{Message: span.Status().Description},
+	}
+	if span.Parent().IsValid() {
+		s.ParentSpanID = span.Parent().SpanID().String()
+	}
+	// OTLP status codes are ordered Unset, Ok, Error while OpenTelemetry's are Unset, Error, Ok
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = 1
+	case codes.Error:
+		s.Status.Code = 2
+	}
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: otlpTime(event.Time),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+	return s
+}
+
+func otlpTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attributes []attribute.KeyValue) []otlpKeyValue {
+	values := []otlpKeyValue{}
+	for _, kv := range attributes {
+		values = append(values, otlpKeyValue{Key: string(kv.Key), Value: otlpValue(kv.Value)})
+	}
+	return values
+}
+
+func otlpValue(value attribute.Value) otlpAnyValue {
+	switch value.Type() {
+	case attribute.BOOL:
+		b := value.AsBool()
+		return otlpAnyValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(value.AsInt64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := value.AsFloat64()
+		return otlpAnyValue{DoubleValue: &f}
+	case attribute.STRING:
+		s := value.AsString()
+		return otlpAnyValue{StringValue: &s}
+	case attribute.BOOLSLICE:
+		array := &otlpArrayValue{Values: []otlpAnyValue{}}
+		for _, b := range value.AsBoolSlice() {
+			array.Values = append(array.Values, otlpValue(attribute.BoolValue(b)))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	case attribute.INT64SLICE:
+		array := &otlpArrayValue{Values: []otlpAnyValue{}}
+		for _, i := range value.AsInt64Slice() {
+			array.Values = append(array.Values, otlpValue(attribute.Int64Value(i)))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	case attribute.FLOAT64SLICE:
+		array := &otlpArrayValue{Values: []otlpAnyValue{}}
+		for _, f := range value.AsFloat64Slice() {
+			array.Values = append(array.Values, otlpValue(attribute.Float64Value(f)))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	case attribute.STRINGSLICE:
+		array := &otlpArrayValue{Values: []otlpAnyValue{}}
+		for _, s := range value.AsStringSlice() {
+			array.Values = append(array.Values, otlpValue(attribute.StringValue(s)))
+		}
+		return otlpAnyValue{ArrayValue: array}
+	default:
+		s := value.Emit()
+		return otlpAnyValue{StringValue: &s}
+	}
+}
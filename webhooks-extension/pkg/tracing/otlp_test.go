@@ -0,0 +1,266 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setOTLPEnv(t *testing.T, env map[string]string) {
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_HEADERS",
+		"OTEL_EXPORTER_OTLP_TRACES_HEADERS", "OTEL_EXPORTER_OTLP_TIMEOUT", "OTEL_EXPORTER_OTLP_TRACES_TIMEOUT"} {
+		os.Unsetenv(name)
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+}
+
+func TestNewOTLPExporter(t *testing.T) {
+	defer setOTLPEnv(t, nil)
+	tests := []struct {
+		name     string
+		env      map[string]string
+		endpoint string
+		headers  map[string]string
+		timeout  time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "endpoint",
+			env:      map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318/"},
+			endpoint: "http://collector:4318/v1/traces",
+			headers:  map[string]string{},
+			timeout:  otlpDefaultTimeout,
+		},
+		{
+			name: "traces settings",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://collector:4318",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "https://collector/traces",
+				"OTEL_EXPORTER_OTLP_HEADERS":         "ignored=true",
+				"OTEL_EXPORTER_OTLP_TRACES_HEADERS":  "Authorization=Bearer%20token, X-Tenant = team",
+				"OTEL_EXPORTER_OTLP_TRACES_TIMEOUT":  "500",
+			},
+			endpoint: "https://collector/traces",
+			headers:  map[string]string{"Authorization": "Bearer token", "X-Tenant": "team"},
+			timeout:  500 * time.Millisecond,
+		},
+		{
+			name:    "endpoint not a URL",
+			env:     map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4318"},
+			wantErr: true,
+		},
+		{
+			name:    "header not a pair",
+			env:     map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_HEADERS": "token"},
+			wantErr: true,
+		},
+		{
+			name:    "timeout not a number",
+			env:     map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_TIMEOUT": "10s"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setOTLPEnv(t, tt.env)
+			exporter, err := newOTLPExporter()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got exporter %+v", exporter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error creating exporter: %s", err)
+			}
+			if exporter.endpoint != tt.endpoint || exporter.client.Timeout != tt.timeout {
+				t.Errorf("Expected endpoint %s and timeout %s, got %s and %s", tt.endpoint, tt.timeout, exporter.endpoint, exporter.client.Timeout)
+			}
+			if len(exporter.headers) != len(tt.headers) {
+				t.Errorf("Expected headers %v, got %v", tt.headers, exporter.headers)
+			}
+			for key, value := range tt.headers {
+				if exporter.headers[key] != value {
+					t.Errorf("Expected headers %v, got %v", tt.headers, exporter.headers)
+				}
+			}
+		})
+	}
+}
+
+func TestOTLPExportSpans(t *testing.T) {
+	defer setOTLPEnv(t, nil)
+	var got otlpRequest
+	var header http.Header
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		if r.URL.Path != otlpTracesPath {
+			t.Errorf("Expected spans to be sent to %s, got %s", otlpTracesPath, r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	setOTLPEnv(t, map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL, "OTEL_EXPORTER_OTLP_HEADERS": "X-Tenant=team"})
+	exporter, err := newOTLPExporter()
+	if err != nil {
+		t.Fatalf("Error creating exporter: %s", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	ctx, parent := provider.Tracer(instrumentationName).Start(context.Background(), "parent")
+	_, child := provider.Tracer(instrumentationName).Start(ctx, "child")
+	child.SetAttributes(attribute.String("repository", "owner/repo"), attribute.Int64("attempts", 2), attribute.Bool("fork", true))
+	child.SetStatus(codes.Error, "validation failed")
+	child.End()
+
+	if header.Get("Content-Type") != "application/json" || header.Get("X-Tenant") != "team" {
+		t.Errorf("Expected a JSON request with the configured headers, got %v", header)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("Expected one span, got %+v", got)
+	}
+	if got.ResourceSpans[0].ScopeSpans[0].Scope.Name != instrumentationName {
+		t.Errorf("Expected scope %s, got %+v", instrumentationName, got.ResourceSpans[0].ScopeSpans[0].Scope)
+	}
+	span := got.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Name != "child" || span.TraceID != parent.SpanContext().TraceID().String() || span.ParentSpanID != parent.SpanContext().SpanID().String() {
+		t.Errorf("Expected the child span of the parent, got %+v", span)
+	}
+	if span.Status.Code != 2 || span.Status.Message != "validation failed" {
+		t.Errorf("Expected an OTLP error status, got %+v", span.Status)
+	}
+	attributes := map[string]otlpAnyValue{}
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	if v := attributes["repository"].StringValue; v == nil || *v != "owner/repo" {
+		t.Errorf("Expected the string attribute, got %+v", span.Attributes)
+	}
+	if v := attributes["attempts"].IntValue; v == nil || *v != "2" {
+		t.Errorf("Expected the int attribute as a string, got %+v", span.Attributes)
+	}
+	if v := attributes["fork"].BoolValue; v == nil || !*v {
+		t.Errorf("Expected the bool attribute, got %+v", span.Attributes)
+	}
+
+	status = http.StatusBadRequest
+	_, failed := provider.Tracer(instrumentationName).Start(context.Background(), "failed")
+	failed.End()
+	if err := exporter.ExportSpans(context.Background(), []sdktrace.ReadOnlySpan{failed.(sdktrace.ReadOnlySpan)}); err == nil {
+		t.Error("Expected an error exporting spans rejected by the endpoint")
+	}
+	parent.End()
+}
+
+func TestOTLPExportSpansJSON(t *testing.T) {
+	defer setOTLPEnv(t, nil)
+	// The batch is decoded as generic JSON, to check the encoding of the OTLP JSON mapping itself
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Error decoding request: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	setOTLPEnv(t, map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL})
+	exporter, err := newOTLPExporter()
+	if err != nil {
+		t.Fatalf("Error creating exporter: %s", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	tracer := provider.Tracer(instrumentationName)
+	for _, status := range []codes.Code{codes.Unset, codes.Ok, codes.Error} {
+		_, span := tracer.Start(context.Background(), status.String())
+		span.SetAttributes(
+			attribute.Int64("attempts", 9007199254740993),
+			attribute.StringSlice("events", []string{"push", "pull_request"}),
+			attribute.Int64Slice("ids", []int64{1, 2}),
+		)
+		span.AddEvent("retried", trace.WithAttributes(attribute.Bool("fork", true)))
+		span.SetStatus(status, "")
+		span.End()
+	}
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Error exporting spans: %s", err)
+	}
+
+	var spans []interface{}
+	for _, resourceSpans := range got["resourceSpans"].([]interface{}) {
+		for _, scopeSpans := range resourceSpans.(map[string]interface{})["scopeSpans"].([]interface{}) {
+			spans = append(spans, scopeSpans.(map[string]interface{})["spans"].([]interface{})...)
+		}
+	}
+	if len(spans) != 3 {
+		t.Fatalf("Expected the batch of 3 spans, got %v", got)
+	}
+	// OTLP status codes are 0 for unset, 1 for ok and 2 for error, and omitted when 0
+	expectedCodes := map[string]interface{}{"Unset": nil, "Ok": float64(1), "Error": float64(2)}
+	for _, s := range spans {
+		span := s.(map[string]interface{})
+		name := span["name"].(string)
+		if code := span["status"].(map[string]interface{})["code"]; code != expectedCodes[name] {
+			t.Errorf("Expected span %s to have status code %v, got %v", name, expectedCodes[name], code)
+		}
+		if _, ok := span["startTimeUnixNano"].(string); !ok {
+			t.Errorf("Expected the start time of span %s as a string, got %v", name, span["startTimeUnixNano"])
+		}
+
+		attributes := map[string]interface{}{}
+		for _, kv := range span["attributes"].([]interface{}) {
+			attributes[kv.(map[string]interface{})["key"].(string)] = kv.(map[string]interface{})["value"]
+		}
+		// 64 bit integers are strings, as JSON numbers lose their precision beyond 2^53
+		expected := map[string]interface{}{
+			"attempts": map[string]interface{}{"intValue": "9007199254740993"},
+			"events": map[string]interface{}{"arrayValue": map[string]interface{}{"values": []interface{}{
+				map[string]interface{}{"stringValue": "push"},
+				map[string]interface{}{"stringValue": "pull_request"},
+			}}},
+			"ids": map[string]interface{}{"arrayValue": map[string]interface{}{"values": []interface{}{
+				map[string]interface{}{"intValue": "1"},
+				map[string]interface{}{"intValue": "2"},
+			}}},
+		}
+		if !reflect.DeepEqual(attributes, expected) {
+			t.Errorf("Expected span %s to have attributes %v, got %v", name, expected, attributes)
+		}
+
+		events := span["events"].([]interface{})
+		expectedEvent := []interface{}{map[string]interface{}{"key": "fork", "value": map[string]interface{}{"boolValue": true}}}
+		if len(events) != 1 || events[0].(map[string]interface{})["name"] != "retried" || !reflect.DeepEqual(events[0].(map[string]interface{})["attributes"], expectedEvent) {
+			t.Errorf("Expected span %s to have the retried event, got %v", name, events)
+		}
+	}
+}
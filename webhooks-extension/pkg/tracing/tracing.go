@@ -0,0 +1,103 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*--------------------------------------
+Each incoming event is traced from the interceptor validating it to the
+PipelineRun the EventListener creates for it. The interceptor adds the W3C
+traceparent of the event's trace to the payload it returns as
+webhooks-tekton-traceparent, for trigger templates to set as the
+webhooks.tekton.dev/traceparent label of the PipelineRun. The extension
+records the spans of labelled PipelineRuns once they complete.
+
+Spans are exported with OTLP over HTTP when OTEL_EXPORTER_OTLP_ENDPOINT or
+OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard
+OTEL_EXPORTER_OTLP_HEADERS and OTEL_EXPORTER_OTLP_TIMEOUT environment variables
+or their OTEL_EXPORTER_OTLP_TRACES_ forms. Otherwise spans are not recorded and
+the traceparent is empty.
+---------------------------------------*/
+
+const (
+	// TraceParentLabel is the label of PipelineRuns carrying the traceparent of the event that created them
+	TraceParentLabel = "webhooks.tekton.dev/traceparent"
+
+	// TraceParentParam is the field of the payload returned by the interceptor holding the traceparent
+	TraceParentParam = "webhooks-tekton-traceparent"
+
+	instrumentationName = "github.com/tektoncd/experimental/webhooks-extension"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Enabled returns whether an OTLP endpoint is configured to export spans to
+func Enabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Init sets up exporting the spans of the service when Enabled, returning the function flushing spans
+// not yet exported on shutdown. Otherwise spans are not recorded and the function does nothing.
+func Init(service string) (func(context.Context) error, error) {
+	if !Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	ctx := context.Background()
+	exporter, err := newOTLPExporter()
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the extension and the interceptor
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// TraceParent returns the traceparent of the span of ctx, which is empty if the span is not recorded
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.HeaderCarrier(http.Header{})
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns ctx with the remote span of the traceparent as its span, ctx is returned
+// unchanged if the traceparent is not valid
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	carrier := propagation.HeaderCarrier(http.Header{})
+	carrier.Set("traceparent", traceParent)
+	return propagator.Extract(ctx, carrier)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+		http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInitWithoutEndpoint(t *testing.T) {
+	os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	shutdown, err := Init("test")
+	if err != nil {
+		t.Fatalf("Error initialising tracing: %s", err)
+	}
+	defer shutdown(context.Background())
+
+	ctx, span := Tracer().Start(context.Background(), "test")
+	defer span.End()
+	if span.IsRecording() {
+		t.Error("Expected spans not to be recorded without an endpoint")
+	}
+	if got := TraceParent(ctx); got != "" {
+		t.Errorf("Expected an empty traceparent without an endpoint, got %s", got)
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	traceParent := TraceParent(ctx)
+	if traceParent == "" {
+		t.Fatal("Expected a traceparent for a recorded span")
+	}
+	remote := trace.SpanContextFromContext(WithTraceParent(context.Background(), traceParent))
+	if !remote.IsRemote() || remote.TraceID() != span.SpanContext().TraceID() || remote.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("Expected the span context of traceparent %s to be that of the span, got %+v", traceParent, remote)
+	}
+
+	for _, invalid := range []string{"", "00-not-a-traceparent"} {
+		if trace.SpanContextFromContext(WithTraceParent(context.Background(), invalid)).IsValid() {
+			t.Errorf("Expected no span context for traceparent %q", invalid)
+		}
+	}
+}
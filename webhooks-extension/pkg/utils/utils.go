@@ -17,7 +17,7 @@ import (
 	"strings"
 
 	restful "github.com/emicklei/go-restful"
-	logging "github.com/tektoncd/dashboard/pkg/logging"
+	logging "github.com/tektoncd/experimental/webhooks-extension/pkg/logging"
 )
 
 // RespondError - logs and writes an error response with a desired status code